@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/buildinfo"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/envflag"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/procutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/pushmetrics"
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaTraces/lib/backup"
+)
+
+var (
+	storageURL = flag.String("storageURL", "http://localhost:10428", "URL of single-node VictoriaTraces or vtstorage node to back up. "+
+		"vtbackup must run on the same host, since it reads partition snapshots from the local filesystem")
+	authKey = flagutil.NewPassword("authKey", "authKey to pass to /internal/partition/* endpoints; it must match -partitionManageAuthKey at VictoriaTraces")
+	dst     = flag.String("dst", "", "Where to put the backup; for example, fs:///path/to/backups or s3://bucket/path/to/backups . "+
+		"Backups share files stored at -dst, so only changed files are uploaded for every new backup")
+	partitions = flagutil.NewArrayString("partition", "Optional names for per-day partitions in YYYYMMDD format to back up. All the partitions are backed up if not set")
+	backupName = flag.String("backupName", "", "Optional name for the backup. The current UTC time in YYYYMMDDhhmmss format is used if not set. "+
+		"The name cannot be set together with -backupInterval")
+	backupInterval = flag.Duration("backupInterval", 0, "Optional interval between backups. If not set, then vtbackup creates a single backup and exits. "+
+		"Otherwise it creates backups with the given interval until it is stopped")
+	keepLastBackups = flag.Int("keepLastBackups", 0, "The number of the newest backups to keep at -dst after creating a new backup. "+
+		"Older backups and files, which aren't referred by the remaining backups, are deleted. All the backups are kept if set to 0")
+	concurrency = flag.Int("concurrency", 10, "The number of concurrent workers for uploading files. Higher concurrency may reduce backup duration")
+	dryRun      = flag.Bool("dryRun", false, "Whether to only log files, which would be uploaded, and backups, which would be deleted, without making changes at -dst")
+
+	httpListenAddrs = flagutil.NewArrayString("httpListenAddr", "Optional TCP address to listen for incoming http requests when -backupInterval is set. "+
+		"vtbackup exposes its metrics at /metrics page at this address")
+)
+
+var (
+	backupsTotal      = metrics.NewCounter(`vt_backup_backups_total`)
+	backupErrorsTotal = metrics.NewCounter(`vt_backup_errors_total`)
+	filesUploaded     = metrics.NewCounter(`vt_backup_uploaded_files_total`)
+	bytesUploaded     = metrics.NewCounter(`vt_backup_uploaded_bytes_total`)
+	filesSkipped      = metrics.NewCounter(`vt_backup_skipped_files_total`)
+	bytesSkipped      = metrics.NewCounter(`vt_backup_skipped_bytes_total`)
+
+	lastSuccessTimestamp atomic.Int64
+	_                    = metrics.NewGauge(`vt_backup_last_success_timestamp_seconds`, func() float64 {
+		return float64(lastSuccessTimestamp.Load())
+	})
+)
+
+func main() {
+	// Write flags and help message to stdout, since it is easier to grep or pipe.
+	flag.CommandLine.SetOutput(os.Stdout)
+	flag.Usage = usage
+	envflag.Parse()
+	buildinfo.Init()
+	logger.Init()
+
+	if *dst == "" {
+		logger.Fatalf("missing -dst command-line flag")
+	}
+	if *backupName != "" {
+		if *backupInterval > 0 {
+			logger.Fatalf("-backupName cannot be set together with -backupInterval")
+		}
+		if err := backup.ValidateBackupName(*backupName); err != nil {
+			logger.Fatalf("invalid -backupName: %s", err)
+		}
+	}
+	if *keepLastBackups < 0 {
+		logger.Fatalf("-keepLastBackups cannot be negative; got %d", *keepLastBackups)
+	}
+	rfs, err := backup.NewRemoteFS(*dst)
+	if err != nil {
+		logger.Fatalf("cannot initialize -dst: %s", err)
+	}
+	sc := &backup.StorageClient{
+		URL:     *storageURL,
+		AuthKey: authKey.Get(),
+	}
+
+	pushmetrics.Init()
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		sig := procutil.WaitForSigterm()
+		logger.Infof("received signal %s; stopping vtbackup", sig)
+		cancel()
+	}()
+
+	if *backupInterval <= 0 {
+		name := *backupName
+		if name == "" {
+			name = newBackupName()
+		}
+		if err := runBackup(ctx, rfs, sc, name); err != nil {
+			logger.Fatalf("cannot create backup %q at %s: %s", name, rfs, err)
+		}
+		pushmetrics.Stop()
+		return
+	}
+
+	if len(*httpListenAddrs) > 0 {
+		go httpserver.Serve(*httpListenAddrs, nil, httpserver.ServeOptions{})
+	}
+	t := time.NewTicker(*backupInterval)
+	defer t.Stop()
+	for {
+		name := newBackupName()
+		if err := runBackup(ctx, rfs, sc, name); err != nil && ctx.Err() == nil {
+			logger.Errorf("cannot create backup %q at %s: %s", name, rfs, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			pushmetrics.Stop()
+			if len(*httpListenAddrs) > 0 {
+				if err := httpserver.Stop(*httpListenAddrs); err != nil {
+					logger.Fatalf("cannot stop the webservice: %s", err)
+				}
+			}
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func newBackupName() string {
+	return time.Now().UTC().Format("20060102150405")
+}
+
+// runBackup creates a backup with the given name at rfs for partitions from sc.
+func runBackup(ctx context.Context, rfs backup.RemoteFS, sc *backup.StorageClient, name string) error {
+	startTime := time.Now()
+	backupsTotal.Inc()
+	if err := createBackup(ctx, rfs, sc, name); err != nil {
+		backupErrorsTotal.Inc()
+		return err
+	}
+	lastSuccessTimestamp.Store(time.Now().Unix())
+
+	if *keepLastBackups > 0 {
+		deletedBackups, deletedObjects, err := backup.DeleteOldBackups(ctx, rfs, *keepLastBackups, *dryRun)
+		if err != nil {
+			backupErrorsTotal.Inc()
+			return fmt.Errorf("cannot delete old backups: %w", err)
+		}
+		if *dryRun {
+			logger.Infof("dry run: %d old backups %q and %d unreferenced files would be deleted from %s", len(deletedBackups), deletedBackups, deletedObjects, rfs)
+		} else {
+			logger.Infof("deleted %d old backups %q and %d unreferenced files from %s", len(deletedBackups), deletedBackups, deletedObjects, rfs)
+		}
+	}
+
+	logger.Infof("backup %q has been created at %s in %.3f seconds", name, rfs, time.Since(startTime).Seconds())
+	return nil
+}
+
+func createBackup(ctx context.Context, rfs backup.RemoteFS, sc *backup.StorageClient, name string) error {
+	backupNames, err := backup.ListBackups(ctx, rfs)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(backupNames, name) {
+		return fmt.Errorf("backup %q already exists", name)
+	}
+	var prev *backup.Manifest
+	if len(backupNames) > 0 {
+		prev, err = backup.ReadManifest(ctx, rfs, backupNames[len(backupNames)-1])
+		if err != nil {
+			return err
+		}
+	}
+
+	names := *partitions
+	if len(names) == 0 {
+		names, err = sc.PartitionList(ctx)
+		if err != nil {
+			return err
+		}
+		slices.Sort(names)
+	}
+	objects, err := backup.ListObjects(ctx, rfs)
+	if err != nil {
+		return err
+	}
+
+	m := &backup.Manifest{
+		Name:      name,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	var stats backup.Stats
+	for _, ptName := range names {
+		snapshotPath, err := sc.PartitionSnapshotCreate(ctx, ptName)
+		if err != nil {
+			return err
+		}
+
+		var prevPM *backup.PartitionManifest
+		if prev != nil {
+			prevPM = prev.GetPartition(ptName)
+		}
+		pm, err := backup.BackupPartition(ctx, rfs, ptName, snapshotPath, prevPM, objects, *concurrency, *dryRun, &stats)
+
+		// Delete the snapshot even if the backup has been interrupted, so it doesn't occupy disk space.
+		if errDelete := sc.PartitionSnapshotDelete(context.Background(), snapshotPath); errDelete != nil {
+			logger.Errorf("cannot delete snapshot %q: %s", snapshotPath, errDelete)
+		}
+		if err != nil {
+			return err
+		}
+		m.Partitions = append(m.Partitions, pm)
+		logger.Infof("backed up partition %q: %d files, %d bytes", ptName, len(pm.Files), pm.Size())
+	}
+
+	filesUploaded.AddInt64(stats.FilesCopied.Load())
+	bytesUploaded.AddInt64(stats.BytesCopied.Load())
+	filesSkipped.AddInt64(stats.FilesSkipped.Load())
+	bytesSkipped.AddInt64(stats.BytesSkipped.Load())
+	if *dryRun {
+		logger.Infof("dry run: %d files with %d bytes would be uploaded to %s for backup %q; %d files with %d bytes already exist there",
+			stats.FilesCopied.Load(), stats.BytesCopied.Load(), rfs, name, stats.FilesSkipped.Load(), stats.BytesSkipped.Load())
+		return nil
+	}
+	logger.Infof("uploaded %d files with %d bytes to %s for backup %q; skipped %d files with %d bytes, which already exist there",
+		stats.FilesCopied.Load(), stats.BytesCopied.Load(), rfs, name, stats.FilesSkipped.Load(), stats.BytesSkipped.Load())
+
+	return backup.WriteManifest(ctx, rfs, m)
+}
+
+func usage() {
+	const s = `
+vtbackup creates backups for VictoriaTraces data.
+
+See the docs at https://docs.victoriametrics.com/victoriatraces/vtbackup/
+`
+	flagutil.Usage(s)
+}
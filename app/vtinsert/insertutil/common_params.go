@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -11,6 +12,8 @@ import (
 	"time"
 
 	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/prefixfilter"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httputil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
@@ -173,6 +176,7 @@ type LogMessageProcessor interface {
 	// AddRow must add row to the LogMessageProcessor with the given timestamp and fields.
 	//
 	// If streamFields is non-nil, then the given streamFields must be used as log stream fields instead of pre-configured fields.
+	// Extra stream fields from CommonParams.ExtraFields must override the corresponding streamFields.
 	//
 	// The LogMessageProcessor implementation cannot hold references to fields, since the caller can reuse them.
 	AddRow(timestamp int64, fields, streamFields []logstorage.Field)
@@ -190,6 +194,15 @@ type logMessageProcessor struct {
 	cp *CommonParams
 	lr *logstorage.LogRows
 
+	// extraStreamFields contains cp.ExtraFields, which are stream fields.
+	extraStreamFields []logstorage.Field
+
+	// streamIgnoreFields contains names for fields, which cannot be used as stream fields in streamFields passed to AddRow.
+	streamIgnoreFields prefixfilter.Filter
+
+	// streamTagsBuf is used for composing stream tags at addRowWithExtraStreamFieldsLocked.
+	streamTagsBuf []byte
+
 	rowsIngestedTotal  *metrics.Counter
 	bytesIngestedTotal *metrics.Counter
 	flushDuration      *metrics.Summary
@@ -224,6 +237,7 @@ func (lmp *logMessageProcessor) initPeriodicFlush() {
 // AddRow adds new log message to lmp with the given timestamp and fields.
 //
 // If streamFields is non-nil, then it is used as log stream fields instead of the pre-configured stream fields.
+// Extra stream fields from lmp.cp.ExtraFields override the corresponding streamFields.
 func (lmp *logMessageProcessor) AddRow(timestamp int64, fields, streamFields []logstorage.Field) {
 	lmp.rowsIngestedTotal.Inc()
 	n := logstorage.EstimatedJSONRowLen(fields)
@@ -239,7 +253,11 @@ func (lmp *logMessageProcessor) AddRow(timestamp int64, fields, streamFields []l
 	lmp.mu.Lock()
 	defer lmp.mu.Unlock()
 
-	lmp.lr.MustAdd(lmp.cp.TenantID, timestamp, fields, streamFields)
+	if streamFields != nil && len(lmp.extraStreamFields) > 0 {
+		lmp.addRowWithExtraStreamFieldsLocked(timestamp, fields, streamFields)
+	} else {
+		lmp.lr.MustAdd(lmp.cp.TenantID, timestamp, fields, streamFields)
+	}
 
 	if lmp.cp.Debug {
 		s := lmp.lr.GetRowString(0)
@@ -253,6 +271,34 @@ func (lmp *logMessageProcessor) AddRow(timestamp int64, fields, streamFields []l
 	}
 }
 
+// addRowWithExtraStreamFieldsLocked adds the row with the given streamFields and lmp.extraStreamFields as log stream fields to lmp.
+//
+// LogRows.MustAdd ignores extra fields in streamFields, since extra fields override the ingested fields.
+// So the log stream is composed here in the same way as LogRows.MustAdd does for the pre-configured stream fields.
+//
+// lmp.mu must be locked by the caller.
+func (lmp *logMessageProcessor) addRowWithExtraStreamFieldsLocked(timestamp int64, fields, streamFields []logstorage.Field) {
+	st := logstorage.GetStreamTags()
+	for _, f := range streamFields {
+		if !lmp.streamIgnoreFields.MatchString(f.Name) {
+			st.Add(f.Name, f.Value)
+		}
+	}
+	for _, f := range lmp.extraStreamFields {
+		st.Add(f.Name, f.Value)
+	}
+	lmp.streamTagsBuf = st.MarshalCanonical(lmp.streamTagsBuf[:0])
+	logstorage.PutStreamTags(st)
+
+	r := logstorage.InsertRow{
+		TenantID:            lmp.cp.TenantID,
+		StreamTagsCanonical: bytesutil.ToUnsafeString(lmp.streamTagsBuf),
+		Timestamp:           timestamp,
+		Fields:              fields,
+	}
+	lmp.lr.MustAddInsertRow(&r)
+}
+
 // InsertRowProcessor is used by native data ingestion protocol parser.
 type InsertRowProcessor interface {
 	// AddInsertRow must add r to the underlying storage.
@@ -327,6 +373,13 @@ func (cp *CommonParams) NewLogMessageProcessor(protocolName string, isStreamMode
 
 		stopCh: make(chan struct{}),
 	}
+	lmp.streamIgnoreFields.AddAllowFilters(cp.IgnoreFields)
+	for _, f := range cp.ExtraFields {
+		lmp.streamIgnoreFields.AddAllowFilter(f.Name)
+		if slices.Contains(cp.StreamFields, f.Name) {
+			lmp.extraStreamFields = append(lmp.extraStreamFields, f)
+		}
+	}
 
 	if isStreamMode {
 		lmp.initPeriodicFlush()
@@ -0,0 +1,81 @@
+package insertutil
+
+import (
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+type testLogRowsStorage struct {
+	rows []string
+}
+
+func (s *testLogRowsStorage) MustAddRows(lr *logstorage.LogRows) {
+	for i := 0; i < lr.RowsCount(); i++ {
+		s.rows = append(s.rows, lr.GetRowString(i))
+	}
+}
+
+func (s *testLogRowsStorage) CanWriteData() error {
+	return nil
+}
+
+func TestLogMessageProcessorAddRowStreamFields(t *testing.T) {
+	f := func(cp *CommonParams, fields, streamFields []logstorage.Field, resultExpected string) {
+		t.Helper()
+
+		s := &testLogRowsStorage{}
+		SetLogRowsStorage(s)
+		defer SetLogRowsStorage(nil)
+
+		lmp := cp.NewLogMessageProcessor("test", false)
+		lmp.AddRow(123, fields, streamFields)
+		lmp.MustClose()
+
+		if len(s.rows) != 1 {
+			t.Fatalf("unexpected number of rows; got %d; want 1", len(s.rows))
+		}
+		if s.rows[0] != resultExpected {
+			t.Fatalf("unexpected row\ngot\n%s\nwant\n%s", s.rows[0], resultExpected)
+		}
+	}
+
+	fields := []logstorage.Field{
+		{Name: "service", Value: "foo"},
+		{Name: "name", Value: "bar"},
+		{Name: "env", Value: "dev"},
+	}
+	streamFields := []logstorage.Field{
+		{Name: "service", Value: "foo"},
+		{Name: "name", Value: "folded"},
+		{Name: "env", Value: "dev"},
+	}
+
+	// pre-configured stream fields
+	f(&CommonParams{
+		StreamFields: []string{"service", "name"},
+	}, fields, nil, `{"_msg":"`+*defaultMsgValue+`","_stream":"{name=\"bar\",service=\"foo\"}","_time":"1970-01-01T00:00:00.000000123Z","env":"dev","name":"bar","service":"foo"}`)
+
+	// the given stream fields
+	f(&CommonParams{
+		StreamFields: []string{"service", "name", "env"},
+	}, fields, streamFields, `{"_msg":"`+*defaultMsgValue+`","_stream":"{env=\"dev\",name=\"folded\",service=\"foo\"}","_time":"1970-01-01T00:00:00.000000123Z","env":"dev","name":"bar","service":"foo"}`)
+
+	// extra stream fields override the given stream fields
+	f(&CommonParams{
+		StreamFields: []string{"service", "name", "env"},
+		ExtraFields: []logstorage.Field{
+			{Name: "env", Value: "prod"},
+			{Name: "region", Value: "eu"},
+		},
+	}, fields, streamFields, `{"_msg":"`+*defaultMsgValue+`","_stream":"{env=\"prod\",name=\"folded\",service=\"foo\"}","_time":"1970-01-01T00:00:00.000000123Z","env":"prod","name":"bar","region":"eu","service":"foo"}`)
+
+	// ignored fields aren't used as stream fields
+	f(&CommonParams{
+		StreamFields: []string{"service", "name", "env"},
+		IgnoreFields: []string{"service"},
+		ExtraFields: []logstorage.Field{
+			{Name: "env", Value: "prod"},
+		},
+	}, fields, streamFields, `{"_msg":"`+*defaultMsgValue+`","_stream":"{env=\"prod\",name=\"folded\"}","_time":"1970-01-01T00:00:00.000000123Z","env":"prod","name":"bar"}`)
+}
@@ -3,9 +3,11 @@ package internalinsert
 import (
 	"fmt"
 	"net/http"
+	"slices"
 	"time"
 
 	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/protoparser/protoparserutil"
@@ -27,8 +29,8 @@ func RequestHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	version := r.FormValue("version")
-	if version != netinsert.ProtocolVersion {
-		httpserver.Errorf(w, r, "unsupported protocol version=%q; want %q", version, netinsert.ProtocolVersion)
+	if !slices.Contains(netinsert.SupportedProtocolVersions, version) {
+		httpserver.Errorf(w, r, "unsupported protocol version=%q; supported versions: %q", version, netinsert.SupportedProtocolVersions)
 		return
 	}
 
@@ -44,8 +46,16 @@ func RequestHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	encoding := r.Header.Get("Content-Encoding")
-	err = protoparserutil.ReadUncompressedData(r.Body, encoding, maxRequestSize, func(data []byte) error {
+	contentEncoding := r.Header.Get("Content-Encoding")
+	err = protoparserutil.ReadUncompressedData(r.Body, contentEncoding, maxRequestSize, func(data []byte) error {
+		if netinsert.HasQueueTimestamp(version) {
+			if len(data) < netinsert.QueueTimestampSize {
+				return fmt.Errorf("too short data; got %d bytes; want at least %d bytes", len(data), netinsert.QueueTimestampSize)
+			}
+			updateQueueDelay(data[:netinsert.QueueTimestampSize])
+			data = data[netinsert.QueueTimestampSize:]
+		}
+
 		lmp := cp.NewLogMessageProcessor("internalinsert", false)
 		irp := lmp.(insertutil.InsertRowProcessor)
 		err := parseData(irp, data)
@@ -61,6 +71,17 @@ func RequestHandler(w http.ResponseWriter, r *http.Request) {
 	requestDuration.UpdateDuration(startTime)
 }
 
+// updateQueueDelay updates queueDelay with the time the data has spent in the persistent queue at vtinsert.
+func updateQueueDelay(queueTimestamp []byte) {
+	ts := int64(encoding.UnmarshalUint64(queueTimestamp))
+	delay := time.Now().Unix() - ts
+	if delay < 0 {
+		// The clock at vtinsert may be ahead of the clock at vtstorage.
+		delay = 0
+	}
+	queueDelay.Update(float64(delay))
+}
+
 func parseData(irp insertutil.InsertRowProcessor, data []byte) error {
 	r := logstorage.GetInsertRow()
 	src := data
@@ -85,4 +106,7 @@ var (
 	errorsTotal   = metrics.NewCounter(`vt_http_errors_total{path="/internal/insert"}`)
 
 	requestDuration = metrics.NewSummary(`vt_http_request_duration_seconds{path="/internal/insert"}`)
+
+	// queueDelay tracks the time the received data has spent in the persistent queue at vtinsert.
+	queueDelay = metrics.NewSummary(`vt_internalinsert_queue_delay_seconds`)
 )
@@ -0,0 +1,91 @@
+package jaeger
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/protoparser/protoparserutil"
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtinsert/insertutil"
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtinsert/opentelemetry"
+)
+
+var maxRequestSize = flagutil.NewBytes("jaeger.maxRequestSize", 64*1024*1024, "The maximum size in bytes of a single Jaeger JSON request "+
+	"sent to /insert/jaeger/json. See https://docs.victoriametrics.com/victoriatraces/data-ingestion/#jaeger-json-api")
+
+var (
+	requestsJSONTotal = metrics.NewCounter(`vt_http_requests_total{path="/insert/jaeger/json"}`)
+	errorsJSONTotal   = metrics.NewCounter(`vt_http_errors_total{path="/insert/jaeger/json"}`)
+
+	requestJSONDuration = metrics.NewSummary(`vt_http_request_duration_seconds{path="/insert/jaeger/json"}`)
+)
+
+// RequestHandler processes Jaeger insert requests
+func RequestHandler(path string, w http.ResponseWriter, r *http.Request) bool {
+	switch path {
+	case "/insert/jaeger/json":
+		handleJSONRequest(r, w)
+		return true
+	default:
+		return false
+	}
+}
+
+// handleJSONRequest handles /insert/jaeger/json request.
+//
+// The request body must contain traces in Jaeger JSON format such as the response of Jaeger /api/traces/<trace_id>
+// or the trace JSON downloaded from Jaeger UI. Spans are converted to OpenTelemetry format before being stored.
+func handleJSONRequest(r *http.Request, w http.ResponseWriter) {
+	startTime := time.Now()
+	requestsJSONTotal.Inc()
+
+	// Form-encoded body is consumed by the parsing of common params, so it cannot be imported.
+	// This is the default Content-Type for curl --data-binary.
+	if contentType := r.Header.Get("Content-Type"); strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		httpserver.Errorf(w, r, "Content-Type %s isn't supported; use application/json", contentType)
+		return
+	}
+
+	cp, err := opentelemetry.GetCommonParams(r)
+	if err != nil {
+		httpserver.Errorf(w, r, "cannot parse common params from request: %s", err)
+		return
+	}
+
+	if err = insertutil.CanWriteData(); err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+
+	encoding := r.Header.Get("Content-Encoding")
+	err = protoparserutil.ReadUncompressedData(r.Body, encoding, maxRequestSize, func(data []byte) error {
+		req, err := unmarshalTraces(data)
+		if err != nil {
+			errorsJSONTotal.Inc()
+			return err
+		}
+		lmp := cp.NewLogMessageProcessor("jaeger_json", false)
+		rejectedSpans, err := opentelemetry.PushExportTraceServiceRequest(req, cp, lmp)
+		lmp.MustClose()
+		if err == nil && rejectedSpans > 0 {
+			// Jaeger JSON has no way to report partially accepted data, so report the rejected spans as an error.
+			// The remaining spans are stored.
+			err = errors.New(opentelemetry.GetRejectedSpansMessage(rejectedSpans))
+		}
+		return err
+	})
+	if err != nil {
+		httpserver.Errorf(w, r, "cannot read Jaeger JSON data: %s", err)
+		return
+	}
+
+	// update requestJSONDuration only for successfully parsed requests
+	// There is no need in updating requestJSONDuration for request errors,
+	// since their timings are usually much smaller than the timing for successful request parsing.
+	requestJSONDuration.UpdateDuration(startTime)
+}
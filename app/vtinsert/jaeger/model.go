@@ -0,0 +1,354 @@
+package jaeger
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	otelpb "github.com/VictoriaMetrics/VictoriaTraces/lib/protoparser/opentelemetry/pb"
+)
+
+// traces represents Jaeger traces in JSON format returned by Jaeger UI and Jaeger HTTP JSON API.
+//
+// It may contain either `data` list with traces such as the response of /api/traces/<trace_id>
+// or a single trace such as the trace downloaded from Jaeger UI.
+//
+// See https://github.com/jaegertracing/jaeger-ui/blob/main/packages/jaeger-ui/src/types/trace.tsx
+type traces struct {
+	Data []*trace `json:"data"`
+
+	trace
+}
+
+type trace struct {
+	Spans     []*span             `json:"spans"`
+	Processes map[string]*process `json:"processes"`
+}
+
+type process struct {
+	ServiceName string      `json:"serviceName"`
+	Tags        []*keyValue `json:"tags"`
+}
+
+type span struct {
+	TraceID       string      `json:"traceID"`
+	SpanID        string      `json:"spanID"`
+	Flags         uint32      `json:"flags"`
+	OperationName string      `json:"operationName"`
+	References    []*spanRef  `json:"references"`
+	StartTime     int64       `json:"startTime"` // microseconds
+	Duration      int64       `json:"duration"`  // microseconds
+	Tags          []*keyValue `json:"tags"`
+	Logs          []*log      `json:"logs"`
+	ProcessID     string      `json:"processID"`
+
+	// Process is set instead of ProcessID in some Jaeger JSON formats.
+	Process *process `json:"process"`
+}
+
+type spanRef struct {
+	RefType string `json:"refType"`
+	TraceID string `json:"traceID"`
+	SpanID  string `json:"spanID"`
+}
+
+type keyValue struct {
+	Key   string          `json:"key"`
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+type log struct {
+	Timestamp int64       `json:"timestamp"` // microseconds
+	Fields    []*keyValue `json:"fields"`
+}
+
+// spanKinds maps Jaeger span.kind tag values to OTLP span kinds.
+var spanKinds = map[string]otelpb.SpanKind{
+	"internal": 1,
+	"server":   2,
+	"client":   3,
+	"producer": 4,
+	"consumer": 5,
+}
+
+// statusCodes maps Jaeger error and otel.status_code tag values to OTLP status codes.
+//
+// The error tag is returned as `true`, `false` or `unset` by VictoriaTraces Jaeger API.
+var statusCodes = map[string]otelpb.StatusCode{
+	"unset": 0,
+	"false": 1,
+	"true":  2,
+	"UNSET": 0,
+	"OK":    1,
+	"ERROR": 2,
+}
+
+// unmarshalTraces unmarshals Jaeger traces in JSON format from data and converts them to OTLP request.
+func unmarshalTraces(data []byte) (*otelpb.ExportTraceServiceRequest, error) {
+	var ts traces
+	if err := json.Unmarshal(data, &ts); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal Jaeger traces: %w", err)
+	}
+	if len(ts.Spans) > 0 {
+		ts.Data = append(ts.Data, &ts.trace)
+	}
+
+	var req otelpb.ExportTraceServiceRequest
+	for _, t := range ts.Data {
+		if err := t.appendResourceSpans(&req); err != nil {
+			return nil, err
+		}
+	}
+	return &req, nil
+}
+
+// appendResourceSpans converts t spans to OTLP and appends them to req.
+//
+// Spans are grouped by Jaeger processes and by instrumentation scopes stored in otel.scope.* span tags.
+func (t *trace) appendResourceSpans(req *otelpb.ExportTraceServiceRequest) error {
+	type resourceSpans struct {
+		rs     *otelpb.ResourceSpans
+		scopes map[string]*otelpb.ScopeSpans
+	}
+	resources := make(map[*process]*resourceSpans)
+
+	for _, s := range t.Spans {
+		p := s.Process
+		if p == nil {
+			p = t.Processes[s.ProcessID]
+			if p == nil {
+				return fmt.Errorf("missing process %q for span %q", s.ProcessID, s.SpanID)
+			}
+		}
+		r := resources[p]
+		if r == nil {
+			resource, err := p.toResource()
+			if err != nil {
+				return err
+			}
+			r = &resourceSpans{
+				rs: &otelpb.ResourceSpans{
+					Resource: *resource,
+				},
+				scopes: make(map[string]*otelpb.ScopeSpans),
+			}
+			resources[p] = r
+			req.ResourceSpans = append(req.ResourceSpans, r.rs)
+		}
+
+		sp, scope, err := s.toSpan()
+		if err != nil {
+			return err
+		}
+		scopeKey := getScopeKey(scope)
+		ss := r.scopes[scopeKey]
+		if ss == nil {
+			ss = &otelpb.ScopeSpans{
+				Scope: *scope,
+			}
+			r.scopes[scopeKey] = ss
+			r.rs.ScopeSpans = append(r.rs.ScopeSpans, ss)
+		}
+		ss.Spans = append(ss.Spans, sp)
+	}
+	return nil
+}
+
+func (p *process) toResource() (*otelpb.Resource, error) {
+	serviceName := p.ServiceName
+	r := &otelpb.Resource{
+		Attributes: []*otelpb.KeyValue{{
+			Key: "service.name",
+			Value: &otelpb.AnyValue{
+				StringValue: &serviceName,
+			},
+		}},
+	}
+	for _, tag := range p.Tags {
+		if tag.Key == "vt.region" || tag.Key == "service.name" {
+			// vt.region is added by VictoriaTraces Jaeger API, while service.name is already set from the serviceName.
+			continue
+		}
+		kv, err := tag.toKeyValue()
+		if err != nil {
+			return nil, err
+		}
+		r.Attributes = append(r.Attributes, kv)
+	}
+	return r, nil
+}
+
+// toSpan converts s to OTLP span with the instrumentation scope.
+//
+// Jaeger tags with special meaning such as span.kind, error or otel.scope.name are converted to the corresponding OTLP fields.
+// See app/vtselect/traces/jaeger for the reverse conversion.
+func (s *span) toSpan() (*otelpb.Span, *otelpb.InstrumentationScope, error) {
+	sp := &otelpb.Span{
+		TraceID:           normalizeID(s.TraceID, 32),
+		SpanID:            normalizeID(s.SpanID, 16),
+		Flags:             s.Flags,
+		Name:              s.OperationName,
+		StartTimeUnixNano: uint64(s.StartTime) * 1000,
+		EndTimeUnixNano:   uint64(s.StartTime+s.Duration) * 1000,
+	}
+	if sp.TraceID == "" || sp.SpanID == "" {
+		return nil, nil, fmt.Errorf("missing traceID or spanID in span %q", s.SpanID)
+	}
+	scope := &otelpb.InstrumentationScope{}
+
+	for _, tag := range s.Tags {
+		v := tag.getString()
+		switch tag.Key {
+		case "span.kind":
+			sp.Kind = spanKinds[v]
+			continue
+		case "error", "otel.status_code":
+			sp.Status.Code = statusCodes[v]
+			continue
+		case "otel.status_description":
+			sp.Status.Message = v
+			continue
+		case "w3c.tracestate":
+			sp.TraceState = v
+			continue
+		case "otel.scope.name", "otel.library.name":
+			scope.Name = v
+			continue
+		case "otel.scope.version", "otel.library.version":
+			scope.Version = v
+			continue
+		case "vt.original_name":
+			// The span name has been normalized by VictoriaTraces, so restore the original name.
+			sp.Name = v
+			continue
+		}
+
+		kv, err := tag.toKeyValue()
+		if err != nil {
+			return nil, nil, err
+		}
+		if strings.HasPrefix(tag.Key, otelpb.InstrumentationScopeAttrPrefix) {
+			// VictoriaTraces Jaeger API returns scope attributes with scope_attr: prefix.
+			kv.Key = strings.TrimPrefix(tag.Key, otelpb.InstrumentationScopeAttrPrefix)
+			scope.Attributes = append(scope.Attributes, kv)
+			continue
+		}
+		sp.Attributes = append(sp.Attributes, kv)
+	}
+
+	for _, ref := range s.References {
+		traceID := normalizeID(ref.TraceID, 32)
+		spanID := normalizeID(ref.SpanID, 16)
+		if ref.RefType == "CHILD_OF" && sp.ParentSpanID == "" && traceID == sp.TraceID {
+			sp.ParentSpanID = spanID
+			continue
+		}
+		link := &otelpb.SpanLink{
+			TraceID: traceID,
+			SpanID:  spanID,
+		}
+		if ref.RefType == "CHILD_OF" {
+			// Links are returned with FOLLOWS_FROM reference type by default, so keep the reference type in the attribute.
+			refType := "child_of"
+			link.Attributes = append(link.Attributes, &otelpb.KeyValue{
+				Key: "opentracing.ref_type",
+				Value: &otelpb.AnyValue{
+					StringValue: &refType,
+				},
+			})
+		}
+		sp.Links = append(sp.Links, link)
+	}
+
+	for _, l := range s.Logs {
+		event := &otelpb.SpanEvent{
+			TimeUnixNano: uint64(l.Timestamp) * 1000,
+		}
+		for _, field := range l.Fields {
+			if field.Key == "event" {
+				event.Name = field.getString()
+				continue
+			}
+			kv, err := field.toKeyValue()
+			if err != nil {
+				return nil, nil, err
+			}
+			event.Attributes = append(event.Attributes, kv)
+		}
+		sp.Events = append(sp.Events, event)
+	}
+
+	return sp, scope, nil
+}
+
+// getScopeKey returns the key, which uniquely identifies the given instrumentation scope.
+func getScopeKey(scope *otelpb.InstrumentationScope) string {
+	b := strconv.AppendQuote(nil, scope.Name)
+	b = strconv.AppendQuote(b, scope.Version)
+	for _, kv := range scope.Attributes {
+		b = strconv.AppendQuote(b, kv.Key)
+		b = strconv.AppendQuote(b, otelpb.FormatAttrValue(kv.Value))
+	}
+	return string(b)
+}
+
+// normalizeID converts Jaeger trace id or span id to the hex-encoded OTLP id with the given length.
+//
+// Jaeger may omit leading zeros in ids, while 64-bit trace ids are used by some Jaeger clients.
+func normalizeID(id string, length int) string {
+	id = strings.ToLower(id)
+	if id != "" && len(id) < length {
+		id = strings.Repeat("0", length-len(id)) + id
+	}
+	return id
+}
+
+// getString returns the string representation of kv value.
+func (kv *keyValue) getString() string {
+	var s string
+	if err := json.Unmarshal(kv.Value, &s); err == nil {
+		return s
+	}
+	return string(kv.Value)
+}
+
+// toKeyValue converts kv to OTLP attribute with the type according to kv.Type.
+func (kv *keyValue) toKeyValue() (*otelpb.KeyValue, error) {
+	s := kv.getString()
+	av := &otelpb.AnyValue{}
+	switch strings.ToLower(kv.Type) {
+	case "bool":
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse bool value for tag %q: %w", kv.Key, err)
+		}
+		av.BoolValue = &b
+	case "int64":
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse int64 value for tag %q: %w", kv.Key, err)
+		}
+		av.IntValue = &n
+	case "float64":
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse float64 value for tag %q: %w", kv.Key, err)
+		}
+		av.DoubleValue = &f
+	case "binary":
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse base64-encoded binary value for tag %q: %w", kv.Key, err)
+		}
+		av.BytesValue = &b
+	default:
+		av.StringValue = &s
+	}
+	return &otelpb.KeyValue{
+		Key:   kv.Key,
+		Value: av,
+	}, nil
+}
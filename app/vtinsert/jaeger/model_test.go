@@ -0,0 +1,206 @@
+package jaeger
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	otelpb "github.com/VictoriaMetrics/VictoriaTraces/lib/protoparser/opentelemetry/pb"
+)
+
+func TestUnmarshalTracesFailure(t *testing.T) {
+	f := func(data string) {
+		t.Helper()
+
+		if _, err := unmarshalTraces([]byte(data)); err == nil {
+			t.Fatalf("expecting non-nil error for %s", data)
+		}
+	}
+
+	// invalid JSON
+	f(`{"data":`)
+
+	// missing process
+	f(`{"data":[{"spans":[{"traceID":"1","spanID":"2","processID":"p1"}]}]}`)
+
+	// missing span id
+	f(`{"spans":[{"traceID":"1","processID":"p1"}],"processes":{"p1":{"serviceName":"foo"}}}`)
+
+	// invalid tag values
+	f(`{"spans":[{"traceID":"1","spanID":"2","processID":"p1","tags":[{"key":"a","type":"int64","value":"foo"}]}],"processes":{"p1":{"serviceName":"foo"}}}`)
+	f(`{"spans":[{"traceID":"1","spanID":"2","processID":"p1","tags":[{"key":"a","type":"binary","value":"!!!"}]}],"processes":{"p1":{"serviceName":"foo"}}}`)
+}
+
+func TestUnmarshalTracesSuccess(t *testing.T) {
+	f := func(data string, resultExpected *otelpb.ExportTraceServiceRequest) {
+		t.Helper()
+
+		result, err := unmarshalTraces([]byte(data))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !cmp.Equal(result, resultExpected) {
+			t.Fatalf("unexpected result; diff = %s", cmp.Diff(result, resultExpected))
+		}
+	}
+
+	str := func(s string) *otelpb.AnyValue {
+		return &otelpb.AnyValue{StringValue: &s}
+	}
+	i64 := func(n int64) *otelpb.AnyValue {
+		return &otelpb.AnyValue{IntValue: &n}
+	}
+	b := func(v bool) *otelpb.AnyValue {
+		return &otelpb.AnyValue{BoolValue: &v}
+	}
+	f64 := func(v float64) *otelpb.AnyValue {
+		return &otelpb.AnyValue{DoubleValue: &v}
+	}
+	bytesValue := func(v []byte) *otelpb.AnyValue {
+		return &otelpb.AnyValue{BytesValue: &v}
+	}
+
+	// empty data
+	f(`{"data":[]}`, &otelpb.ExportTraceServiceRequest{})
+
+	// Jaeger API response with all the supported fields
+	f(`{"data":[{
+		"traceID":"abc",
+		"spans":[{
+			"traceID":"ABC","spanID":"1","flags":1,"operationName":"GET /foo","processID":"p1",
+			"references":[
+				{"refType":"CHILD_OF","traceID":"abc","spanID":"2"},
+				{"refType":"FOLLOWS_FROM","traceID":"def","spanID":"3"},
+				{"refType":"CHILD_OF","traceID":"def","spanID":"4"}
+			],
+			"startTime":1000,"duration":20,
+			"tags":[
+				{"key":"span.kind","type":"string","value":"server"},
+				{"key":"error","type":"string","value":"true"},
+				{"key":"otel.status_description","type":"string","value":"failed"},
+				{"key":"w3c.tracestate","type":"string","value":"k=v"},
+				{"key":"otel.scope.name","type":"string","value":"lib"},
+				{"key":"otel.scope.version","type":"string","value":"v1"},
+				{"key":"scope_attr:sa","type":"string","value":"sv"},
+				{"key":"vt.original_name","type":"string","value":"GET /foo/123"},
+				{"key":"http.status_code","type":"int64","value":500},
+				{"key":"retried","type":"bool","value":true},
+				{"key":"ratio","type":"float64","value":0.5},
+				{"key":"payload","type":"binary","value":"AQI="},
+				{"key":"msg","type":"string","value":"hello"}
+			],
+			"logs":[{"timestamp":1010,"fields":[
+				{"key":"event","type":"string","value":"exception"},
+				{"key":"exception.type","type":"string","value":"io"}
+			]}]
+		}],
+		"processes":{"p1":{"serviceName":"svc","tags":[
+			{"key":"host.name","type":"string","value":"h1"},
+			{"key":"vt.region","type":"string","value":"eu"}
+		]}}
+	}]}`, &otelpb.ExportTraceServiceRequest{
+		ResourceSpans: []*otelpb.ResourceSpans{{
+			Resource: otelpb.Resource{
+				Attributes: []*otelpb.KeyValue{
+					{Key: "service.name", Value: str("svc")},
+					{Key: "host.name", Value: str("h1")},
+				},
+			},
+			ScopeSpans: []*otelpb.ScopeSpans{{
+				Scope: otelpb.InstrumentationScope{
+					Name:    "lib",
+					Version: "v1",
+					Attributes: []*otelpb.KeyValue{
+						{Key: "sa", Value: str("sv")},
+					},
+				},
+				Spans: []*otelpb.Span{{
+					TraceID:           "00000000000000000000000000000abc",
+					SpanID:            "0000000000000001",
+					TraceState:        "k=v",
+					ParentSpanID:      "0000000000000002",
+					Flags:             1,
+					Name:              "GET /foo/123",
+					Kind:              2,
+					StartTimeUnixNano: 1000000,
+					EndTimeUnixNano:   1020000,
+					Attributes: []*otelpb.KeyValue{
+						{Key: "http.status_code", Value: i64(500)},
+						{Key: "retried", Value: b(true)},
+						{Key: "ratio", Value: f64(0.5)},
+						{Key: "payload", Value: bytesValue([]byte{1, 2})},
+						{Key: "msg", Value: str("hello")},
+					},
+					Events: []*otelpb.SpanEvent{{
+						TimeUnixNano: 1010000,
+						Name:         "exception",
+						Attributes: []*otelpb.KeyValue{
+							{Key: "exception.type", Value: str("io")},
+						},
+					}},
+					Links: []*otelpb.SpanLink{
+						{
+							TraceID: "00000000000000000000000000000def",
+							SpanID:  "0000000000000003",
+						},
+						{
+							TraceID: "00000000000000000000000000000def",
+							SpanID:  "0000000000000004",
+							Attributes: []*otelpb.KeyValue{
+								{Key: "opentracing.ref_type", Value: str("child_of")},
+							},
+						},
+					},
+					Status: otelpb.Status{
+						Message: "failed",
+						Code:    2,
+					},
+				}},
+			}},
+		}},
+	})
+
+	// single trace downloaded from Jaeger UI with spans grouped by processes and scopes
+	f(`{
+		"spans":[
+			{"traceID":"1","spanID":"1","operationName":"a","processID":"p1","startTime":1,"duration":1},
+			{"traceID":"1","spanID":"2","operationName":"b","processID":"p2","startTime":1,"duration":1},
+			{"traceID":"1","spanID":"3","operationName":"c","processID":"p1","startTime":1,"duration":1,
+				"tags":[{"key":"otel.library.name","type":"string","value":"lib"}]},
+			{"traceID":"1","spanID":"4","operationName":"d","processID":"p1","startTime":1,"duration":1}
+		],
+		"processes":{"p1":{"serviceName":"svc1"},"p2":{"serviceName":"svc2"}}
+	}`, &otelpb.ExportTraceServiceRequest{
+		ResourceSpans: []*otelpb.ResourceSpans{
+			{
+				Resource: otelpb.Resource{
+					Attributes: []*otelpb.KeyValue{{Key: "service.name", Value: str("svc1")}},
+				},
+				ScopeSpans: []*otelpb.ScopeSpans{
+					{
+						Spans: []*otelpb.Span{
+							{TraceID: "00000000000000000000000000000001", SpanID: "0000000000000001", Name: "a", StartTimeUnixNano: 1000, EndTimeUnixNano: 2000},
+							{TraceID: "00000000000000000000000000000001", SpanID: "0000000000000004", Name: "d", StartTimeUnixNano: 1000, EndTimeUnixNano: 2000},
+						},
+					},
+					{
+						Scope: otelpb.InstrumentationScope{Name: "lib"},
+						Spans: []*otelpb.Span{
+							{TraceID: "00000000000000000000000000000001", SpanID: "0000000000000003", Name: "c", StartTimeUnixNano: 1000, EndTimeUnixNano: 2000},
+						},
+					},
+				},
+			},
+			{
+				Resource: otelpb.Resource{
+					Attributes: []*otelpb.KeyValue{{Key: "service.name", Value: str("svc2")}},
+				},
+				ScopeSpans: []*otelpb.ScopeSpans{{
+					Spans: []*otelpb.Span{
+						{TraceID: "00000000000000000000000000000001", SpanID: "0000000000000002", Name: "b", StartTimeUnixNano: 1000, EndTimeUnixNano: 2000},
+					},
+				}},
+			},
+		},
+	})
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
 
 	"github.com/VictoriaMetrics/VictoriaTraces/app/vtinsert/internalinsert"
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtinsert/jaeger"
 	"github.com/VictoriaMetrics/VictoriaTraces/app/vtinsert/opentelemetry"
 )
 
@@ -19,10 +20,12 @@ var (
 
 // Init initializes vtinsert
 func Init() {
+	opentelemetry.Init()
 }
 
 // Stop stops vtinsert
 func Stop() {
+	opentelemetry.Stop()
 }
 
 // RequestHandler handles insert requests for VictoriaLogs
@@ -61,6 +64,8 @@ func insertHandler(w http.ResponseWriter, r *http.Request, path string) bool {
 	switch {
 	case strings.HasPrefix(path, "/insert/opentelemetry/"):
 		return opentelemetry.RequestHandler(path, w, r)
+	case strings.HasPrefix(path, "/insert/jaeger/"):
+		return jaeger.RequestHandler(path, w, r)
 	}
 
 	return false
@@ -0,0 +1,140 @@
+package opentelemetry
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/protoparser/protoparserutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/writeconcurrencylimiter"
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtinsert/insertutil"
+	otelpb "github.com/VictoriaMetrics/VictoriaTraces/lib/protoparser/opentelemetry/pb"
+)
+
+var (
+	requestsJSONLinesTotal = metrics.NewCounter(`vt_http_requests_total{path="/insert/opentelemetry/v1/traces/jsonl"}`)
+	errorsJSONLinesTotal   = metrics.NewCounter(`vt_http_errors_total{path="/insert/opentelemetry/v1/traces/jsonl"}`)
+
+	requestJSONLinesDuration = metrics.NewSummary(`vt_http_request_duration_seconds{path="/insert/opentelemetry/v1/traces/jsonl"}`)
+)
+
+var invalidJSONLinesLogger = logger.WithThrottler("opentelemetry_invalid_json_lines", 5*time.Second)
+
+// handleJSONLinesRequest handles /insert/opentelemetry/v1/traces/jsonl request.
+//
+// The request body must contain newline-delimited ExportTraceServiceRequest JSON objects
+// such as files written by the OpenTelemetry collector file exporter.
+// The body is processed in a streaming manner, so only a single line must fit -opentelemetry.traces.maxRequestSize.
+func handleJSONLinesRequest(r *http.Request, w http.ResponseWriter) {
+	startTime := time.Now()
+	requestsJSONLinesTotal.Inc()
+
+	// Form-encoded body is consumed by the parsing of common params, so it cannot be imported.
+	// This is the default Content-Type for curl --data-binary.
+	if contentType := r.Header.Get("Content-Type"); strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		httpserver.Errorf(w, r, "Content-Type %s isn't supported; use application/stream+json", contentType)
+		return
+	}
+
+	cp, err := GetCommonParams(r)
+	if err != nil {
+		httpserver.Errorf(w, r, "cannot parse common params from request: %s", err)
+		return
+	}
+
+	if err = insertutil.CanWriteData(); err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+
+	// Every request may hold a line buffer of up to -opentelemetry.traces.maxRequestSize bytes,
+	// so the number of concurrently processed requests is limited by -maxConcurrentInserts in the same way as for other insert requests.
+	wcr := writeconcurrencylimiter.GetReader(r.Body)
+	defer writeconcurrencylimiter.PutReader(wcr)
+
+	reader, err := protoparserutil.GetUncompressedReader(wcr, r.Header.Get("Content-Encoding"))
+	if err != nil {
+		httpserver.Errorf(w, r, "cannot read OpenTelemetry JSON lines: %s", err)
+		return
+	}
+	defer protoparserutil.PutUncompressedReader(reader)
+
+	rejectedSpans := 0
+	lmp := cp.NewLogMessageProcessor("opentelemetry_traces_jsonl", true)
+	skippedLines, err := processJSONLines(reader, maxRequestSize.IntN(), func(line []byte) error {
+		var req otelpb.ExportTraceServiceRequest
+		if err := req.UnmarshalJSONCustom(line); err != nil {
+			return err
+		}
+		n, err := PushExportTraceServiceRequest(&req, cp, lmp)
+		rejectedSpans += n
+		return err
+	})
+	lmp.MustClose()
+	if err != nil {
+		errorsJSONLinesTotal.Inc()
+		httpserver.Errorf(w, r, "cannot read OpenTelemetry JSON lines: %s", err)
+		return
+	}
+	if skippedLines > 0 {
+		// Notify the client about skipped lines, so it could notice the data loss.
+		errorMessage := fmt.Sprintf("%d invalid lines have been skipped; see vtinsert logs for details", skippedLines)
+		if rejectedSpans > 0 {
+			errorMessage += "; " + GetRejectedSpansMessage(rejectedSpans)
+		}
+		writePartialSuccessResponse(w, contentTypeJSON, rejectedSpans, errorMessage)
+	} else {
+		writeExportTraceServiceResponse(w, contentTypeJSON, rejectedSpans)
+	}
+	// update requestJSONLinesDuration only for successfully parsed requests
+	// There is no need in updating requestJSONLinesDuration for request errors,
+	// since their timings are usually much smaller than the timing for successful request parsing.
+	requestJSONLinesDuration.UpdateDuration(startTime)
+}
+
+// processJSONLines calls callback for every non-empty line read from r and returns the number of skipped lines.
+//
+// Lines, which cannot be processed by callback, are logged and skipped, so a single invalid line doesn't prevent from importing the rest of lines.
+// An error is returned if the line exceeds maxLineSize, if r cannot be read or if all the lines have been skipped.
+func processJSONLines(r io.Reader, maxLineSize int, callback func(line []byte) error) (int, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, maxLineSize)
+	lineNum := 0
+	processedLines := 0
+	skippedLines := 0
+	var lastErr error
+	for sc.Scan() {
+		lineNum++
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if err := callback(line); err != nil {
+			errorsJSONLinesTotal.Inc()
+			invalidJSONLinesLogger.Warnf("skipping line #%d: %s", lineNum, err)
+			skippedLines++
+			lastErr = fmt.Errorf("line #%d: %w", lineNum, err)
+			continue
+		}
+		processedLines++
+	}
+	if err := sc.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return skippedLines, fmt.Errorf("line #%d exceeds -opentelemetry.traces.maxRequestSize=%d bytes", lineNum+1, maxLineSize)
+		}
+		return skippedLines, fmt.Errorf("cannot read line #%d: %w", lineNum+1, err)
+	}
+	if processedLines == 0 && skippedLines > 0 {
+		return skippedLines, fmt.Errorf("all the %d lines are invalid; the last error: %w", skippedLines, lastErr)
+	}
+	return skippedLines, nil
+}
@@ -0,0 +1,47 @@
+package opentelemetry
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProcessJSONLines(t *testing.T) {
+	f := func(data string, maxLineSize int, linesExpected []string, skippedLinesExpected int, isErrorExpected bool) {
+		t.Helper()
+
+		var lines []string
+		skippedLines, err := processJSONLines(strings.NewReader(data), maxLineSize, func(line []byte) error {
+			if string(line) == "invalid" {
+				return fmt.Errorf("invalid line")
+			}
+			lines = append(lines, string(line))
+			return nil
+		})
+		if isErrorExpected != (err != nil) {
+			t.Fatalf("unexpected error: %v; isErrorExpected=%v", err, isErrorExpected)
+		}
+		if !reflect.DeepEqual(lines, linesExpected) {
+			t.Fatalf("unexpected lines; got %q; want %q", lines, linesExpected)
+		}
+		if skippedLines != skippedLinesExpected {
+			t.Fatalf("unexpected number of skipped lines; got %d; want %d", skippedLines, skippedLinesExpected)
+		}
+	}
+
+	// empty data
+	f("", 100, nil, 0, false)
+
+	// empty lines are skipped
+	f("\n  \n{}\n\n{\"a\":1}", 100, []string{"{}", `{"a":1}`}, 0, false)
+
+	// invalid lines are skipped
+	f("{}\ninvalid\r\n{\"a\":1}\n", 100, []string{"{}", `{"a":1}`}, 1, false)
+
+	// all the lines are invalid
+	f("invalid\n\ninvalid\n", 100, nil, 2, true)
+
+	// too long line
+	f("{}\n{\"foo\":\"bar\"}\n{}", 10, []string{"{}"}, 0, true)
+}
@@ -1,6 +1,7 @@
 package opentelemetry
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -9,16 +10,19 @@ import (
 	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/protoparser/protoparserutil"
 	"github.com/VictoriaMetrics/fastcache"
 	"github.com/VictoriaMetrics/metrics"
 	"github.com/cespare/xxhash/v2"
 
 	"github.com/VictoriaMetrics/VictoriaTraces/app/vtinsert/insertutil"
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtstorage/retention"
 	otelpb "github.com/VictoriaMetrics/VictoriaTraces/lib/protoparser/opentelemetry/pb"
 )
 
-var maxRequestSize = flagutil.NewBytes("opentelemetry.traces.maxRequestSize", 64*1024*1024, "The maximum size in bytes of a single OpenTelemetry trace export request.")
+var maxRequestSize = flagutil.NewBytes("opentelemetry.traces.maxRequestSize", 64*1024*1024, "The maximum size in bytes of a single OpenTelemetry trace export request. "+
+	"It limits the size of a single line for /insert/opentelemetry/v1/traces/jsonl")
 
 var (
 	requestsProtobufTotal = metrics.NewCounter(`vt_http_requests_total{path="/insert/opentelemetry/v1/traces",format="protobuf"}`)
@@ -45,6 +49,16 @@ const (
 	contentTypeJSON     = "application/json"
 )
 
+// Init initializes OpenTelemetry data ingestion.
+func Init() {
+	mustInitSpanNameNormalizer()
+}
+
+// Stop stops OpenTelemetry data ingestion.
+func Stop() {
+	spanNameNormalizer = nil
+}
+
 // RequestHandler processes Opentelemetry insert requests
 func RequestHandler(path string, w http.ResponseWriter, r *http.Request) bool {
 	switch path {
@@ -52,6 +66,9 @@ func RequestHandler(path string, w http.ResponseWriter, r *http.Request) bool {
 	// https://opentelemetry.io/docs/specs/otlp/#otlphttp-request
 	case "/insert/opentelemetry/v1/traces":
 		return handleTracesRequest(r, w)
+	case "/insert/opentelemetry/v1/traces/jsonl":
+		handleJSONLinesRequest(r, w)
+		return true
 	default:
 		return false
 	}
@@ -74,21 +91,18 @@ func handleProtobufRequest(r *http.Request, w http.ResponseWriter) {
 	startTime := time.Now()
 	requestsProtobufTotal.Inc()
 
-	cp, err := insertutil.GetCommonParams(r)
+	cp, err := GetCommonParams(r)
 	if err != nil {
 		httpserver.Errorf(w, r, "cannot parse common params from request: %s", err)
 		return
 	}
-	// stream fields must contain the service name and span name.
-	// by using arguments and headers, users can also add other fields as stream fields
-	// for potentially better efficiency.
-	cp.StreamFields = append(mandatoryStreamFields, cp.StreamFields...)
 
 	if err = insertutil.CanWriteData(); err != nil {
 		httpserver.Errorf(w, r, "%s", err)
 		return
 	}
 
+	rejectedSpans := 0
 	encoding := r.Header.Get("Content-Encoding")
 	err = protoparserutil.ReadUncompressedData(r.Body, encoding, maxRequestSize, func(data []byte) error {
 		var (
@@ -100,7 +114,7 @@ func handleProtobufRequest(r *http.Request, w http.ResponseWriter) {
 			errorsProtobufTotal.Inc()
 			return fmt.Errorf("cannot unmarshal request from %d protobuf bytes: %w", len(data), callbackErr)
 		}
-		callbackErr = pushExportTraceServiceRequest(&req, lmp)
+		rejectedSpans, callbackErr = PushExportTraceServiceRequest(&req, cp, lmp)
 		lmp.MustClose()
 		return callbackErr
 	})
@@ -108,6 +122,7 @@ func handleProtobufRequest(r *http.Request, w http.ResponseWriter) {
 		httpserver.Errorf(w, r, "cannot read OpenTelemetry protocol data: %s", err)
 		return
 	}
+	writeExportTraceServiceResponse(w, contentTypeProtobuf, rejectedSpans)
 	// update requestProtobufDuration only for successfully parsed requests
 	// There is no need in updating requestProtobufDuration for request errors,
 	// since their timings are usually much smaller than the timing for successful request parsing.
@@ -118,21 +133,18 @@ func handleJSONRequest(r *http.Request, w http.ResponseWriter) {
 	startTime := time.Now()
 	requestsJSONTotal.Inc()
 
-	cp, err := insertutil.GetCommonParams(r)
+	cp, err := GetCommonParams(r)
 	if err != nil {
 		httpserver.Errorf(w, r, "cannot parse common params from request: %s", err)
 		return
 	}
-	// stream fields must contain the service name and span name.
-	// by using arguments and headers, users can also add other fields as stream fields
-	// for potentially better efficiency.
-	cp.StreamFields = append(mandatoryStreamFields, cp.StreamFields...)
 
 	if err = insertutil.CanWriteData(); err != nil {
 		httpserver.Errorf(w, r, "%s", err)
 		return
 	}
 
+	rejectedSpans := 0
 	encoding := r.Header.Get("Content-Encoding")
 	err = protoparserutil.ReadUncompressedData(r.Body, encoding, maxRequestSize, func(data []byte) error {
 		var (
@@ -144,7 +156,7 @@ func handleJSONRequest(r *http.Request, w http.ResponseWriter) {
 			errorsJSONTotal.Inc()
 			return fmt.Errorf("cannot unmarshal request from %d protobuf bytes: %w", len(data), callbackErr)
 		}
-		callbackErr = pushExportTraceServiceRequest(&req, lmp)
+		rejectedSpans, callbackErr = PushExportTraceServiceRequest(&req, cp, lmp)
 		lmp.MustClose()
 		return callbackErr
 	})
@@ -152,27 +164,98 @@ func handleJSONRequest(r *http.Request, w http.ResponseWriter) {
 		httpserver.Errorf(w, r, "cannot read OpenTelemetry protocol data: %s", err)
 		return
 	}
+	writeExportTraceServiceResponse(w, contentTypeJSON, rejectedSpans)
 	// update requestJSONDuration only for successfully parsed requests
 	// There is no need in updating requestJSONDuration for request errors,
 	// since their timings are usually much smaller than the timing for successful request parsing.
 	requestJSONDuration.UpdateDuration(startTime)
 }
 
-func pushExportTraceServiceRequest(req *otelpb.ExportTraceServiceRequest, lmp insertutil.LogMessageProcessor) error {
+// writeExportTraceServiceResponse notifies the client about rejectedSpans via OTLP partial success response in the given contentType.
+//
+// Nothing is written if all the spans are accepted. See https://opentelemetry.io/docs/specs/otlp/#partial-success-1
+func writeExportTraceServiceResponse(w http.ResponseWriter, contentType string, rejectedSpans int) {
+	if rejectedSpans == 0 {
+		return
+	}
+	writePartialSuccessResponse(w, contentType, rejectedSpans, GetRejectedSpansMessage(rejectedSpans))
+}
+
+// writePartialSuccessResponse writes OTLP partial success response with the given rejectedSpans and errorMessage in the given contentType.
+//
+// The errorMessage may be non-empty for zero rejectedSpans, e.g. for warnings. See https://opentelemetry.io/docs/specs/otlp/#partial-success-1
+func writePartialSuccessResponse(w http.ResponseWriter, contentType string, rejectedSpans int, errorMessage string) {
+	resp := &otelpb.ExportTraceServiceResponse{
+		PartialSuccess: otelpb.ExportTracePartialSuccess{
+			RejectedSpans: int64(rejectedSpans),
+			ErrorMessage:  errorMessage,
+		},
+	}
+	w.Header().Set("Content-Type", contentType)
+	if contentType == contentTypeProtobuf {
+		_, _ = w.Write(resp.MarshalProtobuf(nil))
+		return
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		logger.Panicf("BUG: cannot marshal OpenTelemetry response: %s", err)
+	}
+	_, _ = w.Write(data)
+}
+
+// GetRejectedSpansMessage returns the message for the client about rejectedSpans outside the retention.
+func GetRejectedSpansMessage(rejectedSpans int) string {
+	return fmt.Sprintf("%d spans have been rejected, since they are outside the retention; "+
+		"see https://docs.victoriametrics.com/victoriatraces/#retention-policies and https://docs.victoriametrics.com/victoriatraces/#retention-filters", rejectedSpans)
+}
+
+// GetCommonParams returns common params for ingesting spans from r.
+func GetCommonParams(r *http.Request) (*insertutil.CommonParams, error) {
+	cp, err := insertutil.GetCommonParams(r)
+	if err != nil {
+		return nil, err
+	}
+	// stream fields must contain the service name and span name.
+	// by using arguments and headers, users can also add other fields as stream fields
+	// for potentially better efficiency.
+	cp.StreamFields = append(mandatoryStreamFields, cp.StreamFields...)
+	return cp, nil
+}
+
+// PushExportTraceServiceRequest stores spans from req via lmp.
+//
+// Spans from other formats such as Jaeger JSON must be converted to req before storing,
+// so they are stored in the same way as OTLP spans.
+//
+// Spans outside their retention aren't stored. See -retention.configFile and -retentionFilter.configFile.
+// The number of such spans is returned, so it could be reported to the client.
+func PushExportTraceServiceRequest(req *otelpb.ExportTraceServiceRequest, cp *insertutil.CommonParams, lmp insertutil.LogMessageProcessor) (int, error) {
+	snc := spanNameNormalizer
+	rejectedSpans := 0
 	var commonFields []logstorage.Field
 	for _, rs := range req.ResourceSpans {
 		commonFields = commonFields[:0]
 		attributes := rs.Resource.Attributes
 		commonFields = appendKeyValuesWithPrefix(commonFields, attributes, "", otelpb.ResourceAttrPrefix)
+		commonFields = appendAttrTypes(commonFields, attributes, otelpb.ResourceAttrTypesField)
+		commonFields = append(commonFields, logstorage.Field{
+			Name:  otelpb.ResourceDroppedAttributesCountField,
+			Value: strconv.FormatUint(uint64(rs.Resource.DroppedAttributesCount), 10),
+		}, logstorage.Field{
+			Name:  otelpb.ResourceSchemaURLField,
+			Value: rs.SchemaURL,
+		})
 		commonFieldsLen := len(commonFields)
 		for _, ss := range rs.ScopeSpans {
-			commonFields = pushFieldsFromScopeSpans(ss, commonFields[:commonFieldsLen], lmp)
+			var n int
+			commonFields, n = pushFieldsFromScopeSpans(ss, commonFields[:commonFieldsLen], cp, lmp, snc)
+			rejectedSpans += n
 		}
 	}
-	return nil
+	return rejectedSpans, nil
 }
 
-func pushFieldsFromScopeSpans(ss *otelpb.ScopeSpans, commonFields []logstorage.Field, lmp insertutil.LogMessageProcessor) []logstorage.Field {
+func pushFieldsFromScopeSpans(ss *otelpb.ScopeSpans, commonFields []logstorage.Field, cp *insertutil.CommonParams, lmp insertutil.LogMessageProcessor, snc *spanNameConfig) ([]logstorage.Field, int) {
 	commonFields = append(commonFields, logstorage.Field{
 		Name:  otelpb.InstrumentationScopeName,
 		Value: ss.Scope.Name,
@@ -181,14 +264,38 @@ func pushFieldsFromScopeSpans(ss *otelpb.ScopeSpans, commonFields []logstorage.F
 		Value: ss.Scope.Version,
 	})
 	commonFields = appendKeyValuesWithPrefix(commonFields, ss.Scope.Attributes, "", otelpb.InstrumentationScopeAttrPrefix)
+	commonFields = appendAttrTypes(commonFields, ss.Scope.Attributes, otelpb.InstrumentationScopeAttrTypes)
+	commonFields = append(commonFields, logstorage.Field{
+		Name:  otelpb.InstrumentationScopeDroppedAttributesCount,
+		Value: strconv.FormatUint(uint64(ss.Scope.DroppedAttributesCount), 10),
+	}, logstorage.Field{
+		Name:  otelpb.InstrumentationScopeSchemaURL,
+		Value: ss.SchemaURL,
+	})
 	commonFieldsLen := len(commonFields)
+	rejectedSpans := 0
 	for _, span := range ss.Spans {
-		commonFields = pushFieldsFromSpan(span, commonFields[:commonFieldsLen], lmp)
+		var ok bool
+		commonFields, ok = pushFieldsFromSpan(span, commonFields[:commonFieldsLen], cp, lmp, snc)
+		if !ok {
+			rejectedSpans++
+		}
 	}
-	return commonFields
+	return commonFields, rejectedSpans
 }
 
-func pushFieldsFromSpan(span *otelpb.Span, scopeCommonFields []logstorage.Field, lmp insertutil.LogMessageProcessor) []logstorage.Field {
+// pushFieldsFromSpan stores span via lmp.
+//
+// The span name is normalized with snc if it isn't nil.
+//
+// It returns false if the span is rejected, since it is outside its retention.
+func pushFieldsFromSpan(span *otelpb.Span, scopeCommonFields []logstorage.Field, cp *insertutil.CommonParams, lmp insertutil.LogMessageProcessor, snc *spanNameConfig) ([]logstorage.Field, bool) {
+	service := getFieldValue(scopeCommonFields, otelpb.ResourceAttrServiceName)
+	name := span.Name
+	if snc != nil {
+		name = snc.normalize(span, service)
+	}
+
 	fields := scopeCommonFields
 	fields = append(fields,
 		logstorage.Field{Name: otelpb.TraceIDField, Value: span.TraceID},
@@ -196,7 +303,7 @@ func pushFieldsFromSpan(span *otelpb.Span, scopeCommonFields []logstorage.Field,
 		logstorage.Field{Name: otelpb.TraceStateField, Value: span.TraceState},
 		logstorage.Field{Name: otelpb.ParentSpanIDField, Value: span.ParentSpanID},
 		logstorage.Field{Name: otelpb.FlagsField, Value: strconv.FormatUint(uint64(span.Flags), 10)},
-		logstorage.Field{Name: otelpb.NameField, Value: span.Name},
+		logstorage.Field{Name: otelpb.NameField, Value: name},
 		logstorage.Field{Name: otelpb.KindField, Value: strconv.FormatInt(int64(span.Kind), 10)},
 		logstorage.Field{Name: otelpb.StartTimeUnixNanoField, Value: strconv.FormatUint(span.StartTimeUnixNano, 10)},
 		logstorage.Field{Name: otelpb.EndTimeUnixNanoField, Value: strconv.FormatUint(span.EndTimeUnixNano, 10)},
@@ -210,8 +317,15 @@ func pushFieldsFromSpan(span *otelpb.Span, scopeCommonFields []logstorage.Field,
 		logstorage.Field{Name: otelpb.StatusCodeField, Value: strconv.FormatInt(int64(span.Status.Code), 10)},
 	)
 
+	if name != span.Name {
+		// keep the original span name, so it could be found after the normalization.
+		fields = append(fields, logstorage.Field{Name: otelpb.OriginalNameField, Value: span.Name})
+		spanNamesNormalizedTotal.Inc()
+	}
+
 	// append span attributes
 	fields = appendKeyValuesWithPrefix(fields, span.Attributes, "", otelpb.SpanAttrPrefixField)
+	fields = appendAttrTypes(fields, span.Attributes, otelpb.SpanAttrTypesField)
 
 	for idx, event := range span.Events {
 		eventFieldPrefix := otelpb.EventPrefix
@@ -223,6 +337,7 @@ func pushFieldsFromSpan(span *otelpb.Span, scopeCommonFields []logstorage.Field,
 		)
 		// append event attributes
 		fields = appendKeyValuesWithPrefixSuffix(fields, event.Attributes, "", eventFieldPrefix+otelpb.EventAttrPrefix, eventFieldSuffix)
+		fields = appendAttrTypes(fields, event.Attributes, eventFieldPrefix+otelpb.EventAttrTypesField+eventFieldSuffix)
 	}
 
 	for idx, link := range span.Links {
@@ -238,12 +353,27 @@ func pushFieldsFromSpan(span *otelpb.Span, scopeCommonFields []logstorage.Field,
 
 		// append link attributes
 		fields = appendKeyValuesWithPrefixSuffix(fields, link.Attributes, "", linkFieldPrefix+otelpb.LinkAttrPrefix, linkFieldSuffix)
+		fields = appendAttrTypes(fields, link.Attributes, linkFieldPrefix+otelpb.LinkAttrTypesField+linkFieldSuffix)
 	}
 	fields = append(fields, logstorage.Field{
 		Name:  "_msg",
 		Value: msgFieldValue,
 	})
-	lmp.AddRow(int64(span.EndTimeUnixNano), fields, nil)
+
+	// Reject spans outside their retention here instead of the storage, so the client is notified about them.
+	if retention.IsRowExpired(cp.TenantID, int64(span.EndTimeUnixNano), fields) {
+		return fields, false
+	}
+
+	// Store spans with span names exceeding -opentelemetry.traces.maxSpanNamesPerService in the stream with the placeholder span name.
+	var streamFields []logstorage.Field
+	if *maxSpanNamesPerService > 0 {
+		if !spanNamesLimiter.isAllowed(cp.TenantID, service, name, *maxSpanNamesPerService) {
+			spanNamesFoldedTotal.Inc()
+			streamFields = getFoldedStreamFields(fields, cp.StreamFields)
+		}
+	}
+	lmp.AddRow(int64(span.EndTimeUnixNano), fields, streamFields)
 
 	// create an entity in trace-id-idx stream, if this trace_id hasn't been seen before.
 	if !traceIDCache.Has([]byte(span.TraceID)) {
@@ -253,7 +383,16 @@ func pushFieldsFromSpan(span *otelpb.Span, scopeCommonFields []logstorage.Field,
 		}, []logstorage.Field{{Name: otelpb.TraceIDIndexStreamName, Value: strconv.FormatUint(xxhash.Sum64String(span.TraceID)%otelpb.TraceIDIndexPartitionCount, 10)}})
 		traceIDCache.Set([]byte(span.TraceID), nil)
 	}
-	return fields
+	return fields, true
+}
+
+func getFieldValue(fields []logstorage.Field, name string) string {
+	for i := len(fields) - 1; i >= 0; i-- {
+		if fields[i].Name == name {
+			return fields[i].Value
+		}
+	}
+	return ""
 }
 
 func appendKeyValuesWithPrefix(fields []logstorage.Field, kvs []*otelpb.KeyValue, parentField, prefix string) []logstorage.Field {
@@ -272,15 +411,24 @@ func appendKeyValuesWithPrefixSuffix(fields []logstorage.Field, kvs []*otelpb.Ke
 			continue
 		}
 
-		v := attr.Value.FormatString(true)
-		if len(v) == 0 {
-			// VictoriaLogs does not support empty string as field value. set it to "-" to preserve the field.
-			v = "-"
-		}
+		// VictoriaLogs does not support empty string as field value, so FormatAttrValue returns "-" for it to preserve the field.
 		fields = append(fields, logstorage.Field{
 			Name:  prefix + fieldName + suffix,
-			Value: v,
+			Value: otelpb.FormatAttrValue(attr.Value),
 		})
 	}
 	return fields
 }
+
+// appendAttrTypes appends the field with the given name containing types for kvs to fields.
+//
+// This allows restoring the original attribute types at query time, since attributes are stored as strings.
+func appendAttrTypes(fields []logstorage.Field, kvs []*otelpb.KeyValue, name string) []logstorage.Field {
+	if len(kvs) == 0 {
+		return fields
+	}
+	return append(fields, logstorage.Field{
+		Name:  name,
+		Value: string(otelpb.MarshalAttrTypes(nil, kvs)),
+	})
+}
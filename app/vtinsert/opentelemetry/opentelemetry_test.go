@@ -0,0 +1,81 @@
+package opentelemetry
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtinsert/insertutil"
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtstorage/retention"
+	otelpb "github.com/VictoriaMetrics/VictoriaTraces/lib/protoparser/opentelemetry/pb"
+)
+
+func TestPushExportTraceServiceRequestRejectedSpans(t *testing.T) {
+	retention.SetFrozenAge(24 * time.Hour)
+	defer retention.SetFrozenAge(0)
+
+	now := uint64(time.Now().UnixNano())
+	old := uint64(time.Now().Add(-48 * time.Hour).UnixNano())
+	req := &otelpb.ExportTraceServiceRequest{
+		ResourceSpans: []*otelpb.ResourceSpans{{
+			ScopeSpans: []*otelpb.ScopeSpans{{
+				Spans: []*otelpb.Span{
+					{TraceID: "a", SpanID: "1", StartTimeUnixNano: now - 1e9, EndTimeUnixNano: now},
+					{TraceID: "b", SpanID: "2", StartTimeUnixNano: old - 1e9, EndTimeUnixNano: old},
+					{TraceID: "c", SpanID: "3", StartTimeUnixNano: old - 1e9, EndTimeUnixNano: old},
+				},
+			}},
+		}},
+	}
+	cp := &insertutil.CommonParams{
+		StreamFields: mandatoryStreamFields,
+	}
+	tlp := &testLogMessageProcessor{}
+	rejectedSpans, err := PushExportTraceServiceRequest(req, cp, tlp)
+	if err != nil {
+		t.Fatalf("cannot push request: %s", err)
+	}
+	if rejectedSpans != 2 {
+		t.Fatalf("unexpected number of rejected spans; got %d; want 2", rejectedSpans)
+	}
+	if len(tlp.rows) != 1 || getFieldValue(tlp.rows[0], otelpb.TraceIDField) != "a" {
+		t.Fatalf("unexpected stored spans: %v", tlp.rows)
+	}
+}
+
+func TestWriteExportTraceServiceResponse(t *testing.T) {
+	f := func(contentType string, rejectedSpans int, check func(body []byte)) {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		writeExportTraceServiceResponse(w, contentType, rejectedSpans)
+		if w.Code != 200 {
+			t.Fatalf("unexpected status code; got %d; want 200", w.Code)
+		}
+		check(w.Body.Bytes())
+	}
+
+	// all the spans are accepted
+	f(contentTypeProtobuf, 0, func(body []byte) {
+		if len(body) != 0 {
+			t.Fatalf("unexpected non-empty response: %q", body)
+		}
+	})
+
+	// rejected spans are reported via partial success
+	f(contentTypeProtobuf, 3, func(body []byte) {
+		var resp otelpb.ExportTraceServiceResponse
+		if err := resp.UnmarshalProtobuf(body); err != nil {
+			t.Fatalf("cannot unmarshal response: %s", err)
+		}
+		if resp.PartialSuccess.RejectedSpans != 3 || resp.PartialSuccess.ErrorMessage != GetRejectedSpansMessage(3) {
+			t.Fatalf("unexpected response: %+v", &resp)
+		}
+	})
+	f(contentTypeJSON, 3, func(body []byte) {
+		resultExpected := `{"partialSuccess":{"rejectedSpans":"3","errorMessage":"` + GetRejectedSpansMessage(3) + `"}}`
+		if string(body) != resultExpected {
+			t.Fatalf("unexpected response; got %s; want %s", body, resultExpected)
+		}
+	})
+}
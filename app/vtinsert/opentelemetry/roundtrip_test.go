@@ -0,0 +1,278 @@
+package opentelemetry
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtinsert/insertutil"
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtselect/traces/otlp"
+	otelpb "github.com/VictoriaMetrics/VictoriaTraces/lib/protoparser/opentelemetry/pb"
+)
+
+// testLogMessageProcessor collects spans passed to AddRow in the same way as they are stored in VictoriaLogs.
+type testLogMessageProcessor struct {
+	rows [][]logstorage.Field
+}
+
+func (tlp *testLogMessageProcessor) AddRow(_ int64, fields, _ []logstorage.Field) {
+	var row []logstorage.Field
+	for _, f := range fields {
+		if f.Name == otelpb.TraceIDIndexFieldName {
+			// skip trace_id index rows
+			return
+		}
+		if f.Value == "" {
+			// VictoriaLogs doesn't store fields with empty values.
+			continue
+		}
+		row = append(row, f)
+	}
+	tlp.rows = append(tlp.rows, slices.Clone(row))
+}
+
+func (tlp *testLogMessageProcessor) MustClose() {}
+
+func TestExportTraceServiceRequestRoundTrip(t *testing.T) {
+	f := func(req *otelpb.ExportTraceServiceRequest) {
+		t.Helper()
+
+		// pass the request via protobuf in the same way as it is done at data ingestion.
+		var reqUnmarshaled otelpb.ExportTraceServiceRequest
+		if err := reqUnmarshaled.UnmarshalProtobuf(req.MarshalProtobuf(nil)); err != nil {
+			t.Fatalf("cannot unmarshal request from protobuf: %s", err)
+		}
+
+		cp := &insertutil.CommonParams{
+			StreamFields: mandatoryStreamFields,
+		}
+		tlp := &testLogMessageProcessor{}
+		if _, err := PushExportTraceServiceRequest(&reqUnmarshaled, cp, tlp); err != nil {
+			t.Fatalf("cannot push request: %s", err)
+		}
+
+		var rb otlp.RequestBuilder
+		for _, fields := range tlp.rows {
+			if err := rb.AddSpan(fields); err != nil {
+				t.Fatalf("cannot add span: %s", err)
+			}
+		}
+		result := rb.Request()
+		if !reflect.DeepEqual(result, req) {
+			t.Fatalf("unexpected request after the round trip\ngot\n%s\nwant\n%s", mustMarshalJSON(result), mustMarshalJSON(req))
+		}
+
+		// the rebuilt request must be marshaled to OTLP JSON, which can be ingested back.
+		var reqFromJSON otelpb.ExportTraceServiceRequest
+		if err := reqFromJSON.UnmarshalJSONCustom(mustMarshalJSON(result)); err != nil {
+			t.Fatalf("cannot unmarshal request from JSON: %s", err)
+		}
+		if !reflect.DeepEqual(&reqFromJSON, req) {
+			t.Fatalf("unexpected request after JSON round trip\ngot\n%s\nwant\n%s", mustMarshalJSON(&reqFromJSON), mustMarshalJSON(req))
+		}
+		if n := rb.SpansCount(); n != len(tlp.rows) {
+			t.Fatalf("unexpected number of spans; got %d; want %d", n, len(tlp.rows))
+		}
+	}
+
+	// empty request
+	f(&otelpb.ExportTraceServiceRequest{})
+
+	// minimal span
+	f(&otelpb.ExportTraceServiceRequest{
+		ResourceSpans: []*otelpb.ResourceSpans{{
+			ScopeSpans: []*otelpb.ScopeSpans{{
+				Spans: []*otelpb.Span{{
+					TraceID: "0123456789abcdef0123456789abcdef",
+					SpanID:  "0123456789abcdef",
+				}},
+			}},
+		}},
+	})
+
+	// multiple resources and scopes with all the span fields set
+	f(&otelpb.ExportTraceServiceRequest{
+		ResourceSpans: []*otelpb.ResourceSpans{
+			{
+				Resource: otelpb.Resource{
+					Attributes: []*otelpb.KeyValue{
+						{Key: "service.name", Value: &otelpb.AnyValue{StringValue: ptrTo("frontend")}},
+						{Key: "host", Value: &otelpb.AnyValue{KeyValueList: &otelpb.KeyValueList{
+							Values: []*otelpb.KeyValue{
+								{Key: "name", Value: &otelpb.AnyValue{StringValue: ptrTo("host-1")}},
+								{Key: "cpus", Value: &otelpb.AnyValue{IntValue: ptrTo(int64(8))}},
+							},
+						}}},
+					},
+					DroppedAttributesCount: 2,
+				},
+				SchemaURL: "https://opentelemetry.io/schemas/1.21.0",
+				ScopeSpans: []*otelpb.ScopeSpans{
+					{
+						Scope: otelpb.InstrumentationScope{
+							Name:    "net/http",
+							Version: "v1.2.3",
+							Attributes: []*otelpb.KeyValue{
+								{Key: "enabled", Value: &otelpb.AnyValue{BoolValue: ptrTo(true)}},
+							},
+							DroppedAttributesCount: 1,
+						},
+						SchemaURL: "https://opentelemetry.io/schemas/1.20.0",
+						Spans: []*otelpb.Span{
+							{
+								TraceID:           "0123456789abcdef0123456789abcdef",
+								SpanID:            "0000000000000001",
+								TraceState:        "vendor=value",
+								Flags:             257,
+								Name:              "GET /users",
+								Kind:              2,
+								StartTimeUnixNano: 1700000000000000000,
+								EndTimeUnixNano:   1700000000100000000,
+								Attributes: []*otelpb.KeyValue{
+									{Key: "http.status_code", Value: &otelpb.AnyValue{IntValue: ptrTo(int64(200))}},
+									{Key: "ratio", Value: &otelpb.AnyValue{DoubleValue: ptrTo(0.5)}},
+									{Key: "empty", Value: &otelpb.AnyValue{StringValue: ptrTo("")}},
+									{Key: "dash", Value: &otelpb.AnyValue{StringValue: ptrTo("-")}},
+									{Key: "payload", Value: &otelpb.AnyValue{BytesValue: ptrTo([]byte{0, 1, 2})}},
+									{Key: "unset", Value: &otelpb.AnyValue{}},
+									{Key: "tags", Value: &otelpb.AnyValue{ArrayValue: &otelpb.ArrayValue{
+										Values: []*otelpb.AnyValue{
+											{StringValue: ptrTo("a")},
+											{IntValue: ptrTo(int64(1))},
+											{BoolValue: ptrTo(false)},
+										},
+									}}},
+								},
+								DroppedAttributesCount: 3,
+								Events: []*otelpb.SpanEvent{
+									{
+										TimeUnixNano: 1700000000050000000,
+										Name:         "exception",
+										Attributes: []*otelpb.KeyValue{
+											{Key: "exception.message", Value: &otelpb.AnyValue{StringValue: ptrTo("boom")}},
+										},
+										DroppedAttributesCount: 4,
+									},
+									{
+										TimeUnixNano: 1700000000060000000,
+										Name:         "retry",
+									},
+								},
+								DroppedEventsCount: 5,
+								Links: []*otelpb.SpanLink{
+									{
+										TraceID:    "fedcba9876543210fedcba9876543210",
+										SpanID:     "0000000000000002",
+										TraceState: "other=value",
+										Attributes: []*otelpb.KeyValue{
+											{Key: "attempt", Value: &otelpb.AnyValue{IntValue: ptrTo(int64(2))}},
+										},
+										DroppedAttributesCount: 6,
+										Flags:                  1,
+									},
+								},
+								DroppedLinksCount: 7,
+								Status: otelpb.Status{
+									Message: "internal error",
+									Code:    2,
+								},
+							},
+							{
+								TraceID:      "0123456789abcdef0123456789abcdef",
+								SpanID:       "0000000000000003",
+								ParentSpanID: "0000000000000001",
+								Name:         "SELECT users",
+								Kind:         3,
+							},
+						},
+					},
+					{
+						Scope: otelpb.InstrumentationScope{
+							Name: "database/sql",
+						},
+						Spans: []*otelpb.Span{{
+							TraceID: "0123456789abcdef0123456789abcdef",
+							SpanID:  "0000000000000004",
+							Name:    "query",
+						}},
+					},
+				},
+			},
+			{
+				Resource: otelpb.Resource{
+					Attributes: []*otelpb.KeyValue{
+						{Key: "service.name", Value: &otelpb.AnyValue{StringValue: ptrTo("backend")}},
+					},
+				},
+				ScopeSpans: []*otelpb.ScopeSpans{{
+					Scope: otelpb.InstrumentationScope{
+						Name: "net/http",
+					},
+					Spans: []*otelpb.Span{{
+						TraceID: "0123456789abcdef0123456789abcdef",
+						SpanID:  "0000000000000005",
+						Name:    "POST /orders",
+					}},
+				}},
+			},
+		},
+	})
+}
+
+func TestExportTraceServiceRequestRoundTripNormalizedName(t *testing.T) {
+	cfg, err := parseSpanNameConfig([]byte(`{"templating":true}`))
+	if err != nil {
+		t.Fatalf("cannot parse config: %s", err)
+	}
+	spanNameNormalizer = cfg
+	defer func() {
+		spanNameNormalizer = nil
+	}()
+
+	req := &otelpb.ExportTraceServiceRequest{
+		ResourceSpans: []*otelpb.ResourceSpans{{
+			ScopeSpans: []*otelpb.ScopeSpans{{
+				Spans: []*otelpb.Span{{
+					TraceID: "0123456789abcdef0123456789abcdef",
+					SpanID:  "0123456789abcdef",
+					Name:    "GET /users/123",
+				}},
+			}},
+		}},
+	}
+	cp := &insertutil.CommonParams{
+		StreamFields: mandatoryStreamFields,
+	}
+	tlp := &testLogMessageProcessor{}
+	if _, err := PushExportTraceServiceRequest(req, cp, tlp); err != nil {
+		t.Fatalf("cannot push request: %s", err)
+	}
+	if name := getFieldValue(tlp.rows[0], otelpb.NameField); name != "GET /users/{id}" {
+		t.Fatalf("unexpected normalized span name; got %q; want %q", name, "GET /users/{id}")
+	}
+
+	// the original span name must be returned
+	var rb otlp.RequestBuilder
+	if err := rb.AddSpan(tlp.rows[0]); err != nil {
+		t.Fatalf("cannot add span: %s", err)
+	}
+	if !reflect.DeepEqual(rb.Request(), req) {
+		t.Fatalf("unexpected request after the round trip\ngot\n%s\nwant\n%s", mustMarshalJSON(rb.Request()), mustMarshalJSON(req))
+	}
+}
+
+func mustMarshalJSON(req *otelpb.ExportTraceServiceRequest) []byte {
+	data, err := json.Marshal(req)
+	if err != nil {
+		panic(fmt.Errorf("cannot marshal request to JSON: %w", err))
+	}
+	return data
+}
+
+func ptrTo[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,136 @@
+package opentelemetry
+
+import (
+	"flag"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/metrics"
+
+	otelpb "github.com/VictoriaMetrics/VictoriaTraces/lib/protoparser/opentelemetry/pb"
+)
+
+var maxSpanNamesPerService = flag.Int("opentelemetry.traces.maxSpanNamesPerService", 0, "The maximum number of distinct span names per service per day, which are stored as separate streams. "+
+	"Spans with other names are stored in a single stream per service with the span name set to "+otelpb.FoldedSpanName+" placeholder, "+
+	"while the real span name is kept in the name field. The limit is applied per every process accepting spans, so the total number of span names per service may be N times bigger for N vtinsert nodes. There is no limit if set to 0. See https://docs.victoriametrics.com/victoriatraces/#span-name-cardinality")
+
+var (
+	spanNamesFoldedTotal = metrics.NewCounter(`vt_span_names_folded_total`)
+
+	_ = metrics.NewGauge(`vt_services_with_folded_span_names`, func() float64 {
+		return float64(spanNamesLimiter.getFoldedServicesCount())
+	})
+)
+
+var spanNamesLimiter = newSpanNameLimiter()
+
+// spanNameLimiter limits the number of distinct span names per service, which are used as stream field values.
+//
+// The state is reset at the start of every UTC day, since streams are created per every per-day partition.
+type spanNameLimiter struct {
+	mu sync.Mutex
+
+	// day is the number of the current UTC day since the Unix epoch.
+	day int64
+
+	// services contains span names, which are used as stream field values, per every service.
+	services map[serviceKey]*serviceSpanNames
+}
+
+type serviceKey struct {
+	tenantID logstorage.TenantID
+	service  string
+}
+
+type serviceSpanNames struct {
+	names map[string]struct{}
+
+	// folded is set to true after the first span name is folded for the service.
+	folded bool
+}
+
+func newSpanNameLimiter() *spanNameLimiter {
+	return &spanNameLimiter{
+		services: make(map[serviceKey]*serviceSpanNames),
+	}
+}
+
+// isAllowed returns true if the span name can be used as stream field value for the given service at the given tenantID.
+//
+// It returns false if the service already has limit distinct span names since the start of the current UTC day.
+func (snl *spanNameLimiter) isAllowed(tenantID logstorage.TenantID, service, name string, limit int) bool {
+	day := time.Now().Unix() / (24 * 3600)
+
+	snl.mu.Lock()
+	defer snl.mu.Unlock()
+
+	if day != snl.day {
+		snl.day = day
+		clear(snl.services)
+	}
+
+	k := serviceKey{
+		tenantID: tenantID,
+		service:  service,
+	}
+	sn := snl.services[k]
+	if sn == nil {
+		sn = &serviceSpanNames{
+			names: make(map[string]struct{}),
+		}
+		k.service = strings.Clone(service)
+		snl.services[k] = sn
+	}
+	if _, ok := sn.names[name]; ok {
+		return true
+	}
+	if len(sn.names) < limit {
+		sn.names[strings.Clone(name)] = struct{}{}
+		return true
+	}
+
+	if !sn.folded {
+		sn.folded = true
+		logger.Warnf("service %q at tenant %d:%d exceeds -opentelemetry.traces.maxSpanNamesPerService=%d distinct span names today; "+
+			"storing spans with new span names in the stream with %q span name; the real span name is kept in the %q field; for example: %q",
+			service, tenantID.AccountID, tenantID.ProjectID, limit, otelpb.FoldedSpanName, otelpb.NameField, name)
+	}
+	return false
+}
+
+func (snl *spanNameLimiter) getFoldedServicesCount() int {
+	snl.mu.Lock()
+	defer snl.mu.Unlock()
+
+	n := 0
+	for _, sn := range snl.services {
+		if sn.folded {
+			n++
+		}
+	}
+	return n
+}
+
+// getFoldedStreamFields returns stream fields for the span with the given fields, which span name must be folded.
+//
+// streamFieldNames must contain names for the stream fields.
+func getFoldedStreamFields(fields []logstorage.Field, streamFieldNames []string) []logstorage.Field {
+	streamFields := make([]logstorage.Field, 0, len(streamFieldNames))
+	for _, name := range streamFieldNames {
+		if name == otelpb.NameField {
+			streamFields = append(streamFields, logstorage.Field{
+				Name:  name,
+				Value: otelpb.FoldedSpanName,
+			})
+			continue
+		}
+		streamFields = append(streamFields, logstorage.Field{
+			Name:  name,
+			Value: getFieldValue(fields, name),
+		})
+	}
+	return streamFields
+}
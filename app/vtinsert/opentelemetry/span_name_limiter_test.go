@@ -0,0 +1,66 @@
+package opentelemetry
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+
+	otelpb "github.com/VictoriaMetrics/VictoriaTraces/lib/protoparser/opentelemetry/pb"
+)
+
+func TestSpanNameLimiterIsAllowed(t *testing.T) {
+	snl := newSpanNameLimiter()
+
+	f := func(tenantID logstorage.TenantID, service, name string, resultExpected bool) {
+		t.Helper()
+		result := snl.isAllowed(tenantID, service, name, 2)
+		if result != resultExpected {
+			t.Fatalf("unexpected result for service=%q, name=%q; got %v; want %v", service, name, result, resultExpected)
+		}
+	}
+
+	tenant1 := logstorage.TenantID{}
+	tenant2 := logstorage.TenantID{AccountID: 1}
+
+	f(tenant1, "frontend", "GET /user/1", true)
+	f(tenant1, "frontend", "GET /user/2", true)
+	f(tenant1, "frontend", "GET /user/3", false)
+	f(tenant1, "frontend", "GET /user/1", true)
+	f(tenant1, "frontend", "GET /user/4", false)
+
+	// Other services and tenants have their own limits.
+	f(tenant1, "db", "SELECT", true)
+	f(tenant2, "frontend", "GET /user/3", true)
+
+	if n := snl.getFoldedServicesCount(); n != 1 {
+		t.Fatalf("unexpected number of services with folded span names; got %d; want 1", n)
+	}
+
+	// The state must be reset on the next day.
+	snl.day--
+	f(tenant1, "frontend", "GET /user/3", true)
+	if n := snl.getFoldedServicesCount(); n != 0 {
+		t.Fatalf("unexpected number of services with folded span names after reset; got %d; want 0", n)
+	}
+}
+
+func TestGetFoldedStreamFields(t *testing.T) {
+	fields := []logstorage.Field{
+		{Name: otelpb.ResourceAttrServiceName, Value: "frontend"},
+		{Name: otelpb.NameField, Value: "GET /user/1"},
+		{Name: "resource_attr:host.name", Value: "host-1"},
+	}
+	streamFieldNames := []string{otelpb.ResourceAttrServiceName, otelpb.NameField, "resource_attr:host.name", "missing"}
+
+	result := getFoldedStreamFields(fields, streamFieldNames)
+	resultExpected := []logstorage.Field{
+		{Name: otelpb.ResourceAttrServiceName, Value: "frontend"},
+		{Name: otelpb.NameField, Value: otelpb.FoldedSpanName},
+		{Name: "resource_attr:host.name", Value: "host-1"},
+		{Name: "missing", Value: ""},
+	}
+	if !reflect.DeepEqual(result, resultExpected) {
+		t.Fatalf("unexpected stream fields\ngot\n%v\nwant\n%v", result, resultExpected)
+	}
+}
@@ -0,0 +1,287 @@
+package opentelemetry
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs/fscore"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/metrics"
+
+	otelpb "github.com/VictoriaMetrics/VictoriaTraces/lib/protoparser/opentelemetry/pb"
+)
+
+var spanNameConfigFile = flag.String("opentelemetry.traces.spanNameConfigFile", "", "Optional path to JSON file with span name normalization rules. "+
+	"The original span name is kept in the original_name field for normalized spans. See https://docs.victoriametrics.com/victoriatraces/#span-name-normalization")
+
+var spanNamesNormalizedTotal = metrics.NewCounter(`vt_span_names_normalized_total`)
+
+// idPlaceholder is used instead of IDs, UUIDs, hex strings and numbers in span names when templating is enabled.
+const idPlaceholder = "{id}"
+
+// spanNameNormalizer is initialized from -opentelemetry.traces.spanNameConfigFile. It is nil if span names mustn't be normalized.
+//
+// It is set at Init and is reset at Stop after the HTTP server is stopped, so it isn't modified while requests are processed.
+var spanNameNormalizer *spanNameConfig
+
+// spanNameConfig contains span name normalization rules.
+//
+// The rules are applied in the following order: prefer_attributes, templating, rewrites.
+type spanNameConfig struct {
+	// PreferAttributes contains rules for using span attribute values as span name.
+	//
+	// The first matching rule is applied.
+	PreferAttributes []*preferAttributesRule `json:"prefer_attributes,omitempty"`
+
+	// Templating enables replacing IDs, UUIDs, hex strings and numbers in span names with idPlaceholder.
+	Templating bool `json:"templating,omitempty"`
+
+	// Rewrites contains regex rewrite rules. All the matching rules are applied in order.
+	Rewrites []*rewriteRule `json:"rewrites,omitempty"`
+}
+
+// preferAttributesRule sets span name to space-separated values of Attributes if the span has all of them.
+type preferAttributesRule struct {
+	// SpanKinds contains optional span kinds such as server or client, which the rule is applied to.
+	SpanKinds []string `json:"span_kinds,omitempty"`
+
+	// Services contains optional services, which the rule is applied to.
+	Services []string `json:"services,omitempty"`
+
+	// Attributes contains span attribute names such as http.route.
+	Attributes []string `json:"attributes"`
+
+	kinds []otelpb.SpanKind
+}
+
+// rewriteRule replaces span name substrings matching Regex with Replacement.
+type rewriteRule struct {
+	// Services contains optional services, which the rule is applied to.
+	Services []string `json:"services,omitempty"`
+
+	// Regex is the regular expression for matching span name substrings.
+	Regex string `json:"regex"`
+
+	// Replacement is the replacement for matching substrings. It may refer to capturing groups via $1, ${name}, etc.
+	Replacement string `json:"replacement"`
+
+	re *regexp.Regexp
+}
+
+var spanKinds = map[string]otelpb.SpanKind{
+	"unspecified": 0,
+	"internal":    1,
+	"server":      2,
+	"client":      3,
+	"producer":    4,
+	"consumer":    5,
+}
+
+// mustInitSpanNameNormalizer loads span name normalization rules from -opentelemetry.traces.spanNameConfigFile.
+func mustInitSpanNameNormalizer() {
+	if *spanNameConfigFile == "" {
+		return
+	}
+
+	data, err := fscore.ReadFileOrHTTP(*spanNameConfigFile)
+	if err != nil {
+		logger.Fatalf("cannot read -opentelemetry.traces.spanNameConfigFile: %s", err)
+	}
+	cfg, err := parseSpanNameConfig(data)
+	if err != nil {
+		logger.Fatalf("cannot parse -opentelemetry.traces.spanNameConfigFile=%q: %s", *spanNameConfigFile, err)
+	}
+	spanNameNormalizer = cfg
+	logger.Infof("loaded %d prefer_attributes rules and %d rewrites rules from -opentelemetry.traces.spanNameConfigFile=%q; templating: %v",
+		len(cfg.PreferAttributes), len(cfg.Rewrites), *spanNameConfigFile, cfg.Templating)
+}
+
+func parseSpanNameConfig(data []byte) (*spanNameConfig, error) {
+	var cfg spanNameConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+
+	for i, r := range cfg.PreferAttributes {
+		if len(r.Attributes) == 0 {
+			return nil, fmt.Errorf("prefer_attributes rule #%d: attributes cannot be empty", i)
+		}
+		for _, s := range r.SpanKinds {
+			kind, ok := spanKinds[s]
+			if !ok {
+				return nil, fmt.Errorf("prefer_attributes rule #%d: unsupported span kind %q; supported values: unspecified, internal, server, client, producer, consumer", i, s)
+			}
+			r.kinds = append(r.kinds, kind)
+		}
+	}
+
+	for i, r := range cfg.Rewrites {
+		if r.Regex == "" {
+			return nil, fmt.Errorf("rewrites rule #%d: regex cannot be empty", i)
+		}
+		re, err := regexp.Compile(r.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("rewrites rule #%d: cannot parse regex: %w", i, err)
+		}
+		r.re = re
+	}
+
+	return &cfg, nil
+}
+
+// normalize returns the normalized name for the given span of the given service.
+func (cfg *spanNameConfig) normalize(span *otelpb.Span, service string) string {
+	name := span.Name
+
+	for _, r := range cfg.PreferAttributes {
+		if s, ok := r.apply(span, service); ok {
+			name = s
+			break
+		}
+	}
+
+	if cfg.Templating {
+		name = templateIDs(name)
+	}
+
+	for _, r := range cfg.Rewrites {
+		if len(r.Services) > 0 && !slices.Contains(r.Services, service) {
+			continue
+		}
+		name = r.re.ReplaceAllString(name, r.Replacement)
+	}
+
+	return name
+}
+
+func (r *preferAttributesRule) apply(span *otelpb.Span, service string) (string, bool) {
+	if len(r.kinds) > 0 && !slices.Contains(r.kinds, span.Kind) {
+		return "", false
+	}
+	if len(r.Services) > 0 && !slices.Contains(r.Services, service) {
+		return "", false
+	}
+
+	values := make([]string, 0, len(r.Attributes))
+	for _, attr := range r.Attributes {
+		v := getSpanAttribute(span, attr)
+		if v == "" {
+			return "", false
+		}
+		values = append(values, v)
+	}
+	return strings.Join(values, " "), true
+}
+
+func getSpanAttribute(span *otelpb.Span, name string) string {
+	for _, kv := range span.Attributes {
+		if kv.Key == name && kv.Value != nil {
+			return kv.Value.FormatString(true)
+		}
+	}
+	return ""
+}
+
+// templateIDs replaces UUIDs, hex strings and numbers in s with idPlaceholder.
+//
+// Only whole segments are replaced. Segments consist of alphanumeric chars, dots and dashes, and are delimited by other chars such as slashes,
+// spaces and underscores. So `GET /users/123/orders/456` is converted to `GET /users/{id}/orders/{id}`, while `HTTP/1.1` and `v1.2` are left as is.
+func templateIDs(s string) string {
+	if strings.IndexAny(s, "0123456789") < 0 {
+		// Fast path - all the supported IDs contain at least a single digit.
+		return s
+	}
+
+	var b []byte
+	prevEnd := 0
+	i := 0
+	for i < len(s) {
+		if !isSegmentChar(s[i]) {
+			i++
+			continue
+		}
+
+		// s[i] is the start of the segment.
+		end := i + 1
+		for end < len(s) && isSegmentChar(s[end]) {
+			end++
+		}
+		if segment := s[i:end]; !isUUID(segment) && !isIDToken(segment) {
+			i = end
+			continue
+		}
+		b = append(b, s[prevEnd:i]...)
+		b = append(b, idPlaceholder...)
+		prevEnd = end
+		i = end
+	}
+	if b == nil {
+		return s
+	}
+	b = append(b, s[prevEnd:]...)
+	return string(b)
+}
+
+// isUUID returns true if s is UUID.
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		switch i {
+		case 8, 13, 18, 23:
+			if s[i] != '-' {
+				return false
+			}
+		default:
+			if !isHex(s[i]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// isIDToken returns true if the segment looks like an ID.
+//
+// Decimal numbers, hex numbers with 0x prefix and hex strings with at least 8 chars containing digits are treated as IDs.
+// Shorter hex strings are left as is, since they may be regular words such as `add` or `cafe`.
+func isIDToken(s string) bool {
+	if isDecimal(s) {
+		return true
+	}
+	if len(s) > 2 && s[0] == '0' && (s[1] == 'x' || s[1] == 'X') {
+		return isHexString(s[2:])
+	}
+	return len(s) >= 8 && isHexString(s) && strings.IndexAny(s, "0123456789") >= 0
+}
+
+func isDecimal(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isHexString(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isHex(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func isSegmentChar(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '.' || c == '-'
+}
@@ -0,0 +1,127 @@
+package opentelemetry
+
+import (
+	"testing"
+
+	otelpb "github.com/VictoriaMetrics/VictoriaTraces/lib/protoparser/opentelemetry/pb"
+)
+
+func TestParseSpanNameConfigFailure(t *testing.T) {
+	f := func(data string) {
+		t.Helper()
+
+		if _, err := parseSpanNameConfig([]byte(data)); err == nil {
+			t.Fatalf("expecting non-nil error for config %s", data)
+		}
+	}
+
+	// invalid json
+	f(`{`)
+
+	// missing attributes
+	f(`{"prefer_attributes":[{"span_kinds":["server"]}]}`)
+
+	// unsupported span kind
+	f(`{"prefer_attributes":[{"span_kinds":["foo"],"attributes":["http.route"]}]}`)
+
+	// missing regex
+	f(`{"rewrites":[{"replacement":"foo"}]}`)
+
+	// invalid regex
+	f(`{"rewrites":[{"regex":"(foo","replacement":"foo"}]}`)
+}
+
+func TestTemplateIDs(t *testing.T) {
+	f := func(s, resultExpected string) {
+		t.Helper()
+
+		result := templateIDs(s)
+		if result != resultExpected {
+			t.Fatalf("unexpected result for %q; got %q; want %q", s, result, resultExpected)
+		}
+	}
+
+	// no ids
+	f("", "")
+	f("GET /users", "GET /users")
+	f("SELECT users", "SELECT users")
+
+	// numbers
+	f("GET /users/123/orders/456", "GET /users/{id}/orders/{id}")
+	f("process 1", "process {id}")
+	f("user_42", "user_{id}")
+
+	// uuids
+	f("GET /carts/0f8fad5b-d9cb-469f-a165-70867728950e", "GET /carts/{id}")
+	f("0F8FAD5B-D9CB-469F-A165-70867728950E:checkout", "{id}:checkout")
+
+	// hex strings
+	f("GET /blobs/5d41402abc4b2a76b9719d911017c592", "GET /blobs/{id}")
+	f("read 0x1f", "read {id}")
+
+	// alphanumeric tokens, which aren't ids
+	f("GET /api/v1/users", "GET /api/v1/users")
+	f("sha256 abc123", "sha256 abc123")
+	f("deadbeef", "deadbeef")
+	f("GET /carts/0f8fad5b-d9cb-469f-a165-70867728950ex", "GET /carts/0f8fad5b-d9cb-469f-a165-70867728950ex")
+
+	// numbers, which are parts of segments
+	f("GET /index.html HTTP/1.1", "GET /index.html HTTP/1.1")
+	f("GET /api/v1.2/users/42", "GET /api/v1.2/users/{id}")
+	f("GET /orders/order-123", "GET /orders/order-123")
+	f("10.0.0.1:8080", "10.0.0.1:{id}")
+}
+
+func TestSpanNameConfigNormalize(t *testing.T) {
+	cfg, err := parseSpanNameConfig([]byte(`{
+		"prefer_attributes": [
+			{"span_kinds":["server"], "attributes":["http.request.method","http.route"]},
+			{"span_kinds":["server"], "attributes":["http.route"]}
+		],
+		"templating": true,
+		"rewrites": [
+			{"services":["db"], "regex":"^(SELECT|INSERT|UPDATE|DELETE) .*", "replacement":"$1"},
+			{"regex":"^HTTP (GET|POST)$", "replacement":"$1"}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("cannot parse config: %s", err)
+	}
+
+	f := func(service, name string, kind otelpb.SpanKind, attrs map[string]string, resultExpected string) {
+		t.Helper()
+
+		span := &otelpb.Span{
+			Name: name,
+			Kind: kind,
+		}
+		for k, v := range attrs {
+			span.Attributes = append(span.Attributes, &otelpb.KeyValue{
+				Key: k,
+				Value: &otelpb.AnyValue{
+					StringValue: &v,
+				},
+			})
+		}
+		result := cfg.normalize(span, service)
+		if result != resultExpected {
+			t.Fatalf("unexpected result for service=%q, name=%q; got %q; want %q", service, name, result, resultExpected)
+		}
+	}
+
+	// prefer_attributes with all the attributes
+	f("frontend", "GET /users/123", 2, map[string]string{"http.request.method": "GET", "http.route": "/users/:id"}, "GET /users/:id")
+
+	// prefer_attributes with the second rule
+	f("frontend", "GET /users/123", 2, map[string]string{"http.route": "/users/:id"}, "/users/:id")
+
+	// prefer_attributes isn't applied to client spans, so templating is applied
+	f("frontend", "GET /users/123", 3, map[string]string{"http.route": "/users/:id"}, "GET /users/{id}")
+
+	// rewrites for the given service
+	f("db", "SELECT * FROM users WHERE id=1", 3, nil, "SELECT")
+	f("cache", "SELECT * FROM users WHERE id=1", 3, nil, "SELECT * FROM users WHERE id={id}")
+
+	// rewrites for all the services
+	f("cache", "HTTP GET", 3, nil, "GET")
+}
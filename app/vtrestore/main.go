@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/buildinfo"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/envflag"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/procutil"
+
+	"github.com/VictoriaMetrics/VictoriaTraces/lib/backup"
+)
+
+var (
+	src             = flag.String("src", "", "Source with backups created by vtbackup; for example, fs:///path/to/backups or s3://bucket/path/to/backups")
+	backupName      = flag.String("backupName", "", "Optional name for the backup to restore. The newest backup at -src is restored if not set")
+	storageDataPath = flag.String("storageDataPath", "victoria-traces-data", "Path to -storageDataPath directory of VictoriaTraces or vtstorage node to restore the backup to")
+	storageURL      = flag.String("storageURL", "", "Optional URL of the running VictoriaTraces or vtstorage node with the given -storageDataPath; for example, http://localhost:10428 . "+
+		"If set, then restored partitions are detached before being replaced and are attached after the restore. "+
+		"If not set, then VictoriaTraces must be stopped during the restore")
+	authKey     = flagutil.NewPassword("authKey", "authKey to pass to /internal/partition/* endpoints; it must match -partitionManageAuthKey at VictoriaTraces")
+	partitions  = flagutil.NewArrayString("partition", "Optional names for per-day partitions in YYYYMMDD format to restore. All the partitions from the backup are restored if not set")
+	concurrency = flag.Int("concurrency", 10, "The number of concurrent workers for downloading files. Higher concurrency may reduce restore duration")
+	dryRun      = flag.Bool("dryRun", false, "Whether to only verify the backup contains all the files for the restored partitions and log them without making changes at -storageDataPath")
+)
+
+// restoreTmpDirname is the name of the directory at -storageDataPath for partitions being restored.
+const restoreTmpDirname = "vtrestore-tmp"
+
+func main() {
+	// Write flags and help message to stdout, since it is easier to grep or pipe.
+	flag.CommandLine.SetOutput(os.Stdout)
+	flag.Usage = usage
+	envflag.Parse()
+	buildinfo.Init()
+	logger.Init()
+
+	if *src == "" {
+		logger.Fatalf("missing -src command-line flag")
+	}
+	rfs, err := backup.NewRemoteFS(*src)
+	if err != nil {
+		logger.Fatalf("cannot initialize -src: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		sig := procutil.WaitForSigterm()
+		logger.Infof("received signal %s; stopping vtrestore", sig)
+		cancel()
+	}()
+
+	name := *backupName
+	if name == "" {
+		names, err := backup.ListBackups(ctx, rfs)
+		if err != nil {
+			logger.Fatalf("cannot list backups: %s", err)
+		}
+		if len(names) == 0 {
+			logger.Fatalf("missing backups at %s", rfs)
+		}
+		name = names[len(names)-1]
+	}
+	m, err := backup.ReadManifest(ctx, rfs, name)
+	if err != nil {
+		logger.Fatalf("%s", err)
+	}
+
+	pms := m.Partitions
+	if len(*partitions) > 0 {
+		pms = nil
+		for _, ptName := range *partitions {
+			pm := m.GetPartition(ptName)
+			if pm == nil {
+				logger.Fatalf("backup %q doesn't contain partition %q", name, ptName)
+			}
+			pms = append(pms, pm)
+		}
+	}
+
+	if *dryRun {
+		verifyBackup(ctx, rfs, name, pms)
+		return
+	}
+
+	var sc *backup.StorageClient
+	var attached []string
+	if *storageURL != "" {
+		sc = &backup.StorageClient{
+			URL:     *storageURL,
+			AuthKey: authKey.Get(),
+		}
+		attached, err = sc.PartitionList(ctx)
+		if err != nil {
+			logger.Fatalf("cannot obtain the list of partitions: %s", err)
+		}
+	}
+
+	startTime := time.Now()
+	tmpPath := filepath.Join(*storageDataPath, restoreTmpDirname)
+	fs.MustRemoveDir(tmpPath)
+	partitionsPath := filepath.Join(*storageDataPath, "partitions")
+	fs.MustMkdirIfNotExist(partitionsPath)
+
+	var stats backup.Stats
+	for _, pm := range pms {
+		tmpPartitionPath := filepath.Join(tmpPath, pm.Name)
+		if err := backup.RestorePartition(ctx, rfs, pm, tmpPartitionPath, *concurrency, &stats); err != nil {
+			fs.MustRemoveDir(tmpPath)
+			logger.Fatalf("cannot restore backup %q from %s: %s", name, rfs, err)
+		}
+
+		if sc != nil && slices.Contains(attached, pm.Name) {
+			if err := sc.PartitionDetach(ctx, pm.Name); err != nil {
+				fs.MustRemoveDir(tmpPath)
+				logger.Fatalf("cannot detach partition %q: %s", pm.Name, err)
+			}
+		}
+		partitionPath := filepath.Join(partitionsPath, pm.Name)
+		fs.MustRemoveDir(partitionPath)
+		if err := os.Rename(tmpPartitionPath, partitionPath); err != nil {
+			logger.Fatalf("cannot move the restored partition %q to %q: %s", tmpPartitionPath, partitionPath, err)
+		}
+		fs.MustSyncPathAndParentDir(partitionPath)
+		if sc != nil {
+			if err := sc.PartitionAttach(ctx, pm.Name); err != nil {
+				logger.Fatalf("cannot attach the restored partition %q: %s", pm.Name, err)
+			}
+		}
+		logger.Infof("restored partition %q: %d files, %d bytes", pm.Name, len(pm.Files), pm.Size())
+	}
+	fs.MustRemoveDir(tmpPath)
+
+	logger.Infof("restored %d partitions from backup %q at %s to -storageDataPath=%q in %.3f seconds; downloaded %d files with %d bytes",
+		len(pms), name, rfs, *storageDataPath, time.Since(startTime).Seconds(), stats.FilesCopied.Load(), stats.BytesCopied.Load())
+}
+
+// verifyBackup verifies that rfs contains all the files for the partitions pms from the backup with the given name.
+func verifyBackup(ctx context.Context, rfs backup.RemoteFS, name string, pms []*backup.PartitionManifest) {
+	objects, err := backup.ListObjects(ctx, rfs)
+	if err != nil {
+		logger.Fatalf("cannot list files at %s: %s", rfs, err)
+	}
+	missing := 0
+	for _, pm := range pms {
+		for _, fi := range pm.Files {
+			if !objects.Has(fi.SHA256) {
+				logger.Errorf("missing file %q for partition %q at %s", fi.Path, pm.Name, rfs)
+				missing++
+			}
+		}
+		logger.Infof("dry run: partition %q would be restored: %d files, %d bytes", pm.Name, len(pm.Files), pm.Size())
+	}
+	if missing > 0 {
+		logger.Fatalf("backup %q at %s misses %d files", name, rfs, missing)
+	}
+	logger.Infof("dry run: backup %q at %s contains all the files for %d partitions", name, rfs, len(pms))
+}
+
+func usage() {
+	const s = `
+vtrestore restores VictoriaTraces data from backups created by vtbackup.
+
+See the docs at https://docs.victoriametrics.com/victoriatraces/vtrestore/
+`
+	flagutil.Usage(s)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"slices"
 	"strconv"
 	"sync"
 	"time"
@@ -49,16 +50,20 @@ var requestHandlers = map[string]func(ctx context.Context, w http.ResponseWriter
 	"/internal/select/stream_field_values": processStreamFieldValuesRequest,
 	"/internal/select/streams":             processStreamsRequest,
 	"/internal/select/stream_ids":          processStreamIDsRequest,
+	vtstorage.MinTimestampPath:             processMinTimestampRequest,
+	vtstorage.DataGenerationPath:           processDataGenerationRequest,
 }
 
 func processQueryRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
-	cp, err := getCommonParams(r, netselect.QueryProtocolVersion)
+	cp, err := getCommonParams(r)
 	if err != nil {
 		return err
 	}
 
 	w.Header().Set("Content-Type", "application/octet-stream")
 
+	hasQueryStats := netselect.HasQueryStats(cp.ProtocolVersion)
+
 	var wLock sync.Mutex
 	var dataLenBuf []byte
 
@@ -100,8 +105,10 @@ func processQueryRequest(ctx context.Context, w http.ResponseWriter, r *http.Req
 
 		bb := bufs.Get(workerID)
 
-		// Write the marker of a regular data block.
-		bb.B = append(bb.B, 0)
+		if hasQueryStats {
+			// Write the marker of a regular data block.
+			bb.B = append(bb.B, 0)
+		}
 
 		// Marshal the data block.
 		bb.B = db.Marshal(bb.B)
@@ -138,6 +145,11 @@ func processQueryRequest(ctx context.Context, w http.ResponseWriter, r *http.Req
 		}
 	}
 
+	if !hasQueryStats {
+		// The query stats aren't supported by the requested protocol version.
+		return nil
+	}
+
 	// Send the query stats block.
 	bb := bufs.Get(0)
 	// Write the marker of query stats block.
@@ -148,7 +160,7 @@ func processQueryRequest(ctx context.Context, w http.ResponseWriter, r *http.Req
 }
 
 func processFieldNamesRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
-	cp, err := getCommonParams(r, netselect.FieldNamesProtocolVersion)
+	cp, err := getCommonParams(r)
 	if err != nil {
 		return err
 	}
@@ -161,11 +173,11 @@ func processFieldNamesRequest(ctx context.Context, w http.ResponseWriter, r *htt
 		return fmt.Errorf("cannot obtain field names: %w", err)
 	}
 
-	return writeValuesWithHits(w, qctx, fieldNames, cp.DisableCompression)
+	return writeValuesWithHits(w, cp, qctx, fieldNames)
 }
 
 func processFieldValuesRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
-	cp, err := getCommonParams(r, netselect.FieldValuesProtocolVersion)
+	cp, err := getCommonParams(r)
 	if err != nil {
 		return err
 	}
@@ -185,11 +197,11 @@ func processFieldValuesRequest(ctx context.Context, w http.ResponseWriter, r *ht
 		return fmt.Errorf("cannot obtain field values: %w", err)
 	}
 
-	return writeValuesWithHits(w, qctx, fieldValues, cp.DisableCompression)
+	return writeValuesWithHits(w, cp, qctx, fieldValues)
 }
 
 func processStreamFieldNamesRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
-	cp, err := getCommonParams(r, netselect.StreamFieldNamesProtocolVersion)
+	cp, err := getCommonParams(r)
 	if err != nil {
 		return err
 	}
@@ -202,11 +214,11 @@ func processStreamFieldNamesRequest(ctx context.Context, w http.ResponseWriter,
 		return fmt.Errorf("cannot obtain stream field names: %w", err)
 	}
 
-	return writeValuesWithHits(w, qctx, fieldNames, cp.DisableCompression)
+	return writeValuesWithHits(w, cp, qctx, fieldNames)
 }
 
 func processStreamFieldValuesRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
-	cp, err := getCommonParams(r, netselect.StreamFieldValuesProtocolVersion)
+	cp, err := getCommonParams(r)
 	if err != nil {
 		return err
 	}
@@ -226,11 +238,11 @@ func processStreamFieldValuesRequest(ctx context.Context, w http.ResponseWriter,
 		return fmt.Errorf("cannot obtain stream field values: %w", err)
 	}
 
-	return writeValuesWithHits(w, qctx, fieldValues, cp.DisableCompression)
+	return writeValuesWithHits(w, cp, qctx, fieldValues)
 }
 
 func processStreamsRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
-	cp, err := getCommonParams(r, netselect.StreamsProtocolVersion)
+	cp, err := getCommonParams(r)
 	if err != nil {
 		return err
 	}
@@ -248,11 +260,11 @@ func processStreamsRequest(ctx context.Context, w http.ResponseWriter, r *http.R
 		return fmt.Errorf("cannot obtain streams: %w", err)
 	}
 
-	return writeValuesWithHits(w, qctx, streams, cp.DisableCompression)
+	return writeValuesWithHits(w, cp, qctx, streams)
 }
 
 func processStreamIDsRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
-	cp, err := getCommonParams(r, netselect.StreamIDsProtocolVersion)
+	cp, err := getCommonParams(r)
 	if err != nil {
 		return err
 	}
@@ -270,10 +282,31 @@ func processStreamIDsRequest(ctx context.Context, w http.ResponseWriter, r *http
 		return fmt.Errorf("cannot obtain streams: %w", err)
 	}
 
-	return writeValuesWithHits(w, qctx, streamIDs, cp.DisableCompression)
+	return writeValuesWithHits(w, cp, qctx, streamIDs)
+}
+
+// processMinTimestampRequest returns the minimum timestamp in nanoseconds for spans, which may exist in the storage.
+func processMinTimestampRequest(ctx context.Context, w http.ResponseWriter, _ *http.Request) error {
+	minTimestamp, err := vtstorage.GetMinTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = fmt.Fprintf(w, "%d", minTimestamp)
+	return err
+}
+
+// processDataGenerationRequest returns the generation of the stored spans, which changes when spans are deleted.
+func processDataGenerationRequest(ctx context.Context, w http.ResponseWriter, _ *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+	_, err := fmt.Fprintf(w, "%d", vtstorage.GetDataGeneration(ctx))
+	return err
 }
 
 type commonParams struct {
+	// ProtocolVersion is the protocol version requested by the client.
+	ProtocolVersion string
+
 	TenantIDs []logstorage.TenantID
 	Query     *logstorage.Query
 
@@ -291,10 +324,11 @@ func (cp *commonParams) UpdatePerQueryStatsMetrics() {
 	vtstorage.UpdatePerQueryStatsMetrics(&cp.qs)
 }
 
-func getCommonParams(r *http.Request, expectedProtocolVersion string) (*commonParams, error) {
+func getCommonParams(r *http.Request) (*commonParams, error) {
 	version := r.FormValue("version")
-	if version != expectedProtocolVersion {
-		return nil, fmt.Errorf("unexpected version=%q; want %q", version, expectedProtocolVersion)
+	supportedVersions := netselect.SupportedProtocolVersions[r.URL.Path]
+	if !slices.Contains(supportedVersions, version) {
+		return nil, fmt.Errorf("unexpected version=%q; supported versions: %q", version, supportedVersions)
 	}
 
 	tenantIDsStr := r.FormValue("tenant_ids")
@@ -321,6 +355,8 @@ func getCommonParams(r *http.Request, expectedProtocolVersion string) (*commonPa
 	}
 
 	cp := &commonParams{
+		ProtocolVersion: version,
+
 		TenantIDs: tenantIDs,
 		Query:     q,
 
@@ -329,7 +365,7 @@ func getCommonParams(r *http.Request, expectedProtocolVersion string) (*commonPa
 	return cp, nil
 }
 
-func writeValuesWithHits(w http.ResponseWriter, qctx *logstorage.QueryContext, vhs []logstorage.ValueWithHits, disableCompression bool) error {
+func writeValuesWithHits(w http.ResponseWriter, cp *commonParams, qctx *logstorage.QueryContext, vhs []logstorage.ValueWithHits) error {
 	var b []byte
 
 	// Marshal vhs at first
@@ -338,10 +374,12 @@ func writeValuesWithHits(w http.ResponseWriter, qctx *logstorage.QueryContext, v
 		b = vhs[i].Marshal(b)
 	}
 
-	// Marshal query stats block after that
-	b = marshalQueryStatsBlock(b, qctx)
+	if netselect.HasQueryStats(cp.ProtocolVersion) {
+		// Marshal query stats block after that
+		b = marshalQueryStatsBlock(b, qctx)
+	}
 
-	if !disableCompression {
+	if !cp.DisableCompression {
 		b = zstd.CompressLevel(nil, b, 1)
 	}
 
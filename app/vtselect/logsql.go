@@ -10,6 +10,9 @@ import (
 	"github.com/VictoriaMetrics/metrics"
 
 	"github.com/VictoriaMetrics/VictoriaTraces/app/vtselect/logsql"
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtselect/traces/otlp"
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtselect/traces/spans"
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtselect/traces/usage"
 )
 
 // ---------------------------- LogsQL Dependency-----------------------------
@@ -55,6 +58,18 @@ var (
 	logsqlStreamsRequests = metrics.NewCounter(`vt_http_requests_total{path="/select/logsql/streams"}`)
 	logsqlStreamsDuration = metrics.NewSummary(`vt_http_request_duration_seconds{path="/select/logsql/streams"}`)
 
+	tracesUsageRequests = metrics.NewCounter(`vt_http_requests_total{path="/select/traces/usage"}`)
+	tracesUsageDuration = metrics.NewSummary(`vt_http_request_duration_seconds{path="/select/traces/usage"}`)
+
+	tracesCardinalityRequests = metrics.NewCounter(`vt_http_requests_total{path="/select/traces/cardinality"}`)
+	tracesCardinalityDuration = metrics.NewSummary(`vt_http_request_duration_seconds{path="/select/traces/cardinality"}`)
+
+	tracesExportRequests = metrics.NewCounter(`vt_http_requests_total{path="/select/traces/export"}`)
+	tracesExportDuration = metrics.NewSummary(`vt_http_request_duration_seconds{path="/select/traces/export"}`)
+
+	tracesSpansRequests = metrics.NewCounter(`vt_http_requests_total{path="/select/traces/spans"}`)
+	tracesSpansDuration = metrics.NewSummary(`vt_http_request_duration_seconds{path="/select/traces/spans"}`)
+
 	// no need to track duration for tail requests, as they usually take long time
 	logsqlTailRequests = metrics.NewCounter(`vt_http_requests_total{path="/select/logsql/tail"}`)
 )
@@ -137,6 +152,26 @@ func processSelectRequest(ctx context.Context, w http.ResponseWriter, r *http.Re
 		logsql.ProcessStreamsRequest(ctx, w, r)
 		logsqlStreamsDuration.UpdateDuration(startTime)
 		return true
+	case "/select/traces/usage":
+		tracesUsageRequests.Inc()
+		usage.ProcessUsageRequest(ctx, w, r)
+		tracesUsageDuration.UpdateDuration(startTime)
+		return true
+	case "/select/traces/cardinality":
+		tracesCardinalityRequests.Inc()
+		usage.ProcessCardinalityRequest(ctx, w, r)
+		tracesCardinalityDuration.UpdateDuration(startTime)
+		return true
+	case "/select/traces/export":
+		tracesExportRequests.Inc()
+		otlp.ProcessExportRequest(ctx, w, r)
+		tracesExportDuration.UpdateDuration(startTime)
+		return true
+	case "/select/traces/spans":
+		tracesSpansRequests.Inc()
+		spans.ProcessSpansRequest(ctx, w, r)
+		tracesSpansDuration.UpdateDuration(startTime)
+		return true
 	default:
 		return false
 	}
@@ -18,6 +18,8 @@ import (
 	"github.com/VictoriaMetrics/VictoriaTraces/app/vtselect/internalselect"
 	"github.com/VictoriaMetrics/VictoriaTraces/app/vtselect/logsql"
 	"github.com/VictoriaMetrics/VictoriaTraces/app/vtselect/traces/jaeger"
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtselect/traces/otlp"
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtselect/traces/usage"
 )
 
 var (
@@ -49,10 +51,12 @@ func getDefaultMaxConcurrentRequests() int {
 // Init initializes vtselect
 func Init() {
 	concurrencyLimitCh = make(chan struct{}, *maxConcurrentRequests)
+	usage.Init()
 }
 
 // Stop stops vtselect
 func Stop() {
+	usage.Stop()
 }
 
 var concurrencyLimitCh chan struct{}
@@ -148,6 +152,11 @@ func selectHandler(w http.ResponseWriter, r *http.Request, path string) bool {
 		return jaeger.RequestHandler(ctxWithTimeout, w, r)
 	}
 
+	if strings.HasPrefix(path, "/select/opentelemetry/") {
+		// OTLP HTTP APIs for exporting traces in OpenTelemetry format.
+		return otlp.RequestHandler(ctxWithTimeout, w, r)
+	}
+
 	ok := processSelectRequest(ctxWithTimeout, w, r, path)
 	if !ok {
 		return false
@@ -3,6 +3,7 @@ package jaeger
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"sort"
@@ -11,6 +12,7 @@ import (
 	"time"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httputil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
 	"github.com/VictoriaMetrics/metrics"
 
@@ -37,6 +39,12 @@ var (
 	jaegerTraceRequests = metrics.NewCounter(`vt_http_requests_total{path="/select/jaeger/api/traces/*"}`)
 	jaegerTraceDuration = metrics.NewSummary(`vt_http_request_duration_seconds{path="/select/jaeger/api/traces/*"}`)
 
+	jaegerArchiveRequests = metrics.NewCounter(`vt_http_requests_total{path="/select/jaeger/api/archive"}`)
+	jaegerArchiveDuration = metrics.NewSummary(`vt_http_request_duration_seconds{path="/select/jaeger/api/archive"}`)
+
+	jaegerArchiveTraceRequests = metrics.NewCounter(`vt_http_requests_total{path="/select/jaeger/api/archive/*"}`)
+	jaegerArchiveTraceDuration = metrics.NewSummary(`vt_http_request_duration_seconds{path="/select/jaeger/api/archive/*"}`)
+
 	jaegerDependenciesRequests = metrics.NewCounter(`vt_http_requests_total{path="/select/jaeger/api/dependencies"}`)
 	jaegerDependenciesDuration = metrics.NewSummary(`vt_http_request_duration_seconds{path="/select/jaeger/api/dependencies"}`)
 )
@@ -71,6 +79,16 @@ func RequestHandler(ctx context.Context, w http.ResponseWriter, r *http.Request)
 		processGetTraceRequest(ctx, w, r)
 		jaegerTraceDuration.UpdateDuration(startTime)
 		return true
+	} else if path == "/select/jaeger/api/archive" {
+		jaegerArchiveRequests.Inc()
+		processGetArchivedTracesRequest(ctx, w, r)
+		jaegerArchiveDuration.UpdateDuration(startTime)
+		return true
+	} else if strings.HasPrefix(path, "/select/jaeger/api/archive/") && len(path) > len("/select/jaeger/api/archive/") {
+		jaegerArchiveTraceRequests.Inc()
+		processArchiveTraceRequest(ctx, w, r)
+		jaegerArchiveTraceDuration.UpdateDuration(startTime)
+		return true
 	} else if path == "/select/jaeger/api/dependencies" {
 		jaegerDependenciesRequests.Inc()
 		// todo it require additional component to calculate the dependency graph. not implemented yet.
@@ -159,12 +177,109 @@ func processGetTraceRequest(ctx context.Context, w http.ResponseWriter, r *http.
 		return
 	}
 
+	t := rowsToTrace(rows)
+
+	// Write results
+	w.Header().Set("Content-Type", "application/json")
+	WriteGetTracesResponse(w, []*trace{t}, "", nil)
+}
+
+// processArchiveTraceRequest handle the Jaeger /api/archive/<trace_id> API request.
+//
+// POST request copies the trace to the archive, so it outlives the retention. GET request returns the archived trace.
+// DELETE request deletes the trace from the archive.
+// https://github.com/jaegertracing/jaeger/blob/9a45f522422c548827b2f3897affc8170e4a3d8b/cmd/query/app/http_handler.go#L134
+func processArchiveTraceRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	cp, err := query.GetCommonParams(r)
+	if err != nil {
+		httpserver.Errorf(w, r, "incorrect query params: %s", err)
+		return
+	}
+
+	// extract the `trace_id`.
+	// the path must be like `/select/jaeger/api/archive/<trace_id>`.
+	traceID := r.URL.Path[len("/select/jaeger/api/archive/"):]
+	if strings.Contains(traceID, "/") {
+		httpserver.Errorf(w, r, "incorrect query path [%s]", r.URL.Path)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		if _, err := query.ArchiveTrace(ctx, cp, traceID); err != nil {
+			if errors.Is(err, query.ErrTraceNotFound) {
+				err = &httpserver.ErrorWithStatusCode{
+					Err:        err,
+					StatusCode: http.StatusNotFound,
+				}
+			}
+			httpserver.Errorf(w, r, "cannot archive trace_id=%q: %s", traceID, err)
+			return
+		}
+
+		// Jaeger returns empty data on successful archiving.
+		w.Header().Set("Content-Type", "application/json")
+		WriteGetTracesResponse(w, nil, "", nil)
+	case http.MethodGet:
+		rows, err := query.GetArchivedTrace(ctx, cp, traceID)
+		if err != nil {
+			httpserver.Errorf(w, r, "cannot get archived trace: %s", err)
+			return
+		}
+
+		t := rowsToTrace(rows)
+		w.Header().Set("Content-Type", "application/json")
+		WriteGetTracesResponse(w, []*trace{t}, "", nil)
+	case http.MethodDelete:
+		ok, err := query.UnarchiveTrace(ctx, cp, traceID)
+		if err != nil {
+			httpserver.Errorf(w, r, "cannot delete trace_id=%q from archive: %s", traceID, err)
+			return
+		}
+		if !ok {
+			err := &httpserver.ErrorWithStatusCode{
+				Err:        fmt.Errorf("cannot find trace_id=%q in archive", traceID),
+				StatusCode: http.StatusNotFound,
+			}
+			httpserver.Errorf(w, r, "%s", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		WriteGetTracesResponse(w, nil, "", nil)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// processGetArchivedTracesRequest handle the /api/archive API request, which returns the list of archived traces.
+//
+// This API is missing in Jaeger.
+func processGetArchivedTracesRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	cp, err := query.GetCommonParams(r)
+	if err != nil {
+		httpserver.Errorf(w, r, "incorrect query params: %s", err)
+		return
+	}
+
+	traces, err := query.ListArchivedTraces(ctx, cp)
+	if err != nil {
+		httpserver.Errorf(w, r, "cannot get archived traces: %s", err)
+		return
+	}
+
+	// Write results
+	w.Header().Set("Content-Type", "application/json")
+	WriteGetArchivedTracesResponse(w, traces)
+}
+
+// rowsToTrace converts rows with spans of a single trace to Jaeger trace.
+func rowsToTrace(rows []*query.Row) *trace {
 	t := &trace{}
 	processHashIDMap := make(map[uint64]string)     // process name -> process id
 	processIDProcessMap := make(map[string]process) // process id -> process
 	for i := range rows {
-		var sp *span
-		sp, err = fieldsToSpan(rows[i].Fields)
+		sp, err := fieldsToSpan(rows[i].Fields)
 		if err != nil {
 			logger.Errorf("cannot unmarshal log fields [%v] to span: %s", rows[i].Fields, err)
 			continue
@@ -195,9 +310,7 @@ func processGetTraceRequest(ctx context.Context, w http.ResponseWriter, r *http.
 		return t.processMap[i].processID < t.processMap[j].processID
 	})
 
-	// Write results
-	w.Header().Set("Content-Type", "application/json")
-	WriteGetTracesResponse(w, []*trace{t})
+	return t
 }
 
 // processGetTracesRequest handle the Jaeger /api/traces API request.
@@ -215,15 +328,19 @@ func processGetTracesRequest(ctx context.Context, w http.ResponseWriter, r *http
 		return
 	}
 
-	traceIDList, rows, err := query.GetTraceList(ctx, cp, param)
+	traceIDList, rows, nextCursor, stats, err := query.GetTraceList(ctx, cp, param)
 	if err != nil {
 		httpserver.Errorf(w, r, "get trace list error: %s", err)
 		return
 	}
+	var nextCursorStr string
+	if nextCursor != nil {
+		nextCursorStr = nextCursor.String()
+	}
 	if len(rows) == 0 {
 		// Write empty results
 		w.Header().Set("Content-Type", "application/json")
-		WriteGetTracesResponse(w, nil)
+		WriteGetTracesResponse(w, nil, "", stats)
 		return
 	}
 
@@ -286,7 +403,7 @@ func processGetTracesRequest(ctx context.Context, w http.ResponseWriter, r *http
 
 	// Write results
 	w.Header().Set("Content-Type", "application/json")
-	WriteGetTracesResponse(w, traces)
+	WriteGetTracesResponse(w, traces, nextCursorStr, stats)
 }
 
 // parseJaegerTraceQueryParam parse Jaeger request to unified query.TraceQueryParam.
@@ -332,6 +449,16 @@ func parseJaegerTraceQueryParam(_ context.Context, r *http.Request) (*query.Trac
 		}
 	}
 
+	cursor := q.Get("cursor")
+	if cursor != "" {
+		p.Cursor, err = query.ParseTraceCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	p.NoCache = httputil.GetBool(r, "nocache")
+
 	startTimeMin := q.Get("start")
 	if startTimeMin != "" {
 		unixNano, err := strconv.ParseInt(startTimeMin, 10, 64)
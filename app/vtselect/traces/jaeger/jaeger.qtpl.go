@@ -1,570 +1,694 @@
 // Code generated by qtc from "jaeger.qtpl". DO NOT EDIT.
 // See https://github.com/valyala/quicktemplate for details.
 
-//line app/vtselect/traces/jaeger/jaeger.qtpl:1
+//line jaeger.qtpl:1
 package jaeger
 
-//line app/vtselect/traces/jaeger/jaeger.qtpl:1
+//line jaeger.qtpl:1
 import (
 	"sort"
+
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtselect/traces/query"
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtstorage"
 )
 
-//line app/vtselect/traces/jaeger/jaeger.qtpl:7
+//line jaeger.qtpl:10
 import (
 	qtio422016 "io"
 
 	qt422016 "github.com/valyala/quicktemplate"
 )
 
-//line app/vtselect/traces/jaeger/jaeger.qtpl:7
+//line jaeger.qtpl:10
 var (
 	_ = qtio422016.Copy
 	_ = qt422016.AcquireByteBuffer
 )
 
-//line app/vtselect/traces/jaeger/jaeger.qtpl:7
+//line jaeger.qtpl:10
 func StreamGetServicesResponse(qw422016 *qt422016.Writer, serviceList []string) {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:7
+//line jaeger.qtpl:10
 	qw422016.N().S(`{`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:10
+//line jaeger.qtpl:13
 	sort.Slice(serviceList, func(i, j int) bool { return serviceList[i] < serviceList[j] })
 
-//line app/vtselect/traces/jaeger/jaeger.qtpl:11
+//line jaeger.qtpl:14
 	qw422016.N().S(`"data":[`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:13
+//line jaeger.qtpl:16
 	if len(serviceList) > 0 {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:14
+//line jaeger.qtpl:17
 		qw422016.N().Q(serviceList[0])
-//line app/vtselect/traces/jaeger/jaeger.qtpl:15
+//line jaeger.qtpl:18
 		for _, service := range serviceList[1:] {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:15
+//line jaeger.qtpl:18
 			qw422016.N().S(`,`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:16
+//line jaeger.qtpl:19
 			qw422016.N().Q(service)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:17
+//line jaeger.qtpl:20
 		}
-//line app/vtselect/traces/jaeger/jaeger.qtpl:18
+//line jaeger.qtpl:21
 	}
-//line app/vtselect/traces/jaeger/jaeger.qtpl:18
+//line jaeger.qtpl:21
 	qw422016.N().S(`],"errors": null,"limit": 0,"offset": 0,"total":`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:23
+//line jaeger.qtpl:26
 	qw422016.N().D(len(serviceList))
-//line app/vtselect/traces/jaeger/jaeger.qtpl:23
+//line jaeger.qtpl:26
 	qw422016.N().S(`}`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:25
+//line jaeger.qtpl:28
 }
 
-//line app/vtselect/traces/jaeger/jaeger.qtpl:25
+//line jaeger.qtpl:28
 func WriteGetServicesResponse(qq422016 qtio422016.Writer, serviceList []string) {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:25
+//line jaeger.qtpl:28
 	qw422016 := qt422016.AcquireWriter(qq422016)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:25
+//line jaeger.qtpl:28
 	StreamGetServicesResponse(qw422016, serviceList)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:25
+//line jaeger.qtpl:28
 	qt422016.ReleaseWriter(qw422016)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:25
+//line jaeger.qtpl:28
 }
 
-//line app/vtselect/traces/jaeger/jaeger.qtpl:25
+//line jaeger.qtpl:28
 func GetServicesResponse(serviceList []string) string {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:25
+//line jaeger.qtpl:28
 	qb422016 := qt422016.AcquireByteBuffer()
-//line app/vtselect/traces/jaeger/jaeger.qtpl:25
+//line jaeger.qtpl:28
 	WriteGetServicesResponse(qb422016, serviceList)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:25
+//line jaeger.qtpl:28
 	qs422016 := string(qb422016.B)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:25
+//line jaeger.qtpl:28
 	qt422016.ReleaseByteBuffer(qb422016)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:25
+//line jaeger.qtpl:28
 	return qs422016
-//line app/vtselect/traces/jaeger/jaeger.qtpl:25
+//line jaeger.qtpl:28
 }
 
-//line app/vtselect/traces/jaeger/jaeger.qtpl:27
+//line jaeger.qtpl:30
 func StreamGetOperationsResponse(qw422016 *qt422016.Writer, operationList []string) {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:27
+//line jaeger.qtpl:30
 	qw422016.N().S(`{`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:30
+//line jaeger.qtpl:33
 	sort.Slice(operationList, func(i, j int) bool { return operationList[i] < operationList[j] })
 
-//line app/vtselect/traces/jaeger/jaeger.qtpl:31
+//line jaeger.qtpl:34
 	qw422016.N().S(`"data":[`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:33
+//line jaeger.qtpl:36
 	if len(operationList) > 0 {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:34
+//line jaeger.qtpl:37
 		qw422016.N().Q(operationList[0])
-//line app/vtselect/traces/jaeger/jaeger.qtpl:35
+//line jaeger.qtpl:38
 		for _, operation := range operationList[1:] {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:35
+//line jaeger.qtpl:38
 			qw422016.N().S(`,`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:36
+//line jaeger.qtpl:39
 			qw422016.N().Q(operation)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:37
+//line jaeger.qtpl:40
 		}
-//line app/vtselect/traces/jaeger/jaeger.qtpl:38
+//line jaeger.qtpl:41
 	}
-//line app/vtselect/traces/jaeger/jaeger.qtpl:38
+//line jaeger.qtpl:41
 	qw422016.N().S(`],"errors": null,"limit": 0,"offset": 0,"total":`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:43
+//line jaeger.qtpl:46
 	qw422016.N().D(len(operationList))
-//line app/vtselect/traces/jaeger/jaeger.qtpl:43
+//line jaeger.qtpl:46
 	qw422016.N().S(`}`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:45
+//line jaeger.qtpl:48
 }
 
-//line app/vtselect/traces/jaeger/jaeger.qtpl:45
+//line jaeger.qtpl:48
 func WriteGetOperationsResponse(qq422016 qtio422016.Writer, operationList []string) {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:45
+//line jaeger.qtpl:48
 	qw422016 := qt422016.AcquireWriter(qq422016)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:45
+//line jaeger.qtpl:48
 	StreamGetOperationsResponse(qw422016, operationList)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:45
+//line jaeger.qtpl:48
 	qt422016.ReleaseWriter(qw422016)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:45
+//line jaeger.qtpl:48
 }
 
-//line app/vtselect/traces/jaeger/jaeger.qtpl:45
+//line jaeger.qtpl:48
 func GetOperationsResponse(operationList []string) string {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:45
+//line jaeger.qtpl:48
 	qb422016 := qt422016.AcquireByteBuffer()
-//line app/vtselect/traces/jaeger/jaeger.qtpl:45
+//line jaeger.qtpl:48
 	WriteGetOperationsResponse(qb422016, operationList)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:45
+//line jaeger.qtpl:48
 	qs422016 := string(qb422016.B)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:45
+//line jaeger.qtpl:48
 	qt422016.ReleaseByteBuffer(qb422016)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:45
+//line jaeger.qtpl:48
 	return qs422016
-//line app/vtselect/traces/jaeger/jaeger.qtpl:45
+//line jaeger.qtpl:48
 }
 
-//line app/vtselect/traces/jaeger/jaeger.qtpl:47
-func StreamGetTracesResponse(qw422016 *qt422016.Writer, traces []*trace) {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:47
+//line jaeger.qtpl:50
+func StreamGetTracesResponse(qw422016 *qt422016.Writer, traces []*trace, nextCursor string, stats *query.TraceSearchStats) {
+//line jaeger.qtpl:50
 	qw422016.N().S(`{"data":[`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:50
+//line jaeger.qtpl:53
 	if len(traces) > 0 && len(traces[0].spans) > 0 {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:51
+//line jaeger.qtpl:54
 		streamtraceJson(qw422016, traces[0])
-//line app/vtselect/traces/jaeger/jaeger.qtpl:52
+//line jaeger.qtpl:55
 		for _, trace := range traces[1:] {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:53
+//line jaeger.qtpl:56
 			if len(trace.spans) > 0 {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:53
+//line jaeger.qtpl:56
 				qw422016.N().S(`,`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:54
+//line jaeger.qtpl:57
 				streamtraceJson(qw422016, trace)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:55
+//line jaeger.qtpl:58
 			}
-//line app/vtselect/traces/jaeger/jaeger.qtpl:56
+//line jaeger.qtpl:59
+		}
+//line jaeger.qtpl:60
+	}
+//line jaeger.qtpl:60
+	qw422016.N().S(`],"errors": null,"limit": 0,"offset": 0,`)
+//line jaeger.qtpl:65
+	if nextCursor != "" {
+//line jaeger.qtpl:65
+		qw422016.N().S(`"nextCursor":`)
+//line jaeger.qtpl:66
+		qw422016.N().Q(nextCursor)
+//line jaeger.qtpl:66
+		qw422016.N().S(`,`)
+//line jaeger.qtpl:67
+	}
+//line jaeger.qtpl:68
+	if stats != nil {
+//line jaeger.qtpl:68
+		qw422016.N().S(`"searchStats": {"scannedWindows":`)
+//line jaeger.qtpl:70
+		qw422016.N().D(stats.ScannedWindows)
+//line jaeger.qtpl:70
+		qw422016.N().S(`},`)
+//line jaeger.qtpl:72
+	}
+//line jaeger.qtpl:72
+	qw422016.N().S(`"total":`)
+//line jaeger.qtpl:73
+	qw422016.N().D(len(traces))
+//line jaeger.qtpl:73
+	qw422016.N().S(`}`)
+//line jaeger.qtpl:75
+}
+
+//line jaeger.qtpl:75
+func WriteGetTracesResponse(qq422016 qtio422016.Writer, traces []*trace, nextCursor string, stats *query.TraceSearchStats) {
+//line jaeger.qtpl:75
+	qw422016 := qt422016.AcquireWriter(qq422016)
+//line jaeger.qtpl:75
+	StreamGetTracesResponse(qw422016, traces, nextCursor, stats)
+//line jaeger.qtpl:75
+	qt422016.ReleaseWriter(qw422016)
+//line jaeger.qtpl:75
+}
+
+//line jaeger.qtpl:75
+func GetTracesResponse(traces []*trace, nextCursor string, stats *query.TraceSearchStats) string {
+//line jaeger.qtpl:75
+	qb422016 := qt422016.AcquireByteBuffer()
+//line jaeger.qtpl:75
+	WriteGetTracesResponse(qb422016, traces, nextCursor, stats)
+//line jaeger.qtpl:75
+	qs422016 := string(qb422016.B)
+//line jaeger.qtpl:75
+	qt422016.ReleaseByteBuffer(qb422016)
+//line jaeger.qtpl:75
+	return qs422016
+//line jaeger.qtpl:75
+}
+
+//line jaeger.qtpl:77
+func StreamGetArchivedTracesResponse(qw422016 *qt422016.Writer, traces []vtstorage.ArchivedTrace) {
+//line jaeger.qtpl:77
+	qw422016.N().S(`{"data":[`)
+//line jaeger.qtpl:80
+	for i, t := range traces {
+//line jaeger.qtpl:80
+		qw422016.N().S(`{"traceID":`)
+//line jaeger.qtpl:82
+		qw422016.N().Q(t.TraceID)
+//line jaeger.qtpl:82
+		qw422016.N().S(`,"startTime":`)
+//line jaeger.qtpl:83
+		qw422016.N().DL(t.Start / 1000)
+//line jaeger.qtpl:83
+		qw422016.N().S(`,"endTime":`)
+//line jaeger.qtpl:84
+		qw422016.N().DL(t.End / 1000)
+//line jaeger.qtpl:84
+		qw422016.N().S(`,"spans":`)
+//line jaeger.qtpl:85
+		qw422016.N().DUL(t.Spans)
+//line jaeger.qtpl:85
+		qw422016.N().S(`,"archivedAt":`)
+//line jaeger.qtpl:86
+		qw422016.N().Q(t.ArchivedAt)
+//line jaeger.qtpl:86
+		qw422016.N().S(`}`)
+//line jaeger.qtpl:88
+		if i+1 < len(traces) {
+//line jaeger.qtpl:88
+			qw422016.N().S(`,`)
+//line jaeger.qtpl:88
 		}
-//line app/vtselect/traces/jaeger/jaeger.qtpl:57
+//line jaeger.qtpl:89
 	}
-//line app/vtselect/traces/jaeger/jaeger.qtpl:57
+//line jaeger.qtpl:89
 	qw422016.N().S(`],"errors": null,"limit": 0,"offset": 0,"total":`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:62
+//line jaeger.qtpl:94
 	qw422016.N().D(len(traces))
-//line app/vtselect/traces/jaeger/jaeger.qtpl:62
+//line jaeger.qtpl:94
 	qw422016.N().S(`}`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:64
+//line jaeger.qtpl:96
 }
 
-//line app/vtselect/traces/jaeger/jaeger.qtpl:64
-func WriteGetTracesResponse(qq422016 qtio422016.Writer, traces []*trace) {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:64
+//line jaeger.qtpl:96
+func WriteGetArchivedTracesResponse(qq422016 qtio422016.Writer, traces []vtstorage.ArchivedTrace) {
+//line jaeger.qtpl:96
 	qw422016 := qt422016.AcquireWriter(qq422016)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:64
-	StreamGetTracesResponse(qw422016, traces)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:64
+//line jaeger.qtpl:96
+	StreamGetArchivedTracesResponse(qw422016, traces)
+//line jaeger.qtpl:96
 	qt422016.ReleaseWriter(qw422016)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:64
+//line jaeger.qtpl:96
 }
 
-//line app/vtselect/traces/jaeger/jaeger.qtpl:64
-func GetTracesResponse(traces []*trace) string {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:64
+//line jaeger.qtpl:96
+func GetArchivedTracesResponse(traces []vtstorage.ArchivedTrace) string {
+//line jaeger.qtpl:96
 	qb422016 := qt422016.AcquireByteBuffer()
-//line app/vtselect/traces/jaeger/jaeger.qtpl:64
-	WriteGetTracesResponse(qb422016, traces)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:64
+//line jaeger.qtpl:96
+	WriteGetArchivedTracesResponse(qb422016, traces)
+//line jaeger.qtpl:96
 	qs422016 := string(qb422016.B)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:64
+//line jaeger.qtpl:96
 	qt422016.ReleaseByteBuffer(qb422016)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:64
+//line jaeger.qtpl:96
 	return qs422016
-//line app/vtselect/traces/jaeger/jaeger.qtpl:64
+//line jaeger.qtpl:96
 }
 
-//line app/vtselect/traces/jaeger/jaeger.qtpl:66
+//line jaeger.qtpl:98
 func streamtraceJson(qw422016 *qt422016.Writer, trace *trace) {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:66
+//line jaeger.qtpl:98
 	qw422016.N().S(`{"processes": {`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:69
+//line jaeger.qtpl:101
 	if len(trace.processMap) > 0 {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:70
+//line jaeger.qtpl:102
 		qw422016.N().Q(trace.processMap[0].processID)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:70
+//line jaeger.qtpl:102
 		qw422016.N().S(`:`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:70
+//line jaeger.qtpl:102
 		streamprocessJson(qw422016, trace.processMap[0].process)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:71
+//line jaeger.qtpl:103
 		for _, v := range trace.processMap[1:] {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:71
+//line jaeger.qtpl:103
 			qw422016.N().S(`,`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:72
+//line jaeger.qtpl:104
 			qw422016.N().Q(v.processID)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:72
+//line jaeger.qtpl:104
 			qw422016.N().S(`:`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:72
+//line jaeger.qtpl:104
 			streamprocessJson(qw422016, v.process)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:73
+//line jaeger.qtpl:105
 		}
-//line app/vtselect/traces/jaeger/jaeger.qtpl:74
+//line jaeger.qtpl:106
 	}
-//line app/vtselect/traces/jaeger/jaeger.qtpl:74
+//line jaeger.qtpl:106
 	qw422016.N().S(`},"spans": [`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:77
+//line jaeger.qtpl:109
 	if len(trace.spans) > 0 {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:78
+//line jaeger.qtpl:110
 		streamspanJson(qw422016, trace.spans[0])
-//line app/vtselect/traces/jaeger/jaeger.qtpl:79
+//line jaeger.qtpl:111
 		for _, v := range trace.spans[1:] {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:79
+//line jaeger.qtpl:111
 			qw422016.N().S(`,`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:80
+//line jaeger.qtpl:112
 			streamspanJson(qw422016, v)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:81
+//line jaeger.qtpl:113
 		}
-//line app/vtselect/traces/jaeger/jaeger.qtpl:82
+//line jaeger.qtpl:114
 	}
-//line app/vtselect/traces/jaeger/jaeger.qtpl:82
+//line jaeger.qtpl:114
 	qw422016.N().S(`],"traceID":`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:84
+//line jaeger.qtpl:116
 	qw422016.N().Q(trace.spans[0].traceID)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:84
+//line jaeger.qtpl:116
 	qw422016.N().S(`,"warnings": null}`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:87
+//line jaeger.qtpl:119
 }
 
-//line app/vtselect/traces/jaeger/jaeger.qtpl:87
+//line jaeger.qtpl:119
 func writetraceJson(qq422016 qtio422016.Writer, trace *trace) {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:87
+//line jaeger.qtpl:119
 	qw422016 := qt422016.AcquireWriter(qq422016)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:87
+//line jaeger.qtpl:119
 	streamtraceJson(qw422016, trace)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:87
+//line jaeger.qtpl:119
 	qt422016.ReleaseWriter(qw422016)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:87
+//line jaeger.qtpl:119
 }
 
-//line app/vtselect/traces/jaeger/jaeger.qtpl:87
+//line jaeger.qtpl:119
 func traceJson(trace *trace) string {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:87
+//line jaeger.qtpl:119
 	qb422016 := qt422016.AcquireByteBuffer()
-//line app/vtselect/traces/jaeger/jaeger.qtpl:87
+//line jaeger.qtpl:119
 	writetraceJson(qb422016, trace)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:87
+//line jaeger.qtpl:119
 	qs422016 := string(qb422016.B)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:87
+//line jaeger.qtpl:119
 	qt422016.ReleaseByteBuffer(qb422016)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:87
+//line jaeger.qtpl:119
 	return qs422016
-//line app/vtselect/traces/jaeger/jaeger.qtpl:87
+//line jaeger.qtpl:119
 }
 
-//line app/vtselect/traces/jaeger/jaeger.qtpl:89
+//line jaeger.qtpl:121
 func streamprocessJson(qw422016 *qt422016.Writer, process process) {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:89
+//line jaeger.qtpl:121
 	qw422016.N().S(`{"serviceName":`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:91
+//line jaeger.qtpl:123
 	qw422016.N().Q(process.serviceName)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:91
+//line jaeger.qtpl:123
 	qw422016.N().S(`,"tags": [`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:93
+//line jaeger.qtpl:125
 	if len(process.tags) > 0 {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:94
+//line jaeger.qtpl:126
 		streamtagJson(qw422016, process.tags[0])
-//line app/vtselect/traces/jaeger/jaeger.qtpl:95
+//line jaeger.qtpl:127
 		for _, v := range process.tags[1:] {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:95
+//line jaeger.qtpl:127
 			qw422016.N().S(`,`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:96
+//line jaeger.qtpl:128
 			streamtagJson(qw422016, v)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:97
+//line jaeger.qtpl:129
 		}
-//line app/vtselect/traces/jaeger/jaeger.qtpl:98
+//line jaeger.qtpl:130
 	}
-//line app/vtselect/traces/jaeger/jaeger.qtpl:98
+//line jaeger.qtpl:130
 	qw422016.N().S(`]}`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:101
+//line jaeger.qtpl:133
 }
 
-//line app/vtselect/traces/jaeger/jaeger.qtpl:101
+//line jaeger.qtpl:133
 func writeprocessJson(qq422016 qtio422016.Writer, process process) {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:101
+//line jaeger.qtpl:133
 	qw422016 := qt422016.AcquireWriter(qq422016)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:101
+//line jaeger.qtpl:133
 	streamprocessJson(qw422016, process)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:101
+//line jaeger.qtpl:133
 	qt422016.ReleaseWriter(qw422016)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:101
+//line jaeger.qtpl:133
 }
 
-//line app/vtselect/traces/jaeger/jaeger.qtpl:101
+//line jaeger.qtpl:133
 func processJson(process process) string {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:101
+//line jaeger.qtpl:133
 	qb422016 := qt422016.AcquireByteBuffer()
-//line app/vtselect/traces/jaeger/jaeger.qtpl:101
+//line jaeger.qtpl:133
 	writeprocessJson(qb422016, process)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:101
+//line jaeger.qtpl:133
 	qs422016 := string(qb422016.B)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:101
+//line jaeger.qtpl:133
 	qt422016.ReleaseByteBuffer(qb422016)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:101
+//line jaeger.qtpl:133
 	return qs422016
-//line app/vtselect/traces/jaeger/jaeger.qtpl:101
+//line jaeger.qtpl:133
 }
 
-//line app/vtselect/traces/jaeger/jaeger.qtpl:103
+//line jaeger.qtpl:135
 func streamspanJson(qw422016 *qt422016.Writer, span *span) {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:103
+//line jaeger.qtpl:135
 	qw422016.N().S(`{"duration":`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:105
+//line jaeger.qtpl:137
 	qw422016.N().DL(span.duration)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:105
+//line jaeger.qtpl:137
+	qw422016.N().S(`,"flags":`)
+//line jaeger.qtpl:138
+	qw422016.N().DUL(uint64(span.flags))
+//line jaeger.qtpl:138
 	qw422016.N().S(`,"logs":[`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:107
+//line jaeger.qtpl:140
 	if len(span.logs) > 0 {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:108
+//line jaeger.qtpl:141
 		streamlogJson(qw422016, span.logs[0])
-//line app/vtselect/traces/jaeger/jaeger.qtpl:109
+//line jaeger.qtpl:142
 		for _, v := range span.logs[1:] {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:109
+//line jaeger.qtpl:142
 			qw422016.N().S(`,`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:110
+//line jaeger.qtpl:143
 			streamlogJson(qw422016, v)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:111
+//line jaeger.qtpl:144
 		}
-//line app/vtselect/traces/jaeger/jaeger.qtpl:112
+//line jaeger.qtpl:145
 	}
-//line app/vtselect/traces/jaeger/jaeger.qtpl:112
+//line jaeger.qtpl:145
 	qw422016.N().S(`],"operationName":`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:114
+//line jaeger.qtpl:147
 	qw422016.N().Q(span.operationName)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:114
+//line jaeger.qtpl:147
 	qw422016.N().S(`,"processID":`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:115
+//line jaeger.qtpl:148
 	qw422016.N().Q(span.processID)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:115
+//line jaeger.qtpl:148
 	qw422016.N().S(`,"references": [`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:117
+//line jaeger.qtpl:150
 	if len(span.references) > 0 {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:118
+//line jaeger.qtpl:151
 		streamspanRefJson(qw422016, span.references[0])
-//line app/vtselect/traces/jaeger/jaeger.qtpl:119
+//line jaeger.qtpl:152
 		for _, v := range span.references[1:] {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:119
+//line jaeger.qtpl:152
 			qw422016.N().S(`,`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:120
+//line jaeger.qtpl:153
 			streamspanRefJson(qw422016, v)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:121
+//line jaeger.qtpl:154
 		}
-//line app/vtselect/traces/jaeger/jaeger.qtpl:122
+//line jaeger.qtpl:155
 	}
-//line app/vtselect/traces/jaeger/jaeger.qtpl:122
+//line jaeger.qtpl:155
 	qw422016.N().S(`],"spanID":`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:124
+//line jaeger.qtpl:157
 	qw422016.N().Q(span.spanID)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:124
+//line jaeger.qtpl:157
 	qw422016.N().S(`,"startTime":`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:125
+//line jaeger.qtpl:158
 	qw422016.N().DL(span.startTime)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:125
+//line jaeger.qtpl:158
 	qw422016.N().S(`,"tags": [`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:127
+//line jaeger.qtpl:160
 	if len(span.tags) > 0 {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:128
+//line jaeger.qtpl:161
 		streamtagJson(qw422016, span.tags[0])
-//line app/vtselect/traces/jaeger/jaeger.qtpl:129
+//line jaeger.qtpl:162
 		for _, v := range span.tags[1:] {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:129
+//line jaeger.qtpl:162
 			qw422016.N().S(`,`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:130
+//line jaeger.qtpl:163
 			streamtagJson(qw422016, v)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:131
+//line jaeger.qtpl:164
 		}
-//line app/vtselect/traces/jaeger/jaeger.qtpl:132
+//line jaeger.qtpl:165
 	}
-//line app/vtselect/traces/jaeger/jaeger.qtpl:132
+//line jaeger.qtpl:165
 	qw422016.N().S(`],"traceID":`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:134
+//line jaeger.qtpl:167
 	qw422016.N().Q(span.traceID)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:134
+//line jaeger.qtpl:167
 	qw422016.N().S(`,"warnings":null}`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:137
+//line jaeger.qtpl:170
 }
 
-//line app/vtselect/traces/jaeger/jaeger.qtpl:137
+//line jaeger.qtpl:170
 func writespanJson(qq422016 qtio422016.Writer, span *span) {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:137
+//line jaeger.qtpl:170
 	qw422016 := qt422016.AcquireWriter(qq422016)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:137
+//line jaeger.qtpl:170
 	streamspanJson(qw422016, span)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:137
+//line jaeger.qtpl:170
 	qt422016.ReleaseWriter(qw422016)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:137
+//line jaeger.qtpl:170
 }
 
-//line app/vtselect/traces/jaeger/jaeger.qtpl:137
+//line jaeger.qtpl:170
 func spanJson(span *span) string {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:137
+//line jaeger.qtpl:170
 	qb422016 := qt422016.AcquireByteBuffer()
-//line app/vtselect/traces/jaeger/jaeger.qtpl:137
+//line jaeger.qtpl:170
 	writespanJson(qb422016, span)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:137
+//line jaeger.qtpl:170
 	qs422016 := string(qb422016.B)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:137
+//line jaeger.qtpl:170
 	qt422016.ReleaseByteBuffer(qb422016)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:137
+//line jaeger.qtpl:170
 	return qs422016
-//line app/vtselect/traces/jaeger/jaeger.qtpl:137
+//line jaeger.qtpl:170
 }
 
-//line app/vtselect/traces/jaeger/jaeger.qtpl:139
+//line jaeger.qtpl:172
 func streamtagJson(qw422016 *qt422016.Writer, tag keyValue) {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:139
+//line jaeger.qtpl:172
 	qw422016.N().S(`{"key":`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:141
+//line jaeger.qtpl:174
 	qw422016.N().Q(tag.key)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:141
-	qw422016.N().S(`,"type":"string","value":`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:143
-	qw422016.N().Q(tag.vStr)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:143
+//line jaeger.qtpl:174
+	qw422016.N().S(`,`)
+//line jaeger.qtpl:175
+	switch tag.vType {
+//line jaeger.qtpl:176
+	case "bool", "int64", "float64":
+//line jaeger.qtpl:176
+		qw422016.N().S(`"type":`)
+//line jaeger.qtpl:177
+		qw422016.N().Q(tag.vType)
+//line jaeger.qtpl:177
+		qw422016.N().S(`,"value":`)
+//line jaeger.qtpl:178
+		qw422016.N().S(tag.vStr)
+//line jaeger.qtpl:179
+	case "binary":
+//line jaeger.qtpl:179
+		qw422016.N().S(`"type":"binary","value":`)
+//line jaeger.qtpl:181
+		qw422016.N().Q(tag.vStr)
+//line jaeger.qtpl:182
+	default:
+//line jaeger.qtpl:182
+		qw422016.N().S(`"type":"string","value":`)
+//line jaeger.qtpl:184
+		qw422016.N().Q(tag.vStr)
+//line jaeger.qtpl:185
+	}
+//line jaeger.qtpl:185
 	qw422016.N().S(`}`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:145
+//line jaeger.qtpl:187
 }
 
-//line app/vtselect/traces/jaeger/jaeger.qtpl:145
+//line jaeger.qtpl:187
 func writetagJson(qq422016 qtio422016.Writer, tag keyValue) {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:145
+//line jaeger.qtpl:187
 	qw422016 := qt422016.AcquireWriter(qq422016)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:145
+//line jaeger.qtpl:187
 	streamtagJson(qw422016, tag)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:145
+//line jaeger.qtpl:187
 	qt422016.ReleaseWriter(qw422016)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:145
+//line jaeger.qtpl:187
 }
 
-//line app/vtselect/traces/jaeger/jaeger.qtpl:145
+//line jaeger.qtpl:187
 func tagJson(tag keyValue) string {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:145
+//line jaeger.qtpl:187
 	qb422016 := qt422016.AcquireByteBuffer()
-//line app/vtselect/traces/jaeger/jaeger.qtpl:145
+//line jaeger.qtpl:187
 	writetagJson(qb422016, tag)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:145
+//line jaeger.qtpl:187
 	qs422016 := string(qb422016.B)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:145
+//line jaeger.qtpl:187
 	qt422016.ReleaseByteBuffer(qb422016)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:145
+//line jaeger.qtpl:187
 	return qs422016
-//line app/vtselect/traces/jaeger/jaeger.qtpl:145
+//line jaeger.qtpl:187
 }
 
-//line app/vtselect/traces/jaeger/jaeger.qtpl:147
+//line jaeger.qtpl:189
 func streamlogJson(qw422016 *qt422016.Writer, l log) {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:147
+//line jaeger.qtpl:189
 	qw422016.N().S(`{"timestamp":`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:149
+//line jaeger.qtpl:191
 	qw422016.N().DL(l.timestamp)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:149
+//line jaeger.qtpl:191
 	qw422016.N().S(`,"fields":[`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:151
+//line jaeger.qtpl:193
 	if len(l.fields) > 0 {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:152
+//line jaeger.qtpl:194
 		streamtagJson(qw422016, l.fields[0])
-//line app/vtselect/traces/jaeger/jaeger.qtpl:153
+//line jaeger.qtpl:195
 		for _, v := range l.fields[1:] {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:153
+//line jaeger.qtpl:195
 			qw422016.N().S(`,`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:154
+//line jaeger.qtpl:196
 			streamtagJson(qw422016, v)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:155
+//line jaeger.qtpl:197
 		}
-//line app/vtselect/traces/jaeger/jaeger.qtpl:156
+//line jaeger.qtpl:198
 	}
-//line app/vtselect/traces/jaeger/jaeger.qtpl:156
+//line jaeger.qtpl:198
 	qw422016.N().S(`]}`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:159
+//line jaeger.qtpl:201
 }
 
-//line app/vtselect/traces/jaeger/jaeger.qtpl:159
+//line jaeger.qtpl:201
 func writelogJson(qq422016 qtio422016.Writer, l log) {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:159
+//line jaeger.qtpl:201
 	qw422016 := qt422016.AcquireWriter(qq422016)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:159
+//line jaeger.qtpl:201
 	streamlogJson(qw422016, l)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:159
+//line jaeger.qtpl:201
 	qt422016.ReleaseWriter(qw422016)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:159
+//line jaeger.qtpl:201
 }
 
-//line app/vtselect/traces/jaeger/jaeger.qtpl:159
+//line jaeger.qtpl:201
 func logJson(l log) string {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:159
+//line jaeger.qtpl:201
 	qb422016 := qt422016.AcquireByteBuffer()
-//line app/vtselect/traces/jaeger/jaeger.qtpl:159
+//line jaeger.qtpl:201
 	writelogJson(qb422016, l)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:159
+//line jaeger.qtpl:201
 	qs422016 := string(qb422016.B)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:159
+//line jaeger.qtpl:201
 	qt422016.ReleaseByteBuffer(qb422016)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:159
+//line jaeger.qtpl:201
 	return qs422016
-//line app/vtselect/traces/jaeger/jaeger.qtpl:159
+//line jaeger.qtpl:201
 }
 
-//line app/vtselect/traces/jaeger/jaeger.qtpl:161
+//line jaeger.qtpl:203
 func streamspanRefJson(qw422016 *qt422016.Writer, ref spanRef) {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:161
+//line jaeger.qtpl:203
 	qw422016.N().S(`{"refType":`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:163
+//line jaeger.qtpl:205
 	qw422016.N().Q(ref.refType)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:163
+//line jaeger.qtpl:205
 	qw422016.N().S(`,"spanID":`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:164
+//line jaeger.qtpl:206
 	qw422016.N().Q(ref.spanID)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:164
+//line jaeger.qtpl:206
 	qw422016.N().S(`,"traceID":`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:165
+//line jaeger.qtpl:207
 	qw422016.N().Q(ref.traceID)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:165
+//line jaeger.qtpl:207
 	qw422016.N().S(`}`)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:167
+//line jaeger.qtpl:209
 }
 
-//line app/vtselect/traces/jaeger/jaeger.qtpl:167
+//line jaeger.qtpl:209
 func writespanRefJson(qq422016 qtio422016.Writer, ref spanRef) {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:167
+//line jaeger.qtpl:209
 	qw422016 := qt422016.AcquireWriter(qq422016)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:167
+//line jaeger.qtpl:209
 	streamspanRefJson(qw422016, ref)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:167
+//line jaeger.qtpl:209
 	qt422016.ReleaseWriter(qw422016)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:167
+//line jaeger.qtpl:209
 }
 
-//line app/vtselect/traces/jaeger/jaeger.qtpl:167
+//line jaeger.qtpl:209
 func spanRefJson(ref spanRef) string {
-//line app/vtselect/traces/jaeger/jaeger.qtpl:167
+//line jaeger.qtpl:209
 	qb422016 := qt422016.AcquireByteBuffer()
-//line app/vtselect/traces/jaeger/jaeger.qtpl:167
+//line jaeger.qtpl:209
 	writespanRefJson(qb422016, ref)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:167
+//line jaeger.qtpl:209
 	qs422016 := string(qb422016.B)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:167
+//line jaeger.qtpl:209
 	qt422016.ReleaseByteBuffer(qb422016)
-//line app/vtselect/traces/jaeger/jaeger.qtpl:167
+//line jaeger.qtpl:209
 	return qs422016
-//line app/vtselect/traces/jaeger/jaeger.qtpl:167
+//line jaeger.qtpl:209
 }
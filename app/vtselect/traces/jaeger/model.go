@@ -2,6 +2,8 @@ package jaeger
 
 import (
 	"fmt"
+	"maps"
+	"math"
 	"strconv"
 	"strings"
 
@@ -30,13 +32,13 @@ type span struct {
 	spanID        string
 	operationName string
 	references    []spanRef
-	//flags         uint32 // OTLP - jaeger conversion does not use this field, but it exists in jaeger definition.
-	startTime int64
-	duration  int64
-	tags      []keyValue
-	logs      []log
-	process   process
-	processID string
+	flags         uint32
+	startTime     int64
+	duration      int64
+	tags          []keyValue
+	logs          []log
+	process       process
+	processID     string
 	//warnings      []string // OTLP - jaeger conversion does not use this field, but it exists in jaeger definition.
 }
 
@@ -49,6 +51,9 @@ type spanRef struct {
 type keyValue struct {
 	key  string
 	vStr string
+
+	// vType is the Jaeger tag type such as bool, int64, float64 or binary. The tag is returned as string if vType is empty.
+	vType string
 }
 
 type log struct {
@@ -97,6 +102,9 @@ func fieldsToSpan(fields []logstorage.Field) (*span, error) {
 	logsMap := make(map[string]*log)     // idx -> *Log
 	refsMap := make(map[string]*spanRef) // idx -> *SpanRef
 
+	// obtain the original attribute types, so attributes are returned as tags with proper types.
+	fieldTypes := getAttrFieldTypes(fields)
+
 	parentSpanRef := spanRef{}
 	for _, field := range fields {
 		switch field.Name {
@@ -108,6 +116,9 @@ func fieldsToSpan(fields []logstorage.Field) (*span, error) {
 			sp.spanID = field.Value
 		case otelpb.NameField:
 			sp.operationName = field.Value
+		case otelpb.OriginalNameField:
+			// the span name before the normalization at data ingestion
+			spanTagList = append(spanTagList, keyValue{key: "vt.original_name", vStr: field.Value})
 		case otelpb.ParentSpanIDField:
 			parentSpanRef.spanID = field.Value
 			parentSpanRef.refType = "CHILD_OF"
@@ -132,12 +143,11 @@ func fieldsToSpan(fields []logstorage.Field) (*span, error) {
 				spanTagList = append(spanTagList, keyValue{key: "span.kind", vStr: spanKind})
 			}
 		case otelpb.FlagsField:
-			// todo trace does not contain "flag" in result
-			//flagU64, err := strconv.ParseUint(field.Value, 10, 32)
-			//if err != nil {
-			//	return nil, err
-			//}
-			//sp.Flags = uint32(flagU64)
+			flags, err := strconv.ParseUint(field.Value, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid flags field: %s", err)
+			}
+			sp.flags = uint32(flags)
 		case otelpb.StartTimeUnixNanoField:
 			unixNano, err := strconv.ParseInt(field.Value, 10, 64)
 			if err != nil {
@@ -170,6 +180,9 @@ func fieldsToSpan(fields []logstorage.Field) (*span, error) {
 		// resource level fields
 		case otelpb.ResourceAttrServiceName:
 			sp.process.serviceName = field.Value
+		// region of the storage node, which returned the span
+		case otelpb.RegionField:
+			processTagList = append(processTagList, keyValue{key: "vt.region", vStr: field.Value})
 		// scope level fields
 		case otelpb.InstrumentationScopeName:
 			if field.Value != "" {
@@ -181,12 +194,12 @@ func fieldsToSpan(fields []logstorage.Field) (*span, error) {
 			}
 		default:
 			if strings.HasPrefix(field.Name, otelpb.ResourceAttrPrefix) { // resource attributes
-				processTagList = append(processTagList, keyValue{key: strings.TrimPrefix(field.Name, otelpb.ResourceAttrPrefix), vStr: field.Value})
+				processTagList = append(processTagList, newTypedKeyValue(strings.TrimPrefix(field.Name, otelpb.ResourceAttrPrefix), field.Value, fieldTypes[field.Name]))
 			} else if strings.HasPrefix(field.Name, otelpb.SpanAttrPrefixField) { // span attributes
-				spanTagList = append(spanTagList, keyValue{key: strings.TrimPrefix(field.Name, otelpb.SpanAttrPrefixField), vStr: field.Value})
+				spanTagList = append(spanTagList, newTypedKeyValue(strings.TrimPrefix(field.Name, otelpb.SpanAttrPrefixField), field.Value, fieldTypes[field.Name]))
 			} else if strings.HasPrefix(field.Name, otelpb.InstrumentationScopeAttrPrefix) { // instrumentation scope attributes
 				// we have to display `scope_attr:` prefix as there's no way to distinguish these from span attributes.
-				spanTagList = append(spanTagList, keyValue{key: field.Name, vStr: field.Value})
+				spanTagList = append(spanTagList, newTypedKeyValue(field.Name, field.Value, fieldTypes[field.Name]))
 			} else if strings.HasPrefix(field.Name, otelpb.EventPrefix) { // event list
 				fieldName, idx := extraAttributeNameAndIndex(strings.TrimPrefix(field.Name, otelpb.EventPrefix))
 				if idx == "" {
@@ -202,11 +215,11 @@ func fieldsToSpan(fields []logstorage.Field) (*span, error) {
 					lg.timestamp = unixNano / 1000
 				case otelpb.EventNameField:
 					lg.fields = append(lg.fields, keyValue{key: "event", vStr: field.Value})
-				case otelpb.EventDroppedAttributesCountField:
+				case otelpb.EventDroppedAttributesCountField, otelpb.EventAttrTypesField:
 					//no need to display
 					//lg.Fields = append(lg.Fields, KeyValue{Key: fieldName, VStr: field.Value})
 				default:
-					lg.fields = append(lg.fields, keyValue{key: strings.TrimPrefix(fieldName, otelpb.EventAttrPrefix), vStr: field.Value})
+					lg.fields = append(lg.fields, newTypedKeyValue(strings.TrimPrefix(fieldName, otelpb.EventAttrPrefix), field.Value, fieldTypes[field.Name]))
 				}
 			} else if strings.HasPrefix(field.Name, otelpb.LinkPrefix) { // link list
 				fieldName, idx := extraAttributeNameAndIndex(strings.TrimPrefix(field.Name, otelpb.LinkPrefix))
@@ -283,3 +296,65 @@ func extraAttributeNameAndIndex(input string) (string, string) {
 	}
 	return input[:splitIdx], idx
 }
+
+// getAttrFieldTypes returns the original attribute types per every attribute field name.
+//
+// Attributes without types are returned as strings. This is the case for spans ingested before storing attribute types.
+func getAttrFieldTypes(fields []logstorage.Field) map[string]string {
+	var m map[string]string
+	for _, field := range fields {
+		var prefix, suffix string
+		switch {
+		case field.Name == otelpb.ResourceAttrTypesField:
+			prefix = otelpb.ResourceAttrPrefix
+		case field.Name == otelpb.InstrumentationScopeAttrTypes:
+			prefix = otelpb.InstrumentationScopeAttrPrefix
+		case field.Name == otelpb.SpanAttrTypesField:
+			prefix = otelpb.SpanAttrPrefixField
+		case strings.HasPrefix(field.Name, otelpb.EventPrefix+otelpb.EventAttrTypesField+":"):
+			prefix = otelpb.EventPrefix + otelpb.EventAttrPrefix
+			suffix = strings.TrimPrefix(field.Name, otelpb.EventPrefix+otelpb.EventAttrTypesField)
+		default:
+			continue
+		}
+		at, err := otelpb.ParseAttrTypes(field.Value)
+		if err != nil {
+			continue
+		}
+		if m == nil {
+			m = make(map[string]string)
+		}
+		maps.Copy(m, at.GetFieldTypes(prefix, suffix))
+	}
+	return m
+}
+
+// newTypedKeyValue returns Jaeger tag with the given key and value for the attribute of the given attrType.
+//
+// The tag is returned as string if value doesn't match attrType.
+func newTypedKeyValue(key, value, attrType string) keyValue {
+	kv := keyValue{
+		key:  key,
+		vStr: value,
+	}
+	switch attrType {
+	case otelpb.AttrTypeEmptyString:
+		kv.vStr = ""
+	case otelpb.AttrTypeBool:
+		if value == "true" || value == "false" {
+			kv.vType = "bool"
+		}
+	case otelpb.AttrTypeInt:
+		if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+			kv.vType = "int64"
+		}
+	case otelpb.AttrTypeDouble:
+		// NaN and Inf cannot be represented as JSON numbers, so they are returned as strings.
+		if f, err := strconv.ParseFloat(value, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+			kv.vType = "float64"
+		}
+	case otelpb.AttrTypeBytes:
+		kv.vType = "binary"
+	}
+	return kv
+}
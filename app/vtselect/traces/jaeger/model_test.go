@@ -124,44 +124,134 @@ func TestFieldsToSpan(t *testing.T) {
 		startTime: 0,
 		duration:  123456,
 		tags: []keyValue{
-			{"otel.scope.name", "scope_name_1"},
-			{"otel.scope.version", "scope_version_1"},
-			{"scope_attr:scope_attr_1", "scope_attr_1"},
-			{"scope_attr:scope_attr_2", "scope_attr_2"},
-			{"w3c.tracestate", "trace_state_1"},
-			{"span.kind", "internal"},
-			{"attr_1", "attr_1"},
-			{"attr_2", "attr_2"},
-			{"otel.status_description", "status_message_1"},
-			{"error", "true"},
+			{key: "otel.scope.name", vStr: "scope_name_1"},
+			{key: "otel.scope.version", vStr: "scope_version_1"},
+			{key: "scope_attr:scope_attr_1", vStr: "scope_attr_1"},
+			{key: "scope_attr:scope_attr_2", vStr: "scope_attr_2"},
+			{key: "w3c.tracestate", vStr: "trace_state_1"},
+			{key: "span.kind", vStr: "internal"},
+			{key: "attr_1", vStr: "attr_1"},
+			{key: "attr_2", vStr: "attr_2"},
+			{key: "otel.status_description", vStr: "status_message_1"},
+			{key: "error", vStr: "true"},
 		},
 		logs: []log{
 			{
 				timestamp: 0,
 				fields: []keyValue{
-					{"event", "event_0"},
-					{"event_attr_1", "event_0_attr_1"},
-					{"event_attr_2", "event_0_attr_2"},
+					{key: "event", vStr: "event_0"},
+					{key: "event_attr_1", vStr: "event_0_attr_1"},
+					{key: "event_attr_2", vStr: "event_0_attr_2"},
 				},
 			},
 			{
 				timestamp: 0,
 				fields: []keyValue{
-					{"event", "event_1"},
-					{"event_attr_1", "event_1_attr_1"},
-					{"event_attr_2", "event_1_attr_2"},
+					{key: "event", vStr: "event_1"},
+					{key: "event_attr_1", vStr: "event_1_attr_1"},
+					{key: "event_attr_2", vStr: "event_1_attr_2"},
 				},
 			},
 		},
 		process: process{
 			serviceName: "service_name_1",
 			tags: []keyValue{
-				{"resource_attr_1", "resource_attr_1"},
-				{"resource_attr_2", "resource_attr_2"},
+				{key: "resource_attr_1", vStr: "resource_attr_1"},
+				{key: "resource_attr_2", vStr: "resource_attr_2"},
 			},
 		},
 	}
 	f(fields, sp, "")
+
+	// case 6: with region added by vtselect
+	fields = []logstorage.Field{
+		{Name: otelpb.ResourceAttrServiceName, Value: "service_name_1"},
+		{Name: otelpb.TraceIDField, Value: "1234567890"},
+		{Name: otelpb.SpanIDField, Value: "12345"},
+		{Name: otelpb.RegionField, Value: "eu-west"},
+	}
+	sp = &span{
+		traceID: "1234567890",
+		spanID:  "12345",
+		process: process{
+			serviceName: "service_name_1",
+			tags: []keyValue{
+				{key: "vt.region", vStr: "eu-west"},
+			},
+		},
+	}
+	f(fields, sp, "")
+
+	// case 7: with attribute types
+	fields = []logstorage.Field{
+		{Name: otelpb.ResourceAttrServiceName, Value: "service_name_1"},
+		{Name: otelpb.ResourceAttrPrefix + "process.pid", Value: "42"},
+		{Name: otelpb.ResourceAttrTypesField, Value: `{"service.name":"s","process.pid":"i"}`},
+		{Name: otelpb.TraceIDField, Value: "1234567890"},
+		{Name: otelpb.SpanIDField, Value: "12345"},
+		{Name: otelpb.SpanAttrPrefixField + "http.status_code", Value: "200"},
+		{Name: otelpb.SpanAttrPrefixField + "retry", Value: "true"},
+		{Name: otelpb.SpanAttrPrefixField + "ratio", Value: "0.5"},
+		{Name: otelpb.SpanAttrPrefixField + "nan", Value: "NaN"},
+		{Name: otelpb.SpanAttrPrefixField + "payload", Value: "AQI="},
+		{Name: otelpb.SpanAttrPrefixField + "empty", Value: "-"},
+		{Name: otelpb.SpanAttrPrefixField + "map.key", Value: "7"},
+		{Name: otelpb.SpanAttrPrefixField + "invalid", Value: "foo"},
+		{Name: otelpb.SpanAttrTypesField, Value: `{"http.status_code":"i","retry":"b","ratio":"d","nan":"d","payload":"x","empty":"e","map":{"key":"i"},"invalid":"i"}`},
+		{Name: otelpb.EventPrefix + otelpb.EventNameField + ":0", Value: "event_0"},
+		{Name: otelpb.EventPrefix + otelpb.EventAttrPrefix + "attempt" + ":0", Value: "3"},
+		{Name: otelpb.EventPrefix + otelpb.EventAttrTypesField + ":0", Value: `{"attempt":"i"}`},
+	}
+	sp = &span{
+		traceID: "1234567890",
+		spanID:  "12345",
+		tags: []keyValue{
+			{key: "http.status_code", vStr: "200", vType: "int64"},
+			{key: "retry", vStr: "true", vType: "bool"},
+			{key: "ratio", vStr: "0.5", vType: "float64"},
+			{key: "nan", vStr: "NaN"},
+			{key: "payload", vStr: "AQI=", vType: "binary"},
+			{key: "empty", vStr: ""},
+			{key: "map.key", vStr: "7", vType: "int64"},
+			{key: "invalid", vStr: "foo"},
+		},
+		logs: []log{
+			{
+				fields: []keyValue{
+					{key: "event", vStr: "event_0"},
+					{key: "attempt", vStr: "3", vType: "int64"},
+				},
+			},
+		},
+		process: process{
+			serviceName: "service_name_1",
+			tags: []keyValue{
+				{key: "process.pid", vStr: "42", vType: "int64"},
+			},
+		},
+	}
+	f(fields, sp, "")
+
+	// case 8: with non-zero flags
+	fields = []logstorage.Field{
+		{Name: otelpb.TraceIDField, Value: "1234567890"},
+		{Name: otelpb.SpanIDField, Value: "12345"},
+		{Name: otelpb.FlagsField, Value: "1"},
+	}
+	sp = &span{
+		traceID: "1234567890",
+		spanID:  "12345",
+		flags:   1,
+	}
+	f(fields, sp, "")
+
+	// case 9: with invalid flags
+	fields = []logstorage.Field{
+		{Name: otelpb.TraceIDField, Value: "1234567890"},
+		{Name: otelpb.SpanIDField, Value: "12345"},
+		{Name: otelpb.FlagsField, Value: "foo"},
+	}
+	f(fields, nil, `invalid flags field: strconv.ParseUint: parsing "foo": invalid syntax`)
 }
 
 func TestRemoveArrayIndex(t *testing.T) {
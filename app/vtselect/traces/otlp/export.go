@@ -0,0 +1,403 @@
+package otlp
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httputil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtselect/traces/query"
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtstorage"
+	otelpb "github.com/VictoriaMetrics/VictoriaTraces/lib/protoparser/opentelemetry/pb"
+)
+
+const (
+	// defaultExportChunkSize is the default number of spans per every ExportTraceServiceRequest returned by /select/traces/export.
+	defaultExportChunkSize = 1000
+
+	// maxExportChunkSize is the maximum number of spans per every ExportTraceServiceRequest returned by /select/traces/export.
+	//
+	// It limits the memory used by a single export request.
+	maxExportChunkSize = 100_000
+)
+
+// Bounds for the time window scanned by a single query during the export.
+//
+// Every query scans and sorts only spans on the time window starting from the cursor, so the export time grows linearly with the number of exported spans.
+// The window is adjusted to the density of the exported spans: it is doubled after windows with a few spans and is halved after windows
+// with more spans than needed for a single chunk.
+const (
+	exportWindowInitial = time.Hour
+	exportWindowMin     = time.Second
+	exportWindowMax     = 24 * time.Hour
+)
+
+const (
+	// exportCursorTrailer is the HTTP trailer containing the cursor for resuming the export.
+	exportCursorTrailer = "VT-Export-Cursor"
+
+	// exportErrorTrailer is the HTTP trailer containing the error, which stopped the export after the response has been started.
+	exportErrorTrailer = "VT-Export-Error"
+)
+
+var (
+	exportedSpansTotal = metrics.NewCounter(`vt_exported_spans_total`)
+	exportErrorsTotal  = metrics.NewCounter(`vt_export_errors_total`)
+)
+
+// ProcessExportRequest handles /select/traces/export request.
+//
+// It streams spans matching the given LogsQL filter on the given time range as OTLP ExportTraceServiceRequest messages
+// with up to chunk_size spans per message. Messages are written as JSON lines or as length-prefixed protobuf.
+// Spans are exported in the order of their timestamps, so the export can be resumed from the cursor
+// returned in the VT-Export-Cursor HTTP trailer.
+//
+// See https://docs.victoriametrics.com/victoriatraces/querying/#exporting-spans
+func ProcessExportRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	cp, err := query.GetCommonParams(r)
+	if err != nil {
+		httpserver.Errorf(w, r, "incorrect query params: %s", err)
+		return
+	}
+
+	endMsecs, err := httputil.GetTime(r, "end", time.Now().UnixMilli())
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+	startMsecs, err := httputil.GetTime(r, "start", 0)
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+	if startMsecs >= endMsecs {
+		httpserver.Errorf(w, r, "start=%d must be smaller than end=%d", startMsecs, endMsecs)
+		return
+	}
+
+	filter := r.FormValue("query")
+	if filter == "" {
+		filter = "*"
+	}
+	if _, err := logstorage.ParseFilter(filter); err != nil {
+		httpserver.Errorf(w, r, "cannot parse query [%s]: %s", filter, err)
+		return
+	}
+
+	chunkSize, err := getPositiveInt(r, "chunk_size", defaultExportChunkSize)
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+	if chunkSize > maxExportChunkSize {
+		httpserver.Errorf(w, r, "chunk_size cannot exceed %d; got %d", maxExportChunkSize, chunkSize)
+		return
+	}
+	limit, err := getPositiveInt(r, "limit", 0)
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+
+	ec := &exportCursor{
+		timestamp: startMsecs * 1e6,
+	}
+	if s := r.FormValue("cursor"); s != "" {
+		ec, err = parseExportCursor(s)
+		if err != nil {
+			httpserver.Errorf(w, r, "%s", err)
+			return
+		}
+	}
+
+	isProtobuf := isProtobufRequested(r)
+	if isProtobuf {
+		w.Header().Set("Content-Type", contentTypeProtobuf)
+	} else {
+		w.Header().Set("Content-Type", "application/stream+json")
+	}
+	w.Header().Set("Trailer", exportCursorTrailer+", "+exportErrorTrailer)
+
+	ew := &exportWalker{
+		end:    endMsecs * 1e6,
+		cursor: ec,
+		window: exportWindowInitial,
+		fetchRows: func(ec *exportCursor, windowEnd int64, limit int) ([]*query.Row, error) {
+			return getExportRows(ctx, cp, filter, ec, windowEnd, limit)
+		},
+	}
+
+	var rb RequestBuilder
+	var buf []byte
+	spansExported := 0
+	for {
+		n := chunkSize
+		if limit > 0 {
+			n = min(n, limit-spansExported)
+		}
+		rows, err := ew.next(n)
+		if err == nil && len(rows) > 0 {
+			rb.Reset()
+			for _, row := range rows {
+				if err := rb.AddSpan(row.Fields); err != nil {
+					logger.Warnf("skipping span, which cannot be converted to OTLP: %s", err)
+				}
+			}
+			if rb.SpansCount() > 0 {
+				buf, err = appendExportRequest(buf[:0], rb.Request(), isProtobuf)
+			}
+		}
+		if err != nil {
+			exportErrorsTotal.Inc()
+			if spansExported == 0 {
+				httpserver.Errorf(w, r, "cannot export spans: %s", err)
+				return
+			}
+			// The response has been already started, so report the error in the trailer. The client can resume the export from the cursor.
+			logger.Warnf("cannot export spans for the query [%s]; the export can be resumed from cursor=%s: %s", filter, ew.cursor, err)
+			w.Header().Set(exportErrorTrailer, err.Error())
+			break
+		}
+		if rb.SpansCount() > 0 {
+			if _, err := w.Write(buf); err != nil {
+				// The client has closed the connection.
+				return
+			}
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		}
+		ew.commit()
+		spansExported += len(rows)
+		exportedSpansTotal.Add(len(rows))
+
+		if ew.isDone() {
+			break
+		}
+		if limit > 0 && spansExported >= limit {
+			break
+		}
+	}
+
+	if !ew.isDone() {
+		w.Header().Set(exportCursorTrailer, ew.cursor.String())
+	}
+}
+
+// exportWalker walks the time range [cursor, end) in time windows, so every query scans a bounded number of spans.
+type exportWalker struct {
+	// end is the end of the exported time range in nanoseconds.
+	end int64
+
+	// cursor is the position after the last committed rows.
+	cursor *exportCursor
+
+	// nextCursor is the position after the rows returned by the last call to next.
+	nextCursor *exportCursor
+
+	// window is the duration of the time window for the next query.
+	window time.Duration
+
+	// fetchRows must return up to limit rows on the time range [ec, windowEnd) in the export order.
+	fetchRows func(ec *exportCursor, windowEnd int64, limit int) ([]*query.Row, error)
+}
+
+// next returns up to limit rows after the cursor in the export order.
+//
+// Less than limit rows are returned only if the end of the time range is reached. Call commit after the returned rows are exported,
+// so the next call returns the rows after them.
+func (ew *exportWalker) next(limit int) ([]*query.Row, error) {
+	ec := *ew.cursor
+	var rows []*query.Row
+	for len(rows) < limit && ec.timestamp < ew.end {
+		windowEnd := ew.end
+		if ec.timestamp < ew.end-ew.window.Nanoseconds() {
+			windowEnd = ec.timestamp + ew.window.Nanoseconds()
+		}
+		n := limit - len(rows)
+		a, err := ew.fetchRows(&ec, windowEnd, n)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, a...)
+		if len(a) < n {
+			// The window is exhausted. Continue from the next window.
+			ec = exportCursor{
+				timestamp: windowEnd,
+			}
+			if len(a) < n/2 {
+				ew.window = min(2*ew.window, exportWindowMax)
+			}
+			continue
+		}
+		ec.update(a)
+		ew.window = max(ew.window/2, exportWindowMin)
+	}
+	ew.nextCursor = &ec
+	return rows, nil
+}
+
+// commit moves the cursor after the rows returned by the last call to next.
+func (ew *exportWalker) commit() {
+	if ew.nextCursor != nil {
+		ew.cursor = ew.nextCursor
+		ew.nextCursor = nil
+	}
+}
+
+// isDone returns true if all the rows on the time range have been committed.
+func (ew *exportWalker) isDone() bool {
+	return ew.cursor.timestamp >= ew.end
+}
+
+// appendExportRequest appends req to dst as JSON line or length-prefixed protobuf message and returns the result.
+func appendExportRequest(dst []byte, req *otelpb.ExportTraceServiceRequest, isProtobuf bool) ([]byte, error) {
+	if isProtobuf {
+		data := req.MarshalProtobuf(nil)
+		dst = binary.AppendUvarint(dst, uint64(len(data)))
+		return append(dst, data...), nil
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return dst, fmt.Errorf("cannot marshal OTLP request to JSON: %w", err)
+	}
+	dst = append(dst, data...)
+	return append(dst, '\n'), nil
+}
+
+// getExportRows returns up to limit spans matching the filter on the time range [ec, end) in the export order.
+func getExportRows(ctx context.Context, cp *query.CommonParams, filter string, ec *exportCursor, end int64, limit int) ([]*query.Row, error) {
+	// Spans are sorted by trace_id and span_id in addition to _time, so the order is stable for spans with identical timestamps.
+	// Spans with the cursor timestamp, which were already exported, are skipped with the offset.
+	qStr := fmt.Sprintf("_time:[%s, %s) (%s) -%s:* | sort by (_time, %s, %s) offset %d limit %d",
+		formatTimestamp(ec.timestamp), formatTimestamp(end), filter, otelpb.TraceIDIndexFieldName, otelpb.TraceIDField, otelpb.SpanIDField, ec.offset, limit)
+	q, err := logstorage.ParseQueryAtTimestamp(qStr, time.Now().UnixNano())
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse query [%s]: %w", qStr, err)
+	}
+
+	var qs logstorage.QueryStats
+	qctx := logstorage.NewQueryContext(ctx, &qs, cp.TenantIDs, q)
+	defer vtstorage.UpdatePerQueryStatsMetrics(&qs)
+
+	var rowsLock sync.Mutex
+	var rows []*query.Row
+	var missingTimeColumn bool
+	writeBlock := func(_ uint, db *logstorage.DataBlock) {
+		rowsLock.Lock()
+		defer rowsLock.Unlock()
+
+		timestamps, ok := db.GetTimestamps(nil)
+		if !ok {
+			missingTimeColumn = true
+			return
+		}
+		for i, timestamp := range timestamps {
+			fields := make([]logstorage.Field, 0, len(db.Columns))
+			for _, c := range db.Columns {
+				// Skip empty values, since they belong to fields missing in the span.
+				if c.Values[i] != "" {
+					fields = append(fields, logstorage.Field{
+						Name:  strings.Clone(c.Name),
+						Value: strings.Clone(c.Values[i]),
+					})
+				}
+			}
+			rows = append(rows, &query.Row{
+				Timestamp: timestamp,
+				Fields:    fields,
+			})
+		}
+	}
+	if err := vtstorage.RunQuery(qctx, writeBlock); err != nil {
+		return nil, fmt.Errorf("cannot execute query [%s]: %w", qStr, err)
+	}
+	if missingTimeColumn {
+		return nil, fmt.Errorf("missing _time column in the result for the query [%s]", qStr)
+	}
+	return rows, nil
+}
+
+// exportCursor is the position of /select/traces/export request.
+//
+// The export is resumed from spans with the timestamp, while the first offset spans with this timestamp are skipped,
+// since they were already exported.
+type exportCursor struct {
+	// timestamp is the timestamp of the last exported span in nanoseconds.
+	timestamp int64
+
+	// offset is the number of already exported spans with the timestamp.
+	offset int
+}
+
+// parseExportCursor parses the cursor returned by exportCursor.String.
+func parseExportCursor(s string) (*exportCursor, error) {
+	tsStr, offsetStr, ok := strings.Cut(s, "-")
+	if !ok {
+		return nil, fmt.Errorf("cannot parse cursor %q; it must have the form <timestamp>-<offset>", s)
+	}
+	timestamp, err := strconv.ParseInt(tsStr, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse timestamp in the cursor %q: %w", s, err)
+	}
+	offset, err := strconv.Atoi(offsetStr)
+	if err != nil || offset < 0 {
+		return nil, fmt.Errorf("cannot parse offset in the cursor %q", s)
+	}
+	return &exportCursor{
+		timestamp: timestamp,
+		offset:    offset,
+	}, nil
+}
+
+// String returns the string representation of ec, which can be parsed by parseExportCursor.
+func (ec *exportCursor) String() string {
+	return fmt.Sprintf("%d-%d", ec.timestamp, ec.offset)
+}
+
+// update moves ec to the end of rows returned by getExportRows for ec.
+func (ec *exportCursor) update(rows []*query.Row) {
+	if len(rows) == 0 {
+		return
+	}
+	timestamp := rows[len(rows)-1].Timestamp
+	if timestamp != ec.timestamp {
+		ec.timestamp = timestamp
+		ec.offset = 0
+	}
+	for _, row := range rows {
+		if row.Timestamp == timestamp {
+			ec.offset++
+		}
+	}
+}
+
+func getPositiveInt(r *http.Request, argName string, defaultValue int) (int, error) {
+	if r.FormValue(argName) == "" {
+		return defaultValue, nil
+	}
+	n, err := httputil.GetInt(r, argName)
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("%s must be positive; got %d", argName, n)
+	}
+	return n, nil
+}
+
+func formatTimestamp(nsecs int64) string {
+	return time.Unix(0, nsecs).UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,197 @@
+package otlp
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtselect/traces/query"
+	otelpb "github.com/VictoriaMetrics/VictoriaTraces/lib/protoparser/opentelemetry/pb"
+)
+
+func TestParseExportCursorFailure(t *testing.T) {
+	f := func(s string) {
+		t.Helper()
+
+		if _, err := parseExportCursor(s); err == nil {
+			t.Fatalf("expecting non-nil error for cursor %q", s)
+		}
+	}
+
+	f("foo")
+	f("123")
+	f("123-")
+	f("foo-1")
+	f("123-foo")
+	f("123--1")
+}
+
+func TestExportCursorUpdate(t *testing.T) {
+	f := func(cursor string, timestamps []int64, resultExpected string) {
+		t.Helper()
+
+		ec, err := parseExportCursor(cursor)
+		if err != nil {
+			t.Fatalf("cannot parse cursor %q: %s", cursor, err)
+		}
+		var rows []*query.Row
+		for _, ts := range timestamps {
+			rows = append(rows, &query.Row{
+				Timestamp: ts,
+			})
+		}
+		ec.update(rows)
+		if result := ec.String(); result != resultExpected {
+			t.Fatalf("unexpected cursor; got %q; want %q", result, resultExpected)
+		}
+	}
+
+	// no rows
+	f("100-2", nil, "100-2")
+
+	// rows with new timestamps
+	f("100-2", []int64{100, 200, 300}, "300-1")
+	f("100-2", []int64{200, 300, 300}, "300-2")
+
+	// rows with the cursor timestamp
+	f("100-2", []int64{100, 100}, "100-4")
+}
+
+func TestExportWalker(t *testing.T) {
+	f := func(timestamps []int64, end int64, chunkSize int) {
+		t.Helper()
+
+		fetchRows := func(ec *exportCursor, windowEnd int64, limit int) ([]*query.Row, error) {
+			if d := windowEnd - ec.timestamp; d > exportWindowMax.Nanoseconds() {
+				t.Fatalf("too big time window: %d", d)
+			}
+			var rows []*query.Row
+			offset := ec.offset
+			for _, ts := range timestamps {
+				if ts < ec.timestamp || ts >= windowEnd {
+					continue
+				}
+				if ts == ec.timestamp && offset > 0 {
+					offset--
+					continue
+				}
+				if len(rows) < limit {
+					rows = append(rows, &query.Row{
+						Timestamp: ts,
+					})
+				}
+			}
+			return rows, nil
+		}
+		newWalker := func(ec *exportCursor) *exportWalker {
+			return &exportWalker{
+				end:       end,
+				cursor:    ec,
+				window:    exportWindowInitial,
+				fetchRows: fetchRows,
+			}
+		}
+
+		// Export the spans in chunks. Resume the export from the cursor after every chunk in the same way as the client does.
+		var result []int64
+		ew := newWalker(&exportCursor{})
+		for !ew.isDone() {
+			rows, err := ew.next(chunkSize)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if len(rows) > chunkSize {
+				t.Fatalf("too many rows in the chunk; got %d; want up to %d", len(rows), chunkSize)
+			}
+			for _, row := range rows {
+				result = append(result, row.Timestamp)
+			}
+			ew.commit()
+			if len(rows) < chunkSize && !ew.isDone() {
+				t.Fatalf("unexpected chunk with %d rows before the end of the export", len(rows))
+			}
+
+			ec, err := parseExportCursor(ew.cursor.String())
+			if err != nil {
+				t.Fatalf("cannot parse cursor: %s", err)
+			}
+			window := ew.window
+			ew = newWalker(ec)
+			ew.window = window
+		}
+
+		var resultExpected []int64
+		for _, ts := range timestamps {
+			if ts < end {
+				resultExpected = append(resultExpected, ts)
+			}
+		}
+		if !reflect.DeepEqual(result, resultExpected) {
+			t.Fatalf("unexpected exported timestamps\ngot\n%v\nwant\n%v", result, resultExpected)
+		}
+	}
+
+	hour := time.Hour.Nanoseconds()
+
+	// no spans
+	f(nil, 10*hour, 3)
+
+	// sparse spans across many windows
+	f([]int64{1, 3 * hour, 70 * hour, 300 * hour}, 400*hour, 2)
+
+	// dense spans with identical timestamps crossing chunk boundaries
+	f([]int64{5, 5, 5, 5, 5, 6, 6, hour, hour, hour + 1}, 2*hour, 2)
+	f([]int64{5, 5, 5, 5, 5, 6, 6, hour, hour, hour + 1}, 2*hour, 3)
+
+	// spans at the end of the time range are excluded
+	f([]int64{1, 2, hour, 2 * hour}, 2*hour, 10)
+}
+
+func TestAppendExportRequest(t *testing.T) {
+	name := "foo"
+	req := &otelpb.ExportTraceServiceRequest{
+		ResourceSpans: []*otelpb.ResourceSpans{{
+			ScopeSpans: []*otelpb.ScopeSpans{{
+				Spans: []*otelpb.Span{{
+					TraceID: "1234",
+					SpanID:  "12",
+					Name:    name,
+				}},
+			}},
+		}},
+	}
+
+	// JSON line
+	data, err := appendExportRequest(nil, req, false)
+	if err != nil {
+		t.Fatalf("cannot marshal request: %s", err)
+	}
+	if data[len(data)-1] != '\n' {
+		t.Fatalf("missing newline at the end of %q", data)
+	}
+	var reqFromJSON otelpb.ExportTraceServiceRequest
+	if err := reqFromJSON.UnmarshalJSONCustom(data); err != nil {
+		t.Fatalf("cannot unmarshal JSON line: %s", err)
+	}
+	if !reflect.DeepEqual(&reqFromJSON, req) {
+		t.Fatalf("unexpected request unmarshaled from JSON line %q", data)
+	}
+
+	// length-prefixed protobuf
+	data, err = appendExportRequest(nil, req, true)
+	if err != nil {
+		t.Fatalf("cannot marshal request: %s", err)
+	}
+	n, prefixLen := binary.Uvarint(data)
+	if prefixLen <= 0 || int(n) != len(data)-prefixLen {
+		t.Fatalf("unexpected length prefix %d for the message with %d bytes", n, len(data)-prefixLen)
+	}
+	var reqFromProtobuf otelpb.ExportTraceServiceRequest
+	if err := reqFromProtobuf.UnmarshalProtobuf(data[prefixLen:]); err != nil {
+		t.Fatalf("cannot unmarshal protobuf message: %s", err)
+	}
+	if !reflect.DeepEqual(&reqFromProtobuf, req) {
+		t.Fatalf("unexpected request unmarshaled from protobuf")
+	}
+}
@@ -0,0 +1,334 @@
+package otlp
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+
+	otelpb "github.com/VictoriaMetrics/VictoriaTraces/lib/protoparser/opentelemetry/pb"
+)
+
+// RequestBuilder builds OTLP ExportTraceServiceRequest from spans stored in VictoriaTraces.
+//
+// Spans with the same resource and instrumentation scope are grouped into a single ResourceSpans and ScopeSpans
+// in the order of their first appearance.
+type RequestBuilder struct {
+	req        otelpb.ExportTraceServiceRequest
+	resources  map[string]*resourceSpans
+	spansCount int
+}
+
+type resourceSpans struct {
+	rs     *otelpb.ResourceSpans
+	scopes map[string]*otelpb.ScopeSpans
+}
+
+// AddSpan adds the span with the given stored fields to rb.
+func (rb *RequestBuilder) AddSpan(fields []logstorage.Field) error {
+	s, err := fieldsToSpan(fields)
+	if err != nil {
+		return err
+	}
+
+	if rb.resources == nil {
+		rb.resources = make(map[string]*resourceSpans)
+	}
+	r := rb.resources[s.resourceKey]
+	if r == nil {
+		r = &resourceSpans{
+			rs: &otelpb.ResourceSpans{
+				Resource:  s.resource,
+				SchemaURL: s.resourceSchemaURL,
+			},
+			scopes: make(map[string]*otelpb.ScopeSpans),
+		}
+		rb.resources[s.resourceKey] = r
+		rb.req.ResourceSpans = append(rb.req.ResourceSpans, r.rs)
+	}
+	ss := r.scopes[s.scopeKey]
+	if ss == nil {
+		ss = &otelpb.ScopeSpans{
+			Scope:     s.scope,
+			SchemaURL: s.scopeSchemaURL,
+		}
+		r.scopes[s.scopeKey] = ss
+		r.rs.ScopeSpans = append(r.rs.ScopeSpans, ss)
+	}
+	ss.Spans = append(ss.Spans, s.span)
+	rb.spansCount++
+	return nil
+}
+
+// Request returns the request with all the spans added to rb.
+//
+// The returned request is valid until the next call to Reset.
+func (rb *RequestBuilder) Request() *otelpb.ExportTraceServiceRequest {
+	return &rb.req
+}
+
+// SpansCount returns the number of spans added to rb.
+func (rb *RequestBuilder) SpansCount() int {
+	return rb.spansCount
+}
+
+// Reset resets rb, so it could be used for building a new request.
+func (rb *RequestBuilder) Reset() {
+	rb.req.ResourceSpans = nil
+	clear(rb.resources)
+	rb.spansCount = 0
+}
+
+// ParseSpan returns OTLP span together with its resource and instrumentation scope restored from the stored span fields.
+func ParseSpan(fields []logstorage.Field) (*otelpb.Span, *otelpb.Resource, *otelpb.InstrumentationScope, error) {
+	s, err := fieldsToSpan(fields)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return s.span, &s.resource, &s.scope, nil
+}
+
+// span is OTLP span restored from the stored fields together with its resource and instrumentation scope.
+type span struct {
+	// resourceKey identifies the resource of the span.
+	resourceKey       string
+	resource          otelpb.Resource
+	resourceSchemaURL string
+
+	// scopeKey identifies the instrumentation scope of the span.
+	scopeKey       string
+	scope          otelpb.InstrumentationScope
+	scopeSchemaURL string
+
+	span *otelpb.Span
+}
+
+// fieldsToSpan converts the stored span fields to OTLP span.
+//
+// See pushFieldsFromSpan at app/vtinsert/opentelemetry for the stored representation.
+func fieldsToSpan(fields []logstorage.Field) (*span, error) {
+	fp := &fieldsParser{
+		fields: fields,
+		m:      make(map[string]string, len(fields)),
+	}
+	var resourceFields, scopeFields []logstorage.Field
+	eventsCount, linksCount := 0, 0
+	for _, f := range fields {
+		fp.m[f.Name] = f.Value
+		switch {
+		case isResourceField(f.Name):
+			resourceFields = append(resourceFields, f)
+		case isScopeField(f.Name):
+			scopeFields = append(scopeFields, f)
+		case strings.HasPrefix(f.Name, otelpb.EventPrefix):
+			idx, err := getFieldIndex(f.Name)
+			if err != nil {
+				return nil, err
+			}
+			eventsCount = max(eventsCount, idx+1)
+		case strings.HasPrefix(f.Name, otelpb.LinkPrefix):
+			idx, err := getFieldIndex(f.Name)
+			if err != nil {
+				return nil, err
+			}
+			linksCount = max(linksCount, idx+1)
+		}
+	}
+
+	sp := &otelpb.Span{
+		TraceID:                fp.m[otelpb.TraceIDField],
+		SpanID:                 fp.m[otelpb.SpanIDField],
+		TraceState:             fp.m[otelpb.TraceStateField],
+		ParentSpanID:           fp.m[otelpb.ParentSpanIDField],
+		Flags:                  fp.getUint32(otelpb.FlagsField),
+		Name:                   fp.m[otelpb.NameField],
+		Kind:                   otelpb.SpanKind(fp.getInt32(otelpb.KindField)),
+		StartTimeUnixNano:      fp.getUint64(otelpb.StartTimeUnixNanoField),
+		EndTimeUnixNano:        fp.getUint64(otelpb.EndTimeUnixNanoField),
+		Attributes:             fp.getAttributes(otelpb.SpanAttrTypesField, otelpb.SpanAttrPrefixField, ""),
+		DroppedAttributesCount: fp.getUint32(otelpb.DroppedAttributesCountField),
+		DroppedEventsCount:     fp.getUint32(otelpb.DroppedEventsCountField),
+		DroppedLinksCount:      fp.getUint32(otelpb.DroppedLinksCountField),
+		Status: otelpb.Status{
+			Message: fp.m[otelpb.StatusMessageField],
+			Code:    otelpb.StatusCode(fp.getInt32(otelpb.StatusCodeField)),
+		},
+	}
+	if sp.TraceID == "" || sp.SpanID == "" {
+		return nil, fmt.Errorf("missing %s or %s field", otelpb.TraceIDField, otelpb.SpanIDField)
+	}
+	if name, ok := fp.m[otelpb.OriginalNameField]; ok {
+		// The span name has been changed by the normalization at data ingestion, so return the original name.
+		sp.Name = name
+	}
+
+	for i := 0; i < eventsCount; i++ {
+		prefix := otelpb.EventPrefix
+		suffix := ":" + strconv.Itoa(i)
+		sp.Events = append(sp.Events, &otelpb.SpanEvent{
+			TimeUnixNano:           fp.getUint64(prefix + otelpb.EventTimeUnixNanoField + suffix),
+			Name:                   fp.m[prefix+otelpb.EventNameField+suffix],
+			Attributes:             fp.getAttributes(prefix+otelpb.EventAttrTypesField+suffix, prefix+otelpb.EventAttrPrefix, suffix),
+			DroppedAttributesCount: fp.getUint32(prefix + otelpb.EventDroppedAttributesCountField + suffix),
+		})
+	}
+
+	for i := 0; i < linksCount; i++ {
+		prefix := otelpb.LinkPrefix
+		suffix := ":" + strconv.Itoa(i)
+		sp.Links = append(sp.Links, &otelpb.SpanLink{
+			TraceID:                fp.m[prefix+otelpb.LinkTraceIDField+suffix],
+			SpanID:                 fp.m[prefix+otelpb.LinkSpanIDField+suffix],
+			TraceState:             fp.m[prefix+otelpb.LinkTraceStateField+suffix],
+			Attributes:             fp.getAttributes(prefix+otelpb.LinkAttrTypesField+suffix, prefix+otelpb.LinkAttrPrefix, suffix),
+			DroppedAttributesCount: fp.getUint32(prefix + otelpb.LinkDroppedAttributesCountField + suffix),
+			Flags:                  fp.getUint32(prefix + otelpb.LinkFlagsField + suffix),
+		})
+	}
+
+	s := &span{
+		resourceKey: getGroupKey(resourceFields),
+		resource: otelpb.Resource{
+			Attributes:             fp.getAttributes(otelpb.ResourceAttrTypesField, otelpb.ResourceAttrPrefix, ""),
+			DroppedAttributesCount: fp.getUint32(otelpb.ResourceDroppedAttributesCountField),
+		},
+		resourceSchemaURL: fp.m[otelpb.ResourceSchemaURLField],
+
+		scopeKey: getGroupKey(scopeFields),
+		scope: otelpb.InstrumentationScope{
+			Name:                   fp.m[otelpb.InstrumentationScopeName],
+			Version:                fp.m[otelpb.InstrumentationScopeVersion],
+			Attributes:             fp.getAttributes(otelpb.InstrumentationScopeAttrTypes, otelpb.InstrumentationScopeAttrPrefix, ""),
+			DroppedAttributesCount: fp.getUint32(otelpb.InstrumentationScopeDroppedAttributesCount),
+		},
+		scopeSchemaURL: fp.m[otelpb.InstrumentationScopeSchemaURL],
+
+		span: sp,
+	}
+	if fp.err != nil {
+		return nil, fmt.Errorf("cannot parse span %s: %w", sp.SpanID, fp.err)
+	}
+	return s, nil
+}
+
+func isResourceField(name string) bool {
+	switch name {
+	case otelpb.ResourceAttrTypesField, otelpb.ResourceDroppedAttributesCountField, otelpb.ResourceSchemaURLField:
+		return true
+	default:
+		return strings.HasPrefix(name, otelpb.ResourceAttrPrefix)
+	}
+}
+
+func isScopeField(name string) bool {
+	switch name {
+	case otelpb.InstrumentationScopeName, otelpb.InstrumentationScopeVersion, otelpb.InstrumentationScopeAttrTypes,
+		otelpb.InstrumentationScopeDroppedAttributesCount, otelpb.InstrumentationScopeSchemaURL:
+		return true
+	default:
+		return strings.HasPrefix(name, otelpb.InstrumentationScopeAttrPrefix)
+	}
+}
+
+// getFieldIndex returns the index of event or link for the field with the given name such as `event:event_name:0`.
+func getFieldIndex(name string) (int, error) {
+	n := strings.LastIndexByte(name, ':')
+	idx, err := strconv.Atoi(name[n+1:])
+	if err != nil || idx < 0 {
+		return 0, fmt.Errorf("cannot obtain index from field %q", name)
+	}
+	return idx, nil
+}
+
+// getGroupKey returns the key, which uniquely identifies the given fields regardless of their order.
+func getGroupKey(fields []logstorage.Field) string {
+	slices.SortFunc(fields, func(a, b logstorage.Field) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	var b []byte
+	for _, f := range fields {
+		b = strconv.AppendQuote(b, f.Name)
+		b = strconv.AppendQuote(b, f.Value)
+	}
+	return string(b)
+}
+
+// fieldsParser parses values of the stored span fields.
+//
+// The first parse error is kept in err.
+type fieldsParser struct {
+	fields []logstorage.Field
+	m      map[string]string
+	err    error
+}
+
+func (fp *fieldsParser) getValue(name string) (string, bool) {
+	v, ok := fp.m[name]
+	return v, ok
+}
+
+func (fp *fieldsParser) getUint64(name string) uint64 {
+	s, ok := fp.m[name]
+	if !ok {
+		return 0
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil && fp.err == nil {
+		fp.err = fmt.Errorf("cannot parse %s=%q: %w", name, s, err)
+	}
+	return n
+}
+
+func (fp *fieldsParser) getUint32(name string) uint32 {
+	s, ok := fp.m[name]
+	if !ok {
+		return 0
+	}
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil && fp.err == nil {
+		fp.err = fmt.Errorf("cannot parse %s=%q: %w", name, s, err)
+	}
+	return uint32(n)
+}
+
+func (fp *fieldsParser) getInt32(name string) int32 {
+	s, ok := fp.m[name]
+	if !ok {
+		return 0
+	}
+	n, err := strconv.ParseInt(s, 10, 32)
+	if err != nil && fp.err == nil {
+		fp.err = fmt.Errorf("cannot parse %s=%q: %w", name, s, err)
+	}
+	return int32(n)
+}
+
+// getAttributes returns attributes stored in fields with the given prefix and suffix.
+//
+// Attributes are returned with the original types stored in typesField.
+// Attributes are returned as strings if typesField is missing. This is the case for spans ingested before storing attribute types.
+func (fp *fieldsParser) getAttributes(typesField, prefix, suffix string) []*otelpb.KeyValue {
+	if s, ok := fp.m[typesField]; ok {
+		at, err := otelpb.ParseAttrTypes(s)
+		if err == nil {
+			return at.BuildKeyValues(prefix, suffix, fp.getValue)
+		}
+	}
+
+	var kvs []*otelpb.KeyValue
+	for _, f := range fp.fields {
+		if len(f.Name) <= len(prefix)+len(suffix) || !strings.HasPrefix(f.Name, prefix) || !strings.HasSuffix(f.Name, suffix) {
+			continue
+		}
+		v := f.Value
+		kvs = append(kvs, &otelpb.KeyValue{
+			Key: f.Name[len(prefix) : len(f.Name)-len(suffix)],
+			Value: &otelpb.AnyValue{
+				StringValue: &v,
+			},
+		})
+	}
+	return kvs
+}
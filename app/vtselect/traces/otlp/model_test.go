@@ -0,0 +1,134 @@
+package otlp
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+
+	otelpb "github.com/VictoriaMetrics/VictoriaTraces/lib/protoparser/opentelemetry/pb"
+)
+
+func TestFieldsToSpanFailure(t *testing.T) {
+	f := func(fields []logstorage.Field) {
+		t.Helper()
+
+		if _, err := fieldsToSpan(fields); err == nil {
+			t.Fatalf("expecting non-nil error for fields %v", fields)
+		}
+	}
+
+	// missing trace_id and span_id
+	f(nil)
+	f([]logstorage.Field{
+		{Name: otelpb.TraceIDField, Value: "1234"},
+	})
+
+	// invalid numeric field
+	f([]logstorage.Field{
+		{Name: otelpb.TraceIDField, Value: "1234"},
+		{Name: otelpb.SpanIDField, Value: "12"},
+		{Name: otelpb.KindField, Value: "foo"},
+	})
+
+	// invalid event index
+	f([]logstorage.Field{
+		{Name: otelpb.TraceIDField, Value: "1234"},
+		{Name: otelpb.SpanIDField, Value: "12"},
+		{Name: otelpb.EventPrefix + otelpb.EventNameField + ":foo", Value: "bar"},
+	})
+}
+
+func TestRequestBuilderWithoutAttrTypes(t *testing.T) {
+	// Spans ingested before storing attribute types have no *_attr_types fields, so attributes are returned as strings.
+	var rb RequestBuilder
+	spans := [][]logstorage.Field{
+		{
+			{Name: "_time", Value: "2025-01-01T00:00:00Z"},
+			{Name: otelpb.ResourceAttrServiceName, Value: "frontend"},
+			{Name: otelpb.InstrumentationScopeName, Value: "net/http"},
+			{Name: otelpb.TraceIDField, Value: "1234"},
+			{Name: otelpb.SpanIDField, Value: "1"},
+			{Name: otelpb.SpanAttrPrefixField + "http.status_code", Value: "200"},
+			{Name: otelpb.EventPrefix + otelpb.EventNameField + ":0", Value: "exception"},
+			{Name: otelpb.EventPrefix + otelpb.EventAttrPrefix + "exception.message:0", Value: "boom"},
+		},
+		{
+			{Name: otelpb.ResourceAttrServiceName, Value: "backend"},
+			{Name: otelpb.TraceIDField, Value: "1234"},
+			{Name: otelpb.SpanIDField, Value: "2"},
+		},
+		{
+			// the same resource and scope as for the first span, while the fields are in another order.
+			{Name: otelpb.InstrumentationScopeName, Value: "net/http"},
+			{Name: otelpb.ResourceAttrServiceName, Value: "frontend"},
+			{Name: otelpb.TraceIDField, Value: "1234"},
+			{Name: otelpb.SpanIDField, Value: "3"},
+		},
+	}
+	for _, fields := range spans {
+		if err := rb.AddSpan(fields); err != nil {
+			t.Fatalf("cannot add span: %s", err)
+		}
+	}
+
+	newStringAttr := func(k, v string) *otelpb.KeyValue {
+		return &otelpb.KeyValue{
+			Key: k,
+			Value: &otelpb.AnyValue{
+				StringValue: &v,
+			},
+		}
+	}
+	reqExpected := &otelpb.ExportTraceServiceRequest{
+		ResourceSpans: []*otelpb.ResourceSpans{
+			{
+				Resource: otelpb.Resource{
+					Attributes: []*otelpb.KeyValue{newStringAttr("service.name", "frontend")},
+				},
+				ScopeSpans: []*otelpb.ScopeSpans{{
+					Scope: otelpb.InstrumentationScope{
+						Name: "net/http",
+					},
+					Spans: []*otelpb.Span{
+						{
+							TraceID:    "1234",
+							SpanID:     "1",
+							Attributes: []*otelpb.KeyValue{newStringAttr("http.status_code", "200")},
+							Events: []*otelpb.SpanEvent{{
+								Name:       "exception",
+								Attributes: []*otelpb.KeyValue{newStringAttr("exception.message", "boom")},
+							}},
+						},
+						{
+							TraceID: "1234",
+							SpanID:  "3",
+						},
+					},
+				}},
+			},
+			{
+				Resource: otelpb.Resource{
+					Attributes: []*otelpb.KeyValue{newStringAttr("service.name", "backend")},
+				},
+				ScopeSpans: []*otelpb.ScopeSpans{{
+					Spans: []*otelpb.Span{{
+						TraceID: "1234",
+						SpanID:  "2",
+					}},
+				}},
+			},
+		},
+	}
+	if req := rb.Request(); !reflect.DeepEqual(req, reqExpected) {
+		t.Fatalf("unexpected request\ngot\n%v\nwant\n%v", req, reqExpected)
+	}
+	if n := rb.SpansCount(); n != len(spans) {
+		t.Fatalf("unexpected number of spans; got %d; want %d", n, len(spans))
+	}
+
+	rb.Reset()
+	if req := rb.Request(); len(req.ResourceSpans) != 0 {
+		t.Fatalf("expecting empty request after Reset; got %d resource spans", len(req.ResourceSpans))
+	}
+}
@@ -0,0 +1,115 @@
+package otlp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtselect/traces/query"
+)
+
+const (
+	contentTypeProtobuf = "application/x-protobuf"
+	contentTypeJSON     = "application/json"
+)
+
+// OTLP Query APIs metrics
+var (
+	otlpTraceRequests = metrics.NewCounter(`vt_http_requests_total{path="/select/opentelemetry/v1/traces/*"}`)
+	otlpTraceDuration = metrics.NewSummary(`vt_http_request_duration_seconds{path="/select/opentelemetry/v1/traces/*"}`)
+)
+
+// RequestHandler is the entry point for all the APIs returning traces in OTLP format.
+//
+// See https://docs.victoriametrics.com/victoriatraces/querying/#otlp-api
+func RequestHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) bool {
+	httpserver.EnableCORS(w, r)
+	startTime := time.Now()
+	path := r.URL.Path
+	if strings.HasPrefix(path, "/select/opentelemetry/v1/traces/") && len(path) > len("/select/opentelemetry/v1/traces/") {
+		otlpTraceRequests.Inc()
+		processGetTraceRequest(ctx, w, r)
+		otlpTraceDuration.UpdateDuration(startTime)
+		return true
+	}
+	return false
+}
+
+// processGetTraceRequest handles the /select/opentelemetry/v1/traces/<trace_id> API request.
+//
+// It returns the trace as OTLP ExportTraceServiceRequest, so it could be ingested into any system supporting OTLP.
+func processGetTraceRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	cp, err := query.GetCommonParams(r)
+	if err != nil {
+		httpserver.Errorf(w, r, "incorrect query params: %s", err)
+		return
+	}
+
+	// extract the `trace_id`.
+	// the path must be like `/select/opentelemetry/v1/traces/<trace_id>`.
+	traceID := r.URL.Path[len("/select/opentelemetry/v1/traces/"):]
+	if len(traceID) == 0 || strings.Contains(traceID, "/") {
+		httpserver.Errorf(w, r, "incorrect query path [%s]", r.URL.Path)
+		return
+	}
+
+	rows, err := query.GetTrace(ctx, cp, traceID)
+	if err != nil {
+		httpserver.Errorf(w, r, "cannot get traces: %s", err)
+		return
+	}
+	if len(rows) == 0 {
+		err := &httpserver.ErrorWithStatusCode{
+			Err:        fmt.Errorf("trace %q isn't found", traceID),
+			StatusCode: http.StatusNotFound,
+		}
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+
+	var rb RequestBuilder
+	for _, row := range rows {
+		if err := rb.AddSpan(row.Fields); err != nil {
+			httpserver.Errorf(w, r, "cannot convert span of trace %q to OTLP: %s", traceID, err)
+			return
+		}
+	}
+	writeRequest(w, r, &rb)
+}
+
+// writeRequest writes the request built by rb to w in the format requested by r.
+//
+// The request is written in protobuf if `format=protobuf` query arg is set or if `Accept` header contains application/x-protobuf.
+// Otherwise, it is written in JSON.
+func writeRequest(w http.ResponseWriter, r *http.Request, rb *RequestBuilder) {
+	if isProtobufRequested(r) {
+		w.Header().Set("Content-Type", contentTypeProtobuf)
+		_, _ = w.Write(rb.Request().MarshalProtobuf(nil))
+		return
+	}
+
+	data, err := json.Marshal(rb.Request())
+	if err != nil {
+		httpserver.Errorf(w, r, "cannot marshal OTLP request to JSON: %s", err)
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	_, _ = w.Write(data)
+}
+
+func isProtobufRequested(r *http.Request) bool {
+	switch r.FormValue("format") {
+	case "protobuf":
+		return true
+	case "json":
+		return false
+	default:
+		return strings.Contains(r.Header.Get("Accept"), contentTypeProtobuf)
+	}
+}
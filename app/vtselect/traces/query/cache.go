@@ -0,0 +1,358 @@
+package query
+
+import (
+	"container/list"
+	"flag"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fasttime"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+var (
+	traceCacheSize = flagutil.NewBytes("search.traceCacheSize", 64*1024*1024, "The maximum size in bytes of the in-memory cache for recently requested traces. "+
+		"Set it to 0 for disabling the cache. See https://docs.victoriametrics.com/victoriatraces/querying/#trace-cache")
+	traceCacheMinTTL = flag.Duration("search.traceCacheMinTTL", 10*time.Second, "The minimum duration for caching recently updated traces, "+
+		"so late spans appear in the trace soon. See https://docs.victoriametrics.com/victoriatraces/querying/#trace-cache")
+	traceCacheMaxTTL = flag.Duration("search.traceCacheMaxTTL", 10*time.Minute, "The maximum duration for caching old traces. "+
+		"See https://docs.victoriametrics.com/victoriatraces/querying/#trace-cache")
+)
+
+var (
+	traceNotFoundCacheHits   = metrics.NewCounter(`vt_traces_not_found_cache_hits_total`)
+	traceNotFoundCacheMisses = metrics.NewCounter(`vt_traces_not_found_cache_misses_total`)
+)
+
+// tnfCache contains trace ids, which are missing in the storage.
+//
+// Jaeger UI and other clients may request the missing trace many times, e.g. when the trace id is taken from logs
+// before the trace is ingested. Every such request scans all the stored data, so it is better to remember the missing trace for a while.
+var tnfCache = newTraceNotFoundCache()
+
+// traceNotFoundCache remembers missing trace ids for -search.traceNotFoundCacheDuration.
+//
+// Entries are stored in two generations, which are rotated every -search.traceNotFoundCacheDuration,
+// so expired entries are dropped without scanning the whole cache.
+type traceNotFoundCache struct {
+	mu sync.Mutex
+
+	// curr and prev map tenant and trace id to the unix timestamp in milliseconds when the entry expires.
+	curr map[string]uint64
+	prev map[string]uint64
+
+	// nextRotationTime is the unix timestamp in milliseconds when the current generation must be rotated.
+	nextRotationTime uint64
+}
+
+func newTraceNotFoundCache() *traceNotFoundCache {
+	return &traceNotFoundCache{
+		curr: make(map[string]uint64),
+		prev: make(map[string]uint64),
+	}
+}
+
+// contains returns true if the given traceID has been recently registered as missing for the given tenantIDs.
+func (c *traceNotFoundCache) contains(tenantIDs []logstorage.TenantID, traceID string) bool {
+	if *traceNotFoundCacheDuration <= 0 {
+		return false
+	}
+
+	key := getCacheKey(tenantIDs, traceID)
+	currentTime := fasttime.UnixTimestamp() * 1000
+
+	c.mu.Lock()
+	c.rotateIfNeededLocked(currentTime)
+	deadline, ok := c.curr[key]
+	if !ok {
+		deadline, ok = c.prev[key]
+	}
+	c.mu.Unlock()
+
+	if ok && currentTime < deadline {
+		traceNotFoundCacheHits.Inc()
+		return true
+	}
+	traceNotFoundCacheMisses.Inc()
+	return false
+}
+
+// add registers the given traceID as missing for the given tenantIDs.
+func (c *traceNotFoundCache) add(tenantIDs []logstorage.TenantID, traceID string) {
+	d := uint64(traceNotFoundCacheDuration.Milliseconds())
+	if d == 0 {
+		return
+	}
+
+	key := getCacheKey(tenantIDs, traceID)
+	currentTime := fasttime.UnixTimestamp() * 1000
+
+	c.mu.Lock()
+	c.rotateIfNeededLocked(currentTime)
+	c.curr[key] = currentTime + d
+	c.mu.Unlock()
+}
+
+// rotateIfNeededLocked rotates c generations if needed.
+//
+// c.mu must be locked by the caller.
+func (c *traceNotFoundCache) rotateIfNeededLocked(currentTime uint64) {
+	if currentTime < c.nextRotationTime {
+		return
+	}
+	c.prev = c.curr
+	c.curr = make(map[string]uint64)
+	c.nextRotationTime = currentTime + uint64(traceNotFoundCacheDuration.Milliseconds())
+}
+
+// getCacheKey returns the cache key for s at the given tenantIDs.
+func getCacheKey(tenantIDs []logstorage.TenantID, s string) string {
+	var sb strings.Builder
+	for i := range tenantIDs {
+		sb.WriteString(tenantIDs[i].String())
+	}
+	sb.WriteString(s)
+	return sb.String()
+}
+
+// htCache contains recently requested traces.
+//
+// Many users may open the same trace during incidents, so it is better to avoid searching for the trace spans on every request.
+//
+// The cache is local to the current process. It isn't shared among vtselect nodes in cluster mode, so every vtselect node
+// searches for the trace in the storage on the first request. Entries are keyed by tenant and trace id.
+// Every entry expires after the TTL, which depends on the trace age. See getTraceCacheTTL.
+// All the entries are dropped when spans are deleted from the storage. See vtstorage.GetDataGeneration.
+var htCache = newLRUCache("vt_traces_cache", traceCacheSize)
+
+// lruCache is a size-bounded LRU cache with expiring entries.
+type lruCache struct {
+	mu sync.Mutex
+
+	// m maps keys to elements of lru.
+	m map[string]*list.Element
+
+	// lru contains *lruEntry items. Recently used items are at the front.
+	lru *list.List
+
+	// size is the estimated size in bytes for all the cached entries.
+	size int
+
+	// generation is the generation of the stored spans for the cached entries. See vtstorage.GetDataGeneration.
+	generation uint64
+
+	maxSize *flagutil.Bytes
+
+	requests  *metrics.Counter
+	misses    *metrics.Counter
+	evictions *metrics.Counter
+}
+
+type lruEntry struct {
+	key   string
+	value any
+
+	// size is the estimated size in bytes for the entry.
+	size int
+
+	// deadline is the unix timestamp in seconds when the entry expires.
+	deadline uint64
+}
+
+// newLRUCache returns new lruCache with the size limited by maxSize.
+//
+// The cache metrics are exposed with the given metricPrefix.
+func newLRUCache(metricPrefix string, maxSize *flagutil.Bytes) *lruCache {
+	c := &lruCache{
+		m:       make(map[string]*list.Element),
+		lru:     list.New(),
+		maxSize: maxSize,
+
+		requests:  metrics.GetOrCreateCounter(metricPrefix + `_requests_total`),
+		misses:    metrics.GetOrCreateCounter(metricPrefix + `_misses_total`),
+		evictions: metrics.GetOrCreateCounter(metricPrefix + `_evictions_total`),
+	}
+	metrics.GetOrCreateGauge(metricPrefix+`_size_bytes`, func() float64 {
+		return float64(c.sizeBytes())
+	})
+	metrics.GetOrCreateGauge(metricPrefix+`_entries`, func() float64 {
+		return float64(c.len())
+	})
+	metrics.GetOrCreateGauge(metricPrefix+`_max_size_bytes`, func() float64 {
+		return float64(maxSize.N)
+	})
+	return c
+}
+
+// isEnabled returns true if c may contain entries.
+func (c *lruCache) isEnabled() bool {
+	return c.maxSize.N > 0
+}
+
+// get returns the value for the given key from c.
+//
+// All the entries are dropped from c if the given generation of the stored spans differs from the generation of the cached entries.
+//
+// The returned value must not be modified, since it is shared among callers.
+func (c *lruCache) get(key string, generation uint64) (any, bool) {
+	if !c.isEnabled() {
+		return nil, false
+	}
+	c.requests.Inc()
+
+	currentTime := fasttime.UnixTimestamp()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.updateGenerationLocked(generation)
+	e, ok := c.m[key]
+	if !ok {
+		c.misses.Inc()
+		return nil, false
+	}
+	le := e.Value.(*lruEntry)
+	if currentTime >= le.deadline {
+		c.removeLocked(e)
+		c.misses.Inc()
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return le.value, true
+}
+
+// set stores the value with the given key, estimated size in bytes and ttl in c.
+//
+// The generation must be passed to the get call before obtaining the value. The value isn't stored
+// if the generation of the cached entries has been changed since then, since the value may contain deleted spans.
+//
+// The value must not be modified after the call, since it is shared among callers.
+func (c *lruCache) set(key string, value any, size int, ttl time.Duration, generation uint64) {
+	maxSize := int(c.maxSize.N)
+	if maxSize <= 0 {
+		return
+	}
+	if size > maxSize/4 {
+		// Do not cache too big values, since they may evict many other values.
+		return
+	}
+
+	le := &lruEntry{
+		key:      key,
+		value:    value,
+		size:     size,
+		deadline: fasttime.UnixTimestamp() + uint64(ttl.Seconds()),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		// The generation has been changed after the get call for the given generation.
+		return
+	}
+	if e, ok := c.m[key]; ok {
+		c.removeLocked(e)
+	}
+	c.m[key] = c.lru.PushFront(le)
+	c.size += le.size
+	for c.size > maxSize {
+		c.removeLocked(c.lru.Back())
+		c.evictions.Inc()
+	}
+}
+
+// remove removes the value with the given key from c.
+func (c *lruCache) remove(key string) {
+	c.mu.Lock()
+	if e, ok := c.m[key]; ok {
+		c.removeLocked(e)
+	}
+	c.mu.Unlock()
+}
+
+// updateGenerationLocked drops all the entries from c if the generation differs from the generation of the cached entries.
+//
+// c.mu must be locked by the caller.
+func (c *lruCache) updateGenerationLocked(generation uint64) {
+	if generation == c.generation {
+		return
+	}
+	c.m = make(map[string]*list.Element)
+	c.lru.Init()
+	c.size = 0
+	c.generation = generation
+}
+
+func (c *lruCache) removeLocked(e *list.Element) {
+	le := c.lru.Remove(e).(*lruEntry)
+	delete(c.m, le.key)
+	c.size -= le.size
+}
+
+func (c *lruCache) sizeBytes() int {
+	c.mu.Lock()
+	n := c.size
+	c.mu.Unlock()
+	return n
+}
+
+func (c *lruCache) len() int {
+	c.mu.Lock()
+	n := len(c.m)
+	c.mu.Unlock()
+	return n
+}
+
+// getCachedTrace returns spans for the given traceID and tenantIDs from htCache for the given generation of the stored spans.
+//
+// The returned rows must not be modified, since they are shared among callers.
+func getCachedTrace(tenantIDs []logstorage.TenantID, traceID string, generation uint64) ([]*Row, bool) {
+	v, ok := htCache.get(getCacheKey(tenantIDs, traceID), generation)
+	if !ok {
+		return nil, false
+	}
+	return v.([]*Row), true
+}
+
+// setCachedTrace stores rows for the given traceID and tenantIDs in htCache.
+//
+// The generation must be passed to getCachedTrace before obtaining the rows.
+// rows must not be modified after the call, since they are shared among callers.
+func setCachedTrace(tenantIDs []logstorage.TenantID, traceID string, rows []*Row, generation uint64) {
+	if len(rows) == 0 || !htCache.isEnabled() {
+		return
+	}
+	key := getCacheKey(tenantIDs, traceID)
+	htCache.set(key, rows, getRowsSize(key, rows), getTraceCacheTTL(rows), generation)
+}
+
+// getTraceCacheTTL returns the duration for caching the trace with the given rows.
+//
+// Recently updated traces may receive late spans, so they are cached for -search.traceCacheMinTTL.
+// The TTL grows with the time passed since the last span of the trace up to -search.traceCacheMaxTTL.
+func getTraceCacheTTL(rows []*Row) time.Duration {
+	maxTimestamp := int64(0)
+	for _, row := range rows {
+		maxTimestamp = max(maxTimestamp, row.Timestamp)
+	}
+	age := time.Since(time.Unix(0, maxTimestamp))
+	return min(max(age/10, *traceCacheMinTTL), *traceCacheMaxTTL)
+}
+
+// getRowsSize returns the estimated size in bytes for the cache entry with the given key and rows.
+func getRowsSize(key string, rows []*Row) int {
+	// The overhead for the map entry, list element and hotTraceEntry.
+	n := 150 + len(key)
+	for _, row := range rows {
+		n += int(unsafe.Sizeof(*row)) + 8
+		for _, f := range row.Fields {
+			n += int(unsafe.Sizeof(f)) + len(f.Name) + len(f.Value)
+		}
+	}
+	return n
+}
@@ -0,0 +1,181 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+)
+
+func TestTraceNotFoundCache(t *testing.T) {
+	c := newTraceNotFoundCache()
+	tenantIDs := []logstorage.TenantID{{AccountID: 1}}
+	otherTenantIDs := []logstorage.TenantID{{AccountID: 2}}
+
+	if c.contains(tenantIDs, "foo") {
+		t.Fatalf("unexpected trace in empty cache")
+	}
+
+	c.add(tenantIDs, "foo")
+	if !c.contains(tenantIDs, "foo") {
+		t.Fatalf("missing trace in the cache")
+	}
+	if c.contains(tenantIDs, "bar") {
+		t.Fatalf("unexpected trace in the cache")
+	}
+	if c.contains(otherTenantIDs, "foo") {
+		t.Fatalf("unexpected trace for another tenant in the cache")
+	}
+
+	// the entry is still visible after the rotation
+	c.mu.Lock()
+	c.nextRotationTime = 0
+	c.mu.Unlock()
+	if !c.contains(tenantIDs, "foo") {
+		t.Fatalf("missing trace in the cache after the rotation")
+	}
+
+	// the entry is dropped after two rotations
+	c.mu.Lock()
+	c.nextRotationTime = 0
+	c.mu.Unlock()
+	if c.contains(tenantIDs, "foo") {
+		t.Fatalf("unexpected trace in the cache after two rotations")
+	}
+}
+
+func TestLRUCache(t *testing.T) {
+	maxSize := &flagutil.Bytes{
+		N: 1024 * 1024,
+	}
+	c := newLRUCache("vt_test_lru_cache", maxSize)
+
+	if _, ok := c.get("foo", 1); ok {
+		t.Fatalf("unexpected value in empty cache")
+	}
+
+	c.set("foo", "foo_value", 100, time.Minute, 1)
+	v, ok := c.get("foo", 1)
+	if !ok {
+		t.Fatalf("missing value in the cache")
+	}
+	if v.(string) != "foo_value" {
+		t.Fatalf("unexpected cached value; got %q; want %q", v, "foo_value")
+	}
+
+	// expired values aren't returned
+	c.set("bar", "bar_value", 100, 0, 1)
+	if _, ok := c.get("bar", 1); ok {
+		t.Fatalf("unexpected expired value in the cache")
+	}
+
+	// the least recently used value is evicted when the cache size exceeds the limit
+	maxSize.N = 450
+	c.set("bar", "bar_value", 100, time.Minute, 1)
+	c.set("baz", "baz_value", 100, time.Minute, 1)
+	c.get("foo", 1)
+	c.set("qux", "qux_value", 100, time.Minute, 1)
+	c.set("quux", "quux_value", 100, time.Minute, 1)
+	if _, ok := c.get("bar", 1); ok {
+		t.Fatalf("the least recently used value must be evicted")
+	}
+	if _, ok := c.get("foo", 1); !ok {
+		t.Fatalf("the recently used value must be kept")
+	}
+	if size := c.sizeBytes(); size > int(maxSize.N) {
+		t.Fatalf("too big cache size; got %d; want up to %d", size, maxSize.N)
+	}
+
+	// too big values aren't cached
+	c.set("big", "big_value", 200, time.Minute, 1)
+	if _, ok := c.get("big", 1); ok {
+		t.Fatalf("unexpected too big value in the cache")
+	}
+
+	c.remove("foo")
+	if _, ok := c.get("foo", 1); ok {
+		t.Fatalf("unexpected removed value in the cache")
+	}
+
+	// all the entries are dropped when the generation changes
+	c.set("foo", "foo_value", 100, time.Minute, 1)
+	if _, ok := c.get("foo", 2); ok {
+		t.Fatalf("unexpected value for the previous generation in the cache")
+	}
+	if _, ok := c.get("foo", 1); ok {
+		t.Fatalf("unexpected value for the previous generation in the cache after the generation change")
+	}
+	if size := c.sizeBytes(); size != 0 {
+		t.Fatalf("unexpected cache size after the generation change; got %d; want 0", size)
+	}
+
+	// values obtained for the outdated generation aren't cached
+	c.get("foo", 2)
+	c.set("foo", "foo_value", 100, time.Minute, 1)
+	if _, ok := c.get("foo", 2); ok {
+		t.Fatalf("unexpected value for the outdated generation in the cache")
+	}
+
+	// disabled cache
+	maxSize.N = 0
+	c.set("foo", "foo_value", 100, time.Minute, 1)
+	if _, ok := c.get("foo", 1); ok {
+		t.Fatalf("unexpected value in disabled cache")
+	}
+}
+
+func TestCachedTrace(t *testing.T) {
+	tenantIDs := []logstorage.TenantID{{AccountID: 1}}
+	rows := []*Row{{
+		Timestamp: time.Now().UnixNano(),
+		Fields: []logstorage.Field{{
+			Name:  "name",
+			Value: "foo",
+		}},
+	}}
+
+	if _, ok := getCachedTrace(tenantIDs, "foo", 1); ok {
+		t.Fatalf("unexpected trace in the cache")
+	}
+	setCachedTrace(tenantIDs, "foo", rows, 1)
+	defer htCache.remove(getCacheKey(tenantIDs, "foo"))
+	result, ok := getCachedTrace(tenantIDs, "foo", 1)
+	if !ok {
+		t.Fatalf("missing trace in the cache")
+	}
+	if v := result[0].Fields[0].Value; v != "foo" {
+		t.Fatalf("unexpected cached trace; got %q; want %q", v, "foo")
+	}
+	if _, ok := getCachedTrace([]logstorage.TenantID{{AccountID: 2}}, "foo", 1); ok {
+		t.Fatalf("unexpected trace for another tenant in the cache")
+	}
+
+	// empty traces aren't cached
+	setCachedTrace(tenantIDs, "bar", nil, 1)
+	if _, ok := getCachedTrace(tenantIDs, "bar", 1); ok {
+		t.Fatalf("unexpected empty trace in the cache")
+	}
+}
+
+func TestGetTraceCacheTTL(t *testing.T) {
+	f := func(age, ttlExpected time.Duration) {
+		t.Helper()
+
+		rows := []*Row{{
+			Timestamp: time.Now().Add(-age).UnixNano(),
+		}}
+		ttl := getTraceCacheTTL(rows)
+		if ttl < ttlExpected-time.Second || ttl > ttlExpected+time.Second {
+			t.Fatalf("unexpected ttl for trace age %s; got %s; want %s", age, ttl, ttlExpected)
+		}
+	}
+
+	// recent traces
+	f(0, *traceCacheMinTTL)
+	f(time.Minute, *traceCacheMinTTL)
+
+	// old traces
+	f(time.Hour, 6*time.Minute)
+	f(24*time.Hour, *traceCacheMaxTTL)
+}
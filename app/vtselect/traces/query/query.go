@@ -2,16 +2,23 @@ package query
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"flag"
 	"fmt"
+	"maps"
+	"math"
 	"net/http"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+	"github.com/VictoriaMetrics/metrics"
 	"github.com/cespare/xxhash/v2"
 
 	"github.com/VictoriaMetrics/VictoriaTraces/app/vtstorage"
@@ -24,9 +31,15 @@ var (
 		"It affects both Jaeger's /api/traces and /api/traces/<trace_id> APIs.")
 	traceServiceAndSpanNameLookbehind = flag.Duration("search.traceServiceAndSpanNameLookbehind", 3*24*time.Hour, "The time range of searching for service name and span name. "+
 		"It affects Jaeger's /api/services and /api/services/*/operations APIs.")
-	traceSearchStep = flag.Duration("search.traceSearchStep", 24*time.Hour, "Splits the [0, now] time range into many small time ranges by -search.traceSearchStep "+
-		"when searching for spans by trace_id. Once it finds spans in a time range, it performs an additional search according to -search.traceMaxDurationWindow and then stops. "+
+	traceSearchStep = flag.Duration("search.traceSearchStep", 24*time.Hour, "Splits the time range from the oldest stored span till now into many small time ranges by -search.traceSearchStep "+
+		"when searching for spans by trace_id. The time ranges are searched in parallel starting from the most recent one, see -search.traceSearchConcurrency. "+
+		"Once it finds a span in a time range, it stops and performs an additional search according to -search.traceMaxDurationWindow. "+
 		"It affects Jaeger's /api/traces/<trace_id> API.")
+	traceSearchConcurrency = flag.Int("search.traceSearchConcurrency", 4, "The maximum number of -search.traceSearchStep time ranges, which are searched in parallel when searching for spans by trace_id. "+
+		"It affects Jaeger's /api/traces/<trace_id> API.")
+	traceNotFoundCacheDuration = flag.Duration("search.traceNotFoundCacheDuration", 10*time.Second, "How long to remember trace ids, which are missing in the storage. "+
+		"This speeds up repeated requests for missing traces, while spans ingested during this time may be invisible for such requests. "+
+		"Set it to 0 for disabling the cache. It affects Jaeger's /api/traces/<trace_id> API.")
 	traceMaxServiceNameList = flag.Uint64("search.traceMaxServiceNameList", 1000, "The maximum number of service name can return in a get service name request. "+
 		"This limit affects Jaeger's /api/services API.")
 	traceMaxSpanNameList = flag.Uint64("search.traceMaxSpanNameList", 1000, "The maximum number of span name can return in a get span name request. "+
@@ -37,6 +50,12 @@ var (
 	traceIDRegex = regexp.MustCompile(`^[a-zA-Z0-9_\-.:]*$`)
 )
 
+// windowsScannedPerQuery tracks the number of time windows scanned per every trace search. See scanTraceIDWindows.
+var windowsScannedPerQuery = metrics.NewHistogram(`vt_traces_search_per_query_scanned_windows`)
+
+// ErrTraceNotFound is returned when the requested trace is missing.
+var ErrTraceNotFound = errors.New("trace not found")
+
 // CommonParams common query params that shared by all requests.
 type CommonParams struct {
 	TenantIDs []logstorage.TenantID
@@ -77,6 +96,63 @@ type TraceQueryParam struct {
 	DurationMin  time.Duration
 	DurationMax  time.Duration
 	Limit        int
+
+	// Cursor is the position to resume the search from. The search starts from the most recent traces if it is nil.
+	Cursor *TraceCursor
+
+	// NoCache disables the trace search cache for the query. See findTraceIDsWithCache.
+	NoCache bool
+}
+
+// TraceCursor is the position in the trace search results.
+//
+// Traces are ordered by the timestamp of their last matching span in descending order,
+// while traces with identical timestamps are ordered by trace_id.
+// The next page contains traces ordered after the trace with the given Timestamp and TraceID.
+type TraceCursor struct {
+	// Timestamp is the timestamp of the last matching span of the last returned trace in nanoseconds.
+	Timestamp int64
+
+	// TraceID is the trace_id of the last returned trace.
+	TraceID string
+}
+
+// ParseTraceCursor parses the opaque cursor returned by TraceCursor.String.
+func ParseTraceCursor(s string) (*TraceCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode cursor %q: %w", s, err)
+	}
+	tsStr, traceID, ok := strings.Cut(string(data), ":")
+	if !ok || traceID == "" {
+		return nil, fmt.Errorf("invalid cursor %q", s)
+	}
+	timestamp, err := strconv.ParseInt(tsStr, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse timestamp in cursor %q: %w", s, err)
+	}
+	if !traceIDRegex.MatchString(traceID) {
+		return nil, fmt.Errorf("invalid trace_id in cursor %q", s)
+	}
+	return &TraceCursor{
+		Timestamp: timestamp,
+		TraceID:   traceID,
+	}, nil
+}
+
+// String returns the opaque string representation of tc, which can be parsed by ParseTraceCursor.
+func (tc *TraceCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%s", tc.Timestamp, tc.TraceID)))
+}
+
+// filter returns LogsQL filter for traces ordered after tc.
+//
+// The filter must be applied to the _time and trace_id of the last matching span per every trace.
+func (tc *TraceCursor) filter() string {
+	t := time.Unix(0, tc.Timestamp).UTC().Format(time.RFC3339Nano)
+	// trace_id values are compared as strings, since they may look like numbers.
+	return fmt.Sprintf("_time:[1970-01-01T00:00:00Z, %s) OR (_time:[%s, %s] -%s:string_range(\"\", %q) -%s:=%q)",
+		t, t, t, otelpb.TraceIDField, tc.TraceID, otelpb.TraceIDField, tc.TraceID)
 }
 
 // Row represent the query result of a trace span.
@@ -115,13 +191,15 @@ func GetServiceNameList(ctx context.Context, cp *CommonParams) ([]string, error)
 }
 
 // GetSpanNameList returns all unique span names for a service within *traceServiceAndSpanNameLookbehind window.
+//
+// The placeholder for folded span names isn't returned. Real span names are returned for spans with folded span names instead.
 // todo: cache of recent result.
 func GetSpanNameList(ctx context.Context, cp *CommonParams, serviceName string) ([]string, error) {
 	currentTime := time.Now()
 
 	// query: _time:[start, end] {"resource_attr:service.name"=serviceName}
 	qStr := fmt.Sprintf("_stream:{%s=%q}", otelpb.ResourceAttrServiceName, serviceName)
-	q, err := logstorage.ParseQueryAtTimestamp(qStr, currentTime.Unix())
+	q, err := logstorage.ParseQueryAtTimestamp(qStr, currentTime.UnixNano())
 	if err != nil {
 		return nil, fmt.Errorf("cannot parse query [%s]: %s", qStr, err)
 	}
@@ -137,43 +215,198 @@ func GetSpanNameList(ctx context.Context, cp *CommonParams, serviceName string)
 	}
 
 	spanNameList := make([]string, 0, len(spanNameHits))
+	hasFoldedSpanNames := false
 	for i := range spanNameHits {
+		if spanNameHits[i].Value == otelpb.FoldedSpanName {
+			hasFoldedSpanNames = true
+			continue
+		}
 		spanNameList = append(spanNameList, spanNameHits[i].Value)
 	}
+	if !hasFoldedSpanNames {
+		return spanNameList, nil
+	}
+
+	// Spans with folded span names are stored in the stream with the placeholder span name, while the real span name is stored in the name field.
+	// See -opentelemetry.traces.maxSpanNamesPerService at vtinsert.
+	qStr = fmt.Sprintf("_stream:{%s=%q, %s=%q}", otelpb.ResourceAttrServiceName, serviceName, otelpb.NameField, otelpb.FoldedSpanName)
+	q, err = logstorage.ParseQueryAtTimestamp(qStr, currentTime.UnixNano())
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse query [%s]: %s", qStr, err)
+	}
+	q.AddTimeFilter(currentTime.Add(-*traceServiceAndSpanNameLookbehind).UnixNano(), currentTime.UnixNano())
+	qctx = qctx.WithQuery(q)
+
+	foldedSpanNameHits, err := vtstorage.GetFieldValues(qctx, otelpb.NameField, *traceMaxSpanNameList)
+	if err != nil {
+		return nil, fmt.Errorf("get folded span name hits error: %s", err)
+	}
+	for i := range foldedSpanNameHits {
+		if uint64(len(spanNameList)) >= *traceMaxSpanNameList {
+			break
+		}
+		if name := foldedSpanNameHits[i].Value; !slices.Contains(spanNameList, name) {
+			spanNameList = append(spanNameList, name)
+		}
+	}
 	return spanNameList, nil
 }
 
 // GetTrace returns all spans of a trace in []*Row format.
 // It search in the index stream for the approximate timestamp.
-// If found:
-// - search for span in time range [aTimestamp-traceMaxDurationWindow, aTimestamp+traceMaxDurationWindow].
-// If not found:
-// - search span by step via findSpansByTraceID.
+// If not found, it searches for any span of the trace instead, since the root span may be missing.
+// Then it searches for spans in time range [aTimestamp-traceMaxDurationWindow, aTimestamp+traceMaxDurationWindow].
+// See findTraceTimestamp for details.
 //
-// todo in-memory cache of hot traces.
+// If the trace is missing in the main storage, then it is searched in the archive.
+// See ArchiveTrace.
+//
+// Recently requested traces are cached in memory. The returned rows must not be modified, since they may be shared among callers.
 func GetTrace(ctx context.Context, cp *CommonParams, traceID string) ([]*Row, error) {
-	currentTime := time.Now()
+	generation := uint64(0)
+	if htCache.isEnabled() {
+		generation = vtstorage.GetDataGeneration(ctx)
+	}
+	if rows, ok := getCachedTrace(cp.TenantIDs, traceID, generation); ok {
+		return rows, nil
+	}
+	if tnfCache.contains(cp.TenantIDs, traceID) {
+		return nil, nil
+	}
 
+	rows, isComplete, err := getTrace(ctx, cp, traceID)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		// The trace may be already dropped from the main storage according to the retention, while it is kept in the archive.
+		rows, err = GetArchivedTrace(ctx, cp, traceID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(rows) == 0 {
+		if isComplete {
+			tnfCache.add(cp.TenantIDs, traceID)
+		}
+		return nil, nil
+	}
+
+	setCachedTrace(cp.TenantIDs, traceID, rows, generation)
+	return rows, nil
+}
+
+// getTrace returns all spans of a trace from the main storage.
+//
+// isComplete is set to false if older spans of the trace may be missed because of unavailable storage nodes.
+// See findTraceTimestamp for details.
+func getTrace(ctx context.Context, cp *CommonParams, traceID string) ([]*Row, bool, error) {
 	// possible partition
 	// query: {trace_id_idx="xx"} AND trace_id:traceID
-	qStr := fmt.Sprintf(`{%s="%d"} AND %s:=%q | fields _time`, otelpb.TraceIDIndexStreamName, xxhash.Sum64String(traceID)%otelpb.TraceIDIndexPartitionCount, otelpb.TraceIDIndexFieldName, traceID)
-	q, err := logstorage.ParseQueryAtTimestamp(qStr, currentTime.UnixNano())
+	qStr := fmt.Sprintf(`{%s="%d"} AND %s:=%q`, otelpb.TraceIDIndexStreamName, xxhash.Sum64String(traceID)%otelpb.TraceIDIndexPartitionCount, otelpb.TraceIDIndexFieldName, traceID)
+	traceTimestamp, isComplete, err := findTraceTimestamp(ctx, cp, qStr)
 	if err != nil {
-		return nil, fmt.Errorf("cannot unmarshal query=%q: %w", qStr, err)
+		return nil, false, fmt.Errorf("cannot find trace_id %q start time: %s", traceID, err)
 	}
-	q.AddPipeOffsetLimit(0, 1)
-	traceTimestamp, err := findTraceIDTimeSplitTimeRange(ctx, q, cp)
+
+	// slow path: if trace start time not exist, probably the root span was not available.
+	// try to search for any span of the trace.
+	if traceTimestamp.IsZero() {
+		qStr = fmt.Sprintf(`%s:=%q`, otelpb.TraceIDField, traceID)
+		traceTimestamp, isComplete, err = findTraceTimestamp(ctx, cp, qStr)
+		if err != nil {
+			return nil, false, fmt.Errorf("cannot find spans for trace_id %q: %s", traceID, err)
+		}
+	}
+	if traceTimestamp.IsZero() {
+		return nil, isComplete, nil
+	}
+
+	// search in [trace timestamp - *traceMaxDurationWindow, trace timestamp + *traceMaxDurationWindow] time range.
+	rows, err := findSpansByTraceIDAndTime(ctx, cp, traceID, traceTimestamp.Add(-*traceMaxDurationWindow), traceTimestamp.Add(*traceMaxDurationWindow))
+	return rows, isComplete, err
+}
+
+// ArchiveTrace copies all spans of a trace from the main storage to the archive, so the trace outlives the retention of the main storage.
+//
+// It returns the number of archived spans. ErrTraceNotFound is returned if the trace is missing in the main storage.
+func ArchiveTrace(ctx context.Context, cp *CommonParams, traceID string) (uint64, error) {
+	if len(cp.TenantIDs) != 1 {
+		// Spans of the trace cannot be attributed to tenants, so the trace cannot be archived for multiple tenants.
+		return 0, fmt.Errorf("the trace must be archived for a single tenant; got %d tenants", len(cp.TenantIDs))
+	}
+	rows, _, err := getTrace(ctx, cp, traceID)
 	if err != nil {
-		return nil, fmt.Errorf("cannot find trace_id %q start time: %s", traceID, err)
+		return 0, err
+	}
+	if len(rows) == 0 {
+		return 0, ErrTraceNotFound
+	}
+
+	// Spans are stored with the end timestamp, while the trace_id_idx entry is stored with the span start timestamp.
+	// So the time range must start at the earliest span start in order to archive the trace_id_idx entry.
+	start := rows[0].Timestamp
+	end := rows[0].Timestamp
+	for _, row := range rows {
+		start = min(start, row.Timestamp)
+		end = max(end, row.Timestamp)
+		for _, f := range row.Fields {
+			if f.Name != otelpb.StartTimeUnixNanoField {
+				continue
+			}
+			if n, err := strconv.ParseInt(f.Value, 10, 64); err == nil {
+				start = min(start, n)
+			}
+		}
 	}
+	return vtstorage.ArchiveTrace(ctx, cp.TenantIDs[0], traceID, start, end)
+}
+
+// UnarchiveTrace deletes a trace from the archive for all the cp.TenantIDs. It returns false if the trace is missing in the archive.
+func UnarchiveTrace(ctx context.Context, cp *CommonParams, traceID string) (bool, error) {
+	// The cached trace may contain archived spans, which are no longer available.
+	defer htCache.remove(getCacheKey(cp.TenantIDs, traceID))
 
-	// fast path: trace start time found, search in [trace start time, trace start time + *traceMaxDurationWindow] time range.
-	if !traceTimestamp.IsZero() {
-		return findSpansByTraceIDAndTime(ctx, cp, traceID, traceTimestamp.Add(-*traceMaxDurationWindow), traceTimestamp.Add(*traceMaxDurationWindow))
+	found := false
+	for _, tenantID := range cp.TenantIDs {
+		ok, err := vtstorage.UnarchiveTrace(ctx, tenantID, traceID)
+		if err != nil {
+			return found, err
+		}
+		found = found || ok
 	}
-	// slow path: if trace start time not exist, probably the root span was not available.
-	// try to search from now to 0 timestamp.
-	return findSpansByTraceID(ctx, cp, traceID)
+	return found, nil
+}
+
+// ListArchivedTraces returns archived traces for all the cp.TenantIDs.
+func ListArchivedTraces(ctx context.Context, cp *CommonParams) ([]vtstorage.ArchivedTrace, error) {
+	var traces []vtstorage.ArchivedTrace
+	for _, tenantID := range cp.TenantIDs {
+		tenantTraces, err := vtstorage.ListArchivedTraces(ctx, tenantID)
+		if err != nil {
+			return nil, err
+		}
+		traces = append(traces, tenantTraces...)
+	}
+	return traces, nil
+}
+
+// GetArchivedTrace returns all spans of an archived trace for all the cp.TenantIDs in []*Row format.
+func GetArchivedTrace(ctx context.Context, cp *CommonParams, traceID string) ([]*Row, error) {
+	var rows []*Row
+	for _, tenantID := range cp.TenantIDs {
+		spans, err := vtstorage.GetArchivedTrace(ctx, tenantID, traceID)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get archived trace: %w", err)
+		}
+		for _, span := range spans {
+			rows = append(rows, &Row{
+				Timestamp: span.Timestamp,
+				Fields:    span.Fields,
+			})
+		}
+	}
+	return rows, nil
 }
 
 // GetTraceList returns multiple traceIDs and spans of them in []*Row format.
@@ -185,23 +418,33 @@ func GetTrace(ctx context.Context, cp *CommonParams, traceID string) ([]*Row, er
 // 1. input time range: [00:00, 09:00]
 // 2. found 20 trace id, and adjust time range to: [08:00, 09:00]
 // 3. find spans on time range: [08:00-traceMaxDurationWindow, 09:00+traceMaxDurationWindow]
-func GetTraceList(ctx context.Context, cp *CommonParams, param *TraceQueryParam) ([]string, []*Row, error) {
+//
+// It also returns the cursor for the next page if there may be more traces matching the param, and the search stats.
+func GetTraceList(ctx context.Context, cp *CommonParams, param *TraceQueryParam) ([]string, []*Row, *TraceCursor, *TraceSearchStats, error) {
 	currentTime := time.Now()
 
-	// query 1: * AND filter_conditions | last 1 by (_time) partition by (trace_id) | fields _time, trace_id | sort by (_time) desc
-	traceIDs, startTime, err := getTraceIDList(ctx, cp, param)
+	// query 1: * AND filter_conditions | last 1 by (_time) partition by (trace_id) | fields _time, trace_id | sort by (_time desc, trace_id)
+	traceIDs, startTime, stats, err := getTraceIDList(ctx, cp, param)
 	if err != nil {
-		return nil, nil, fmt.Errorf("get trace id error: %w", err)
+		return nil, nil, nil, nil, fmt.Errorf("get trace id error: %w", err)
 	}
 	if len(traceIDs) == 0 {
-		return nil, nil, nil
+		return nil, nil, nil, stats, nil
+	}
+	var nextCursor *TraceCursor
+	if len(traceIDs) == param.Limit {
+		// The last trace has the smallest timestamp, which is returned as startTime.
+		nextCursor = &TraceCursor{
+			Timestamp: startTime.UnixNano(),
+			TraceID:   traceIDs[len(traceIDs)-1],
+		}
 	}
 
 	// query 2: trace_id:in(traceID, traceID, ...)
 	qStr := fmt.Sprintf(otelpb.TraceIDField+":in(%s)", strings.Join(traceIDs, ","))
 	q, err := logstorage.ParseQueryAtTimestamp(qStr, currentTime.UnixNano())
 	if err != nil {
-		return nil, nil, fmt.Errorf("cannot parse query [%s]: %s", qStr, err)
+		return nil, nil, nil, nil, fmt.Errorf("cannot parse query [%s]: %s", qStr, err)
 	}
 
 	// adjust start time and end time with max duration window to make sure all spans are included.
@@ -254,30 +497,93 @@ func GetTraceList(ctx context.Context, cp *CommonParams, param *TraceQueryParam)
 	}
 
 	if err = vtstorage.RunQuery(qctx, writeBlock); err != nil {
-		return nil, nil, err
+		return nil, nil, nil, nil, err
 	}
 	if missingTimeColumn.Load() {
-		return nil, nil, fmt.Errorf("missing _time column in the result for the query [%s]", q)
+		return nil, nil, nil, nil, fmt.Errorf("missing _time column in the result for the query [%s]", q)
 	}
-	return traceIDs, rows, nil
+	return traceIDs, rows, nextCursor, stats, nil
+}
+
+// TraceSearchStats contains stats for the trace search.
+type TraceSearchStats struct {
+	// ScannedWindows is the number of queries over time windows executed in the storage while searching for trace ids.
+	//
+	// Time windows served from the search result cache aren't counted.
+	ScannedWindows int
 }
 
 // getTraceIDList returns traceIDs according to the search params.
 // It also returns the earliest start time of these traces, to help reducing the time range for spans search.
-func getTraceIDList(ctx context.Context, cp *CommonParams, param *TraceQueryParam) ([]string, time.Time, error) {
+func getTraceIDList(ctx context.Context, cp *CommonParams, param *TraceQueryParam) ([]string, time.Time, *TraceSearchStats, error) {
 	currentTime := time.Now()
-	// query: * AND <filter> | last 1 by (_time) partition by (trace_id) | fields _time, trace_id | sort by (_time) desc
+	// query: * AND <filter> | last 1 by (_time) partition by (trace_id) | fields _time, trace_id | sort by (_time desc, trace_id)
+	filterStr := getTraceSearchFilter(param)
+	qStr := filterStr + " | last 1 by (_time) partition by (" + otelpb.TraceIDField + ") | fields _time, " + otelpb.TraceIDField
+	if param.Cursor != nil {
+		qStr += " | filter " + param.Cursor.filter()
+	}
+	qStr += " | sort by (_time desc, " + otelpb.TraceIDField + ")"
+	endTime, firstStep := getTraceIDSearchWindow(param)
+
+	q, err := logstorage.ParseQueryAtTimestamp(qStr, currentTime.UnixNano())
+	if err != nil {
+		return nil, time.Time{}, nil, fmt.Errorf("cannot parse query [%s]: %s", qStr, err)
+	}
+	q.AddPipeOffsetLimit(0, uint64(param.Limit))
+
+	tc := newTraceIDCollector(param.Limit)
+	if param.Cursor == nil && !param.NoCache && tsCache.isEnabled() {
+		err = findTraceIDsWithCache(ctx, q, cp, filterStr, param.StartTimeMin, endTime, tc)
+	} else {
+		err = findTraceIDsSplitTimeRange(ctx, q, cp, param.StartTimeMin, endTime, firstStep, tc)
+	}
+	windowsScannedPerQuery.Update(float64(tc.windowsScanned))
+	if err != nil {
+		return nil, time.Time{}, nil, err
+	}
+	stats := &TraceSearchStats{
+		ScannedWindows: tc.windowsScanned,
+	}
+	traceIDs, startTime, err := tc.getResult(endTime)
+	if err != nil {
+		return nil, time.Time{}, nil, err
+	}
+	return traceIDs, startTime, stats, nil
+}
+
+// getTraceIDSearchWindow returns the end of the time range and the duration of the first time window for the trace ids search.
+//
+// See scanTraceIDWindows.
+func getTraceIDSearchWindow(param *TraceQueryParam) (time.Time, time.Duration) {
+	endTime := param.StartTimeMax
+	firstStep := time.Minute
+	if param.Cursor != nil {
+		// Traces returned before the cursor cannot have spans older than the cursor plus *traceMaxDurationWindow,
+		// so there is no need in scanning the more recent time range again.
+		if t := time.Unix(0, param.Cursor.Timestamp).Add(*traceMaxDurationWindow); t.Before(endTime) {
+			endTime = t
+		}
+		// The first window must contain all the spans of traces returned before the cursor, since they are filtered out by the cursor filter.
+		// Otherwise, such traces could be found again in the older windows.
+		firstStep = max(firstStep, 2**traceMaxDurationWindow)
+	}
+	return endTime, firstStep
+}
+
+// getTraceSearchFilter returns LogsQL filter for spans matching the param.
+//
+// The filter doesn't depend on the order of param.Attributes, so it can be used in cache keys.
+func getTraceSearchFilter(param *TraceQueryParam) string {
 	qStr := "* "
 	if param.ServiceName != "" {
-		qStr += fmt.Sprintf("AND _stream:{"+otelpb.ResourceAttrServiceName+"=%q} ", param.ServiceName)
+		qStr += "AND " + GetServiceNameFilter(param.ServiceName) + " "
 	}
 	if param.SpanName != "" {
-		qStr += fmt.Sprintf("AND _stream:{"+otelpb.NameField+"=%q} ", param.SpanName)
+		qStr += "AND " + GetSpanNameFilter(param.SpanName) + " "
 	}
-	if len(param.Attributes) > 0 {
-		for k, v := range param.Attributes {
-			qStr += fmt.Sprintf(`AND %q:=%q `, k, v)
-		}
+	for _, k := range slices.Sorted(maps.Keys(param.Attributes)) {
+		qStr += "AND " + GetAttributeFilter(k, param.Attributes[k]) + " "
 	}
 	if param.DurationMin > 0 {
 		qStr += fmt.Sprintf("AND "+otelpb.DurationField+":>%d ", param.DurationMin.Nanoseconds())
@@ -285,206 +591,354 @@ func getTraceIDList(ctx context.Context, cp *CommonParams, param *TraceQueryPara
 	if param.DurationMax > 0 {
 		qStr += fmt.Sprintf("AND duration:<%d ", param.DurationMax.Nanoseconds())
 	}
-	qStr += " | last 1 by (_time) partition by (" + otelpb.TraceIDField + ") | fields _time, " + otelpb.TraceIDField + " | sort by (_time) desc"
+	return qStr
+}
 
-	q, err := logstorage.ParseQueryAtTimestamp(qStr, currentTime.UnixNano())
-	if err != nil {
-		return nil, time.Time{}, fmt.Errorf("cannot parse query [%s]: %s", qStr, err)
-	}
-	q.AddPipeOffsetLimit(0, uint64(param.Limit))
+// GetServiceNameFilter returns LogsQL filter for spans of the service with the given name.
+func GetServiceNameFilter(serviceName string) string {
+	return fmt.Sprintf("_stream:{"+otelpb.ResourceAttrServiceName+"=%q}", serviceName)
+}
 
-	traceIDs, maxStartTime, err := findTraceIDsSplitTimeRange(ctx, q, cp, param.StartTimeMin, param.StartTimeMax, param.Limit)
-	if err != nil {
-		return nil, time.Time{}, err
-	}
+// GetSpanNameFilter returns LogsQL filter for spans with the given name.
+func GetSpanNameFilter(spanName string) string {
+	// Spans with folded span names are stored in the stream with the placeholder span name, while the real span name is stored in the name field.
+	return fmt.Sprintf("(_stream:{"+otelpb.NameField+"=%q} OR (_stream:{"+otelpb.NameField+"=%q} AND "+otelpb.NameField+":=%q))",
+		spanName, otelpb.FoldedSpanName, spanName)
+}
 
-	return traceIDs, maxStartTime, nil
+// GetAttributeFilter returns LogsQL filter for the attribute field k with the value v.
+//
+// Numeric values are also matched by value, so 1.50 matches 1.5 stored for double attributes.
+func GetAttributeFilter(k, v string) string {
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return fmt.Sprintf("%q:=%q", k, v)
+	}
+	n := strconv.FormatFloat(f, 'f', -1, 64)
+	return fmt.Sprintf("(%q:=%q OR %q:range[%s, %s])", k, v, k, n, n)
 }
 
-// findTraceIDsSplitTimeRange try to search from the nearest time range of the end time.
-// if the result already met requirement of `limit`, return.
-// otherwise, amplify the time range to 5x and search again, until the start time exceed the input.
-func findTraceIDsSplitTimeRange(ctx context.Context, q *logstorage.Query, cp *CommonParams, startTime, endTime time.Time, limit int) ([]string, time.Time, error) {
+// findTraceIDsSplitTimeRange searches for trace ids on the [startTime, endTime] time range starting from the endTime and adds them to tc.
+//
+// It scans disjoint time windows backwards from the endTime. The first window has firstStep duration, while every next window is 5x bigger.
+// Distinct trace ids are accumulated across windows, so the most recent data isn't scanned again when there are not enough
+// trace ids in it, e.g. for rare services. The search stops when tc is full or when the startTime is reached.
+//
+// The query q must return _time and trace_id of the last matching span per every trace, sorted by _time in descending order.
+func findTraceIDsSplitTimeRange(ctx context.Context, q *logstorage.Query, cp *CommonParams, startTime, endTime time.Time, firstStep time.Duration, tc *traceIDCollector) error {
 	currentTime := time.Now()
 
-	step := time.Minute
-	currentStartTime := endTime.Add(-step)
-
-	var traceIDListLock sync.Mutex
-	traceIDList := make([]string, 0, limit)
-	maxStartTimeStr := endTime.Format(time.RFC3339)
+	// trace ids and their _time values found in the current window.
+	var windowLock sync.Mutex
+	var windowTraceIDs, windowTimestamps []string
 
 	cp.Query = q
 	qctx := cp.NewQueryContext(ctx)
 	defer cp.UpdatePerQueryStatsMetrics()
 
 	writeBlock := func(_ uint, db *logstorage.DataBlock) {
-		columns := db.Columns
-		clonedColumnNames := make([]string, len(columns))
-		for i, c := range columns {
-			clonedColumnNames[i] = strings.Clone(c.Name)
-		}
-		for i := range clonedColumnNames {
-			switch clonedColumnNames[i] {
-			case "trace_id":
-				traceIDListLock.Lock()
-				for _, v := range columns[i].Values {
-					traceIDList = append(traceIDList, strings.Clone(v))
-				}
-				traceIDListLock.Unlock()
+		var traceIDs, timestamps []string
+		for _, c := range db.Columns {
+			switch c.Name {
+			case otelpb.TraceIDField:
+				traceIDs = c.Values
 			case "_time":
-				for _, v := range columns[i].Values {
-					if v < maxStartTimeStr {
-						maxStartTimeStr = strings.Clone(v)
-					}
-				}
+				timestamps = c.Values
 			}
 		}
+		if len(traceIDs) != len(timestamps) {
+			return
+		}
+
+		windowLock.Lock()
+		for i := range traceIDs {
+			windowTraceIDs = append(windowTraceIDs, strings.Clone(traceIDs[i]))
+			windowTimestamps = append(windowTimestamps, strings.Clone(timestamps[i]))
+		}
+		windowLock.Unlock()
 	}
 
-	for currentStartTime.After(startTime) {
-		qClone := q.CloneWithTimeFilter(currentTime.UnixNano(), currentStartTime.UnixNano(), endTime.UnixNano())
+	searchWindow := func(windowStart, windowEnd time.Time) ([]string, []string, error) {
+		windowTraceIDs, windowTimestamps = windowTraceIDs[:0], windowTimestamps[:0]
+		qClone := q.CloneWithTimeFilter(currentTime.UnixNano(), windowStart.UnixNano(), windowEnd.UnixNano())
 		qctx = qctx.WithQuery(qClone)
 		if err := vtstorage.RunQuery(qctx, writeBlock); err != nil {
-			return nil, time.Time{}, err
+			return nil, nil, err
 		}
+		return windowTraceIDs, windowTimestamps, nil
+	}
+	return scanTraceIDWindows(startTime, endTime, firstStep, tc, searchWindow)
+}
 
-		// found enough trace_id, return directly
-		if len(traceIDList) == limit {
-			maxStartTime, err := time.Parse(time.RFC3339, maxStartTimeStr)
-			if err != nil {
-				return nil, maxStartTime, err
-			}
-			return traceIDList, maxStartTime, nil
+// scanTraceIDWindows adds trace ids found by searchWindow on the [startTime, endTime] time range to tc.
+//
+// It calls searchWindow for disjoint time windows backwards from the endTime. The first window has firstStep duration,
+// while every next window is 5x bigger. The search stops when tc is full or when the startTime is reached.
+//
+// searchWindow must return trace ids with the _time of their last matching span on the [windowStart, windowEnd] time range,
+// sorted by _time in descending order.
+func scanTraceIDWindows(startTime, endTime time.Time, firstStep time.Duration, tc *traceIDCollector,
+	searchWindow func(windowStart, windowEnd time.Time) ([]string, []string, error)) error {
+	step := firstStep
+	windowEnd := endTime
+	for !tc.isFull() && !windowEnd.Before(startTime) {
+		windowStart := windowEnd.Add(-step)
+		if windowStart.Before(startTime) {
+			windowStart = startTime
+		}
+
+		traceIDs, timestamps, err := searchWindow(windowStart, windowEnd)
+		if err != nil {
+			return err
 		}
+		tc.windowsScanned++
+		tc.add(traceIDs, timestamps)
 
-		// not enough trace_id, clear the result, extend the time range and try again.
-		traceIDList = traceIDList[:0]
+		// The time filter includes both bounds, so the next window must end before the current window start.
+		windowEnd = windowStart.Add(-time.Nanosecond)
 		step *= 5
-		currentStartTime = currentStartTime.Add(-step)
 	}
+	return nil
+}
+
+// traceIDCollector collects up to limit distinct trace ids from time ranges, which are scanned from the most recent one.
+type traceIDCollector struct {
+	limit int
+
+	seen     map[string]struct{}
+	traceIDs []string
+
+	// lastTimestamp is the _time of the last matching span for the last collected trace.
+	lastTimestamp string
 
-	// one last try with input time range
-	if currentStartTime.Before(startTime) {
-		currentStartTime = startTime
+	// windowsScanned is the number of time windows scanned in the storage. See scanTraceIDWindows.
+	windowsScanned int
+}
+
+func newTraceIDCollector(limit int) *traceIDCollector {
+	return &traceIDCollector{
+		limit:    limit,
+		seen:     make(map[string]struct{}, limit),
+		traceIDs: make([]string, 0, limit),
 	}
+}
+
+func (tc *traceIDCollector) isFull() bool {
+	return len(tc.traceIDs) >= tc.limit
+}
 
-	qClone := q.CloneWithTimeFilter(currentTime.UnixNano(), currentStartTime.UnixNano(), endTime.UnixNano())
-	qctx = qctx.WithQuery(qClone)
-	if err := vtstorage.RunQuery(qctx, writeBlock); err != nil {
-		return nil, time.Time{}, err
+// add adds traceIDs with the given timestamps of their last matching spans to tc until tc is full.
+//
+// traceIDs must be sorted by timestamps in descending order and must be older than the previously added trace ids.
+func (tc *traceIDCollector) add(traceIDs, timestamps []string) {
+	for i, traceID := range traceIDs {
+		if tc.isFull() {
+			return
+		}
+		// Time ranges are scanned from the most recent one, so the trace has been already collected with its last matching span
+		// if it is seen again.
+		if _, ok := tc.seen[traceID]; ok {
+			continue
+		}
+		tc.seen[traceID] = struct{}{}
+		tc.traceIDs = append(tc.traceIDs, traceID)
+		tc.lastTimestamp = timestamps[i]
 	}
+}
 
-	maxStartTime, err := time.Parse(time.RFC3339, maxStartTimeStr)
+// getResult returns the collected trace ids and the _time of the last matching span for the last collected trace.
+//
+// The endTime is returned as the time if there are no collected trace ids.
+func (tc *traceIDCollector) getResult(endTime time.Time) ([]string, time.Time, error) {
+	if len(tc.traceIDs) == 0 {
+		return nil, endTime, nil
+	}
+	lastTimestamp, err := time.Parse(time.RFC3339, tc.lastTimestamp)
 	if err != nil {
-		return nil, maxStartTime, err
+		return nil, time.Time{}, fmt.Errorf("cannot parse _time=%q: %w", tc.lastTimestamp, err)
 	}
-
-	return checkTraceIDList(traceIDList), maxStartTime, nil
+	return checkTraceIDList(tc.traceIDs), lastTimestamp, nil
 }
 
-// findTraceIDTimeSplitTimeRange try to search from {trace_id_idx_stream="xx"} stream, which contains
-// the trace_id and start time of the root span. It returns the start time of the trace if found.
-// Otherwise, the root span may not reach VictoriaTraces, and zero time is returned.
-func findTraceIDTimeSplitTimeRange(ctx context.Context, q *logstorage.Query, cp *CommonParams) (time.Time, error) {
-	traceIDStartTimeInt := int64(0)
-	var missingTimeColumn atomic.Bool
+// findTraceTimestamp returns the timestamp of a span matching qStr from the most recent time range with matching spans.
+//
+// The time range from the oldest stored span till now is split into *traceSearchStep time ranges,
+// which are searched in parallel by up to *traceSearchConcurrency workers starting from the most recent one.
+// Once a matching span is found in some time range, the search in older time ranges is stopped, while the search
+// in more recent time ranges continues, so the result doesn't depend on the order of workers' execution.
+// Zero time is returned if there are no matching spans.
+//
+// isComplete is set to false if the oldest stored span couldn't be obtained from all the storage nodes,
+// so the search may miss older matching spans. See vtstorage.GetMinTimestamp.
+func findTraceTimestamp(ctx context.Context, cp *CommonParams, qStr string) (time.Time, bool, error) {
+	currentTime := time.Now()
+	q, err := logstorage.ParseQueryAtTimestamp(qStr+" | fields _time", currentTime.UnixNano())
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("cannot parse query [%s]: %s", qStr, err)
+	}
+	q.AddPipeOffsetLimit(0, 1)
+
+	minTimestamp, err := vtstorage.GetMinTimestamp(ctx)
+	isComplete := err == nil
+	timeRanges := getTraceSearchTimeRanges(minTimestamp, currentTime.UnixNano(), traceSearchStep.Nanoseconds())
+	hits := newTraceTimestampHits(len(timeRanges))
 
 	ctxWithCancel, cancel := context.WithCancel(ctx)
+	defer cancel()
 	cp.Query = q
-	qctx := cp.NewQueryContext(ctxWithCancel)
 	defer cp.UpdatePerQueryStatsMetrics()
 
-	writeBlock := func(_ uint, db *logstorage.DataBlock) {
-		if missingTimeColumn.Load() {
-			return
-		}
+	var missingTimeColumn atomic.Bool
 
-		columns := db.Columns
-		clonedColumnNames := make([]string, len(columns))
-		for i, c := range columns {
-			clonedColumnNames[i] = strings.Clone(c.Name)
-		}
+	var nextTimeRange atomic.Int64
+	workers := min(max(*traceSearchConcurrency, 1), len(timeRanges))
+	errs := make([]error, len(timeRanges))
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				n := int(nextTimeRange.Add(1) - 1)
+				if n >= len(timeRanges) || ctxWithCancel.Err() != nil {
+					return
+				}
+				if !hits.needSearch(n) {
+					// A matching span has been already found in a more recent time range. The remaining time ranges are older.
+					return
+				}
 
-		timestamps, ok := db.GetTimestamps(nil)
-		if !ok {
-			missingTimeColumn.Store(true)
-			cancel()
-			return
-		}
-		if len(timestamps) > 0 {
-			traceIDStartTimeInt = timestamps[0]
-		}
+				rangeCtx, rangeCancel := context.WithCancel(ctxWithCancel)
+				hits.setCancel(n, rangeCancel)
+				writeBlock := func(_ uint, db *logstorage.DataBlock) {
+					if missingTimeColumn.Load() {
+						return
+					}
+
+					timestamps, ok := db.GetTimestamps(nil)
+					if !ok {
+						missingTimeColumn.Store(true)
+						cancel()
+						return
+					}
+					if len(timestamps) > 0 {
+						// There is no need in searching older time ranges.
+						hits.add(n, slices.Max(timestamps))
+					}
+				}
+
+				tr := timeRanges[n]
+				qq := q.CloneWithTimeFilter(currentTime.UnixNano(), tr[0], tr[1])
+				qctx := cp.NewQueryContext(rangeCtx).WithQuery(qq)
+				err := vtstorage.RunQuery(qctx, writeBlock)
+				rangeCancel()
+				if err != nil && hits.needSearch(n) && !missingTimeColumn.Load() {
+					errs[n] = err
+					cancel()
+					return
+				}
+			}
+		}()
 	}
+	wg.Wait()
 
-	currentTime := time.Now()
-	startTime := currentTime.Add(-*traceSearchStep)
-	endTime := currentTime
-	for startTime.UnixNano() > 0 { // todo: no need to search time range before retention period.
-		qq := q.CloneWithTimeFilter(currentTime.UnixNano(), startTime.UnixNano(), endTime.UnixNano())
-		qctx = qctx.WithQuery(qq)
+	if missingTimeColumn.Load() {
+		return time.Time{}, false, fmt.Errorf("missing _time column in the result for the query [%s]", q)
+	}
+	// Errors in time ranges older than the found span do not affect the result.
+	timestamp, n, ok := hits.getNewest()
+	if err := errors.Join(errs[:n]...); err != nil {
+		return time.Time{}, false, err
+	}
+	if ok {
+		return time.Unix(0, timestamp), isComplete, nil
+	}
+	return time.Time{}, isComplete, nil
+}
 
-		if err := vtstorage.RunQuery(qctx, writeBlock); err != nil {
-			return time.Time{}, err
-		}
-		if missingTimeColumn.Load() {
-			return time.Time{}, fmt.Errorf("missing _time column in the result for the query [%s]", qq)
-		}
+// traceTimestampHits holds timestamps of matching spans found in time ranges ordered from the most recent one.
+type traceTimestampHits struct {
+	mu sync.Mutex
 
-		// no hit in this time range, continue with step.
-		if traceIDStartTimeInt == 0 {
-			endTime = startTime
-			startTime = startTime.Add(-*traceSearchStep)
-			continue
-		}
+	// newest is the index of the most recent time range with matching spans. It equals to len(timestamps) if there are no matching spans.
+	newest int
 
-		// found result, perform extra search for traceMaxDurationWindow and then break.
-		return time.Unix(traceIDStartTimeInt/1e9, traceIDStartTimeInt%1e9), nil
+	// timestamps contains the maximum timestamp of matching spans per every time range.
+	timestamps []int64
+
+	// cancels contains functions for canceling the search per every time range.
+	cancels []context.CancelFunc
+}
+
+func newTraceTimestampHits(timeRanges int) *traceTimestampHits {
+	return &traceTimestampHits{
+		newest:     timeRanges,
+		timestamps: make([]int64, timeRanges),
+		cancels:    make([]context.CancelFunc, timeRanges),
 	}
+}
 
-	return time.Time{}, nil
+// needSearch returns true if the time range n must be searched, e.g. there are no matching spans in more recent time ranges.
+func (h *traceTimestampHits) needSearch(n int) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return n <= h.newest
+}
+
+// setCancel sets the function for canceling the search in the time range n.
+func (h *traceTimestampHits) setCancel(n int, cancel context.CancelFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.cancels[n] = cancel
 }
 
-// findSpansByTraceID searches for spans from now to 0 time with steps.
-// In order to avoid scanning all data blocks, search is performed on time range splitting by traceSearchStep.
-// Once a trace is found, it assumes other spans will exist on the same time range, and only search this
-// time range (with traceMaxDurationWindow).
+// add registers the matching span with the given timestamp in the time range n.
 //
-// e.g.
-//  1. find traces span on [now-traceSearchStep, now], no hit.
-//  2. find traces span on [now-2 * traceSearchStep, now - traceSearchStep], hit.
-//  3. make sure to include all the spans by an additional search on: [now-2 * traceSearchStep-traceMaxDurationWindow, now-2 * traceSearchStep].
-//  4. skip [0,  now-2 * traceSearchStep-traceMaxDurationWindow] and return.
-func findSpansByTraceID(ctx context.Context, cp *CommonParams, traceID string) ([]*Row, error) {
-	// query: trace_id:traceID
-	currentTime := time.Now()
-	startTime := currentTime.Add(-*traceSearchStep)
-	endTime := currentTime
-	var (
-		rows []*Row
-		err  error
-	)
-	for startTime.UnixNano() > 0 { // todo: no need to search time range before retention period.
-		rows, err = findSpansByTraceIDAndTime(ctx, cp, traceID, startTime, endTime)
-		if err != nil {
-			return nil, err
-		}
-		// no hit in this time range, continue with step.
-		if len(rows) == 0 {
-			endTime = startTime
-			startTime = startTime.Add(-*traceSearchStep)
-			continue
+// The search in older time ranges is canceled.
+func (h *traceTimestampHits) add(n int, timestamp int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if n > h.newest {
+		return
+	}
+	if n == h.newest {
+		h.timestamps[n] = max(h.timestamps[n], timestamp)
+		return
+	}
+	h.newest = n
+	h.timestamps[n] = timestamp
+	for _, cancel := range h.cancels[n+1:] {
+		if cancel != nil {
+			cancel()
 		}
+	}
+}
 
-		// found result, perform extra search for traceMaxDurationWindow and then break.
-		extraRows, err := findSpansByTraceIDAndTime(ctx, cp, traceID, startTime.Add(-*traceMaxDurationWindow), startTime)
-		if err != nil {
-			return nil, err
-		}
-		rows = append(rows, extraRows...)
-		break
+// getNewest returns the timestamp of the matching span from the most recent time range with matching spans and the index of this time range.
+//
+// false is returned if there are no matching spans. The returned index equals to the number of time ranges in this case.
+func (h *traceTimestampHits) getNewest() (int64, int, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.newest >= len(h.timestamps) {
+		return 0, h.newest, false
 	}
-	return rows, nil
+	return h.timestamps[h.newest], h.newest, true
+}
+
+// getTraceSearchTimeRanges splits [minTimestamp, maxTimestamp] time range into [start, end] time ranges with the given step
+// starting from the maxTimestamp.
+func getTraceSearchTimeRanges(minTimestamp, maxTimestamp, step int64) [][2]int64 {
+	var timeRanges [][2]int64
+	for end := maxTimestamp; end >= minTimestamp; end -= step {
+		timeRanges = append(timeRanges, [2]int64{max(end-step, minTimestamp), end})
+	}
+	return timeRanges
 }
 
 // findSpansByTraceIDAndTime search for spans in given time range.
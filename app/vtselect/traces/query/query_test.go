@@ -1,7 +1,14 @@
 package query
 
 import (
+	"encoding/base64"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
 )
 
 func TestCheckTraceIDList(t *testing.T) {
@@ -21,3 +28,282 @@ func TestCheckTraceIDList(t *testing.T) {
 	f("abcd bcad", false)
 	f("abcd\"", false)
 }
+
+func TestGetAttributeFilter(t *testing.T) {
+	f := func(k, v, resultExpected string) {
+		t.Helper()
+
+		result := GetAttributeFilter(k, v)
+		if result != resultExpected {
+			t.Fatalf("unexpected filter for %s=%s; got %s; want %s", k, v, result, resultExpected)
+		}
+		if _, err := logstorage.ParseFilter(result); err != nil {
+			t.Fatalf("cannot parse filter %s: %s", result, err)
+		}
+	}
+
+	f("span_attr:http.method", "GET", `"span_attr:http.method":="GET"`)
+	f("span_attr:retry", "true", `"span_attr:retry":="true"`)
+	f("span_attr:nan", "NaN", `"span_attr:nan":="NaN"`)
+	f("span_attr:http.status_code", "200", `("span_attr:http.status_code":="200" OR "span_attr:http.status_code":range[200, 200])`)
+	f("span_attr:ratio", "1.50", `("span_attr:ratio":="1.50" OR "span_attr:ratio":range[1.5, 1.5])`)
+	f("span_attr:delta", "-2e3", `("span_attr:delta":="-2e3" OR "span_attr:delta":range[-2000, -2000])`)
+}
+
+func TestTraceCursor(t *testing.T) {
+	f := func(tc *TraceCursor, filterExpected string) {
+		t.Helper()
+
+		s := tc.String()
+		result, err := ParseTraceCursor(s)
+		if err != nil {
+			t.Fatalf("cannot parse cursor %q: %s", s, err)
+		}
+		if *result != *tc {
+			t.Fatalf("unexpected cursor parsed from %q; got %+v; want %+v", s, result, tc)
+		}
+
+		filter := tc.filter()
+		if filter != filterExpected {
+			t.Fatalf("unexpected filter; got %s; want %s", filter, filterExpected)
+		}
+		if _, err := logstorage.ParseFilter(filter); err != nil {
+			t.Fatalf("cannot parse filter %s: %s", filter, err)
+		}
+	}
+
+	f(&TraceCursor{
+		Timestamp: 1760000000123456789,
+		TraceID:   "9e06226196051d9c3c10dfab343791ad",
+	}, `_time:[1970-01-01T00:00:00Z, 2025-10-09T08:53:20.123456789Z) OR (_time:[2025-10-09T08:53:20.123456789Z, 2025-10-09T08:53:20.123456789Z] `+
+		`-trace_id:string_range("", "9e06226196051d9c3c10dfab343791ad") -trace_id:="9e06226196051d9c3c10dfab343791ad")`)
+	f(&TraceCursor{
+		Timestamp: 1760000000000000000,
+		TraceID:   "123",
+	}, `_time:[1970-01-01T00:00:00Z, 2025-10-09T08:53:20Z) OR (_time:[2025-10-09T08:53:20Z, 2025-10-09T08:53:20Z] -trace_id:string_range("", "123") -trace_id:="123")`)
+}
+
+func TestParseTraceCursorFailure(t *testing.T) {
+	f := func(s string) {
+		t.Helper()
+
+		if _, err := ParseTraceCursor(s); err == nil {
+			t.Fatalf("expecting non-nil error for cursor %q", s)
+		}
+	}
+
+	// invalid base64
+	f("!!!")
+
+	// missing trace_id
+	f(base64.RawURLEncoding.EncodeToString([]byte("123")))
+	f(base64.RawURLEncoding.EncodeToString([]byte("123:")))
+
+	// invalid timestamp
+	f(base64.RawURLEncoding.EncodeToString([]byte("foo:abc")))
+
+	// invalid trace_id
+	f(base64.RawURLEncoding.EncodeToString([]byte(`123:abc") OR *`)))
+}
+
+func TestGetTraceSearchTimeRanges(t *testing.T) {
+	f := func(minTimestamp, maxTimestamp, step int64, resultExpected [][2]int64) {
+		t.Helper()
+
+		result := getTraceSearchTimeRanges(minTimestamp, maxTimestamp, step)
+		if !reflect.DeepEqual(result, resultExpected) {
+			t.Fatalf("unexpected time ranges; got %v; want %v", result, resultExpected)
+		}
+	}
+
+	// a single time range
+	f(100, 100, 10, [][2]int64{{100, 100}})
+	f(95, 100, 10, [][2]int64{{95, 100}})
+
+	// multiple time ranges starting from the most recent one
+	f(75, 100, 10, [][2]int64{{90, 100}, {80, 90}, {75, 80}})
+	f(80, 100, 10, [][2]int64{{90, 100}, {80, 90}, {80, 80}})
+}
+
+func TestTraceTimestampHits(t *testing.T) {
+	h := newTraceTimestampHits(4)
+	if _, n, ok := h.getNewest(); ok || n != 4 {
+		t.Fatalf("unexpected hit without matching spans at time range #%d", n)
+	}
+
+	var canceled [4]bool
+	for i := range canceled {
+		h.setCancel(i, func() { canceled[i] = true })
+	}
+
+	// A hit in an older time range is replaced by a hit in a more recent time range regardless of the order of hits.
+	h.add(2, 20)
+	if !canceled[3] || canceled[1] {
+		t.Fatalf("unexpected canceled time ranges after the hit at time range #2: %v", canceled)
+	}
+	h.add(3, 30)
+	h.add(1, 10)
+	if !canceled[2] || canceled[0] {
+		t.Fatalf("unexpected canceled time ranges after the hit at time range #1: %v", canceled)
+	}
+	h.add(2, 25)
+	h.add(1, 15)
+	h.add(1, 5)
+
+	timestamp, n, ok := h.getNewest()
+	if !ok || n != 1 || timestamp != 15 {
+		t.Fatalf("unexpected newest hit; got timestamp=%d at time range #%d; want timestamp=15 at time range #1", timestamp, n)
+	}
+	if !h.needSearch(0) || !h.needSearch(1) || h.needSearch(2) {
+		t.Fatalf("only time ranges up to the newest hit must be searched")
+	}
+}
+
+func TestScanTraceIDWindows(t *testing.T) {
+	type span struct {
+		traceID   string
+		timestamp time.Time
+	}
+
+	now := time.Date(2025, 10, 10, 0, 0, 0, 0, time.UTC)
+
+	// search emulates the trace ids search over spans in the same way as getTraceIDList does.
+	search := func(spans []span, param *TraceQueryParam) ([]string, *TraceCursor, int) {
+		t.Helper()
+
+		endTime, firstStep := getTraceIDSearchWindow(param)
+		tc := newTraceIDCollector(param.Limit)
+		var prevWindowStart time.Time
+		searchWindow := func(windowStart, windowEnd time.Time) ([]string, []string, error) {
+			if !prevWindowStart.IsZero() && !windowEnd.Equal(prevWindowStart.Add(-time.Nanosecond)) {
+				t.Fatalf("the window [%s, %s] isn't adjacent to the previous window starting at %s", windowStart, windowEnd, prevWindowStart)
+			}
+			prevWindowStart = windowStart
+
+			// last 1 by (_time) partition by (trace_id)
+			lastTimestamps := make(map[string]time.Time)
+			for _, sp := range spans {
+				if sp.timestamp.Before(windowStart) || sp.timestamp.After(windowEnd) {
+					continue
+				}
+				if ts, ok := lastTimestamps[sp.traceID]; !ok || sp.timestamp.After(ts) {
+					lastTimestamps[sp.traceID] = sp.timestamp
+				}
+			}
+
+			var rows []span
+			for traceID, ts := range lastTimestamps {
+				if c := param.Cursor; c != nil {
+					cts := time.Unix(0, c.Timestamp)
+					if ts.After(cts) || ts.Equal(cts) && traceID <= c.TraceID {
+						continue
+					}
+				}
+				rows = append(rows, span{
+					traceID:   traceID,
+					timestamp: ts,
+				})
+			}
+			slices.SortFunc(rows, func(a, b span) int {
+				if n := b.timestamp.Compare(a.timestamp); n != 0 {
+					return n
+				}
+				return strings.Compare(a.traceID, b.traceID)
+			})
+
+			var traceIDs, timestamps []string
+			for _, row := range rows[:min(len(rows), param.Limit)] {
+				traceIDs = append(traceIDs, row.traceID)
+				timestamps = append(timestamps, row.timestamp.Format(time.RFC3339Nano))
+			}
+			return traceIDs, timestamps, nil
+		}
+		if err := scanTraceIDWindows(param.StartTimeMin, endTime, firstStep, tc, searchWindow); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		traceIDs, startTime, err := tc.getResult(endTime)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		var nextCursor *TraceCursor
+		if len(traceIDs) == param.Limit {
+			nextCursor = &TraceCursor{
+				Timestamp: startTime.UnixNano(),
+				TraceID:   traceIDs[len(traceIDs)-1],
+			}
+		}
+		return traceIDs, nextCursor, tc.windowsScanned
+	}
+
+	f := func(spans []span, startTime time.Time, limit int, traceIDsExpected []string, windowsScannedExpected int) {
+		t.Helper()
+
+		param := &TraceQueryParam{
+			StartTimeMin: startTime,
+			StartTimeMax: now,
+			Limit:        limit,
+		}
+		traceIDs, _, windowsScanned := search(spans, param)
+		if !reflect.DeepEqual(traceIDs, traceIDsExpected) {
+			t.Fatalf("unexpected trace ids; got %q; want %q", traceIDs, traceIDsExpected)
+		}
+		if windowsScanned != windowsScannedExpected {
+			t.Fatalf("unexpected number of scanned windows; got %d; want %d", windowsScanned, windowsScannedExpected)
+		}
+	}
+
+	// trace ids are accumulated across windows, which grow 5x: [-1m, 0], [-6m, -1m), [-31m, -6m)
+	f([]span{
+		{"a", now.Add(-30 * time.Second)},
+		{"b", now.Add(-3 * time.Minute)},
+		{"c", now.Add(-20 * time.Minute)},
+		{"d", now.Add(-2 * time.Hour)},
+	}, now.Add(-24*time.Hour), 3, []string{"a", "b", "c"}, 3)
+
+	// trace ids are deduplicated across windows
+	f([]span{
+		{"a", now.Add(-30 * time.Second)},
+		{"a", now.Add(-3 * time.Minute)},
+		{"b", now.Add(-4 * time.Minute)},
+		{"c", now.Add(-10 * time.Minute)},
+	}, now.Add(-24*time.Hour), 3, []string{"a", "b", "c"}, 3)
+
+	// the limit is reached in the middle of the window, so older windows aren't scanned
+	f([]span{
+		{"a", now.Add(-2 * time.Minute)},
+		{"b", now.Add(-3 * time.Minute)},
+		{"c", now.Add(-4 * time.Minute)},
+		{"d", now.Add(-10 * time.Minute)},
+	}, now.Add(-24*time.Hour), 2, []string{"a", "b"}, 2)
+
+	// the search stops at the start time: [-1m, 0], [-6m, -1m), [-10m, -6m)
+	f([]span{
+		{"a", now.Add(-30 * time.Second)},
+		{"b", now.Add(-8 * time.Minute)},
+		{"c", now.Add(-20 * time.Minute)},
+	}, now.Add(-10*time.Minute), 5, []string{"a", "b"}, 3)
+
+	// traces returned before the cursor aren't returned again, even if they have spans in older windows
+	spans := []span{
+		{"a", now.Add(-10 * time.Second)},
+		{"a", now.Add(-10*time.Second - *traceMaxDurationWindow + time.Second)},
+		{"b", now.Add(-20 * time.Second)},
+		{"c", now.Add(-time.Hour)},
+	}
+	param := &TraceQueryParam{
+		StartTimeMin: now.Add(-24 * time.Hour),
+		StartTimeMax: now.Add(10 * time.Minute),
+		Limit:        1,
+	}
+	traceIDs, nextCursor, _ := search(spans, param)
+	if !reflect.DeepEqual(traceIDs, []string{"a"}) || nextCursor == nil {
+		t.Fatalf("unexpected trace ids for the first page; got %q; want %q", traceIDs, []string{"a"})
+	}
+	param.Cursor = nextCursor
+	param.Limit = 3
+	traceIDs, _, _ = search(spans, param)
+	if !reflect.DeepEqual(traceIDs, []string{"b", "c"}) {
+		t.Fatalf("unexpected trace ids for the second page; got %q; want %q", traceIDs, []string{"b", "c"})
+	}
+}
@@ -0,0 +1,274 @@
+package query
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtstorage"
+	otelpb "github.com/VictoriaMetrics/VictoriaTraces/lib/protoparser/opentelemetry/pb"
+)
+
+var (
+	traceSearchCacheSize = flagutil.NewBytes("search.traceSearchCacheSize", 32*1024*1024, "The maximum size in bytes of the in-memory cache for trace search results. "+
+		"Set it to 0 for disabling the cache. See https://docs.victoriametrics.com/victoriatraces/querying/#search-result-cache")
+	traceSearchCacheBucket = flag.Duration("search.traceSearchCacheBucket", 10*time.Minute, "The duration of time buckets, which are used for caching trace search results. "+
+		"See https://docs.victoriametrics.com/victoriatraces/querying/#search-result-cache")
+	traceSearchCacheMinAge = flag.Duration("search.traceSearchCacheMinAge", 5*time.Minute, "Time buckets ending later than now-search.traceSearchCacheMinAge aren't cached, "+
+		"since they may receive late spans. See https://docs.victoriametrics.com/victoriatraces/querying/#search-result-cache")
+	traceSearchCacheTTL = flag.Duration("search.traceSearchCacheTTL", 2*time.Minute, "The duration for caching trace search results per time bucket. "+
+		"See https://docs.victoriametrics.com/victoriatraces/querying/#search-result-cache")
+)
+
+// tsCache contains trace ids found per time bucket for trace search filters.
+//
+// Dashboards and Grafana panels repeat the same trace search over the same time range on every refresh.
+// Spans for the past time buckets do not change, so only the most recent time range must be scanned again.
+//
+// All the entries are dropped when spans are deleted from the storage. See vtstorage.GetDataGeneration.
+// Every entry expires after -search.traceSearchCacheTTL, so spans ingested with delays bigger than -search.traceSearchCacheMinAge
+// appear in search results soon.
+var tsCache = newLRUCache("vt_traces_search_cache", traceSearchCacheSize)
+
+// traceSearchBucket contains trace ids found in a time bucket sorted by the _time of their last matching span in descending order.
+type traceSearchBucket struct {
+	traceIDs   []string
+	timestamps []string
+
+	// limit is the maximum number of trace ids, which were searched in the bucket.
+	limit int
+}
+
+// canServe returns true if b contains enough trace ids for the search with the given limit.
+func (b *traceSearchBucket) canServe(limit int) bool {
+	// The bucket contains all the matching trace ids if it contains less than b.limit trace ids.
+	return len(b.traceIDs) < b.limit || b.limit >= limit
+}
+
+func (b *traceSearchBucket) size() int {
+	// The overhead for the map entry, list element, lruEntry and traceSearchBucket.
+	n := 150
+	for i := range b.traceIDs {
+		n += len(b.traceIDs[i]) + len(b.timestamps[i]) + 32
+	}
+	return n
+}
+
+// getTraceSearchBucketKey returns the tsCache key for the time bucket with the given index k and duration.
+func getTraceSearchBucketKey(tenantIDs []logstorage.TenantID, filterStr string, bucket time.Duration, k int64) string {
+	return getCacheKey(tenantIDs, fmt.Sprintf("%d/%d/%s", bucket.Nanoseconds(), k, filterStr))
+}
+
+// getTraceSearchBuckets returns the range [kMin, kMax] of time buckets with the given duration,
+// which can be cached for the search on the [startTime, endTime] time range.
+//
+// Time buckets are aligned to the unix epoch. The bucket with index k covers [k*bucket, (k+1)*bucket) time range.
+// Only buckets fully covered by the [startTime, endTime] and ending before the freshTime can be cached.
+// kMin > kMax if there are no such buckets.
+func getTraceSearchBuckets(startTime, endTime, freshTime time.Time, bucket time.Duration) (int64, int64) {
+	b := bucket.Nanoseconds()
+	kMin := (startTime.UnixNano() + b - 1) / b
+	kMax := min(endTime.UnixNano()+1, freshTime.UnixNano())/b - 1
+	return kMin, kMax
+}
+
+// findTraceIDsWithCache searches for trace ids on the [startTime, endTime] time range starting from the endTime and adds them to tc.
+//
+// It works the same way as findTraceIDsSplitTimeRange, but trace ids for the past time buckets are taken from tsCache.
+// The most recent time range, which may receive new spans, is always scanned. See -search.traceSearchCacheMinAge.
+//
+// The query q must return _time and trace_id of the last matching span per every trace for the filterStr,
+// sorted by _time in descending order.
+func findTraceIDsWithCache(ctx context.Context, q *logstorage.Query, cp *CommonParams, filterStr string, startTime, endTime time.Time, tc *traceIDCollector) error {
+	bucket := *traceSearchCacheBucket
+	if bucket <= 0 {
+		return findTraceIDsSplitTimeRange(ctx, q, cp, startTime, endTime, time.Minute, tc)
+	}
+
+	// There is no need in caching empty buckets older than the oldest stored span.
+	// The time range cannot be limited if the oldest stored span is unknown because of unavailable storage nodes.
+	if minTimestamp, err := vtstorage.GetMinTimestamp(ctx); err == nil && startTime.Before(time.Unix(0, minTimestamp)) {
+		startTime = time.Unix(0, minTimestamp)
+	}
+	kMin, kMax := getTraceSearchBuckets(startTime, endTime, time.Now().Add(-*traceSearchCacheMinAge), bucket)
+	if kMin > kMax {
+		return findTraceIDsSplitTimeRange(ctx, q, cp, startTime, endTime, time.Minute, tc)
+	}
+
+	// Scan the most recent time range, which cannot be cached.
+	if err := findTraceIDsSplitTimeRange(ctx, q, cp, time.Unix(0, (kMax+1)*bucket.Nanoseconds()), endTime, time.Minute, tc); err != nil {
+		return err
+	}
+
+	// Scan the cacheable time buckets.
+	generation := vtstorage.GetDataGeneration(ctx)
+	if err := findTraceIDsInBuckets(ctx, cp, filterStr, bucket, kMin, kMax, generation, tc); err != nil {
+		return err
+	}
+
+	// Scan the oldest time range, which doesn't cover the whole bucket.
+	return findTraceIDsSplitTimeRange(ctx, q, cp, startTime, time.Unix(0, kMin*bucket.Nanoseconds()-1), time.Minute, tc)
+}
+
+// findTraceIDsInBuckets adds trace ids for the time buckets [kMin, kMax] to tc starting from the kMax bucket.
+//
+// The generation of the stored spans must be obtained via vtstorage.GetDataGeneration before the call.
+//
+// Trace ids for buckets missing in tsCache are searched with a single query per run of consecutive missing buckets.
+// The maximum run length starts from a single bucket and grows 5x after every query, so the search for rare spans
+// needs a few queries, while the search for frequent spans doesn't scan too many buckets.
+func findTraceIDsInBuckets(ctx context.Context, cp *CommonParams, filterStr string, bucket time.Duration, kMin, kMax int64, generation uint64, tc *traceIDCollector) error {
+	// cached contains tsCache lookup results per bucket, so every bucket is looked up only once.
+	cached := make(map[int64]*traceSearchBucket)
+	getBucket := func(k int64) *traceSearchBucket {
+		if b, ok := cached[k]; ok {
+			return b
+		}
+		var b *traceSearchBucket
+		if v, ok := tsCache.get(getTraceSearchBucketKey(cp.TenantIDs, filterStr, bucket, k), generation); ok {
+			if vb := v.(*traceSearchBucket); vb.canServe(tc.limit) {
+				b = vb
+			}
+		}
+		cached[k] = b
+		return b
+	}
+
+	runSize := int64(1)
+	k := kMax
+	for k >= kMin && !tc.isFull() {
+		if b := getBucket(k); b != nil {
+			tc.add(b.traceIDs, b.timestamps)
+			k--
+			continue
+		}
+
+		runStart := k
+		for runStart > kMin && k-runStart+1 < runSize && getBucket(runStart-1) == nil {
+			runStart--
+		}
+		buckets, err := searchTraceIDBuckets(ctx, cp, filterStr, bucket, runStart, k, tc.limit)
+		if err != nil {
+			return err
+		}
+		tc.windowsScanned++
+		for i := k; i >= runStart; i-- {
+			b := buckets[i]
+			if b == nil {
+				b = &traceSearchBucket{
+					limit: tc.limit,
+				}
+			}
+			key := getTraceSearchBucketKey(cp.TenantIDs, filterStr, bucket, i)
+			tsCache.set(key, b, len(key)+b.size(), *traceSearchCacheTTL, generation)
+			tc.add(b.traceIDs, b.timestamps)
+		}
+
+		runSize *= 5
+		k = runStart - 1
+	}
+	return nil
+}
+
+// searchTraceIDBuckets returns up to limit trace ids for every time bucket in the [kMin, kMax] range.
+//
+// Buckets without trace ids are missing in the returned map.
+func searchTraceIDBuckets(ctx context.Context, cp *CommonParams, filterStr string, bucket time.Duration, kMin, kMax int64, limit int) (map[int64]*traceSearchBucket, error) {
+	currentTime := time.Now()
+
+	// query: * AND <filter> | stats by (_time:<bucket>, trace_id) max(_time) as last_time | sort by (last_time desc, trace_id) partition by (_time) limit <limit>
+	qStr := fmt.Sprintf("%s | stats by (_time:%s, %s) max(_time) as last_time | sort by (last_time desc, %s) partition by (_time) limit %d",
+		filterStr, bucket, otelpb.TraceIDField, otelpb.TraceIDField, limit)
+	q, err := logstorage.ParseQueryAtTimestamp(qStr, currentTime.UnixNano())
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse query [%s]: %s", qStr, err)
+	}
+	q.AddTimeFilter(kMin*bucket.Nanoseconds(), (kMax+1)*bucket.Nanoseconds()-1)
+
+	cp.Query = q
+	qctx := cp.NewQueryContext(ctx)
+	defer cp.UpdatePerQueryStatsMetrics()
+
+	var rowsLock sync.Mutex
+	var bucketTimes, traceIDs, lastTimes []string
+	writeBlock := func(_ uint, db *logstorage.DataBlock) {
+		var bs, ids, ts []string
+		for _, c := range db.Columns {
+			switch c.Name {
+			case "_time":
+				bs = c.Values
+			case otelpb.TraceIDField:
+				ids = c.Values
+			case "last_time":
+				ts = c.Values
+			}
+		}
+		if len(bs) != len(ids) || len(ids) != len(ts) {
+			return
+		}
+
+		rowsLock.Lock()
+		for i := range ids {
+			bucketTimes = append(bucketTimes, strings.Clone(bs[i]))
+			traceIDs = append(traceIDs, strings.Clone(ids[i]))
+			lastTimes = append(lastTimes, strings.Clone(ts[i]))
+		}
+		rowsLock.Unlock()
+	}
+	if err := vtstorage.RunQuery(qctx, writeBlock); err != nil {
+		return nil, err
+	}
+
+	type traceIDRow struct {
+		traceID       string
+		lastTime      string
+		lastTimestamp int64
+	}
+	rowsByBucket := make(map[int64][]traceIDRow)
+	for i := range traceIDs {
+		bt, err := time.Parse(time.RFC3339, bucketTimes[i])
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse _time=%q: %w", bucketTimes[i], err)
+		}
+		lt, err := time.Parse(time.RFC3339, lastTimes[i])
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse last_time=%q: %w", lastTimes[i], err)
+		}
+		k := bt.UnixNano() / bucket.Nanoseconds()
+		rowsByBucket[k] = append(rowsByBucket[k], traceIDRow{
+			traceID:       traceIDs[i],
+			lastTime:      lastTimes[i],
+			lastTimestamp: lt.UnixNano(),
+		})
+	}
+
+	// Rows may be returned in arbitrary order, so sort them in the same way as findTraceIDsSplitTimeRange does.
+	buckets := make(map[int64]*traceSearchBucket, len(rowsByBucket))
+	for k, rows := range rowsByBucket {
+		slices.SortFunc(rows, func(a, b traceIDRow) int {
+			if a.lastTimestamp != b.lastTimestamp {
+				if a.lastTimestamp > b.lastTimestamp {
+					return -1
+				}
+				return 1
+			}
+			return strings.Compare(a.traceID, b.traceID)
+		})
+		b := &traceSearchBucket{
+			limit: limit,
+		}
+		for _, row := range rows {
+			b.traceIDs = append(b.traceIDs, row.traceID)
+			b.timestamps = append(b.timestamps, row.lastTime)
+		}
+		buckets[k] = b
+	}
+	return buckets, nil
+}
@@ -0,0 +1,63 @@
+package query
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetTraceSearchBuckets(t *testing.T) {
+	f := func(startTime, endTime, freshTime int64, kMinExpected, kMaxExpected int64) {
+		t.Helper()
+
+		kMin, kMax := getTraceSearchBuckets(time.Unix(startTime, 0), time.Unix(endTime, 0), time.Unix(freshTime, 0), 10*time.Second)
+		if kMin != kMinExpected || kMax != kMaxExpected {
+			t.Fatalf("unexpected buckets; got [%d, %d]; want [%d, %d]", kMin, kMax, kMinExpected, kMaxExpected)
+		}
+	}
+
+	// aligned time range
+	f(100, 200, 1000, 10, 19)
+
+	// unaligned time range
+	f(105, 195, 1000, 11, 18)
+
+	// the end of the bucket is included into the time range
+	kMin, kMax := getTraceSearchBuckets(time.Unix(100, 0), time.Unix(200, 0).Add(-time.Nanosecond), time.Unix(1000, 0), 10*time.Second)
+	if kMin != 10 || kMax != 19 {
+		t.Fatalf("unexpected buckets; got [%d, %d]; want [%d, %d]", kMin, kMax, 10, 19)
+	}
+
+	// recent buckets aren't cached
+	f(100, 200, 155, 10, 14)
+
+	// no buckets
+	f(105, 114, 1000, 11, 10)
+	f(100, 200, 100, 10, 9)
+}
+
+func TestTraceSearchBucketCanServe(t *testing.T) {
+	f := func(traceIDs []string, bucketLimit, limit int, resultExpected bool) {
+		t.Helper()
+
+		b := &traceSearchBucket{
+			traceIDs:   traceIDs,
+			timestamps: make([]string, len(traceIDs)),
+			limit:      bucketLimit,
+		}
+		if result := b.canServe(limit); result != resultExpected {
+			t.Fatalf("unexpected result for %d trace ids searched with limit=%d and requested limit=%d; got %v; want %v",
+				len(traceIDs), bucketLimit, limit, result, resultExpected)
+		}
+	}
+
+	// the bucket contains all the trace ids
+	f(nil, 2, 20, true)
+	f([]string{"a"}, 2, 20, true)
+
+	// the bucket has been searched with bigger limit
+	f([]string{"a", "b"}, 2, 2, true)
+	f([]string{"a", "b"}, 2, 1, true)
+
+	// the bucket may miss trace ids
+	f([]string{"a", "b"}, 2, 3, false)
+}
@@ -0,0 +1,186 @@
+package spans
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtselect/traces/otlp"
+	otelpb "github.com/VictoriaMetrics/VictoriaTraces/lib/protoparser/opentelemetry/pb"
+)
+
+// spanKindNames maps OTLP span kinds to names used in /select/traces/spans responses and in the `kind` query arg.
+var spanKindNames = map[otelpb.SpanKind]string{
+	0: "unspecified",
+	1: "internal",
+	2: "server",
+	3: "client",
+	4: "producer",
+	5: "consumer",
+}
+
+// statusCodeNames maps OTLP status codes to names used in /select/traces/spans responses and in the `status` query arg.
+var statusCodeNames = map[otelpb.StatusCode]string{
+	0: "unset",
+	1: "ok",
+	2: "error",
+}
+
+// span is the span returned by /select/traces/spans.
+//
+// Attributes are returned with their original types, while attribute names are returned without the prefixes
+// used for storing them in VictoriaTraces.
+type span struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	TraceState        string         `json:"traceState,omitempty"`
+	Service           string         `json:"service"`
+	Name              string         `json:"name"`
+	Kind              string         `json:"kind"`
+	StartTimeUnixNano uint64         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   uint64         `json:"endTimeUnixNano"`
+	DurationNanos     uint64         `json:"durationNanos"`
+	Status            status         `json:"status"`
+	Attributes        map[string]any `json:"attributes,omitempty"`
+	Resource          map[string]any `json:"resource,omitempty"`
+	Scope             *scope         `json:"scope,omitempty"`
+	Events            []*event       `json:"events,omitempty"`
+	Links             []*link        `json:"links,omitempty"`
+	Region            string         `json:"region,omitempty"`
+}
+
+type status struct {
+	Code    string `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type scope struct {
+	Name       string         `json:"name,omitempty"`
+	Version    string         `json:"version,omitempty"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+}
+
+type event struct {
+	TimeUnixNano uint64         `json:"timeUnixNano"`
+	Name         string         `json:"name"`
+	Attributes   map[string]any `json:"attributes,omitempty"`
+}
+
+type link struct {
+	TraceID    string         `json:"traceId"`
+	SpanID     string         `json:"spanId"`
+	TraceState string         `json:"traceState,omitempty"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+}
+
+// newSpan returns span for the given stored span fields.
+func newSpan(fields []logstorage.Field) (*span, error) {
+	sp, resource, sc, err := otlp.ParseSpan(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &span{
+		TraceID:           sp.TraceID,
+		SpanID:            sp.SpanID,
+		ParentSpanID:      sp.ParentSpanID,
+		TraceState:        sp.TraceState,
+		Name:              sp.Name,
+		Kind:              spanKindNames[sp.Kind],
+		StartTimeUnixNano: sp.StartTimeUnixNano,
+		EndTimeUnixNano:   sp.EndTimeUnixNano,
+		Status: status{
+			Code:    statusCodeNames[sp.Status.Code],
+			Message: sp.Status.Message,
+		},
+		Attributes: attributesToMap(sp.Attributes),
+		Resource:   attributesToMap(resource.Attributes),
+	}
+	if sp.EndTimeUnixNano > sp.StartTimeUnixNano {
+		s.DurationNanos = sp.EndTimeUnixNano - sp.StartTimeUnixNano
+	}
+	if serviceName, ok := s.Resource["service.name"].(string); ok {
+		s.Service = serviceName
+	}
+	if sc.Name != "" || sc.Version != "" || len(sc.Attributes) > 0 {
+		s.Scope = &scope{
+			Name:       sc.Name,
+			Version:    sc.Version,
+			Attributes: attributesToMap(sc.Attributes),
+		}
+	}
+	for _, e := range sp.Events {
+		s.Events = append(s.Events, &event{
+			TimeUnixNano: e.TimeUnixNano,
+			Name:         e.Name,
+			Attributes:   attributesToMap(e.Attributes),
+		})
+	}
+	for _, l := range sp.Links {
+		s.Links = append(s.Links, &link{
+			TraceID:    l.TraceID,
+			SpanID:     l.SpanID,
+			TraceState: l.TraceState,
+			Attributes: attributesToMap(l.Attributes),
+		})
+	}
+	for _, f := range fields {
+		if f.Name == otelpb.RegionField {
+			s.Region = f.Value
+			break
+		}
+	}
+	return s, nil
+}
+
+// attributesToMap converts kvs to the map from attribute names to attribute values with their original types.
+func attributesToMap(kvs []*otelpb.KeyValue) map[string]any {
+	if len(kvs) == 0 {
+		return nil
+	}
+	m := make(map[string]any, len(kvs))
+	for _, kv := range kvs {
+		m[kv.Key] = anyValueToJSON(kv.Value)
+	}
+	return m
+}
+
+// anyValueToJSON converts av to the value, which can be marshaled to JSON.
+func anyValueToJSON(av *otelpb.AnyValue) any {
+	switch {
+	case av == nil:
+		return nil
+	case av.StringValue != nil:
+		return *av.StringValue
+	case av.BoolValue != nil:
+		return *av.BoolValue
+	case av.IntValue != nil:
+		return *av.IntValue
+	case av.DoubleValue != nil:
+		f := *av.DoubleValue
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			// NaN and Inf cannot be represented as JSON numbers.
+			return strconv.FormatFloat(f, 'f', -1, 64)
+		}
+		return f
+	case av.BytesValue != nil:
+		// []byte is marshaled to base64-encoded string.
+		return *av.BytesValue
+	case av.ArrayValue != nil:
+		a := make([]any, 0, len(av.ArrayValue.Values))
+		for _, v := range av.ArrayValue.Values {
+			a = append(a, anyValueToJSON(v))
+		}
+		return a
+	case av.KeyValueList != nil:
+		m := make(map[string]any, len(av.KeyValueList.Values))
+		for _, kv := range av.KeyValueList.Values {
+			m[kv.Key] = anyValueToJSON(kv.Value)
+		}
+		return m
+	default:
+		return nil
+	}
+}
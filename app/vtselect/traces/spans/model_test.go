@@ -0,0 +1,64 @@
+package spans
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+
+	otelpb "github.com/VictoriaMetrics/VictoriaTraces/lib/protoparser/opentelemetry/pb"
+)
+
+func TestNewSpan(t *testing.T) {
+	f := func(fields []logstorage.Field, resultExpected string) {
+		t.Helper()
+
+		s, err := newSpan(fields)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		data, err := json.Marshal(s)
+		if err != nil {
+			t.Fatalf("cannot marshal span: %s", err)
+		}
+		if string(data) != resultExpected {
+			t.Fatalf("unexpected span\ngot\n%s\nwant\n%s", data, resultExpected)
+		}
+	}
+
+	// basic fields
+	f([]logstorage.Field{
+		{Name: otelpb.TraceIDField, Value: "1234"},
+		{Name: otelpb.SpanIDField, Value: "12"},
+		{Name: otelpb.NameField, Value: "foo"},
+	}, `{"traceId":"1234","spanId":"12","service":"","name":"foo","kind":"unspecified","startTimeUnixNano":0,"endTimeUnixNano":0,"durationNanos":0,"status":{"code":"unset"}}`)
+
+	// typed attributes, events and links
+	f([]logstorage.Field{
+		{Name: otelpb.ResourceAttrServiceName, Value: "db"},
+		{Name: otelpb.ResourceAttrPrefix + "host.cpus", Value: "4"},
+		{Name: otelpb.ResourceAttrTypesField, Value: `{"service.name":"s","host.cpus":"i"}`},
+		{Name: otelpb.InstrumentationScopeName, Value: "lib"},
+		{Name: otelpb.TraceIDField, Value: "1234"},
+		{Name: otelpb.SpanIDField, Value: "12"},
+		{Name: otelpb.ParentSpanIDField, Value: "11"},
+		{Name: otelpb.NameField, Value: "SELECT"},
+		{Name: otelpb.KindField, Value: "3"},
+		{Name: otelpb.StartTimeUnixNanoField, Value: "1000"},
+		{Name: otelpb.EndTimeUnixNanoField, Value: "3000"},
+		{Name: otelpb.StatusCodeField, Value: "2"},
+		{Name: otelpb.StatusMessageField, Value: "timeout"},
+		{Name: otelpb.SpanAttrPrefixField + "db.statement", Value: "SELECT 1"},
+		{Name: otelpb.SpanAttrPrefixField + "retry", Value: "true"},
+		{Name: otelpb.SpanAttrPrefixField + "ratio", Value: "NaN"},
+		{Name: otelpb.SpanAttrTypesField, Value: `{"db.statement":"s","retry":"b","ratio":"d"}`},
+		{Name: otelpb.EventPrefix + otelpb.EventTimeUnixNanoField + ":0", Value: "2000"},
+		{Name: otelpb.EventPrefix + otelpb.EventNameField + ":0", Value: "exception"},
+		{Name: otelpb.LinkPrefix + otelpb.LinkTraceIDField + ":0", Value: "5678"},
+		{Name: otelpb.LinkPrefix + otelpb.LinkSpanIDField + ":0", Value: "56"},
+		{Name: otelpb.RegionField, Value: "eu"},
+	}, `{"traceId":"1234","spanId":"12","parentSpanId":"11","service":"db","name":"SELECT","kind":"client",`+
+		`"startTimeUnixNano":1000,"endTimeUnixNano":3000,"durationNanos":2000,"status":{"code":"error","message":"timeout"},`+
+		`"attributes":{"db.statement":"SELECT 1","ratio":"NaN","retry":true},"resource":{"host.cpus":4,"service.name":"db"},`+
+		`"scope":{"name":"lib"},"events":[{"timeUnixNano":2000,"name":"exception"}],"links":[{"traceId":"5678","spanId":"56"}],"region":"eu"}`)
+}
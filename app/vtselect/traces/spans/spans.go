@@ -0,0 +1,417 @@
+package spans
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httputil"
+
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtselect/traces/query"
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtstorage"
+	otelpb "github.com/VictoriaMetrics/VictoriaTraces/lib/protoparser/opentelemetry/pb"
+)
+
+const (
+	// defaultSpansLimit is the default number of spans returned by /select/traces/spans.
+	defaultSpansLimit = 100
+
+	// maxSpansLimit is the maximum number of spans returned by /select/traces/spans.
+	maxSpansLimit = 10_000
+)
+
+// sortFields maps the values of `sort_by` query arg to the fields used for sorting the spans.
+var sortFields = map[string]string{
+	"start_time": otelpb.StartTimeUnixNanoField,
+	"duration":   otelpb.DurationField,
+}
+
+// attrOps contains the supported operators for `attr` conditions.
+//
+// Longer operators must go first, so `=~` isn't mistaken for `=`.
+var attrOps = []string{"!=", "!~", "=~", ">=", "<=", "=", ">", "<"}
+
+// ProcessSpansRequest handles /select/traces/spans request.
+//
+// It returns spans matching the given filters on the given time range sorted by start time or by duration.
+// The next page of spans can be obtained by passing the returned nextCursor to the `cursor` query arg.
+//
+// See https://docs.victoriametrics.com/victoriatraces/querying/#span-search-api
+func ProcessSpansRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	cp, err := query.GetCommonParams(r)
+	if err != nil {
+		httpserver.Errorf(w, r, "incorrect query params: %s", err)
+		return
+	}
+
+	endMsecs, err := httputil.GetTime(r, "end", time.Now().UnixMilli())
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+	startMsecs, err := httputil.GetTime(r, "start", 0)
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+	if startMsecs >= endMsecs {
+		httpserver.Errorf(w, r, "start=%d must be smaller than end=%d", startMsecs, endMsecs)
+		return
+	}
+
+	filter, err := getSpansFilter(r)
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+
+	sortBy := r.FormValue("sort_by")
+	if sortBy == "" {
+		sortBy = "start_time"
+	}
+	sortField, ok := sortFields[sortBy]
+	if !ok {
+		httpserver.Errorf(w, r, "unsupported sort_by=%q; supported values: start_time, duration", sortBy)
+		return
+	}
+	var desc bool
+	switch order := r.FormValue("order"); order {
+	case "", "desc":
+		desc = true
+	case "asc":
+		desc = false
+	default:
+		httpserver.Errorf(w, r, "unsupported order=%q; supported values: asc, desc", order)
+		return
+	}
+
+	limit := defaultSpansLimit
+	if r.FormValue("limit") != "" {
+		limit, err = httputil.GetInt(r, "limit")
+		if err != nil {
+			httpserver.Errorf(w, r, "%s", err)
+			return
+		}
+		if limit <= 0 || limit > maxSpansLimit {
+			httpserver.Errorf(w, r, "limit must be in the range [1, %d]; got %d", maxSpansLimit, limit)
+			return
+		}
+	}
+
+	var c *cursor
+	if s := r.FormValue("cursor"); s != "" {
+		c, err = parseCursor(s)
+		if err != nil {
+			httpserver.Errorf(w, r, "%s", err)
+			return
+		}
+	}
+
+	qStr := getSpansQuery(filter, startMsecs*1e6, endMsecs*1e6, sortField, desc, c, limit)
+	rows, err := getRows(ctx, cp, qStr)
+	if err != nil {
+		httpserver.Errorf(w, r, "cannot search spans: %s", err)
+		return
+	}
+
+	resp := &response{
+		Spans: make([]*span, 0, len(rows)),
+	}
+	values := make([]int64, 0, len(rows))
+	for _, fields := range rows {
+		s, err := newSpan(fields)
+		if err != nil {
+			httpserver.Errorf(w, r, "cannot convert span: %s", err)
+			return
+		}
+		resp.Spans = append(resp.Spans, s)
+		values = append(values, getSortValue(fields, sortField))
+	}
+	if len(rows) == limit {
+		// There may be more spans, so return the cursor for the next page.
+		resp.NextCursor = c.next(values).String()
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		httpserver.Errorf(w, r, "cannot marshal response: %s", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+// response is the response of /select/traces/spans.
+type response struct {
+	Spans      []*span `json:"spans"`
+	NextCursor string  `json:"nextCursor,omitempty"`
+}
+
+// getSpansFilter returns LogsQL filter for the structured filters and `query` arg from r.
+func getSpansFilter(r *http.Request) (string, error) {
+	if err := r.ParseForm(); err != nil {
+		return "", fmt.Errorf("cannot parse request args: %w", err)
+	}
+
+	var filters []string
+	if serviceName := r.FormValue("service"); serviceName != "" {
+		filters = append(filters, query.GetServiceNameFilter(serviceName))
+	}
+	if spanName := r.FormValue("name"); spanName != "" {
+		filters = append(filters, query.GetSpanNameFilter(spanName))
+	}
+	if kindName := r.FormValue("kind"); kindName != "" {
+		kind, ok := getSpanKind(kindName)
+		if !ok {
+			return "", fmt.Errorf("unsupported kind=%q; supported values: unspecified, internal, server, client, producer, consumer", kindName)
+		}
+		filters = append(filters, fmt.Sprintf("%s:=%d", otelpb.KindField, kind))
+	}
+	if statusName := r.FormValue("status"); statusName != "" {
+		code, ok := getStatusCode(statusName)
+		if !ok {
+			return "", fmt.Errorf("unsupported status=%q; supported values: unset, ok, error", statusName)
+		}
+		filters = append(filters, fmt.Sprintf("%s:=%d", otelpb.StatusCodeField, code))
+	}
+	for _, arg := range []string{"min_duration", "max_duration"} {
+		s := r.FormValue(arg)
+		if s == "" {
+			continue
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return "", fmt.Errorf("cannot parse %s=%q: %w", arg, s, err)
+		}
+		op := ">="
+		if arg == "max_duration" {
+			op = "<="
+		}
+		filters = append(filters, fmt.Sprintf("%s:%s%d", otelpb.DurationField, op, d.Nanoseconds()))
+	}
+	for _, cond := range r.Form["attr"] {
+		f, err := getAttrFilter(cond)
+		if err != nil {
+			return "", err
+		}
+		filters = append(filters, f)
+	}
+	if q := r.FormValue("query"); q != "" {
+		filters = append(filters, "("+q+")")
+	}
+
+	if len(filters) == 0 {
+		return "*", nil
+	}
+	filter := strings.Join(filters, " AND ")
+	if _, err := logstorage.ParseFilter(filter); err != nil {
+		return "", fmt.Errorf("cannot parse filter [%s]: %w", filter, err)
+	}
+	return filter, nil
+}
+
+func getSpanKind(name string) (otelpb.SpanKind, bool) {
+	for kind, kindName := range spanKindNames {
+		if kindName == name {
+			return kind, true
+		}
+	}
+	return 0, false
+}
+
+func getStatusCode(name string) (otelpb.StatusCode, bool) {
+	for code, codeName := range statusCodeNames {
+		if codeName == name {
+			return code, true
+		}
+	}
+	return 0, false
+}
+
+// getAttrFilter returns LogsQL filter for the attribute condition such as `http.status_code>=500` or `db.statement=~SELECT.*`.
+//
+// The attribute name is given without prefixes, so the condition matches both span and resource attributes with this name.
+func getAttrFilter(cond string) (string, error) {
+	n := strings.IndexAny(cond, "=!<>")
+	if n <= 0 {
+		return "", fmt.Errorf("cannot parse attr=%q; it must have the form <name><op><value>, where <op> is one of %s", cond, strings.Join(attrOps, ", "))
+	}
+	name, rest := cond[:n], cond[n:]
+	for _, op := range attrOps {
+		value, ok := strings.CutPrefix(rest, op)
+		if !ok {
+			continue
+		}
+		if op == ">" || op == ">=" || op == "<" || op == "<=" {
+			if _, err := strconv.ParseFloat(value, 64); err != nil {
+				return "", fmt.Errorf("cannot parse attr=%q; the value for %s must be a number", cond, op)
+			}
+		}
+
+		var filters []string
+		for _, prefix := range []string{otelpb.SpanAttrPrefixField, otelpb.ResourceAttrPrefix} {
+			fieldName := prefix + name
+			switch op {
+			case "=", "!=":
+				filters = append(filters, query.GetAttributeFilter(fieldName, value))
+			case "=~", "!~":
+				filters = append(filters, fmt.Sprintf("%q:~%q", fieldName, value))
+			default:
+				filters = append(filters, fmt.Sprintf("%q:%s%s", fieldName, op, value))
+			}
+		}
+		f := "(" + strings.Join(filters, " OR ") + ")"
+		if op == "!=" || op == "!~" {
+			f = "-" + f
+		}
+		return f, nil
+	}
+	return "", fmt.Errorf("cannot parse attr=%q; unsupported operator; supported operators: %s", cond, strings.Join(attrOps, ", "))
+}
+
+// getSpansQuery returns LogsQL query for the page of spans matching the filter on the time range [start, end) starting from c.
+func getSpansQuery(filter string, start, end int64, sortField string, desc bool, c *cursor, limit int) string {
+	qStr := fmt.Sprintf("_time:[%s, %s) (%s) -%s:*", formatTimestamp(start), formatTimestamp(end), filter, otelpb.TraceIDIndexFieldName)
+	offset := 0
+	if c != nil {
+		qStr += " " + c.filter(sortField, desc)
+		offset = c.offset
+	}
+	order := ""
+	if desc {
+		order = " desc"
+	}
+	// Spans are sorted by trace_id and span_id in addition to the sort field, so the order is stable for spans with identical values.
+	// Spans with the cursor value, which were already returned, are skipped with the offset.
+	return fmt.Sprintf("%s | sort by (%s%s, %s, %s) offset %d limit %d", qStr, sortField, order, otelpb.TraceIDField, otelpb.SpanIDField, offset, limit)
+}
+
+// getRows returns fields for spans returned by the given LogsQL query.
+func getRows(ctx context.Context, cp *query.CommonParams, qStr string) ([][]logstorage.Field, error) {
+	q, err := logstorage.ParseQueryAtTimestamp(qStr, time.Now().UnixNano())
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse query [%s]: %w", qStr, err)
+	}
+
+	var qs logstorage.QueryStats
+	qctx := logstorage.NewQueryContext(ctx, &qs, cp.TenantIDs, q)
+	defer vtstorage.UpdatePerQueryStatsMetrics(&qs)
+
+	var rowsLock sync.Mutex
+	var rows [][]logstorage.Field
+	writeBlock := func(_ uint, db *logstorage.DataBlock) {
+		rowsLock.Lock()
+		defer rowsLock.Unlock()
+
+		for i := 0; i < db.RowsCount(); i++ {
+			fields := make([]logstorage.Field, 0, len(db.Columns))
+			for _, c := range db.Columns {
+				// Skip empty values, since they belong to fields missing in the span.
+				if c.Values[i] != "" {
+					fields = append(fields, logstorage.Field{
+						Name:  strings.Clone(c.Name),
+						Value: strings.Clone(c.Values[i]),
+					})
+				}
+			}
+			rows = append(rows, fields)
+		}
+	}
+	if err := vtstorage.RunQuery(qctx, writeBlock); err != nil {
+		return nil, fmt.Errorf("cannot execute query [%s]: %w", qStr, err)
+	}
+	return rows, nil
+}
+
+func getSortValue(fields []logstorage.Field, sortField string) int64 {
+	for _, f := range fields {
+		if f.Name == sortField {
+			n, _ := strconv.ParseInt(f.Value, 10, 64)
+			return n
+		}
+	}
+	return 0
+}
+
+// cursor is the position of /select/traces/spans request.
+//
+// The next page starts from spans with the value of the sort field, while the first offset spans with this value are skipped,
+// since they were already returned.
+type cursor struct {
+	// value is the value of the sort field for the last returned span.
+	value int64
+
+	// offset is the number of already returned spans with the value.
+	offset int
+}
+
+// parseCursor parses the cursor returned by cursor.String.
+func parseCursor(s string) (*cursor, error) {
+	valueStr, offsetStr, ok := strings.Cut(s, "-")
+	if !ok {
+		return nil, fmt.Errorf("cannot parse cursor %q; it must have the form <value>-<offset>", s)
+	}
+	value, err := strconv.ParseInt(valueStr, 10, 64)
+	if err != nil || value < 0 {
+		return nil, fmt.Errorf("cannot parse value in the cursor %q", s)
+	}
+	offset, err := strconv.Atoi(offsetStr)
+	if err != nil || offset < 0 {
+		return nil, fmt.Errorf("cannot parse offset in the cursor %q", s)
+	}
+	return &cursor{
+		value:  value,
+		offset: offset,
+	}, nil
+}
+
+// String returns the string representation of c, which can be parsed by parseCursor.
+func (c *cursor) String() string {
+	return fmt.Sprintf("%d-%d", c.value, c.offset)
+}
+
+// next returns the cursor for the page following the page with sort field values starting from c.
+//
+// c may be nil for the first page.
+func (c *cursor) next(values []int64) *cursor {
+	value := values[len(values)-1]
+	nc := &cursor{
+		value: value,
+	}
+	if c != nil && c.value == value {
+		nc.offset = c.offset
+	}
+	for _, v := range values {
+		if v == value {
+			nc.offset++
+		}
+	}
+	return nc
+}
+
+// filter returns LogsQL filter for spans starting from c in the given sort order.
+func (c *cursor) filter(sortField string, desc bool) string {
+	if sortField == otelpb.StartTimeUnixNanoField {
+		// Start timestamps in nanoseconds cannot be compared precisely with numeric range filters, since they are converted to float64.
+		// So they are compared as strings, which is correct for timestamps with the same number of digits, e.g. after the year 2001.
+		if desc {
+			return fmt.Sprintf("%s:string_range(%q, %q)", sortField, "", strconv.FormatInt(c.value+1, 10))
+		}
+		return fmt.Sprintf("%s:string_range(%q, %q)", sortField, strconv.FormatInt(c.value, 10), "99999999999999999999")
+	}
+	if desc {
+		return fmt.Sprintf("%s:<=%d", sortField, c.value)
+	}
+	return fmt.Sprintf("%s:>=%d", sortField, c.value)
+}
+
+func formatTimestamp(nsecs int64) string {
+	return time.Unix(0, nsecs).UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,176 @@
+package spans
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+func TestGetAttrFilterSuccess(t *testing.T) {
+	f := func(cond, resultExpected string) {
+		t.Helper()
+
+		result, err := getAttrFilter(cond)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if result != resultExpected {
+			t.Fatalf("unexpected filter for %q; got %s; want %s", cond, result, resultExpected)
+		}
+		if _, err := logstorage.ParseFilter(result); err != nil {
+			t.Fatalf("cannot parse filter %s: %s", result, err)
+		}
+	}
+
+	f("db.system=postgresql", `("span_attr:db.system":="postgresql" OR "resource_attr:db.system":="postgresql")`)
+	f("db.system!=postgresql", `-("span_attr:db.system":="postgresql" OR "resource_attr:db.system":="postgresql")`)
+	f("db.statement=~SELECT.*", `("span_attr:db.statement":~"SELECT.*" OR "resource_attr:db.statement":~"SELECT.*")`)
+	f("db.statement!~SELECT.*", `-("span_attr:db.statement":~"SELECT.*" OR "resource_attr:db.statement":~"SELECT.*")`)
+	f("http.status_code>=500", `("span_attr:http.status_code":>=500 OR "resource_attr:http.status_code":>=500)`)
+	f("http.status_code<400", `("span_attr:http.status_code":<400 OR "resource_attr:http.status_code":<400)`)
+
+	// empty value
+	f("peer=", `("span_attr:peer":="" OR "resource_attr:peer":="")`)
+
+	// value containing operator chars
+	f("query=a=b", `("span_attr:query":="a=b" OR "resource_attr:query":="a=b")`)
+}
+
+func TestGetAttrFilterFailure(t *testing.T) {
+	f := func(cond string) {
+		t.Helper()
+
+		if _, err := getAttrFilter(cond); err == nil {
+			t.Fatalf("expecting non-nil error for %q", cond)
+		}
+	}
+
+	f("")
+	f("foo")
+	f("=bar")
+	f("foo!bar")
+	f("foo>bar")
+}
+
+func TestGetSpansFilter(t *testing.T) {
+	f := func(args url.Values, resultExpected string, isErrorExpected bool) {
+		t.Helper()
+
+		r := httptest.NewRequest("GET", "/select/traces/spans?"+args.Encode(), nil)
+		result, err := getSpansFilter(r)
+		if isErrorExpected != (err != nil) {
+			t.Fatalf("unexpected error: %v; isErrorExpected=%v", err, isErrorExpected)
+		}
+		if result != resultExpected {
+			t.Fatalf("unexpected filter; got %s; want %s", result, resultExpected)
+		}
+	}
+
+	// no filters
+	f(url.Values{}, "*", false)
+
+	// all the filters
+	f(url.Values{
+		"service":      {"db"},
+		"kind":         {"client"},
+		"status":       {"error"},
+		"min_duration": {"500ms"},
+		"max_duration": {"2s"},
+		"attr":         {"db.system=postgresql", "peer.service!=cache"},
+		"query":        {"foo or bar"},
+	}, `_stream:{resource_attr:service.name="db"} AND kind:=3 AND status_code:=2 AND duration:>=500000000 AND duration:<=2000000000 AND `+
+		`("span_attr:db.system":="postgresql" OR "resource_attr:db.system":="postgresql") AND `+
+		`-("span_attr:peer.service":="cache" OR "resource_attr:peer.service":="cache") AND (foo or bar)`, false)
+
+	// invalid args
+	f(url.Values{"kind": {"foo"}}, "", true)
+	f(url.Values{"status": {"failed"}}, "", true)
+	f(url.Values{"min_duration": {"1x"}}, "", true)
+	f(url.Values{"attr": {"foo"}}, "", true)
+	f(url.Values{"query": {"foo |"}}, "", true)
+}
+
+func TestGetSpansQuery(t *testing.T) {
+	f := func(sortField string, desc bool, c *cursor, resultExpected string) {
+		t.Helper()
+
+		result := getSpansQuery("*", 0, 1e9, sortField, desc, c, 10)
+		if result != resultExpected {
+			t.Fatalf("unexpected query; got %s; want %s", result, resultExpected)
+		}
+		if _, err := logstorage.ParseQuery(result); err != nil {
+			t.Fatalf("cannot parse query %s: %s", result, err)
+		}
+	}
+
+	// the first page
+	f("start_time_unix_nano", true, nil,
+		`_time:[1970-01-01T00:00:00Z, 1970-01-01T00:00:01Z) (*) -trace_id_idx:* | sort by (start_time_unix_nano desc, trace_id, span_id) offset 0 limit 10`)
+	f("duration", false, nil,
+		`_time:[1970-01-01T00:00:00Z, 1970-01-01T00:00:01Z) (*) -trace_id_idx:* | sort by (duration, trace_id, span_id) offset 0 limit 10`)
+
+	// the next page
+	c := &cursor{
+		value:  1760000000000000000,
+		offset: 2,
+	}
+	f("start_time_unix_nano", true, c,
+		`_time:[1970-01-01T00:00:00Z, 1970-01-01T00:00:01Z) (*) -trace_id_idx:* start_time_unix_nano:string_range("", "1760000000000000001") | sort by (start_time_unix_nano desc, trace_id, span_id) offset 2 limit 10`)
+	f("start_time_unix_nano", false, c,
+		`_time:[1970-01-01T00:00:00Z, 1970-01-01T00:00:01Z) (*) -trace_id_idx:* start_time_unix_nano:string_range("1760000000000000000", "99999999999999999999") | sort by (start_time_unix_nano, trace_id, span_id) offset 2 limit 10`)
+	c = &cursor{
+		value:  500,
+		offset: 1,
+	}
+	f("duration", true, c,
+		`_time:[1970-01-01T00:00:00Z, 1970-01-01T00:00:01Z) (*) -trace_id_idx:* duration:<=500 | sort by (duration desc, trace_id, span_id) offset 1 limit 10`)
+	f("duration", false, c,
+		`_time:[1970-01-01T00:00:00Z, 1970-01-01T00:00:01Z) (*) -trace_id_idx:* duration:>=500 | sort by (duration, trace_id, span_id) offset 1 limit 10`)
+}
+
+func TestCursorNext(t *testing.T) {
+	f := func(cursorStr string, values []int64, resultExpected string) {
+		t.Helper()
+
+		var c *cursor
+		if cursorStr != "" {
+			var err error
+			c, err = parseCursor(cursorStr)
+			if err != nil {
+				t.Fatalf("cannot parse cursor %q: %s", cursorStr, err)
+			}
+		}
+		if result := c.next(values).String(); result != resultExpected {
+			t.Fatalf("unexpected cursor; got %q; want %q", result, resultExpected)
+		}
+	}
+
+	// the first page
+	f("", []int64{300, 200, 100}, "100-1")
+	f("", []int64{300, 100, 100}, "100-2")
+
+	// the page with new values
+	f("300-2", []int64{300, 200, 200}, "200-2")
+
+	// the page with the cursor value only
+	f("300-2", []int64{300, 300}, "300-4")
+}
+
+func TestParseCursorFailure(t *testing.T) {
+	f := func(s string) {
+		t.Helper()
+
+		if _, err := parseCursor(s); err == nil {
+			t.Fatalf("expecting non-nil error for cursor %q", s)
+		}
+	}
+
+	f("foo")
+	f("123")
+	f("-1-2")
+	f("foo-1")
+	f("123-foo")
+	f("123--1")
+}
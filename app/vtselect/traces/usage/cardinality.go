@@ -0,0 +1,206 @@
+package usage
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httputil"
+
+	otelpb "github.com/VictoriaMetrics/VictoriaTraces/lib/protoparser/opentelemetry/pb"
+)
+
+// defaultSamples is the default number of sample span names to return per every service at /select/traces/cardinality.
+const defaultSamples = 5
+
+// streamsWarmup is the duration at the start of the selected time range, which is used for detecting already existing streams.
+//
+// Streams seen during this duration aren't counted as new streams, since they may exist before the selected time range.
+const streamsWarmup = time.Hour
+
+// ServiceCardinality contains stream cardinality stats for a single service.
+type ServiceCardinality struct {
+	// Service is the service name.
+	Service string
+
+	// SpanNames is the number of distinct span names for the service.
+	SpanNames uint64
+
+	// Streams is the number of streams for the service.
+	Streams uint64
+
+	// NewStreams is the number of streams, which were first seen after streamsWarmup since the start of the selected time range.
+	NewStreams uint64
+
+	// NewStreamsPerHour is the average number of new streams per hour.
+	NewStreamsPerHour float64
+
+	// FoldedSpans is the number of spans with span names folded because of -opentelemetry.traces.maxSpanNamesPerService limit.
+	FoldedSpans uint64
+
+	// SampleSpanNames contains sample span names for the service.
+	SampleSpanNames []string
+}
+
+// ProcessCardinalityRequest handles /select/traces/cardinality request.
+//
+// It returns services ranked by the number of distinct span names, since every span name creates a separate stream.
+func ProcessCardinalityRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	endMsecs, err := httputil.GetTime(r, "end", now.UnixMilli())
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+	startMsecs, err := httputil.GetTime(r, "start", endMsecs-24*time.Hour.Milliseconds())
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+	if startMsecs >= endMsecs {
+		httpserver.Errorf(w, r, "start=%d must be smaller than end=%d", startMsecs, endMsecs)
+		return
+	}
+
+	tenantID, err := logstorage.GetTenantIDFromRequest(r)
+	if err != nil {
+		httpserver.Errorf(w, r, "cannot obtain tenantID: %s", err)
+		return
+	}
+
+	filter := r.FormValue("query")
+	if filter == "" {
+		filter = "*"
+	}
+	if _, err := logstorage.ParseFilter(filter); err != nil {
+		httpserver.Errorf(w, r, "cannot parse query [%s]: %s", filter, err)
+		return
+	}
+
+	limit, err := getPositiveInt(r, "limit", defaultLimit)
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+	samples, err := getPositiveInt(r, "samples", defaultSamples)
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+
+	scs, err := GetCardinality(ctx, tenantID, filter, startMsecs*1e6, endMsecs*1e6, limit, samples)
+	if err != nil {
+		httpserver.Errorf(w, r, "cannot obtain stream cardinality: %s", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	WriteCardinalityResponse(w, startMsecs, endMsecs, scs)
+}
+
+// GetCardinality returns up to limit services with the biggest number of distinct span names
+// for spans matching the given LogsQL filter at the given tenantID on the time range [start, end) in nanoseconds.
+//
+// Up to samples span names are returned per every service.
+func GetCardinality(ctx context.Context, tenantID logstorage.TenantID, filter string, start, end int64, limit, samples int) ([]*ServiceCardinality, error) {
+	services := make(map[string]*ServiceCardinality)
+	getService := func(name string) *ServiceCardinality {
+		sc := services[name]
+		if sc == nil {
+			sc = &ServiceCardinality{
+				Service: name,
+			}
+			services[name] = sc
+		}
+		return sc
+	}
+
+	// The real span name is stored in the name field for spans with folded span names, so count_uniq(name) accounts for them too.
+	timeFilter := fmt.Sprintf("_time:[%s, %s) (%s) -%s:*", formatTimestamp(start), formatTimestamp(end), filter, otelpb.TraceIDIndexFieldName)
+	qStr := fmt.Sprintf(`%s | stats by (%q) count_uniq(%s) span_names, count() if (_stream:{%s=%q}) folded_spans, uniq_values(%s) limit %d sample_span_names`,
+		timeFilter, otelpb.ResourceAttrServiceName, otelpb.NameField, otelpb.NameField, otelpb.FoldedSpanName, otelpb.NameField, samples)
+	err := runQuery(ctx, tenantID, qStr, func(get func(name string) string) {
+		sc := getService(get(otelpb.ResourceAttrServiceName))
+		sc.SpanNames = parseUint(get("span_names"))
+		sc.FoldedSpans = parseUint(get("folded_spans"))
+		sc.SampleSpanNames = parseJSONStringArray(get("sample_span_names"))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	warmupEnd := start + streamsWarmup.Nanoseconds()
+	qStr = fmt.Sprintf(`%s | stats by (%q, _stream_id) min(_time) _time | stats by (%q) count() streams, count() if (_time:>=%s) new_streams`,
+		timeFilter, otelpb.ResourceAttrServiceName, otelpb.ResourceAttrServiceName, formatTimestamp(warmupEnd))
+	err = runQuery(ctx, tenantID, qStr, func(get func(name string) string) {
+		sc := getService(get(otelpb.ResourceAttrServiceName))
+		sc.Streams = parseUint(get("streams"))
+		sc.NewStreams = parseUint(get("new_streams"))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	scs := make([]*ServiceCardinality, 0, len(services))
+	for _, sc := range services {
+		scs = append(scs, sc)
+	}
+	return getTopServiceCardinalities(scs, end-warmupEnd, limit), nil
+}
+
+// getTopServiceCardinalities returns up to limit scs with the biggest number of distinct span names.
+//
+// newStreamsDuration is the duration in nanoseconds, which is used for calculating NewStreamsPerHour.
+func getTopServiceCardinalities(scs []*ServiceCardinality, newStreamsDuration int64, limit int) []*ServiceCardinality {
+	for _, sc := range scs {
+		if newStreamsDuration > 0 {
+			sc.NewStreamsPerHour = float64(sc.NewStreams) / (float64(newStreamsDuration) / float64(time.Hour.Nanoseconds()))
+		}
+	}
+	slices.SortFunc(scs, func(a, b *ServiceCardinality) int {
+		if n := cmp.Compare(b.SpanNames, a.SpanNames); n != 0 {
+			return n
+		}
+		if n := cmp.Compare(b.NewStreams, a.NewStreams); n != 0 {
+			return n
+		}
+		return strings.Compare(a.Service, b.Service)
+	})
+	if len(scs) > limit {
+		scs = scs[:limit]
+	}
+	return scs
+}
+
+func getPositiveInt(r *http.Request, argName string, defaultValue int) (int, error) {
+	if r.FormValue(argName) == "" {
+		return defaultValue, nil
+	}
+	n, err := httputil.GetInt(r, argName)
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("%s must be positive; got %d", argName, n)
+	}
+	return n, nil
+}
+
+// parseJSONStringArray parses JSON array of strings returned by uniq_values() stats function.
+func parseJSONStringArray(s string) []string {
+	if s == "" {
+		return nil
+	}
+	var a []string
+	if err := json.Unmarshal([]byte(s), &a); err != nil {
+		return nil
+	}
+	return a
+}
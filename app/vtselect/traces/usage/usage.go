@@ -0,0 +1,507 @@
+package usage
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httputil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/timeutil"
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtstorage"
+	otelpb "github.com/VictoriaMetrics/VictoriaTraces/lib/protoparser/opentelemetry/pb"
+)
+
+var (
+	metricsTopN = flag.Int("usage.metricsTopN", 0, "The number of the biggest services, span names and fields by stored bytes to expose as vt_usage_* metrics at /metrics page. "+
+		"Metrics aren't exposed if set to 0. See https://docs.victoriametrics.com/victoriatraces/#storage-usage")
+	metricsInterval = flag.Duration("usage.metricsInterval", time.Hour, "The interval for updating vt_usage_* metrics when -usage.metricsTopN is set. "+
+		"See https://docs.victoriametrics.com/victoriatraces/#storage-usage")
+	metricsLookback = flag.Duration("usage.metricsLookback", 24*time.Hour, "The time range for calculating vt_usage_* metrics when -usage.metricsTopN is set. "+
+		"See https://docs.victoriametrics.com/victoriatraces/#storage-usage")
+	metricsTenants = flagutil.NewArrayString("usage.metricsTenant", "Tenants in the form accountID:projectID to calculate vt_usage_* metrics for when -usage.metricsTopN is set. "+
+		"The default tenant 0:0 is used if not set. See https://docs.victoriametrics.com/victoriatraces/#storage-usage")
+)
+
+// defaultLimit is the default number of entries to return per every breakdown at /select/traces/usage.
+const defaultLimit = 10
+
+// virtualFields contains fields, which are returned by block_stats pipe, while they aren't stored in data blocks.
+var virtualFields = []string{"_stream", "_stream_id"}
+
+// Usage contains storage usage for a tenant on the given time range.
+//
+// Spans and index rows are counted exactly, while stored bytes are calculated from block and column headers.
+// Stored bytes per service and per span name are estimated from the average stored bytes per row in the corresponding partitions,
+// since blocks with spans for different services share partitions.
+type Usage struct {
+	Tenant string
+
+	// Spans is the number of spans.
+	Spans uint64
+
+	// IndexRows is the number of rows in the trace_id index.
+	IndexRows uint64
+
+	// IndexBytes is the estimated number of stored bytes for the trace_id index.
+	IndexBytes uint64
+
+	// Bytes is the number of stored bytes for spans and the trace_id index.
+	Bytes uint64
+
+	Services   []Entry
+	SpanNames  []Entry
+	Fields     []Entry
+	Partitions []Entry
+}
+
+// Entry contains usage for a single service, span name, field or partition.
+type Entry struct {
+	// Service is the service name for span name entries.
+	Service string
+
+	Name string
+
+	// Rows is the number of spans for services, span names and partitions, and the number of rows with the given field for fields.
+	Rows uint64
+
+	Bytes uint64
+}
+
+var (
+	usageUpdates       = metrics.NewCounter(`vt_usage_metrics_updates_total`)
+	usageUpdateErrors  = metrics.NewCounter(`vt_usage_metrics_update_errors_total`)
+	usageUpdateSeconds = metrics.NewSummary(`vt_usage_metrics_update_duration_seconds`)
+)
+
+var (
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+
+	// metricsSet contains vt_usage_* metrics for the top consumers. It is replaced on every update.
+	metricsSet *metrics.Set
+)
+
+// Init starts updating vt_usage_* metrics if -usage.metricsTopN is set.
+func Init() {
+	stopCh = make(chan struct{})
+	if *metricsTopN <= 0 {
+		return
+	}
+
+	tenantIDs, err := parseTenantIDs(*metricsTenants)
+	if err != nil {
+		logger.Fatalf("cannot parse -usage.metricsTenant: %s", err)
+	}
+	if len(tenantIDs) == 0 {
+		tenantIDs = []logstorage.TenantID{{}}
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runMetricsUpdater(tenantIDs)
+	}()
+}
+
+// Stop stops updating vt_usage_* metrics.
+func Stop() {
+	close(stopCh)
+	wg.Wait()
+
+	if metricsSet != nil {
+		metrics.UnregisterSet(metricsSet, true)
+		metricsSet = nil
+	}
+}
+
+func runMetricsUpdater(tenantIDs []logstorage.TenantID) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stopCh
+		cancel()
+	}()
+
+	d := timeutil.AddJitterToDuration(*metricsInterval)
+	t := time.NewTicker(d)
+	defer t.Stop()
+	for {
+		updateMetrics(ctx, tenantIDs)
+
+		select {
+		case <-stopCh:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func updateMetrics(ctx context.Context, tenantIDs []logstorage.TenantID) {
+	startTime := time.Now()
+	usageUpdates.Inc()
+
+	end := startTime.UnixNano()
+	start := end - metricsLookback.Nanoseconds()
+	s := metrics.NewSet()
+	for _, tenantID := range tenantIDs {
+		u, err := GetUsage(ctx, tenantID, "*", start, end, *metricsTopN)
+		if err != nil {
+			if ctx.Err() == nil {
+				usageUpdateErrors.Inc()
+				logger.Errorf("cannot update vt_usage_* metrics for tenant %s: %s", formatTenantID(tenantID), err)
+			}
+			return
+		}
+		addUsageMetrics(s, u)
+	}
+
+	if metricsSet != nil {
+		metrics.UnregisterSet(metricsSet, true)
+	}
+	metrics.RegisterSet(s)
+	metricsSet = s
+	usageUpdateSeconds.UpdateDuration(startTime)
+}
+
+func addUsageMetrics(s *metrics.Set, u *Usage) {
+	addGauge := func(name string, value uint64) {
+		_ = s.NewGauge(name, func() float64 {
+			return float64(value)
+		})
+	}
+	for _, e := range u.Services {
+		addGauge(fmt.Sprintf(`vt_usage_service_spans{tenant=%q,service=%q}`, u.Tenant, e.Name), e.Rows)
+		addGauge(fmt.Sprintf(`vt_usage_service_bytes{tenant=%q,service=%q}`, u.Tenant, e.Name), e.Bytes)
+	}
+	for _, e := range u.SpanNames {
+		addGauge(fmt.Sprintf(`vt_usage_span_name_spans{tenant=%q,service=%q,span_name=%q}`, u.Tenant, e.Service, e.Name), e.Rows)
+		addGauge(fmt.Sprintf(`vt_usage_span_name_bytes{tenant=%q,service=%q,span_name=%q}`, u.Tenant, e.Service, e.Name), e.Bytes)
+	}
+	for _, e := range u.Fields {
+		addGauge(fmt.Sprintf(`vt_usage_field_bytes{tenant=%q,field=%q}`, u.Tenant, e.Name), e.Bytes)
+	}
+}
+
+// ProcessUsageRequest handles /select/traces/usage request.
+//
+// See https://docs.victoriametrics.com/victoriatraces/#storage-usage
+func ProcessUsageRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	endMsecs, err := httputil.GetTime(r, "end", now.UnixMilli())
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+	startMsecs, err := httputil.GetTime(r, "start", endMsecs-24*time.Hour.Milliseconds())
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+	if startMsecs >= endMsecs {
+		httpserver.Errorf(w, r, "start=%d must be smaller than end=%d", startMsecs, endMsecs)
+		return
+	}
+
+	// The tenant is taken only from request headers, since proxies in front of VictoriaTraces may enforce tenancy via these headers.
+	// The usage for multiple tenants is available via vt_usage_* metrics. See -usage.metricsTenant.
+	tenantID, err := logstorage.GetTenantIDFromRequest(r)
+	if err != nil {
+		httpserver.Errorf(w, r, "cannot obtain tenantID: %s", err)
+		return
+	}
+
+	filter := r.FormValue("query")
+	if filter == "" {
+		filter = "*"
+	}
+	if _, err := logstorage.ParseFilter(filter); err != nil {
+		httpserver.Errorf(w, r, "cannot parse query [%s]: %s", filter, err)
+		return
+	}
+
+	limit, err := getPositiveInt(r, "limit", defaultLimit)
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+
+	u, err := GetUsage(ctx, tenantID, filter, startMsecs*1e6, endMsecs*1e6, limit)
+	if err != nil {
+		httpserver.Errorf(w, r, "cannot obtain storage usage for tenant %s: %s", formatTenantID(tenantID), err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	WriteUsageResponse(w, startMsecs, endMsecs, []*Usage{u})
+}
+
+func formatTenantID(tenantID logstorage.TenantID) string {
+	return fmt.Sprintf("%d:%d", tenantID.AccountID, tenantID.ProjectID)
+}
+
+func parseTenantIDs(a []string) ([]logstorage.TenantID, error) {
+	var tenantIDs []logstorage.TenantID
+	for _, s := range a {
+		tenantID, err := logstorage.ParseTenantID(s)
+		if err != nil {
+			return nil, err
+		}
+		tenantIDs = append(tenantIDs, tenantID)
+	}
+	return tenantIDs, nil
+}
+
+// GetUsage returns storage usage for spans matching the given LogsQL filter at the given tenantID on the time range [start, end) in nanoseconds.
+//
+// Up to limit entries with the biggest stored bytes are returned per every breakdown except of partitions.
+func GetUsage(ctx context.Context, tenantID logstorage.TenantID, filter string, start, end int64, limit int) (*Usage, error) {
+	u := &Usage{
+		Tenant: formatTenantID(tenantID),
+	}
+
+	// Count spans per stream and per partition. This query reads only block headers and the timestamps,
+	// since service name and span name are stream fields, which are stored in block headers.
+	qStr := fmt.Sprintf("_time:[%s, %s) (%s) | stats by (%q, %q, _time:1d) count() rows, count(%s) index_rows",
+		formatTimestamp(start), formatTimestamp(end), filter, otelpb.ResourceAttrServiceName, otelpb.NameField, otelpb.TraceIDIndexFieldName)
+	var rows []streamRows
+	err := runQuery(ctx, tenantID, qStr, func(get func(name string) string) {
+		rows = append(rows, streamRows{
+			service:   get(otelpb.ResourceAttrServiceName),
+			name:      get(otelpb.NameField),
+			partition: getPartitionName(get("_time")),
+			rows:      parseUint(get("rows")),
+			indexRows: parseUint(get("index_rows")),
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Obtain stored bytes per field for every partition from block and column headers.
+	// Partitions are queried one by one, since block_stats pipe doesn't return the partition for in-memory parts.
+	var partitionNames []string
+	for _, sr := range rows {
+		partitionNames = append(partitionNames, sr.partition)
+	}
+	slices.Sort(partitionNames)
+	partitionNames = slices.Compact(partitionNames)
+
+	fields := make(map[string]*Entry)
+	partitionBytes := make(map[string]uint64)
+	for _, partitionName := range partitionNames {
+		dayStart, err := time.Parse("20060102", partitionName)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected partition name %q: %w", partitionName, err)
+		}
+		partitionStart := max(start, dayStart.UnixNano())
+		partitionEnd := min(end, dayStart.Add(24*time.Hour).UnixNano())
+		qStr := fmt.Sprintf("_time:[%s, %s) (%s) | block_stats | stats by (field) sum(values_bytes) values_bytes, sum(bloom_bytes) bloom_bytes, sum(dict_bytes) dict_bytes, sum(rows) rows",
+			formatTimestamp(partitionStart), formatTimestamp(partitionEnd), filter)
+		err = runQuery(ctx, tenantID, qStr, func(get func(name string) string) {
+			name := get("field")
+			if slices.Contains(virtualFields, name) {
+				return
+			}
+			bytes := parseUint(get("values_bytes")) + parseUint(get("bloom_bytes")) + parseUint(get("dict_bytes"))
+			e := fields[name]
+			if e == nil {
+				e = &Entry{
+					Name: name,
+				}
+				fields[name] = e
+			}
+			e.Rows += parseUint(get("rows"))
+			e.Bytes += bytes
+			partitionBytes[partitionName] += bytes
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, e := range fields {
+		u.Fields = append(u.Fields, *e)
+	}
+	u.Fields = getTopEntries(u.Fields, limit)
+
+	aggregateStreamRows(u, rows, partitionBytes)
+	u.Services = getTopEntries(u.Services, limit)
+	u.SpanNames = getTopEntries(u.SpanNames, limit)
+	return u, nil
+}
+
+// streamRows contains the number of rows for a single stream in a single partition.
+type streamRows struct {
+	service   string
+	name      string
+	partition string
+	rows      uint64
+	indexRows uint64
+}
+
+// aggregateStreamRows fills u with usage per service, span name and partition from rows and partitionBytes.
+//
+// Stored bytes per service, span name and for the trace_id index are estimated from the average stored bytes per row in every partition.
+func aggregateStreamRows(u *Usage, rows []streamRows, partitionBytes map[string]uint64) {
+	partitionRows := make(map[string]uint64)
+	for _, sr := range rows {
+		partitionRows[sr.partition] += sr.rows
+	}
+	estimateBytes := func(sr *streamRows, rows uint64) uint64 {
+		n := partitionRows[sr.partition]
+		if n == 0 {
+			return 0
+		}
+		return uint64(float64(partitionBytes[sr.partition]) * float64(rows) / float64(n))
+	}
+
+	services := make(map[string]*Entry)
+	spanNames := make(map[[2]string]*Entry)
+	partitions := make(map[string]*Entry)
+	for i := range rows {
+		sr := &rows[i]
+		spans := sr.rows - sr.indexRows
+		u.Spans += spans
+		u.IndexRows += sr.indexRows
+		u.IndexBytes += estimateBytes(sr, sr.indexRows)
+
+		pe := partitions[sr.partition]
+		if pe == nil {
+			pe = &Entry{
+				Name:  sr.partition,
+				Bytes: partitionBytes[sr.partition],
+			}
+			partitions[sr.partition] = pe
+		}
+		pe.Rows += spans
+
+		if spans == 0 {
+			// Skip the trace_id index streams.
+			continue
+		}
+		bytes := estimateBytes(sr, spans)
+
+		se := services[sr.service]
+		if se == nil {
+			se = &Entry{
+				Name: sr.service,
+			}
+			services[sr.service] = se
+		}
+		se.Rows += spans
+		se.Bytes += bytes
+
+		k := [2]string{sr.service, sr.name}
+		ne := spanNames[k]
+		if ne == nil {
+			ne = &Entry{
+				Service: sr.service,
+				Name:    sr.name,
+			}
+			spanNames[k] = ne
+		}
+		ne.Rows += spans
+		ne.Bytes += bytes
+	}
+
+	for _, e := range services {
+		u.Services = append(u.Services, *e)
+	}
+	for _, e := range spanNames {
+		u.SpanNames = append(u.SpanNames, *e)
+	}
+	for _, e := range partitions {
+		u.Bytes += e.Bytes
+		u.Partitions = append(u.Partitions, *e)
+	}
+	slices.SortFunc(u.Partitions, func(a, b Entry) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+}
+
+// getTopEntries returns up to limit entries with the biggest stored bytes from entries.
+func getTopEntries(entries []Entry, limit int) []Entry {
+	slices.SortFunc(entries, func(a, b Entry) int {
+		if n := cmp.Compare(b.Bytes, a.Bytes); n != 0 {
+			return n
+		}
+		if n := cmp.Compare(b.Rows, a.Rows); n != 0 {
+			return n
+		}
+		if n := cmp.Compare(a.Service, b.Service); n != 0 {
+			return n
+		}
+		return cmp.Compare(a.Name, b.Name)
+	})
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries
+}
+
+// runQuery executes the given qStr at tenantID and calls f for every result row.
+//
+// f can obtain values for the row fields via get callback.
+func runQuery(ctx context.Context, tenantID logstorage.TenantID, qStr string, f func(get func(name string) string)) error {
+	q, err := logstorage.ParseQueryAtTimestamp(qStr, time.Now().UnixNano())
+	if err != nil {
+		return fmt.Errorf("cannot parse query [%s]: %w", qStr, err)
+	}
+
+	var resultLock sync.Mutex
+	writeBlock := func(_ uint, db *logstorage.DataBlock) {
+		resultLock.Lock()
+		defer resultLock.Unlock()
+
+		rowsCount := db.RowsCount()
+		for i := 0; i < rowsCount; i++ {
+			f(func(name string) string {
+				c := db.GetColumnByName(name)
+				if c == nil {
+					return ""
+				}
+				// Clone the value, since it refers to the data block, which is re-used after returning from writeBlock.
+				return strings.Clone(c.Values[i])
+			})
+		}
+	}
+
+	var qs logstorage.QueryStats
+	qctx := logstorage.NewQueryContext(ctx, &qs, []logstorage.TenantID{tenantID}, q)
+	defer vtstorage.UpdatePerQueryStatsMetrics(&qs)
+	if err := vtstorage.RunQuery(qctx, writeBlock); err != nil {
+		return fmt.Errorf("cannot execute query [%s]: %w", qStr, err)
+	}
+	return nil
+}
+
+// getPartitionName returns partition name in the form YYYYMMDD for the given day start in RFC3339 format.
+func getPartitionName(dayStart string) string {
+	t, err := time.Parse(time.RFC3339, dayStart)
+	if err != nil {
+		return ""
+	}
+	return t.UTC().Format("20060102")
+}
+
+func formatTimestamp(nsecs int64) string {
+	return time.Unix(0, nsecs).UTC().Format(time.RFC3339Nano)
+}
+
+func parseUint(s string) uint64 {
+	n, _ := strconv.ParseUint(s, 10, 64)
+	return n
+}
@@ -0,0 +1,331 @@
+// Code generated by qtc from "usage.qtpl". DO NOT EDIT.
+// See https://github.com/valyala/quicktemplate for details.
+
+// UsageResponse generates response for /select/traces/usage
+
+//line usage.qtpl:4
+package usage
+
+//line usage.qtpl:4
+import (
+	qtio422016 "io"
+
+	qt422016 "github.com/valyala/quicktemplate"
+)
+
+//line usage.qtpl:4
+var (
+	_ = qtio422016.Copy
+	_ = qt422016.AcquireByteBuffer
+)
+
+//line usage.qtpl:4
+func StreamUsageResponse(qw422016 *qt422016.Writer, start, end int64, us []*Usage) {
+//line usage.qtpl:4
+	qw422016.N().S(`{"start":`)
+//line usage.qtpl:6
+	qw422016.N().DL(start)
+//line usage.qtpl:6
+	qw422016.N().S(`,"end":`)
+//line usage.qtpl:7
+	qw422016.N().DL(end)
+//line usage.qtpl:7
+	qw422016.N().S(`,"tenants":[`)
+//line usage.qtpl:9
+	for i, u := range us {
+//line usage.qtpl:10
+		streamusageJSON(qw422016, u)
+//line usage.qtpl:11
+		if i+1 < len(us) {
+//line usage.qtpl:11
+			qw422016.N().S(`,`)
+//line usage.qtpl:11
+		}
+//line usage.qtpl:12
+	}
+//line usage.qtpl:12
+	qw422016.N().S(`]}`)
+//line usage.qtpl:15
+}
+
+//line usage.qtpl:15
+func WriteUsageResponse(qq422016 qtio422016.Writer, start, end int64, us []*Usage) {
+//line usage.qtpl:15
+	qw422016 := qt422016.AcquireWriter(qq422016)
+//line usage.qtpl:15
+	StreamUsageResponse(qw422016, start, end, us)
+//line usage.qtpl:15
+	qt422016.ReleaseWriter(qw422016)
+//line usage.qtpl:15
+}
+
+//line usage.qtpl:15
+func UsageResponse(start, end int64, us []*Usage) string {
+//line usage.qtpl:15
+	qb422016 := qt422016.AcquireByteBuffer()
+//line usage.qtpl:15
+	WriteUsageResponse(qb422016, start, end, us)
+//line usage.qtpl:15
+	qs422016 := string(qb422016.B)
+//line usage.qtpl:15
+	qt422016.ReleaseByteBuffer(qb422016)
+//line usage.qtpl:15
+	return qs422016
+//line usage.qtpl:15
+}
+
+//line usage.qtpl:17
+func streamusageJSON(qw422016 *qt422016.Writer, u *Usage) {
+//line usage.qtpl:17
+	qw422016.N().S(`{"tenant":`)
+//line usage.qtpl:19
+	qw422016.N().Q(u.Tenant)
+//line usage.qtpl:19
+	qw422016.N().S(`,"spans":`)
+//line usage.qtpl:20
+	qw422016.N().DUL(u.Spans)
+//line usage.qtpl:20
+	qw422016.N().S(`,"index_rows":`)
+//line usage.qtpl:21
+	qw422016.N().DUL(u.IndexRows)
+//line usage.qtpl:21
+	qw422016.N().S(`,"index_bytes":`)
+//line usage.qtpl:22
+	qw422016.N().DUL(u.IndexBytes)
+//line usage.qtpl:22
+	qw422016.N().S(`,"bytes":`)
+//line usage.qtpl:23
+	qw422016.N().DUL(u.Bytes)
+//line usage.qtpl:23
+	qw422016.N().S(`,"services":[`)
+//line usage.qtpl:25
+	for i, e := range u.Services {
+//line usage.qtpl:25
+		qw422016.N().S(`{"name":`)
+//line usage.qtpl:27
+		qw422016.N().Q(e.Name)
+//line usage.qtpl:27
+		qw422016.N().S(`,"spans":`)
+//line usage.qtpl:28
+		qw422016.N().DUL(e.Rows)
+//line usage.qtpl:28
+		qw422016.N().S(`,"bytes":`)
+//line usage.qtpl:29
+		qw422016.N().DUL(e.Bytes)
+//line usage.qtpl:29
+		qw422016.N().S(`}`)
+//line usage.qtpl:31
+		if i+1 < len(u.Services) {
+//line usage.qtpl:31
+			qw422016.N().S(`,`)
+//line usage.qtpl:31
+		}
+//line usage.qtpl:32
+	}
+//line usage.qtpl:32
+	qw422016.N().S(`],"span_names":[`)
+//line usage.qtpl:35
+	for i, e := range u.SpanNames {
+//line usage.qtpl:35
+		qw422016.N().S(`{"service":`)
+//line usage.qtpl:37
+		qw422016.N().Q(e.Service)
+//line usage.qtpl:37
+		qw422016.N().S(`,"name":`)
+//line usage.qtpl:38
+		qw422016.N().Q(e.Name)
+//line usage.qtpl:38
+		qw422016.N().S(`,"spans":`)
+//line usage.qtpl:39
+		qw422016.N().DUL(e.Rows)
+//line usage.qtpl:39
+		qw422016.N().S(`,"bytes":`)
+//line usage.qtpl:40
+		qw422016.N().DUL(e.Bytes)
+//line usage.qtpl:40
+		qw422016.N().S(`}`)
+//line usage.qtpl:42
+		if i+1 < len(u.SpanNames) {
+//line usage.qtpl:42
+			qw422016.N().S(`,`)
+//line usage.qtpl:42
+		}
+//line usage.qtpl:43
+	}
+//line usage.qtpl:43
+	qw422016.N().S(`],"fields":[`)
+//line usage.qtpl:46
+	for i, e := range u.Fields {
+//line usage.qtpl:46
+		qw422016.N().S(`{"name":`)
+//line usage.qtpl:48
+		qw422016.N().Q(e.Name)
+//line usage.qtpl:48
+		qw422016.N().S(`,"rows":`)
+//line usage.qtpl:49
+		qw422016.N().DUL(e.Rows)
+//line usage.qtpl:49
+		qw422016.N().S(`,"bytes":`)
+//line usage.qtpl:50
+		qw422016.N().DUL(e.Bytes)
+//line usage.qtpl:50
+		qw422016.N().S(`}`)
+//line usage.qtpl:52
+		if i+1 < len(u.Fields) {
+//line usage.qtpl:52
+			qw422016.N().S(`,`)
+//line usage.qtpl:52
+		}
+//line usage.qtpl:53
+	}
+//line usage.qtpl:53
+	qw422016.N().S(`],"partitions":[`)
+//line usage.qtpl:56
+	for i, e := range u.Partitions {
+//line usage.qtpl:56
+		qw422016.N().S(`{"name":`)
+//line usage.qtpl:58
+		qw422016.N().Q(e.Name)
+//line usage.qtpl:58
+		qw422016.N().S(`,"spans":`)
+//line usage.qtpl:59
+		qw422016.N().DUL(e.Rows)
+//line usage.qtpl:59
+		qw422016.N().S(`,"bytes":`)
+//line usage.qtpl:60
+		qw422016.N().DUL(e.Bytes)
+//line usage.qtpl:60
+		qw422016.N().S(`}`)
+//line usage.qtpl:62
+		if i+1 < len(u.Partitions) {
+//line usage.qtpl:62
+			qw422016.N().S(`,`)
+//line usage.qtpl:62
+		}
+//line usage.qtpl:63
+	}
+//line usage.qtpl:63
+	qw422016.N().S(`]}`)
+//line usage.qtpl:66
+}
+
+//line usage.qtpl:66
+func writeusageJSON(qq422016 qtio422016.Writer, u *Usage) {
+//line usage.qtpl:66
+	qw422016 := qt422016.AcquireWriter(qq422016)
+//line usage.qtpl:66
+	streamusageJSON(qw422016, u)
+//line usage.qtpl:66
+	qt422016.ReleaseWriter(qw422016)
+//line usage.qtpl:66
+}
+
+//line usage.qtpl:66
+func usageJSON(u *Usage) string {
+//line usage.qtpl:66
+	qb422016 := qt422016.AcquireByteBuffer()
+//line usage.qtpl:66
+	writeusageJSON(qb422016, u)
+//line usage.qtpl:66
+	qs422016 := string(qb422016.B)
+//line usage.qtpl:66
+	qt422016.ReleaseByteBuffer(qb422016)
+//line usage.qtpl:66
+	return qs422016
+//line usage.qtpl:66
+}
+
+// CardinalityResponse generates response for /select/traces/cardinality
+
+//line usage.qtpl:69
+func StreamCardinalityResponse(qw422016 *qt422016.Writer, start, end int64, scs []*ServiceCardinality) {
+//line usage.qtpl:69
+	qw422016.N().S(`{"start":`)
+//line usage.qtpl:71
+	qw422016.N().DL(start)
+//line usage.qtpl:71
+	qw422016.N().S(`,"end":`)
+//line usage.qtpl:72
+	qw422016.N().DL(end)
+//line usage.qtpl:72
+	qw422016.N().S(`,"services":[`)
+//line usage.qtpl:74
+	for i, sc := range scs {
+//line usage.qtpl:74
+		qw422016.N().S(`{"name":`)
+//line usage.qtpl:76
+		qw422016.N().Q(sc.Service)
+//line usage.qtpl:76
+		qw422016.N().S(`,"span_names":`)
+//line usage.qtpl:77
+		qw422016.N().DUL(sc.SpanNames)
+//line usage.qtpl:77
+		qw422016.N().S(`,"streams":`)
+//line usage.qtpl:78
+		qw422016.N().DUL(sc.Streams)
+//line usage.qtpl:78
+		qw422016.N().S(`,"new_streams":`)
+//line usage.qtpl:79
+		qw422016.N().DUL(sc.NewStreams)
+//line usage.qtpl:79
+		qw422016.N().S(`,"new_streams_per_hour":`)
+//line usage.qtpl:80
+		qw422016.N().FPrec(sc.NewStreamsPerHour, 2)
+//line usage.qtpl:80
+		qw422016.N().S(`,"folded_spans":`)
+//line usage.qtpl:81
+		qw422016.N().DUL(sc.FoldedSpans)
+//line usage.qtpl:81
+		qw422016.N().S(`,"sample_span_names":[`)
+//line usage.qtpl:83
+		for j, name := range sc.SampleSpanNames {
+//line usage.qtpl:84
+			qw422016.N().Q(name)
+//line usage.qtpl:85
+			if j+1 < len(sc.SampleSpanNames) {
+//line usage.qtpl:85
+				qw422016.N().S(`,`)
+//line usage.qtpl:85
+			}
+//line usage.qtpl:86
+		}
+//line usage.qtpl:86
+		qw422016.N().S(`]}`)
+//line usage.qtpl:89
+		if i+1 < len(scs) {
+//line usage.qtpl:89
+			qw422016.N().S(`,`)
+//line usage.qtpl:89
+		}
+//line usage.qtpl:90
+	}
+//line usage.qtpl:90
+	qw422016.N().S(`]}`)
+//line usage.qtpl:93
+}
+
+//line usage.qtpl:93
+func WriteCardinalityResponse(qq422016 qtio422016.Writer, start, end int64, scs []*ServiceCardinality) {
+//line usage.qtpl:93
+	qw422016 := qt422016.AcquireWriter(qq422016)
+//line usage.qtpl:93
+	StreamCardinalityResponse(qw422016, start, end, scs)
+//line usage.qtpl:93
+	qt422016.ReleaseWriter(qw422016)
+//line usage.qtpl:93
+}
+
+//line usage.qtpl:93
+func CardinalityResponse(start, end int64, scs []*ServiceCardinality) string {
+//line usage.qtpl:93
+	qb422016 := qt422016.AcquireByteBuffer()
+//line usage.qtpl:93
+	WriteCardinalityResponse(qb422016, start, end, scs)
+//line usage.qtpl:93
+	qs422016 := string(qb422016.B)
+//line usage.qtpl:93
+	qt422016.ReleaseByteBuffer(qb422016)
+//line usage.qtpl:93
+	return qs422016
+//line usage.qtpl:93
+}
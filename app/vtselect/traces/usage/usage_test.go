@@ -0,0 +1,111 @@
+package usage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAggregateStreamRows(t *testing.T) {
+	rows := []streamRows{
+		{service: "frontend", name: "GET /", partition: "20251017", rows: 300},
+		{service: "frontend", name: "POST /cart", partition: "20251017", rows: 100},
+		{service: "db", name: "SELECT", partition: "20251017", rows: 400},
+		{partition: "20251017", rows: 200, indexRows: 200},
+		{service: "db", name: "SELECT", partition: "20251018", rows: 50},
+	}
+	partitionBytes := map[string]uint64{
+		"20251017": 10000,
+		"20251018": 1000,
+	}
+
+	var u Usage
+	aggregateStreamRows(&u, rows, partitionBytes)
+
+	if u.Spans != 850 {
+		t.Fatalf("unexpected spans; got %d; want 850", u.Spans)
+	}
+	if u.IndexRows != 200 {
+		t.Fatalf("unexpected index rows; got %d; want 200", u.IndexRows)
+	}
+	if u.IndexBytes != 2000 {
+		t.Fatalf("unexpected index bytes; got %d; want 2000", u.IndexBytes)
+	}
+	if u.Bytes != 11000 {
+		t.Fatalf("unexpected bytes; got %d; want 11000", u.Bytes)
+	}
+
+	services := getTopEntries(u.Services, 10)
+	servicesExpected := []Entry{
+		{Name: "db", Rows: 450, Bytes: 5000},
+		{Name: "frontend", Rows: 400, Bytes: 4000},
+	}
+	if !reflect.DeepEqual(services, servicesExpected) {
+		t.Fatalf("unexpected services\ngot\n%v\nwant\n%v", services, servicesExpected)
+	}
+
+	spanNames := getTopEntries(u.SpanNames, 2)
+	spanNamesExpected := []Entry{
+		{Service: "db", Name: "SELECT", Rows: 450, Bytes: 5000},
+		{Service: "frontend", Name: "GET /", Rows: 300, Bytes: 3000},
+	}
+	if !reflect.DeepEqual(spanNames, spanNamesExpected) {
+		t.Fatalf("unexpected span names\ngot\n%v\nwant\n%v", spanNames, spanNamesExpected)
+	}
+
+	partitionsExpected := []Entry{
+		{Name: "20251017", Rows: 800, Bytes: 10000},
+		{Name: "20251018", Rows: 50, Bytes: 1000},
+	}
+	if !reflect.DeepEqual(u.Partitions, partitionsExpected) {
+		t.Fatalf("unexpected partitions\ngot\n%v\nwant\n%v", u.Partitions, partitionsExpected)
+	}
+}
+
+func TestGetPartitionName(t *testing.T) {
+	f := func(dayStart, resultExpected string) {
+		t.Helper()
+		result := getPartitionName(dayStart)
+		if result != resultExpected {
+			t.Fatalf("unexpected partition name for %q; got %q; want %q", dayStart, result, resultExpected)
+		}
+	}
+
+	f("2025-10-18T00:00:00Z", "20251018")
+	f("2025-01-01T00:00:00Z", "20250101")
+	f("", "")
+}
+
+func TestGetTopServiceCardinalities(t *testing.T) {
+	scs := []*ServiceCardinality{
+		{Service: "db", SpanNames: 3, NewStreams: 0},
+		{Service: "frontend", SpanNames: 1000, NewStreams: 920},
+		{Service: "cart", SpanNames: 3, NewStreams: 2},
+		{Service: "auth", SpanNames: 3, NewStreams: 2},
+	}
+
+	result := getTopServiceCardinalities(scs, 23*time.Hour.Nanoseconds(), 3)
+	resultExpected := []*ServiceCardinality{
+		{Service: "frontend", SpanNames: 1000, NewStreams: 920, NewStreamsPerHour: 40},
+		{Service: "auth", SpanNames: 3, NewStreams: 2, NewStreamsPerHour: 2.0 / 23},
+		{Service: "cart", SpanNames: 3, NewStreams: 2, NewStreamsPerHour: 2.0 / 23},
+	}
+	if !reflect.DeepEqual(result, resultExpected) {
+		t.Fatalf("unexpected result\ngot\n%v\nwant\n%v", result, resultExpected)
+	}
+}
+
+func TestParseJSONStringArray(t *testing.T) {
+	f := func(s string, resultExpected []string) {
+		t.Helper()
+		result := parseJSONStringArray(s)
+		if !reflect.DeepEqual(result, resultExpected) {
+			t.Fatalf("unexpected result for %q; got %q; want %q", s, result, resultExpected)
+		}
+	}
+
+	f("", nil)
+	f("[]", []string{})
+	f(`["GET /user/1","GET /user/2"]`, []string{"GET /user/1", "GET /user/2"})
+	f("invalid", nil)
+}
@@ -0,0 +1,764 @@
+package vtstorage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtstorage/netselect"
+	otelpb "github.com/VictoriaMetrics/VictoriaTraces/lib/protoparser/opentelemetry/pb"
+)
+
+var (
+	archiveRetentionPeriod = flagutil.NewRetentionDuration("archive.retentionPeriod", "1y", "Archived traces with span timestamps older than now-archive.retentionPeriod are automatically deleted; "+
+		"see https://docs.victoriametrics.com/victoriatraces/#archiving-traces")
+	archiveAuthKey = flagutil.NewPassword("archiveAuthKey", "authKey, which must be passed in query string to /internal/archive/* . It overrides -httpAuth.* . "+
+		"vtselect passes it to -storageNode nodes when archiving traces via Jaeger HTTP API. See https://docs.victoriametrics.com/victoriatraces/#archiving-traces")
+)
+
+var archiveRowsDeleted = metrics.NewCounter(`vt_archive_rows_deleted_total`)
+
+// archiveDirname is the name of the directory at -storageDataPath, which holds the archive storage.
+const archiveDirname = "archive"
+
+// archivedTracesFilename is the name of the file at -storageDataPath, which holds the list of archived traces.
+const archivedTracesFilename = "archived-traces.json"
+
+// ArchivedTrace contains information about the archived trace.
+type ArchivedTrace struct {
+	// TenantID is the tenant of the trace in the form accountID:projectID.
+	TenantID string `json:"tenant_id"`
+
+	// TraceID is the id of the trace.
+	TraceID string `json:"trace_id"`
+
+	// Start and End contain the time range in nanoseconds for the archived spans.
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+
+	// Spans is the number of archived spans including trace ID index entries.
+	Spans uint64 `json:"spans"`
+
+	// ArchivedAt is the archive time in RFC3339 format.
+	ArchivedAt string `json:"archived_at"`
+}
+
+// ArchivedSpan is the span stored in the archive.
+type ArchivedSpan struct {
+	Timestamp int64              `json:"timestamp"`
+	Fields    []logstorage.Field `json:"fields"`
+}
+
+// traceArchive is the storage for archived traces.
+//
+// Archived traces are stored in a separate storage with -archive.retentionPeriod retention, so they outlive spans in the main storage.
+type traceArchive struct {
+	storage *logstorage.Storage
+
+	// storagePath is the path to the archive storage.
+	storagePath string
+
+	// path is the path to the file with the list of archived traces.
+	path string
+
+	// tracesLock protects traces and serializes archive operations.
+	tracesLock sync.Mutex
+	traces     []*ArchivedTrace
+}
+
+var archive *traceArchive
+
+func mustOpenTraceArchive(dataPath string) *traceArchive {
+	cfg := &logstorage.StorageConfig{
+		Retention:     archiveRetentionPeriod.Duration(),
+		FlushInterval: *inmemoryDataFlushInterval,
+		// Archived spans may have timestamps in the future according to -futureRetention.
+		FutureRetention: futureRetention.Duration(),
+	}
+	storagePath := filepath.Join(dataPath, archiveDirname)
+	// Finish partition rewrites interrupted by unclean shutdown before opening the storage.
+	mustRecoverPartitionRewriteTmpDir(storagePath)
+	ta := &traceArchive{
+		storage:     logstorage.MustOpenStorage(storagePath, cfg),
+		storagePath: storagePath,
+		path:        filepath.Join(dataPath, archivedTracesFilename),
+	}
+	if fs.IsPathExist(ta.path) {
+		data, err := os.ReadFile(ta.path)
+		if err != nil {
+			logger.Panicf("FATAL: cannot read archived traces: %s", err)
+		}
+		if err := json.Unmarshal(data, &ta.traces); err != nil {
+			logger.Panicf("FATAL: cannot parse archived traces from %q: %s", ta.path, err)
+		}
+	}
+
+	_ = metrics.GetOrCreateGauge(`vt_archived_traces`, func() float64 {
+		return float64(countArchivedTraces())
+	})
+	return ta
+}
+
+func (ta *traceArchive) mustClose() {
+	ta.storage.MustClose()
+}
+
+func countArchivedTraces() int {
+	if archive == nil {
+		return 0
+	}
+	archive.tracesLock.Lock()
+	defer archive.tracesLock.Unlock()
+	return len(archive.traces)
+}
+
+func (ta *traceArchive) mustSaveTracesLocked() {
+	data, err := json.Marshal(ta.traces)
+	if err != nil {
+		logger.Panicf("BUG: cannot marshal archived traces: %s", err)
+	}
+	fs.MustWriteAtomic(ta.path, data, true)
+}
+
+// removeExpiredTracesLocked removes traces, which are dropped from the archive storage according to -archive.retentionPeriod.
+func (ta *traceArchive) removeExpiredTracesLocked() {
+	deadline := time.Now().Add(-archiveRetentionPeriod.Duration()).UnixNano()
+	n := len(ta.traces)
+	ta.traces = slices.DeleteFunc(ta.traces, func(at *ArchivedTrace) bool {
+		return at.End < deadline
+	})
+	if len(ta.traces) != n {
+		ta.mustSaveTracesLocked()
+	}
+}
+
+func (ta *traceArchive) getTraceLocked(tenantID logstorage.TenantID, traceID string) *ArchivedTrace {
+	tenant := formatTenantID(tenantID)
+	for _, at := range ta.traces {
+		if at.TenantID == tenant && at.TraceID == traceID {
+			return at
+		}
+	}
+	return nil
+}
+
+// addTrace copies spans for the given traceID on the time range [start, end] from the local storage to the archive.
+func (ta *traceArchive) addTrace(ctx context.Context, tenantID logstorage.TenantID, traceID string, start, end int64) (*ArchivedTrace, error) {
+	ta.tracesLock.Lock()
+	defer ta.tracesLock.Unlock()
+
+	ta.removeExpiredTracesLocked()
+
+	qStr := fmt.Sprintf("_time:[%s, %s] (%s:=%q OR %s:=%q)", timestampToString(start), timestampToString(end),
+		otelpb.TraceIDField, traceID, otelpb.TraceIDIndexFieldName, traceID)
+	q, err := logstorage.ParseQueryAtTimestamp(qStr, time.Now().UnixNano())
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse query [%s]: %w", qStr, err)
+	}
+	var qs logstorage.QueryStats
+	qctx := logstorage.NewQueryContext(ctx, &qs, []logstorage.TenantID{tenantID}, q)
+
+	// Copy only the spans missing in the archive, since the trace may be already archived on another time range.
+	archivedSpans, err := ta.getSpans(qctx)
+	if err != nil {
+		return nil, err
+	}
+	archivedKeys := make(map[string]struct{}, len(archivedSpans))
+	for _, as := range archivedSpans {
+		archivedKeys[archivedSpanKey(as.Timestamp, as.Fields)] = struct{}{}
+	}
+	keepRow := func(_ string, timestamp int64, fields []logstorage.Field) bool {
+		_, ok := archivedKeys[archivedSpanKey(timestamp, fields)]
+		return !ok
+	}
+
+	// Deleted spans and spans outside their retention mustn't be archived.
+	qctxLocal := withExtraFilters(qctx)
+	_, spansCopied, err := copyQueryRows(qctxLocal, localStorage.RunQuery, ta.storage, keepRow)
+	if err != nil {
+		return nil, err
+	}
+	ta.storage.DebugFlush()
+	spans := uint64(len(archivedSpans)) + spansCopied
+
+	at := ta.getTraceLocked(tenantID, traceID)
+	if at == nil {
+		at = &ArchivedTrace{
+			TenantID: formatTenantID(tenantID),
+			TraceID:  traceID,
+			Start:    start,
+			End:      end,
+		}
+		ta.traces = append(ta.traces, at)
+	}
+	at.Start = min(at.Start, start)
+	at.End = max(at.End, end)
+	at.Spans = spans
+	at.ArchivedAt = time.Now().UTC().Format(time.RFC3339)
+	ta.mustSaveTracesLocked()
+
+	result := *at
+	return &result, nil
+}
+
+// archivedSpanKey returns the key for detecting already archived spans with the given timestamp and fields.
+//
+// Spans are identified by span_id, while trace ID index entries are identified by their timestamp.
+func archivedSpanKey(timestamp int64, fields []logstorage.Field) string {
+	for _, f := range fields {
+		if f.Name == otelpb.SpanIDField && f.Value != "" {
+			return f.Value
+		}
+	}
+	return "_time:" + strconv.FormatInt(timestamp, 10)
+}
+
+// deleteTrace deletes the trace with the given traceID from the archive.
+//
+// Spans of the deleted trace are physically deleted from the archive storage.
+//
+// It returns false if the trace is missing in the archive.
+func (ta *traceArchive) deleteTrace(ctx context.Context, tenantID logstorage.TenantID, traceID string) (bool, error) {
+	ta.tracesLock.Lock()
+	defer ta.tracesLock.Unlock()
+
+	at := ta.getTraceLocked(tenantID, traceID)
+	if at == nil {
+		return false, nil
+	}
+
+	// Delete the trace from the list after deleting its spans, so the deletion could be retried on error.
+	filter := fmt.Sprintf("%s:=%q OR %s:=%q", otelpb.TraceIDField, traceID, otelpb.TraceIDIndexFieldName, traceID)
+	if _, err := ta.deleteSpansLocked(ctx, tenantID, at.Start, at.End, filter); err != nil {
+		return false, err
+	}
+	ta.traces = slices.DeleteFunc(ta.traces, func(x *ArchivedTrace) bool {
+		return x == at
+	})
+	ta.mustSaveTracesLocked()
+
+	// Cached query results may contain the deleted trace.
+	bumpDataGeneration()
+	return true, nil
+}
+
+// deleteSpans physically deletes spans matching dt from the archive storage.
+//
+// Archived traces without the remaining spans are deleted from the archive.
+// It returns the number of deleted spans including trace ID index entries.
+func (ta *traceArchive) deleteSpans(ctx context.Context, dt *deleteTask) (uint64, error) {
+	ta.tracesLock.Lock()
+	defer ta.tracesLock.Unlock()
+
+	tenantID := dt.tenantID()
+	rowsDeleted, err := ta.deleteSpansLocked(ctx, tenantID, dt.indexStart(), dt.End, dt.filterString())
+	if err != nil || rowsDeleted == 0 {
+		return rowsDeleted, err
+	}
+
+	// Update the number of spans for the archived traces.
+	tenant := formatTenantID(tenantID)
+	for _, at := range ta.traces {
+		if at.TenantID != tenant || at.End < dt.indexStart() || at.Start > dt.End {
+			continue
+		}
+		filter := fmt.Sprintf("(%s:=%q OR %s:=%q)", otelpb.TraceIDField, at.TraceID, otelpb.TraceIDIndexFieldName, at.TraceID)
+		spans, err := countRows(ctx, ta.storage, tenantID, at.Start, at.End+1, filter)
+		if err != nil {
+			return rowsDeleted, err
+		}
+		at.Spans = spans
+	}
+	ta.traces = slices.DeleteFunc(ta.traces, func(at *ArchivedTrace) bool {
+		return at.Spans == 0
+	})
+	ta.mustSaveTracesLocked()
+	return rowsDeleted, nil
+}
+
+// deleteSpansLocked physically deletes spans matching the given filter for the given tenantID on the time range [start, end] from the archive storage.
+//
+// Per-day partitions with the matching spans are rewritten without these spans. The archive storage doesn't receive new spans during the rewrite,
+// since they are added under ta.tracesLock, which must be held by the caller.
+//
+// It returns the number of deleted spans including trace ID index entries.
+func (ta *traceArchive) deleteSpansLocked(ctx context.Context, tenantID logstorage.TenantID, start, end int64, filter string) (uint64, error) {
+	tenantIDs := ta.getTenantIDsLocked()
+	filtersPerTenant := map[logstorage.TenantID][]string{
+		tenantID: {"(" + filter + ")"},
+	}
+
+	rowsDeleted := uint64(0)
+	for _, name := range ta.storage.PartitionList() {
+		day, err := time.Parse("20060102", name)
+		if err != nil {
+			continue
+		}
+		partitionStart := day.UnixNano()
+		partitionEnd := day.Add(24 * time.Hour).UnixNano()
+		if start >= partitionEnd || end < partitionStart {
+			continue
+		}
+		rows, err := countRows(ctx, ta.storage, tenantID, partitionStart, partitionEnd, "("+filter+")")
+		if err != nil {
+			return rowsDeleted, err
+		}
+		if rows == 0 {
+			continue
+		}
+
+		stats, err := rewritePartitionExcluding(ctx, ta.storage, ta.storagePath, name, partitionStart, partitionEnd, tenantIDs, filtersPerTenant)
+		if err != nil {
+			return rowsDeleted, fmt.Errorf("cannot rewrite archive partition %q: %w", name, err)
+		}
+		archiveRowsDeleted.AddInt64(int64(stats.RowsBefore - stats.RowsAfter))
+		rowsDeleted += stats.RowsBefore - stats.RowsAfter
+	}
+	return rowsDeleted, nil
+}
+
+// getTenantIDsLocked returns tenants, which may have spans at the archive storage.
+func (ta *traceArchive) getTenantIDsLocked() []logstorage.TenantID {
+	tenantIDs := knownTenantsInstance.getTenantIDs()
+	for _, at := range ta.traces {
+		tenantID, err := logstorage.ParseTenantID(at.TenantID)
+		if err != nil {
+			logger.Panicf("BUG: unexpected tenant_id=%q for the archived trace_id=%q: %s", at.TenantID, at.TraceID, err)
+		}
+		tenantIDs = append(tenantIDs, tenantID)
+	}
+	slices.SortFunc(tenantIDs, compareTenantIDs)
+	return slices.Compact(tenantIDs)
+}
+
+// listTraces returns copies of the archived traces for the given tenantID.
+func (ta *traceArchive) listTraces(tenantID logstorage.TenantID) []ArchivedTrace {
+	ta.tracesLock.Lock()
+	defer ta.tracesLock.Unlock()
+
+	ta.removeExpiredTracesLocked()
+
+	tenant := formatTenantID(tenantID)
+	var traces []ArchivedTrace
+	for _, at := range ta.traces {
+		if at.TenantID == tenant {
+			traces = append(traces, *at)
+		}
+	}
+	return traces
+}
+
+// getTrace returns spans for the archived trace with the given traceID.
+//
+// nil is returned if the trace is missing in the archive.
+func (ta *traceArchive) getTrace(ctx context.Context, tenantID logstorage.TenantID, traceID string) ([]ArchivedSpan, error) {
+	ta.tracesLock.Lock()
+	at := ta.getTraceLocked(tenantID, traceID)
+	var start, end int64
+	if at != nil {
+		start, end = at.Start, at.End
+	}
+	ta.tracesLock.Unlock()
+
+	if at == nil {
+		return nil, nil
+	}
+
+	qStr := fmt.Sprintf("_time:[%s, %s] %s:=%q", timestampToString(start), timestampToString(end), otelpb.TraceIDField, traceID)
+	q, err := logstorage.ParseQueryAtTimestamp(qStr, time.Now().UnixNano())
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse query [%s]: %w", qStr, err)
+	}
+	var qs logstorage.QueryStats
+	qctx := logstorage.NewQueryContext(ctx, &qs, []logstorage.TenantID{tenantID}, q)
+	return ta.getSpans(qctx)
+}
+
+// getSpans returns spans from the archive for the given qctx.
+func (ta *traceArchive) getSpans(qctx *logstorage.QueryContext) ([]ArchivedSpan, error) {
+	var spansLock sync.Mutex
+	var spans []ArchivedSpan
+	writeBlock := func(_ uint, db *logstorage.DataBlock) {
+		timestamps, _ := db.GetTimestamps(nil)
+
+		spansLock.Lock()
+		defer spansLock.Unlock()
+
+		for i, timestamp := range timestamps {
+			fields := make([]logstorage.Field, 0, len(db.Columns))
+			for _, c := range db.Columns {
+				// Only append non-empty columns, since the span may miss some fields.
+				if v := c.Values[i]; v != "" {
+					fields = append(fields, logstorage.Field{
+						Name:  strings.Clone(c.Name),
+						Value: strings.Clone(v),
+					})
+				}
+			}
+			spans = append(spans, ArchivedSpan{
+				Timestamp: timestamp,
+				Fields:    fields,
+			})
+		}
+	}
+	if err := ta.storage.RunQuery(qctx, writeBlock); err != nil {
+		return nil, fmt.Errorf("cannot execute query [%s]: %w", qctx.Query, err)
+	}
+	return spans, nil
+}
+
+// ArchiveTrace copies spans for the given traceID at the given tenantID on the time range [start, end] in nanoseconds to the archive.
+//
+// The archived trace outlives spans in the main storage until -archive.retentionPeriod.
+// It returns the number of archived spans including trace ID index entries.
+func ArchiveTrace(ctx context.Context, tenantID logstorage.TenantID, traceID string, start, end int64) (uint64, error) {
+	if localStorage != nil {
+		at, err := archive.addTrace(ctx, tenantID, traceID, start, end)
+		if err != nil {
+			return 0, err
+		}
+		return at.Spans, nil
+	}
+
+	args := getArchiveArgs(tenantID, traceID)
+	args.Set("start", strconv.FormatInt(start, 10))
+	args.Set("end", strconv.FormatInt(end, 10))
+	groupResults, err := runArchiveAdminRequest(ctx, "/internal/archive/add", args)
+	if err != nil {
+		return 0, err
+	}
+
+	// Every storage group contains a copy of the trace, so return the number of spans for the group with the biggest number of spans.
+	maxSpans := uint64(0)
+	for _, results := range groupResults {
+		spans := uint64(0)
+		for _, result := range results {
+			var at ArchivedTrace
+			if err := json.Unmarshal(result, &at); err != nil {
+				return 0, fmt.Errorf("cannot parse archived trace %q: %w", result, err)
+			}
+			spans += at.Spans
+		}
+		maxSpans = max(maxSpans, spans)
+	}
+	return maxSpans, nil
+}
+
+// UnarchiveTrace deletes the trace with the given traceID at the given tenantID from the archive.
+//
+// It returns false if the trace is missing in the archive.
+func UnarchiveTrace(ctx context.Context, tenantID logstorage.TenantID, traceID string) (bool, error) {
+	if localStorage != nil {
+		return archive.deleteTrace(ctx, tenantID, traceID)
+	}
+
+	groupResults, err := runArchiveAdminRequest(ctx, "/internal/archive/delete", getArchiveArgs(tenantID, traceID))
+	if err != nil {
+		return false, err
+	}
+	deleted := false
+	for _, results := range groupResults {
+		for _, result := range results {
+			var ok bool
+			if err := json.Unmarshal(result, &ok); err != nil {
+				return false, fmt.Errorf("cannot parse archive delete result %q: %w", result, err)
+			}
+			deleted = deleted || ok
+		}
+	}
+	return deleted, nil
+}
+
+// ListArchivedTraces returns archived traces for the given tenantID sorted by the archive time.
+func ListArchivedTraces(ctx context.Context, tenantID logstorage.TenantID) ([]ArchivedTrace, error) {
+	var traces []ArchivedTrace
+	if localStorage != nil {
+		traces = archive.listTraces(tenantID)
+	} else {
+		results, err := runArchiveSelectRequest(ctx, "/internal/archive/list", getArchiveArgs(tenantID, ""))
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[string]*ArchivedTrace)
+		for _, result := range results {
+			var a []ArchivedTrace
+			if err := json.Unmarshal(result, &a); err != nil {
+				return nil, fmt.Errorf("cannot parse archived traces %q: %w", result, err)
+			}
+			// Merge per-node information for traces, since spans of a trace are spread among storage nodes.
+			for i := range a {
+				at := &a[i]
+				x := m[at.TraceID]
+				if x == nil {
+					m[at.TraceID] = at
+					continue
+				}
+				x.Start = min(x.Start, at.Start)
+				x.End = max(x.End, at.End)
+				x.Spans += at.Spans
+				x.ArchivedAt = max(x.ArchivedAt, at.ArchivedAt)
+			}
+		}
+		for _, at := range m {
+			traces = append(traces, *at)
+		}
+	}
+
+	slices.SortFunc(traces, func(a, b ArchivedTrace) int {
+		if n := strings.Compare(a.ArchivedAt, b.ArchivedAt); n != 0 {
+			return n
+		}
+		return strings.Compare(a.TraceID, b.TraceID)
+	})
+	return traces, nil
+}
+
+// GetArchivedTrace returns spans for the archived trace with the given traceID at the given tenantID.
+//
+// nil is returned if the trace is missing in the archive.
+func GetArchivedTrace(ctx context.Context, tenantID logstorage.TenantID, traceID string) ([]ArchivedSpan, error) {
+	if localStorage != nil {
+		return archive.getTrace(ctx, tenantID, traceID)
+	}
+
+	results, err := runArchiveSelectRequest(ctx, "/internal/archive/get", getArchiveArgs(tenantID, traceID))
+	if err != nil {
+		return nil, err
+	}
+	var spans []ArchivedSpan
+	for _, result := range results {
+		var a []ArchivedSpan
+		if err := json.Unmarshal(result, &a); err != nil {
+			return nil, fmt.Errorf("cannot parse archived spans %q: %w", result, err)
+		}
+		spans = append(spans, a...)
+	}
+	return spans, nil
+}
+
+func getArchiveArgs(tenantID logstorage.TenantID, traceID string) url.Values {
+	args := url.Values{}
+	args.Set("tenant_id", formatTenantID(tenantID))
+	if traceID != "" {
+		args.Set("trace_id", traceID)
+	}
+	if authKey := archiveAuthKey.Get(); authKey != "" {
+		args.Set("authKey", authKey)
+	}
+	return args
+}
+
+// runArchiveAdminRequest sends the request with the given args to the given path at the storage nodes of all the storage groups.
+//
+// It is used for changing the archive, since every storage group contains a copy of the ingested spans.
+// It returns results from all the storage nodes per every group. An error is returned if some of the storage nodes failed,
+// since the archived trace would be incomplete.
+func runArchiveAdminRequest(ctx context.Context, path string, args url.Values) ([][]json.RawMessage, error) {
+	groups := runNetworkAdminRequest(ctx, path, args)
+	groupResults := make([][]json.RawMessage, 0, len(groups))
+	for _, g := range groups {
+		results, err := getArchiveResults(path, g.Nodes)
+		if err != nil {
+			return nil, err
+		}
+		groupResults = append(groupResults, results)
+	}
+	return groupResults, nil
+}
+
+// runArchiveSelectRequest sends the request with the given args to the given path at netstorageSelects in the order of preference.
+//
+// It is used for reading the archive. It returns results from all the storage nodes of the first group, which successfully processed the request.
+func runArchiveSelectRequest(ctx context.Context, path string, args url.Values) ([]json.RawMessage, error) {
+	var err error
+	for i, sg := range netstorageSelects {
+		var results []json.RawMessage
+		results, err = getArchiveResults(path, sg.s.RunAdminRequest(ctx, path, args))
+		if err == nil || ctx.Err() != nil || i+1 == len(netstorageSelects) {
+			return results, err
+		}
+		logger.Warnf("cannot read archive at %s: %s; re-trying the request at %s", sg, err, netstorageSelects[i+1])
+		selectGroupFailovers.Inc()
+	}
+	return nil, err
+}
+
+func getArchiveResults(path string, responses []netselect.AdminResponse) ([]json.RawMessage, error) {
+	results := make([]json.RawMessage, 0, len(responses))
+	for _, resp := range responses {
+		if resp.Error != "" {
+			return nil, fmt.Errorf("cannot execute %s at storage node %s: %s", path, resp.Addr, resp.Error)
+		}
+		if len(resp.Result) > 0 {
+			results = append(results, resp.Result)
+		}
+	}
+	return results, nil
+}
+
+// getArchiveRequestArgs returns tenantID and traceID from r for the /internal/archive/* request.
+//
+// The tenant_id arg is set from AccountID and ProjectID request headers if it is missing.
+func getArchiveRequestArgs(r *http.Request, traceIDRequired bool) (logstorage.TenantID, string, error) {
+	if err := r.ParseForm(); err != nil {
+		return logstorage.TenantID{}, "", fmt.Errorf("cannot parse request args: %w", err)
+	}
+	if err := setTenantIDArg(r); err != nil {
+		return logstorage.TenantID{}, "", err
+	}
+	s := r.FormValue("tenant_id")
+	tenantID, err := logstorage.ParseTenantID(s)
+	if err != nil {
+		return tenantID, "", fmt.Errorf("cannot parse tenant_id=%q: %w", s, err)
+	}
+	traceID := r.FormValue("trace_id")
+	if traceID == "" && traceIDRequired {
+		return tenantID, "", fmt.Errorf("missing trace_id arg")
+	}
+	return tenantID, traceID, nil
+}
+
+// processArchiveAdd copies spans for the trace_id on the time range [start, end] to the archive.
+//
+// tenant_id is obtained from AccountID and ProjectID request headers if missing.
+func processArchiveAdd(w http.ResponseWriter, r *http.Request) bool {
+	tenantID, traceID, err := getArchiveRequestArgs(r, true)
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return true
+	}
+
+	if localStorage == nil {
+		return processClusterAdminRequest(w, r, archiveAuthKey)
+	}
+
+	if !httpserver.CheckAuthFlag(w, r, archiveAuthKey) {
+		return true
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return true
+	}
+	start, err := getTimeNsec(r, "start", 0)
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return true
+	}
+	end, err := getTimeNsec(r, "end", time.Now().UnixNano())
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return true
+	}
+	if start > end {
+		httpserver.Errorf(w, r, "start=%d cannot exceed end=%d", start, end)
+		return true
+	}
+
+	at, err := archive.addTrace(r.Context(), tenantID, traceID, start, end)
+	if err != nil {
+		httpserver.Errorf(w, r, "cannot archive trace_id=%q: %s", traceID, err)
+		return true
+	}
+
+	writeJSONResponse(w, at)
+	return true
+}
+
+// processArchiveDelete deletes the trace_id from the archive.
+func processArchiveDelete(w http.ResponseWriter, r *http.Request) bool {
+	tenantID, traceID, err := getArchiveRequestArgs(r, true)
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return true
+	}
+
+	if localStorage == nil {
+		return processClusterAdminRequest(w, r, archiveAuthKey)
+	}
+
+	if !httpserver.CheckAuthFlag(w, r, archiveAuthKey) {
+		return true
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return true
+	}
+
+	ok, err := archive.deleteTrace(r.Context(), tenantID, traceID)
+	if err != nil {
+		httpserver.Errorf(w, r, "cannot delete trace_id=%q from the archive: %s", traceID, err)
+		return true
+	}
+	writeJSONResponse(w, ok)
+	return true
+}
+
+// processArchiveList returns archived traces for the tenant_id.
+func processArchiveList(w http.ResponseWriter, r *http.Request) bool {
+	tenantID, _, err := getArchiveRequestArgs(r, false)
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return true
+	}
+
+	if localStorage == nil {
+		return processClusterAdminRequest(w, r, archiveAuthKey)
+	}
+
+	if !httpserver.CheckAuthFlag(w, r, archiveAuthKey) {
+		return true
+	}
+
+	traces := archive.listTraces(tenantID)
+	if traces == nil {
+		// This is needed in order to return `[]` instead of `null` to the client.
+		traces = []ArchivedTrace{}
+	}
+	writeJSONResponse(w, traces)
+	return true
+}
+
+// processArchiveGet returns spans for the archived trace_id.
+func processArchiveGet(w http.ResponseWriter, r *http.Request) bool {
+	tenantID, traceID, err := getArchiveRequestArgs(r, true)
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return true
+	}
+
+	if localStorage == nil {
+		return processClusterAdminRequest(w, r, archiveAuthKey)
+	}
+
+	if !httpserver.CheckAuthFlag(w, r, archiveAuthKey) {
+		return true
+	}
+
+	spans, err := archive.getTrace(r.Context(), tenantID, traceID)
+	if err != nil {
+		httpserver.Errorf(w, r, "cannot get archived trace_id=%q: %s", traceID, err)
+		return true
+	}
+	if spans == nil {
+		// This is needed in order to return `[]` instead of `null` to the client.
+		spans = []ArchivedSpan{}
+	}
+	writeJSONResponse(w, spans)
+	return true
+}
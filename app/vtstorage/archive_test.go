@@ -0,0 +1,213 @@
+package vtstorage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+
+	otelpb "github.com/VictoriaMetrics/VictoriaTraces/lib/protoparser/opentelemetry/pb"
+)
+
+func TestTraceArchive(t *testing.T) {
+	dataPath := t.TempDir()
+	mustOpenTestStorage(t, filepath.Join(dataPath, "main"), 7*24*time.Hour)
+
+	// Ingest spans for two traces together with the trace_id_idx entry for the first trace.
+	tenantID := logstorage.TenantID{AccountID: 1, ProjectID: 2}
+	now := time.Now().UnixNano()
+	mustAddTestRows(func(lr *logstorage.LogRows) {
+		for i, traceID := range []string{"a", "a", "b"} {
+			addTestSpan(lr, tenantID, now+int64(i), traceID, "svc")
+		}
+		addTestTraceIDIndex(lr, tenantID, now-1, "a")
+	})
+
+	ctx := context.Background()
+	ta := mustOpenTraceArchive(dataPath)
+
+	at, err := ta.addTrace(ctx, tenantID, "a", now-1, now+10)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if at.Spans != 3 {
+		t.Fatalf("unexpected number of archived spans; got %d; want 3", at.Spans)
+	}
+
+	// Archiving the trace again mustn't duplicate spans.
+	at, err = ta.addTrace(ctx, tenantID, "a", now-1, now+10)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if at.Spans != 3 {
+		t.Fatalf("unexpected number of archived spans after archiving the trace again; got %d; want 3", at.Spans)
+	}
+
+	spans, err := ta.getTrace(ctx, tenantID, "a")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(spans) != 2 {
+		t.Fatalf("unexpected number of spans for the archived trace; got %d; want 2", len(spans))
+	}
+	for _, span := range spans {
+		if !hasField(span.Fields, "_stream", `{resource_attr:service.name="svc"}`) {
+			t.Fatalf("missing stream fields in the archived span: %v", span.Fields)
+		}
+	}
+
+	// The trace isn't visible for other tenants.
+	if traces := ta.listTraces(logstorage.TenantID{}); len(traces) != 0 {
+		t.Fatalf("unexpected archived traces for other tenant: %+v", traces)
+	}
+	spans, err = ta.getTrace(ctx, logstorage.TenantID{}, "a")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if spans != nil {
+		t.Fatalf("unexpected spans for other tenant: %v", spans)
+	}
+
+	ta.mustClose()
+
+	// Verify that the archived trace is restored after the restart and can be deleted.
+	ta = mustOpenTraceArchive(dataPath)
+	defer ta.mustClose()
+
+	traces := ta.listTraces(tenantID)
+	if len(traces) != 1 || traces[0].TraceID != "a" || traces[0].TenantID != "1:2" {
+		t.Fatalf("unexpected archived traces after the restart: %+v", traces)
+	}
+	if _, err := ta.addTrace(ctx, tenantID, "b", now-1, now+10); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	deleteTrace := func(traceID string) bool {
+		t.Helper()
+
+		ok, err := ta.deleteTrace(ctx, tenantID, traceID)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return ok
+	}
+	if !deleteTrace("a") {
+		t.Fatalf("cannot delete the archived trace")
+	}
+	if deleteTrace("a") {
+		t.Fatalf("unexpected deletion of the already deleted trace")
+	}
+	spans, err = ta.getTrace(ctx, tenantID, "a")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if spans != nil {
+		t.Fatalf("unexpected spans for the deleted trace: %v", spans)
+	}
+
+	getArchiveRowsCount := func(filter string) uint64 {
+		t.Helper()
+
+		rows, err := countRows(ctx, ta.storage, tenantID, now-24*3600*1e9, now+24*3600*1e9, filter)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return rows
+	}
+
+	// Spans and the trace_id_idx entry of the deleted trace must be physically deleted, while spans of other traces must remain.
+	if n := getArchiveRowsCount(`trace_id:=a OR trace_id_idx:=a`); n != 0 {
+		t.Fatalf("unexpected number of archived rows for the deleted trace; got %d; want 0", n)
+	}
+	if n := getArchiveRowsCount(`trace_id:=b`); n != 1 {
+		t.Fatalf("unexpected number of archived rows for the remaining trace; got %d; want 1", n)
+	}
+
+	// Delete tasks must delete spans from the archive.
+	dt := &deleteTask{
+		ID:       "foo",
+		TenantID: "1:2",
+		TraceIDs: []string{"b"},
+		Start:    now - 1,
+		End:      now + 10,
+	}
+	rowsDeleted, err := ta.deleteSpans(ctx, dt)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if rowsDeleted != 1 {
+		t.Fatalf("unexpected number of deleted archived rows; got %d; want 1", rowsDeleted)
+	}
+	if n := getArchiveRowsCount(""); n != 0 {
+		t.Fatalf("unexpected number of archived rows after the delete task; got %d; want 0", n)
+	}
+	if traces := ta.listTraces(tenantID); len(traces) != 0 {
+		t.Fatalf("archived traces without spans must be deleted; got %+v", traces)
+	}
+}
+
+func TestTraceArchiveAddMissingSpans(t *testing.T) {
+	dataPath := t.TempDir()
+	mustOpenTestStorage(t, filepath.Join(dataPath, "main"), 7*24*time.Hour)
+
+	tenantID := logstorage.TenantID{AccountID: 1}
+	now := time.Now().UnixNano()
+	addSpans := func(timestamp int64, spanIDs ...string) {
+		mustAddTestRows(func(lr *logstorage.LogRows) {
+			for _, spanID := range spanIDs {
+				lr.MustAdd(tenantID, timestamp, []logstorage.Field{
+					{Name: otelpb.TraceIDField, Value: "a"},
+					{Name: otelpb.SpanIDField, Value: spanID},
+					{Name: "_msg", Value: "-"},
+				}, []logstorage.Field{
+					{Name: otelpb.ResourceAttrServiceName, Value: "svc"},
+				})
+			}
+		})
+	}
+
+	ctx := context.Background()
+	ta := mustOpenTraceArchive(dataPath)
+	defer ta.mustClose()
+
+	addTrace := func(start, end int64, spansExpected uint64) {
+		t.Helper()
+
+		at, err := ta.addTrace(ctx, tenantID, "a", start, end)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if at.Spans != spansExpected {
+			t.Fatalf("unexpected number of archived spans; got %d; want %d", at.Spans, spansExpected)
+		}
+		spans, err := ta.getTrace(ctx, tenantID, "a")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if uint64(len(spans)) != spansExpected {
+			t.Fatalf("unexpected number of spans for the archived trace; got %d; want %d", len(spans), spansExpected)
+		}
+	}
+
+	addSpans(now, "s1", "s2")
+	addTrace(now-1, now+10, 2)
+
+	// Spans, which are missing in the archive, must be copied, while the already archived spans mustn't be duplicated.
+	addSpans(now+20, "s3")
+	addTrace(now-1, now+30, 3)
+
+	// Spans with the same span_id are archived only once.
+	addSpans(now+40, "s1", "s4")
+	addTrace(now-1, now+50, 4)
+}
+
+func hasField(fields []logstorage.Field, name, value string) bool {
+	for _, f := range fields {
+		if f.Name == name && f.Value == value {
+			return true
+		}
+	}
+	return false
+}
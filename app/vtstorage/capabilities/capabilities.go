@@ -0,0 +1,241 @@
+package capabilities
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fasttime"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promauth"
+)
+
+// Path is the path for HTTP endpoint, which returns capabilities of VictoriaTraces node.
+const Path = "/internal/capabilities"
+
+// negotiationInterval is the interval in seconds between protocol versions negotiations with the remote node.
+//
+// The negotiation is performed periodically, since the remote node can be upgraded or downgraded at any time.
+const negotiationInterval = 30
+
+// fetchTimeout is the timeout for obtaining capabilities from the remote node.
+const fetchTimeout = 10 * time.Second
+
+// Capabilities contains capabilities of VictoriaTraces node.
+type Capabilities struct {
+	// ProtocolVersions contains the supported protocol versions per every /internal/* HTTP endpoint path.
+	//
+	// The versions are ordered from the newest to the oldest.
+	ProtocolVersions map[string][]string `json:"protocol_versions"`
+}
+
+// Negotiate returns the newest version from localVersions, which is supported by c for the given path.
+//
+// localVersions must be ordered from the newest to the oldest.
+//
+// legacyVersion is returned if c is nil or if c doesn't contain versions for the given path.
+// This is the case for nodes, which do not support capabilities yet, so legacyVersion must contain
+// the only version supported by such nodes for the given path.
+func (c *Capabilities) Negotiate(path string, localVersions []string, legacyVersion string) (string, error) {
+	if c == nil {
+		return legacyVersion, nil
+	}
+	remoteVersions := c.ProtocolVersions[path]
+	if len(remoteVersions) == 0 {
+		return legacyVersion, nil
+	}
+	for _, v := range localVersions {
+		if slices.Contains(remoteVersions, v) {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("there are no common protocol versions for %s; local versions: %q; remote versions: %q", path, localVersions, remoteVersions)
+}
+
+// WriteResponse writes c to w.
+func (c *Capabilities) WriteResponse(w http.ResponseWriter) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		logger.Panicf("BUG: unexpected error when marshaling capabilities: %s", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// VersionNumber returns the numeric representation for the protocol version in the form vN.
+//
+// It returns 0 if the version has unexpected format.
+func VersionNumber(version string) float64 {
+	n, err := strconv.ParseUint(strings.TrimPrefix(version, "v"), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return float64(n)
+}
+
+// Negotiator negotiates protocol versions with the remote node.
+type Negotiator struct {
+	// c is an http client used for requesting capabilities from the remote node.
+	c *http.Client
+
+	// ac is auth config used for setting request headers such as Authorization and Host.
+	ac *promauth.Config
+
+	// addr is TCP address of the remote node.
+	addr string
+
+	// reqURL is the url for requesting capabilities from the remote node.
+	reqURL string
+
+	// caps contains the last obtained capabilities for the remote node.
+	//
+	// It is nil if the remote node doesn't support capabilities.
+	capsMu sync.Mutex
+	caps   *Capabilities
+
+	// hasCaps is set to true after the first negotiation attempt with the remote node.
+	hasCaps bool
+
+	// fetchDoneCh is closed when the in-flight request for capabilities is finished. It is nil if there is no in-flight request.
+	//
+	// The request is performed without holding capsMu, so a hanging remote node doesn't block concurrent callers.
+	fetchDoneCh chan struct{}
+
+	// nextNegotiationTime is the unix timestamp in seconds for the next negotiation with the remote node.
+	nextNegotiationTime uint64
+}
+
+// NewNegotiator returns new Negotiator for the remote node at the given scheme and addr.
+//
+// The c and ac are used for sending requests to the remote node.
+func NewNegotiator(c *http.Client, ac *promauth.Config, scheme, addr string) *Negotiator {
+	return &Negotiator{
+		c:      c,
+		ac:     ac,
+		reqURL: fmt.Sprintf("%s://%s%s", scheme, addr, Path),
+		addr:   addr,
+	}
+}
+
+// GetVersion returns the newest protocol version from localVersions for the given path, which is supported by the remote node.
+//
+// localVersions must be ordered from the newest to the oldest.
+//
+// legacyVersion is returned if the remote node doesn't support capabilities. See Capabilities.Negotiate for details.
+//
+// The newest local version is returned if there are no common versions with the remote node.
+func (n *Negotiator) GetVersion(ctx context.Context, path string, localVersions []string, legacyVersion string) string {
+	caps := n.getCapabilities(ctx)
+	version, err := caps.Negotiate(path, localVersions, legacyVersion)
+	if err != nil {
+		logger.Warnf("cannot negotiate protocol version with %q: %s; using the newest version %q", n.addr, err, localVersions[0])
+		return localVersions[0]
+	}
+	return version
+}
+
+// Reset forces the negotiation of protocol versions on the next GetVersion call.
+//
+// This is useful when the remote node returns an error, since it may be caused by the remote node upgrade or downgrade.
+func (n *Negotiator) Reset() {
+	n.capsMu.Lock()
+	n.nextNegotiationTime = 0
+	n.capsMu.Unlock()
+}
+
+// getCapabilities returns capabilities for the remote node.
+//
+// Only a single request for capabilities is performed at a time. Concurrent callers use the previously obtained capabilities
+// while the request is in flight. Callers wait for the request to finish if the capabilities haven't been obtained yet.
+func (n *Negotiator) getCapabilities(ctx context.Context) *Capabilities {
+	n.capsMu.Lock()
+	if n.fetchDoneCh != nil {
+		// The request for capabilities is in flight.
+		doneCh := n.fetchDoneCh
+		caps, hasCaps := n.caps, n.hasCaps
+		n.capsMu.Unlock()
+
+		if hasCaps {
+			return caps
+		}
+		select {
+		case <-doneCh:
+		case <-ctx.Done():
+		}
+		n.capsMu.Lock()
+		caps = n.caps
+		n.capsMu.Unlock()
+		return caps
+	}
+
+	currentTime := fasttime.UnixTimestamp()
+	if currentTime < n.nextNegotiationTime {
+		caps := n.caps
+		n.capsMu.Unlock()
+		return caps
+	}
+	n.nextNegotiationTime = currentTime + negotiationInterval
+	doneCh := make(chan struct{})
+	n.fetchDoneCh = doneCh
+	n.capsMu.Unlock()
+
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, fetchTimeout)
+	caps, err := n.fetchCapabilities(ctxWithTimeout)
+	cancel()
+
+	n.capsMu.Lock()
+	if err != nil {
+		// Keep the previously obtained capabilities, since the remote node may be temporarily unavailable.
+		logger.Warnf("cannot obtain capabilities from %q: %s", n.addr, err)
+	} else {
+		n.caps = caps
+	}
+	n.hasCaps = true
+	caps = n.caps
+	n.fetchDoneCh = nil
+	n.capsMu.Unlock()
+
+	close(doneCh)
+	return caps
+}
+
+func (n *Negotiator) fetchCapabilities(ctx context.Context) (*Capabilities, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", n.reqURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create a request for %q: %w", n.reqURL, err)
+	}
+	if err := n.ac.SetHeaders(req, true); err != nil {
+		return nil, fmt.Errorf("cannot set auth headers for %q: %w", n.reqURL, err)
+	}
+
+	resp, err := n.c.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot send request to %q: %w", n.reqURL, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read response from %q: %w", n.reqURL, err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		// The remote node doesn't support capabilities.
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response status code from %q: %d; want %d; response: %q", n.reqURL, resp.StatusCode, http.StatusOK, data)
+	}
+
+	var caps Capabilities
+	if err := json.Unmarshal(data, &caps); err != nil {
+		return nil, fmt.Errorf("cannot parse response from %q: %w", n.reqURL, err)
+	}
+	return &caps, nil
+}
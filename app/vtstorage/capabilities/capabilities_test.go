@@ -0,0 +1,180 @@
+package capabilities
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promauth"
+)
+
+func TestCapabilitiesNegotiateSuccess(t *testing.T) {
+	f := func(c *Capabilities, localVersions []string, versionExpected string) {
+		t.Helper()
+
+		version, err := c.Negotiate("/internal/insert", localVersions, "v1")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if version != versionExpected {
+			t.Fatalf("unexpected version; got %q; want %q", version, versionExpected)
+		}
+	}
+
+	// The remote node doesn't support capabilities
+	f(nil, []string{"v2", "v1"}, "v1")
+
+	// The remote node doesn't advertise versions for the path
+	f(&Capabilities{}, []string{"v2", "v1"}, "v1")
+
+	// The remote node supports the newest local version
+	f(&Capabilities{
+		ProtocolVersions: map[string][]string{
+			"/internal/insert": {"v2", "v1"},
+		},
+	}, []string{"v2", "v1"}, "v2")
+
+	// The remote node is older than the local node
+	f(&Capabilities{
+		ProtocolVersions: map[string][]string{
+			"/internal/insert": {"v1"},
+		},
+	}, []string{"v2", "v1"}, "v1")
+
+	// The remote node is newer than the local node
+	f(&Capabilities{
+		ProtocolVersions: map[string][]string{
+			"/internal/insert": {"v3", "v2"},
+		},
+	}, []string{"v2", "v1"}, "v2")
+}
+
+func TestCapabilitiesNegotiateFailure(t *testing.T) {
+	c := &Capabilities{
+		ProtocolVersions: map[string][]string{
+			"/internal/insert": {"v4", "v3"},
+		},
+	}
+	if _, err := c.Negotiate("/internal/insert", []string{"v2", "v1"}, "v1"); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+}
+
+func TestVersionNumber(t *testing.T) {
+	f := func(version string, nExpected float64) {
+		t.Helper()
+
+		if n := VersionNumber(version); n != nExpected {
+			t.Fatalf("unexpected number for version %q; got %v; want %v", version, n, nExpected)
+		}
+	}
+
+	f("v1", 1)
+	f("v12", 12)
+	f("", 0)
+	f("foo", 0)
+}
+
+func TestNegotiator(t *testing.T) {
+	var remoteVersions atomic.Pointer[string]
+	var statusCode atomic.Int64
+	var hangCh atomic.Pointer[chan struct{}]
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != Path {
+			t.Errorf("unexpected path requested: %q", r.URL.Path)
+		}
+		if ch := hangCh.Load(); ch != nil {
+			// Emulate the hanging node.
+			<-*ch
+		}
+		if code := statusCode.Load(); code != 0 {
+			w.WriteHeader(int(code))
+			return
+		}
+		versions := remoteVersions.Load()
+		if versions == nil {
+			// Emulate the node without capabilities support.
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		c := &Capabilities{
+			ProtocolVersions: map[string][]string{
+				"/internal/insert": strings.Split(*versions, ","),
+			},
+		}
+		c.WriteResponse(w)
+	}))
+	defer srv.Close()
+
+	ac, err := (&promauth.Options{}).NewConfig()
+	if err != nil {
+		t.Fatalf("cannot create auth config: %s", err)
+	}
+	n := NewNegotiator(srv.Client(), ac, "http", strings.TrimPrefix(srv.URL, "http://"))
+
+	f := func(versionExpected string) {
+		t.Helper()
+
+		version := n.GetVersion(context.Background(), "/internal/insert", []string{"v2", "v1"}, "v2")
+		if version != versionExpected {
+			t.Fatalf("unexpected version; got %q; want %q", version, versionExpected)
+		}
+	}
+
+	// The node without capabilities support
+	f("v2")
+
+	// The node is downgraded, but the negotiation isn't performed until Reset call
+	versions := "v1"
+	remoteVersions.Store(&versions)
+	f("v2")
+	n.Reset()
+	f("v1")
+
+	// The node is upgraded
+	versions = "v3,v2,v1"
+	remoteVersions.Store(&versions)
+	n.Reset()
+	f("v2")
+
+	// Unexpected errors do not reset the previously obtained capabilities
+	versions = "v1"
+	statusCode.Store(http.StatusBadRequest)
+	n.Reset()
+	f("v2")
+	statusCode.Store(0)
+
+	// The hanging node doesn't block callers while the capabilities are requested
+	ch := make(chan struct{})
+	hangCh.Store(&ch)
+	n.Reset()
+	fetchDoneCh := make(chan struct{})
+	go func() {
+		defer close(fetchDoneCh)
+		n.GetVersion(context.Background(), "/internal/insert", []string{"v2", "v1"}, "v2")
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		n.capsMu.Lock()
+		isInflight := n.fetchDoneCh != nil
+		n.capsMu.Unlock()
+		if isInflight {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout while waiting for the in-flight request for capabilities")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	f("v2")
+	hangCh.Store(nil)
+	close(ch)
+	<-fetchDoneCh
+
+	// The capabilities obtained by the finished request are used
+	f("v1")
+}
@@ -0,0 +1,119 @@
+package vtstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fasttime"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+// clusterDataGenerationUpdateInterval is the interval in seconds between updates of the data generation from storage nodes.
+const clusterDataGenerationUpdateInterval = 5
+
+// DataGenerationPath is the path for the HTTP endpoint at storage nodes, which returns the generation of the stored spans.
+const DataGenerationPath = "/internal/select/data_generation"
+
+var clusterDataGenerationLogger = logger.WithThrottler("cluster_data_generation", 5*time.Minute)
+
+// dataGeneration is incremented every time spans are deleted from the local storage or the archive.
+//
+// It starts from the process start time, so the generation doesn't repeat after the restart.
+var dataGeneration = func() *atomic.Uint64 {
+	var n atomic.Uint64
+	n.Store(uint64(time.Now().UnixNano()))
+	return &n
+}()
+
+var clusterDataGeneration dataGenerationCache
+
+// GetDataGeneration returns the generation of the stored spans.
+//
+// The generation changes every time spans are deleted from the storage, e.g. by delete tasks, retention policies,
+// retention filters or by deleting traces from the archive. The generation doesn't change when new spans are ingested.
+// So the callers may cache query results until the generation changes.
+//
+// In cluster mode the generation is obtained from storage nodes via DataGenerationPath, so it may lag behind deletions
+// at storage nodes for up to 5 seconds.
+func GetDataGeneration(ctx context.Context) uint64 {
+	if localStorage != nil {
+		return getLocalDataGeneration()
+	}
+	return clusterDataGeneration.get(ctx)
+}
+
+// getLocalDataGeneration returns the generation of spans at the local storage.
+//
+// Partitions dropped by the storage according to -retentionPeriod change the minimum partition timestamp,
+// so it is added to the generation.
+func getLocalDataGeneration() uint64 {
+	n := dataGeneration.Load()
+	if ptNames := localStorage.PartitionList(); len(ptNames) > 0 {
+		n += uint64(getMinPartitionTimestamp(ptNames))
+	}
+	return n
+}
+
+// bumpDataGeneration must be called after deleting spans from the local storage or the archive.
+func bumpDataGeneration() {
+	dataGeneration.Add(1)
+}
+
+// dataGenerationCache caches the generation of spans at storage nodes,
+// since it is expensive to request all the storage nodes on every request.
+type dataGenerationCache struct {
+	mu sync.Mutex
+
+	generation uint64
+
+	// nextUpdateTime is the unix timestamp in seconds for the next update of generation.
+	nextUpdateTime uint64
+}
+
+func (c *dataGenerationCache) get(ctx context.Context) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	currentTime := fasttime.UnixTimestamp()
+	if currentTime < c.nextUpdateTime {
+		return c.generation
+	}
+
+	// Unavailable storage nodes change the generation, so cached results are dropped when they become available again.
+	// This is OK, since they may have deleted spans while being unavailable.
+	generation, err := getClusterDataGeneration(ctx)
+	if err != nil {
+		clusterDataGenerationLogger.Warnf("%s", err)
+	}
+	c.generation = generation
+	c.nextUpdateTime = currentTime + clusterDataGenerationUpdateInterval
+	return c.generation
+}
+
+// getClusterDataGeneration returns the generation of spans across all the -storageNode and -storageGroup nodes.
+//
+// If some storage nodes are unavailable, then the generation across the available storage nodes is returned together with an error.
+func getClusterDataGeneration(ctx context.Context) (uint64, error) {
+	var generation uint64
+	var errs []error
+	for _, g := range runNetworkAdminRequest(ctx, DataGenerationPath, nil) {
+		for _, resp := range g.Nodes {
+			if resp.Error != "" {
+				errs = append(errs, fmt.Errorf("cannot obtain the data generation from storage node %s: %s", resp.Addr, resp.Error))
+				continue
+			}
+			var n uint64
+			if err := json.Unmarshal(resp.Result, &n); err != nil {
+				errs = append(errs, fmt.Errorf("cannot parse the data generation from storage node %s: %w", resp.Addr, err))
+				continue
+			}
+			generation += n
+		}
+	}
+	return generation, errors.Join(errs...)
+}
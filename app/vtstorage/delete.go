@@ -0,0 +1,845 @@
+package vtstorage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/timeutil"
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtstorage/retention"
+	otelpb "github.com/VictoriaMetrics/VictoriaTraces/lib/protoparser/opentelemetry/pb"
+)
+
+var deleteAuthKey = flagutil.NewPassword("deleteAuthKey", "authKey, which must be passed in query string to /internal/delete and /internal/delete/status . It overrides -httpAuth.* . "+
+	"See https://docs.victoriametrics.com/victoriatraces/#deleting-trace-spans")
+
+// deleteTasksFilename is the name of the file at -storageDataPath, which holds delete tasks.
+const deleteTasksFilename = "delete-tasks.json"
+
+// deleteTaskBatchSize is the maximum number of trace IDs, which are checked by a single query when executing delete task.
+const deleteTaskBatchSize = 1000
+
+// deleteTaskMaxTraceIDs is the maximum number of trace IDs, which can be collected by a single delete task with a filter.
+const deleteTaskMaxTraceIDs = 1_000_000
+
+// deletePartitionMinAge is the minimum age for per-day partitions to be rewritten by delete tasks.
+//
+// Younger partitions aren't rewritten, since they are actively written. The rewrite of such partitions would be canceled
+// because of too many spans ingested during the rewrite. See partitionRewriteMaxBufferedRows.
+const deletePartitionMinAge = time.Hour
+
+// deleteTasksInterval is the interval between checks for partitions, which must be rewritten by delete tasks.
+const deleteTasksInterval = time.Hour
+
+var (
+	deletePartitionsRewritten = metrics.NewCounter(`vt_delete_partitions_rewritten_total`)
+	deletePartitionsFailed    = metrics.NewCounter(`vt_delete_partitions_failed_total`)
+	deleteRowsDeleted         = metrics.NewCounter(`vt_delete_rows_deleted_total`)
+	deleteBytesReclaimed      = metrics.NewCounter(`vt_delete_bytes_reclaimed_total`)
+)
+
+// Delete task statuses.
+const (
+	deleteTaskStatusPending = "pending"
+	deleteTaskStatusRunning = "running"
+	deleteTaskStatusDone    = "done"
+	deleteTaskStatusFailed  = "failed"
+)
+
+var deleteTaskIDRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// deleteTask is a request for deleting trace spans.
+//
+// The task is pending until the trace_id_idx_stream rows to delete are collected. Then the task is running until
+// all the per-day partitions with the matching spans are rewritten without these spans.
+// The matching spans are hidden from all the queries until the task is finished.
+type deleteTask struct {
+	// ID is the unique id of the task.
+	ID string `json:"task_id"`
+
+	// TenantID is the tenant to delete spans from in the form accountID:projectID.
+	TenantID string `json:"tenant_id"`
+
+	// TraceIDs contains trace IDs to delete.
+	TraceIDs []string `json:"trace_ids,omitempty"`
+
+	// Filter contains LogsQL filter for spans to delete.
+	Filter string `json:"filter,omitempty"`
+
+	// Start and End contain the time range in nanoseconds for spans to delete.
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+
+	// IndexTraceIDs contains trace IDs for Filter, which have no remaining spans after the deletion.
+	//
+	// The trace_id_idx_stream rows for these trace IDs are deleted after the task is done.
+	IndexTraceIDs []string `json:"index_trace_ids,omitempty"`
+
+	// Status is the task status: pending, running, done or failed.
+	Status string `json:"status"`
+
+	// Error contains the error for failed task or the last error for running task.
+	//
+	// Running task is retried on the next check for partitions to rewrite.
+	Error string `json:"error,omitempty"`
+
+	// PendingPartitions contains per-day partitions with the matching spans, which are too young for the rewrite.
+	//
+	// See deletePartitionMinAge.
+	PendingPartitions []string `json:"pending_partitions,omitempty"`
+
+	// RowsDeleted is the number of deleted spans and trace_id_idx_stream rows.
+	RowsDeleted uint64 `json:"rows_deleted"`
+
+	// CreatedAt is the task creation time in RFC3339 format.
+	CreatedAt string `json:"created_at"`
+
+	// FinishedAt is the task finish time in RFC3339 format.
+	FinishedAt string `json:"finished_at,omitempty"`
+}
+
+// tenantID returns the tenant for dt.
+func (dt *deleteTask) tenantID() logstorage.TenantID {
+	tenantID, err := logstorage.ParseTenantID(dt.TenantID)
+	if err != nil {
+		logger.Panicf("BUG: unexpected tenant_id=%q for delete task %q: %s", dt.TenantID, dt.ID, err)
+	}
+	return tenantID
+}
+
+// indexStart returns the start of the time range in nanoseconds for trace_id_idx_stream rows deleted by dt.
+//
+// trace_id_idx_stream rows are stored with the timestamp of the trace start, so the time range is extended by a day.
+func (dt *deleteTask) indexStart() int64 {
+	return max(dt.Start-24*3600*1e9, 0)
+}
+
+// filterString returns LogsQL filter, which matches spans and trace_id_idx_stream rows deleted by dt.
+func (dt *deleteTask) filterString() string {
+	timeFilter := fmt.Sprintf("_time:[%s, %s]", timestampToString(dt.Start), timestampToString(dt.End))
+	indexTimeFilter := fmt.Sprintf("_time:[%s, %s]", timestampToString(dt.indexStart()), timestampToString(dt.End))
+
+	var filters []string
+	if len(dt.TraceIDs) > 0 {
+		traceIDs := quoteStrings(dt.TraceIDs)
+		filters = append(filters, fmt.Sprintf("(%s %s:in(%s))", timeFilter, otelpb.TraceIDField, traceIDs))
+		filters = append(filters, fmt.Sprintf("(%s %s:in(%s))", indexTimeFilter, otelpb.TraceIDIndexFieldName, traceIDs))
+	}
+	if dt.Filter != "" {
+		filters = append(filters, fmt.Sprintf("(%s (%s))", timeFilter, dt.Filter))
+	}
+	if len(dt.IndexTraceIDs) > 0 {
+		filters = append(filters, fmt.Sprintf("(%s %s:in(%s))", indexTimeFilter, otelpb.TraceIDIndexFieldName, quoteStrings(dt.IndexTraceIDs)))
+	}
+	return strings.Join(filters, " OR ")
+}
+
+// formatTenantID returns tenantID in the form accountID:projectID, which can be parsed by logstorage.ParseTenantID.
+func formatTenantID(tenantID logstorage.TenantID) string {
+	return fmt.Sprintf("%d:%d", tenantID.AccountID, tenantID.ProjectID)
+}
+
+func quoteStrings(a []string) string {
+	quoted := make([]string, len(a))
+	for i, s := range a {
+		quoted[i] = fmt.Sprintf("%q", s)
+	}
+	return strings.Join(quoted, ",")
+}
+
+func timestampToString(nsecs int64) string {
+	t := time.Unix(nsecs/1e9, nsecs%1e9).UTC()
+	return t.Format(time.RFC3339Nano)
+}
+
+// deleteTasksManager manages delete tasks for the local storage.
+type deleteTasksManager struct {
+	// dataPath is the path to -storageDataPath.
+	dataPath string
+
+	// path is the path to the file with delete tasks.
+	path string
+
+	tasksLock sync.Mutex
+	tasks     []*deleteTask
+
+	// filters contains per-tenant filters, which must be applied to all the queries in order to exclude spans being deleted.
+	filters atomic.Pointer[map[logstorage.TenantID]*logstorage.Filter]
+
+	// wakeupCh is used for notifying the worker about new tasks.
+	wakeupCh chan struct{}
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+var deleteTasks *deleteTasksManager
+
+// mustOpenDeleteTasksManager opens delete tasks manager for the tasks stored at dataPath.
+//
+// Call start for resuming unfinished tasks.
+func mustOpenDeleteTasksManager(dataPath string) *deleteTasksManager {
+	dtm := &deleteTasksManager{
+		dataPath: dataPath,
+		path:     filepath.Join(dataPath, deleteTasksFilename),
+		wakeupCh: make(chan struct{}, 1),
+		stopCh:   make(chan struct{}),
+	}
+
+	if fs.IsPathExist(dtm.path) {
+		data, err := os.ReadFile(dtm.path)
+		if err != nil {
+			logger.Panicf("FATAL: cannot read delete tasks: %s", err)
+		}
+		if err := json.Unmarshal(data, &dtm.tasks); err != nil {
+			logger.Panicf("FATAL: cannot parse delete tasks from %q: %s", dtm.path, err)
+		}
+	}
+	dtm.mustUpdateFilters()
+
+	_ = metrics.GetOrCreateGauge(`vt_delete_tasks{status="pending"}`, func() float64 {
+		return float64(countDeleteTasks(deleteTaskStatusPending) + countDeleteTasks(deleteTaskStatusRunning))
+	})
+	_ = metrics.GetOrCreateGauge(`vt_delete_tasks{status="done"}`, func() float64 {
+		return float64(countDeleteTasks(deleteTaskStatusDone))
+	})
+	_ = metrics.GetOrCreateGauge(`vt_delete_tasks{status="failed"}`, func() float64 {
+		return float64(countDeleteTasks(deleteTaskStatusFailed))
+	})
+
+	return dtm
+}
+
+// start starts processing delete tasks in background.
+func (dtm *deleteTasksManager) start() {
+	dtm.wg.Add(1)
+	go func() {
+		defer dtm.wg.Done()
+		dtm.runWorker()
+	}()
+}
+
+// mustStop stops dtm. The unfinished tasks are resumed on the next start.
+func (dtm *deleteTasksManager) mustStop() {
+	close(dtm.stopCh)
+	dtm.wg.Wait()
+}
+
+func countDeleteTasks(status string) int {
+	dtm := deleteTasks
+	if dtm == nil {
+		return 0
+	}
+	return dtm.countTasks(status)
+}
+
+func (dtm *deleteTasksManager) countTasks(status string) int {
+	dtm.tasksLock.Lock()
+	defer dtm.tasksLock.Unlock()
+
+	n := 0
+	for _, dt := range dtm.tasks {
+		if dt.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// addTask adds dt to dtm.
+//
+// The spans matching dt become invisible for queries after returning from addTask.
+func (dtm *deleteTasksManager) addTask(dt *deleteTask) error {
+	dtm.tasksLock.Lock()
+	for _, x := range dtm.tasks {
+		if x.ID == dt.ID {
+			dtm.tasksLock.Unlock()
+			return fmt.Errorf("delete task with task_id=%q already exists", dt.ID)
+		}
+	}
+	dtm.tasks = append(dtm.tasks, dt)
+	dtm.mustSaveTasksLocked()
+	dtm.tasksLock.Unlock()
+
+	dtm.mustUpdateFilters()
+
+	select {
+	case dtm.wakeupCh <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// getTasks returns copies of tasks with the given taskID. All the tasks are returned if taskID is empty.
+func (dtm *deleteTasksManager) getTasks(taskID string) []deleteTask {
+	dtm.tasksLock.Lock()
+	defer dtm.tasksLock.Unlock()
+
+	var tasks []deleteTask
+	for _, dt := range dtm.tasks {
+		if taskID == "" || dt.ID == taskID {
+			tasks = append(tasks, *dt)
+		}
+	}
+	return tasks
+}
+
+// getFilter returns filter, which excludes spans being deleted for the given tenantID.
+//
+// nil is returned if there are no unfinished delete tasks for the given tenantID.
+func (dtm *deleteTasksManager) getFilter(tenantID logstorage.TenantID) *logstorage.Filter {
+	filters := *dtm.filters.Load()
+	return filters[tenantID]
+}
+
+// mustUpdateFilters re-creates filters for the unfinished tasks.
+//
+// Finished tasks do not need filters, since the spans for them are already deleted.
+func (dtm *deleteTasksManager) mustUpdateFilters() {
+	dtm.tasksLock.Lock()
+	filtersPerTenant := make(map[logstorage.TenantID][]string)
+	for _, dt := range dtm.tasks {
+		if dt.Status != deleteTaskStatusPending && dt.Status != deleteTaskStatusRunning {
+			continue
+		}
+		tenantID := dt.tenantID()
+		filtersPerTenant[tenantID] = append(filtersPerTenant[tenantID], dt.filterString())
+	}
+	dtm.tasksLock.Unlock()
+
+	filters := make(map[logstorage.TenantID]*logstorage.Filter, len(filtersPerTenant))
+	for tenantID, a := range filtersPerTenant {
+		s := fmt.Sprintf("!(%s)", strings.Join(a, " OR "))
+		f, err := logstorage.ParseFilter(s)
+		if err != nil {
+			logger.Panicf("BUG: cannot parse delete filter %q: %s", s, err)
+		}
+		filters[tenantID] = f
+	}
+	dtm.filters.Store(&filters)
+}
+
+func (dtm *deleteTasksManager) mustSaveTasksLocked() {
+	data, err := json.Marshal(dtm.tasks)
+	if err != nil {
+		logger.Panicf("BUG: cannot marshal delete tasks: %s", err)
+	}
+	fs.MustWriteAtomic(dtm.path, data, true)
+}
+
+func (dtm *deleteTasksManager) runWorker() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-dtm.stopCh
+		cancel()
+	}()
+
+	d := timeutil.AddJitterToDuration(deleteTasksInterval)
+	t := time.NewTicker(d)
+	defer t.Stop()
+
+	for {
+		dtm.removeExpiredTasks()
+		for {
+			dt := dtm.getPendingTask()
+			if dt == nil {
+				break
+			}
+			dtm.runPendingTask(ctx, dt)
+			if ctx.Err() != nil {
+				return
+			}
+		}
+		dtm.processRunningTasks(ctx)
+
+		select {
+		case <-dtm.stopCh:
+			return
+		case <-dtm.wakeupCh:
+		case <-t.C:
+		}
+	}
+}
+
+// removeExpiredTasks removes finished tasks for spans, which are already dropped according to the retention.
+func (dtm *deleteTasksManager) removeExpiredTasks() {
+	minTimestamp := time.Now().Add(-retention.MaxRetention() - 24*time.Hour).UnixNano()
+
+	dtm.tasksLock.Lock()
+	defer dtm.tasksLock.Unlock()
+
+	n := len(dtm.tasks)
+	dtm.tasks = slices.DeleteFunc(dtm.tasks, func(dt *deleteTask) bool {
+		return (dt.Status == deleteTaskStatusDone || dt.Status == deleteTaskStatusFailed) && dt.End < minTimestamp
+	})
+	if n != len(dtm.tasks) {
+		dtm.mustSaveTasksLocked()
+	}
+}
+
+// getPendingTask returns a copy of the first pending task, so it could be processed without holding the lock.
+func (dtm *deleteTasksManager) getPendingTask() *deleteTask {
+	dtm.tasksLock.Lock()
+	defer dtm.tasksLock.Unlock()
+
+	for _, dt := range dtm.tasks {
+		if dt.Status == deleteTaskStatusPending {
+			dtCopy := *dt
+			return &dtCopy
+		}
+	}
+	return nil
+}
+
+// getRunningTasks returns copies of running tasks, so they could be processed without holding the lock.
+func (dtm *deleteTasksManager) getRunningTasks() []*deleteTask {
+	dtm.tasksLock.Lock()
+	defer dtm.tasksLock.Unlock()
+
+	var tasks []*deleteTask
+	for _, dt := range dtm.tasks {
+		if dt.Status == deleteTaskStatusRunning {
+			dtCopy := *dt
+			tasks = append(tasks, &dtCopy)
+		}
+	}
+	return tasks
+}
+
+// updateTask calls f for the task with the given taskID and persists the changes.
+func (dtm *deleteTasksManager) updateTask(taskID string, f func(dt *deleteTask)) {
+	dtm.tasksLock.Lock()
+	defer dtm.tasksLock.Unlock()
+
+	for _, dt := range dtm.tasks {
+		if dt.ID == taskID {
+			f(dt)
+		}
+	}
+	dtm.mustSaveTasksLocked()
+}
+
+// runPendingTask collects trace_id_idx_stream rows to delete for dt and switches it to running state.
+func (dtm *deleteTasksManager) runPendingTask(ctx context.Context, dt *deleteTask) {
+	indexTraceIDs, err := getIndexTraceIDsForDeleteTask(ctx, dt)
+	if ctx.Err() != nil {
+		// The task has been interrupted. It will be resumed after the restart.
+		logger.Infof("delete task %q has been interrupted; it will be resumed after the restart", dt.ID)
+		return
+	}
+
+	dtm.updateTask(dt.ID, func(dt *deleteTask) {
+		if err != nil {
+			dt.Status = deleteTaskStatusFailed
+			dt.Error = err.Error()
+			dt.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+			return
+		}
+		dt.Status = deleteTaskStatusRunning
+		dt.IndexTraceIDs = indexTraceIDs
+	})
+	dtm.mustUpdateFilters()
+
+	if err != nil {
+		logger.Errorf("delete task %q failed: %s", dt.ID, err)
+	}
+}
+
+// processRunningTasks rewrites per-day partitions with spans for the running tasks, so they no longer contain these spans.
+//
+// Tasks without remaining partitions to rewrite are marked as done.
+func (dtm *deleteTasksManager) processRunningTasks(ctx context.Context) {
+	tasks := dtm.getRunningTasks()
+	if len(tasks) == 0 {
+		return
+	}
+
+	tenantIDs := knownTenantsInstance.getTenantIDs()
+	deadline := time.Now().Add(-deletePartitionMinAge)
+
+	pendingPartitions := make(map[string][]string)
+	rowsDeleted := make(map[string]uint64)
+	errs := make(map[string]error)
+
+	// Delete the matching spans from the archive. This is retried on every call until the task is done.
+	// The archive doesn't receive new spans for the deleted traces, since archived spans are copied from the local storage with delete filters.
+	for _, dt := range tasks {
+		if _, err := archive.deleteSpans(ctx, dt); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			errs[dt.ID] = fmt.Errorf("cannot delete spans from the archive: %w", err)
+		}
+	}
+
+	for _, name := range localStorage.PartitionList() {
+		day, err := time.Parse("20060102", name)
+		if err != nil {
+			continue
+		}
+		start := day.UnixNano()
+		end := day.Add(24 * time.Hour).UnixNano()
+
+		// Collect tasks with spans at the partition.
+		var partitionTasks []*deleteTask
+		for _, dt := range tasks {
+			if dt.indexStart() >= end || dt.End < start {
+				continue
+			}
+			rows, err := countRows(ctx, localStorage, dt.tenantID(), start, end, "("+dt.filterString()+")")
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				errs[dt.ID] = err
+				continue
+			}
+			if rows > 0 {
+				partitionTasks = append(partitionTasks, dt)
+			}
+		}
+		if len(partitionTasks) == 0 {
+			continue
+		}
+
+		if day.Add(24 * time.Hour).After(deadline) {
+			// The partition may still receive spans, so it is rewritten later.
+			for _, dt := range partitionTasks {
+				pendingPartitions[dt.ID] = append(pendingPartitions[dt.ID], name)
+			}
+			continue
+		}
+
+		logger.Infof("rewriting partition %q by %d delete tasks", name, len(partitionTasks))
+		startTime := time.Now()
+		partitionsMoveLock.Lock()
+		stats, err := rewriteDeletePartition(ctx, dtm.dataPath, name, start, end, tenantIDs, partitionTasks)
+		partitionsMoveLock.Unlock()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Errorf("cannot rewrite partition %q by delete tasks: %s", name, err)
+			deletePartitionsFailed.Inc()
+			for _, dt := range partitionTasks {
+				errs[dt.ID] = fmt.Errorf("cannot rewrite partition %q: %w", name, err)
+			}
+			continue
+		}
+
+		deletePartitionsRewritten.Inc()
+		deleteRowsDeleted.AddInt64(int64(stats.RowsBefore - stats.RowsAfter))
+		if stats.BytesBefore > stats.BytesAfter {
+			deleteBytesReclaimed.AddInt64(int64(stats.BytesBefore - stats.BytesAfter))
+		}
+		for _, dt := range partitionTasks {
+			// Rows matching multiple tasks are accounted in every task.
+			rowsDeleted[dt.ID] += stats.RowsBefore - stats.RowsAfter
+		}
+		logger.Infof("partition %q has been rewritten by delete tasks in %.3f seconds; rows: %d -> %d; bytes: %d -> %d",
+			name, time.Since(startTime).Seconds(), stats.RowsBefore, stats.RowsAfter, stats.BytesBefore, stats.BytesAfter)
+	}
+
+	finished := false
+	for _, dt := range tasks {
+		err := errs[dt.ID]
+		pending := pendingPartitions[dt.ID]
+		dtm.updateTask(dt.ID, func(dt *deleteTask) {
+			dt.PendingPartitions = pending
+			dt.RowsDeleted += rowsDeleted[dt.ID]
+			if err != nil {
+				dt.Error = err.Error()
+				return
+			}
+			dt.Error = ""
+			if len(pending) == 0 {
+				dt.Status = deleteTaskStatusDone
+				dt.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+				finished = true
+			}
+		})
+		if err == nil && len(pending) == 0 {
+			logger.Infof("delete task %q has been finished", dt.ID)
+		}
+	}
+	if finished {
+		dtm.mustUpdateFilters()
+	}
+}
+
+// rewriteDeletePartition rewrites the partition with the given name on the time range [start, end), so it doesn't contain rows matching the given tasks.
+func rewriteDeletePartition(ctx context.Context, dataPath, name string, start, end int64, tenantIDs []logstorage.TenantID, tasks []*deleteTask) (*partitionRewriteStats, error) {
+	filtersPerTenant := make(map[logstorage.TenantID][]string)
+	for _, dt := range tasks {
+		tenantID := dt.tenantID()
+		filtersPerTenant[tenantID] = append(filtersPerTenant[tenantID], dt.filterString())
+	}
+
+	return rewritePartitionExcluding(ctx, localStorage, dataPath, name, start, end, tenantIDs, filtersPerTenant)
+}
+
+// getIndexTraceIDsForDeleteTask returns trace IDs for dt.Filter, which have no remaining spans after the deletion.
+func getIndexTraceIDsForDeleteTask(ctx context.Context, dt *deleteTask) ([]string, error) {
+	if dt.Filter == "" {
+		// trace_id_idx_stream rows for dt.TraceIDs are deleted together with spans.
+		return nil, nil
+	}
+
+	tenantIDs := []logstorage.TenantID{dt.tenantID()}
+	timeFilter := fmt.Sprintf("_time:[%s, %s]", timestampToString(dt.Start), timestampToString(dt.End))
+
+	// Collect trace IDs for the deleted spans.
+	qStr := fmt.Sprintf("%s (%s) | uniq by (%s) limit %d", timeFilter, dt.Filter, otelpb.TraceIDField, deleteTaskMaxTraceIDs+1)
+	traceIDs, err := getUniqTraceIDs(ctx, tenantIDs, qStr)
+	if err != nil {
+		return nil, err
+	}
+	if len(traceIDs) > deleteTaskMaxTraceIDs {
+		return nil, fmt.Errorf("the filter matches more than %d trace IDs; narrow down the filter or the time range", deleteTaskMaxTraceIDs)
+	}
+
+	// Exclude trace IDs with the remaining spans.
+	var indexTraceIDs []string
+	for len(traceIDs) > 0 {
+		n := min(len(traceIDs), deleteTaskBatchSize)
+		batch := traceIDs[:n]
+		traceIDs = traceIDs[n:]
+
+		qStr := fmt.Sprintf("%s:in(%s) !(%s (%s)) | uniq by (%s)", otelpb.TraceIDField, quoteStrings(batch), timeFilter, dt.Filter, otelpb.TraceIDField)
+		remainingTraceIDs, err := getUniqTraceIDs(ctx, tenantIDs, qStr)
+		if err != nil {
+			return nil, err
+		}
+		for _, traceID := range batch {
+			if !slices.Contains(remainingTraceIDs, traceID) {
+				indexTraceIDs = append(indexTraceIDs, traceID)
+			}
+		}
+	}
+	return indexTraceIDs, nil
+}
+
+// getUniqTraceIDs returns unique trace IDs returned by qStr query over the local storage.
+//
+// The query is executed without delete filters.
+func getUniqTraceIDs(ctx context.Context, tenantIDs []logstorage.TenantID, qStr string) ([]string, error) {
+	q, err := logstorage.ParseQueryAtTimestamp(qStr, time.Now().UnixNano())
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse query [%s]: %w", qStr, err)
+	}
+
+	var traceIDsLock sync.Mutex
+	var traceIDs []string
+	writeBlock := func(_ uint, db *logstorage.DataBlock) {
+		c := db.GetColumnByName(otelpb.TraceIDField)
+		if c == nil {
+			return
+		}
+		traceIDsLock.Lock()
+		for _, v := range c.Values {
+			if v != "" {
+				traceIDs = append(traceIDs, strings.Clone(v))
+			}
+		}
+		traceIDsLock.Unlock()
+	}
+
+	var qs logstorage.QueryStats
+	qctx := logstorage.NewQueryContext(ctx, &qs, tenantIDs, q)
+	if err := localStorage.RunQuery(qctx, writeBlock); err != nil {
+		return nil, fmt.Errorf("cannot execute query [%s]: %w", qStr, err)
+	}
+	return traceIDs, nil
+}
+
+// processDelete creates delete task from r.
+//
+// The following args are supported:
+//
+//   - trace_id - trace IDs to delete. The arg can be passed multiple times.
+//   - filter - LogsQL filter for spans to delete.
+//   - start and end - the time range for spans to delete. The time range is required for the filter.
+//   - task_id - optional unique id for the task. It is generated automatically if missing.
+//   - tenant_id - optional tenant in the form accountID:projectID. It is obtained from AccountID and ProjectID request headers if missing.
+func processDelete(w http.ResponseWriter, r *http.Request) bool {
+	if !httpserver.CheckAuthFlag(w, r, deleteAuthKey) {
+		return true
+	}
+	if err := r.ParseForm(); err != nil {
+		httpserver.Errorf(w, r, "cannot parse request args: %s", err)
+		return true
+	}
+	if r.FormValue("task_id") == "" {
+		// Generate task_id, so it is the same at all the storage nodes in cluster mode.
+		r.Form.Set("task_id", fmt.Sprintf("%d", time.Now().UnixNano()))
+	}
+	if err := setTenantIDArg(r); err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return true
+	}
+
+	if localStorage == nil {
+		return processClusterAdminRequest(w, r, deleteAuthKey)
+	}
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return true
+	}
+
+	dt, err := newDeleteTaskFromRequest(r)
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return true
+	}
+	if err := deleteTasks.addTask(dt); err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return true
+	}
+
+	writeJSONResponse(w, dt)
+	return true
+}
+
+// setTenantIDArg sets tenant_id arg at r from AccountID and ProjectID request headers if it is missing.
+//
+// This is needed for passing the tenant to the storage nodes in cluster mode, since request headers aren't proxied to them.
+//
+// r.ParseForm must be called before calling setTenantIDArg.
+func setTenantIDArg(r *http.Request) error {
+	if r.FormValue("tenant_id") != "" {
+		return nil
+	}
+	tenantID, err := logstorage.GetTenantIDFromRequest(r)
+	if err != nil {
+		return fmt.Errorf("cannot obtain tenantID: %w", err)
+	}
+	r.Form.Set("tenant_id", formatTenantID(tenantID))
+	return nil
+}
+
+func newDeleteTaskFromRequest(r *http.Request) (*deleteTask, error) {
+	taskID := r.FormValue("task_id")
+	if !deleteTaskIDRegexp.MatchString(taskID) {
+		return nil, fmt.Errorf("task_id=%q must contain from 1 to 64 alphanumeric chars, '_' or '-'", taskID)
+	}
+
+	tenantID, err := logstorage.ParseTenantID(r.FormValue("tenant_id"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse tenant_id=%q: %w", r.FormValue("tenant_id"), err)
+	}
+
+	var traceIDs []string
+	for _, s := range r.Form["trace_id"] {
+		for _, traceID := range strings.Split(s, ",") {
+			if traceID = strings.TrimSpace(traceID); traceID != "" {
+				traceIDs = append(traceIDs, traceID)
+			}
+		}
+	}
+
+	filter := r.FormValue("filter")
+	if filter != "" {
+		f, err := logstorage.ParseFilter(filter)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse filter=%q: %w", filter, err)
+		}
+		filter = f.String()
+	}
+
+	if len(traceIDs) == 0 && filter == "" {
+		return nil, fmt.Errorf("missing trace_id or filter arg")
+	}
+	if len(traceIDs) > 0 && filter != "" {
+		return nil, fmt.Errorf("trace_id and filter args cannot be set simultaneously")
+	}
+
+	currentTime := time.Now()
+	start, err := getTimeNsec(r, "start", currentTime.Add(-retention.MaxRetention()).UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	end, err := getTimeNsec(r, "end", currentTime.Add(futureRetention.Duration()).UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	if filter != "" && (r.FormValue("start") == "" || r.FormValue("end") == "") {
+		return nil, fmt.Errorf("start and end args must be set for the filter")
+	}
+	if start > end {
+		return nil, fmt.Errorf("start=%s cannot exceed end=%s", timestampToString(start), timestampToString(end))
+	}
+
+	dt := &deleteTask{
+		ID:        taskID,
+		TenantID:  formatTenantID(tenantID),
+		TraceIDs:  traceIDs,
+		Filter:    filter,
+		Start:     start,
+		End:       end,
+		Status:    deleteTaskStatusPending,
+		CreatedAt: currentTime.UTC().Format(time.RFC3339),
+	}
+	return dt, nil
+}
+
+func getTimeNsec(r *http.Request, argName string, defaultValue int64) (int64, error) {
+	s := r.FormValue(argName)
+	if s == "" {
+		return defaultValue, nil
+	}
+	nsecs, err := timeutil.ParseTimeAt(s, time.Now().UnixNano())
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse %s=%s: %w", argName, s, err)
+	}
+	if nsecs < 0 {
+		nsecs = 0
+	}
+	if nsecs > math.MaxInt64/2 {
+		nsecs = math.MaxInt64 / 2
+	}
+	return nsecs, nil
+}
+
+// processDeleteStatus returns delete tasks with the given task_id or all the delete tasks if task_id is missing.
+func processDeleteStatus(w http.ResponseWriter, r *http.Request) bool {
+	if localStorage == nil {
+		return processClusterAdminRequest(w, r, deleteAuthKey)
+	}
+
+	if !httpserver.CheckAuthFlag(w, r, deleteAuthKey) {
+		return true
+	}
+
+	taskID := r.FormValue("task_id")
+	tasks := deleteTasks.getTasks(taskID)
+	if taskID != "" && len(tasks) == 0 {
+		httpserver.Errorf(w, r, "cannot find delete task with task_id=%q", taskID)
+		return true
+	}
+	if tasks == nil {
+		// This is needed in order to return `[]` instead of `null` to the client.
+		tasks = []deleteTask{}
+	}
+
+	writeJSONResponse(w, tasks)
+	return true
+}
@@ -0,0 +1,404 @@
+package vtstorage
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+)
+
+func TestDeleteTaskFilterString(t *testing.T) {
+	f := func(dt *deleteTask, resultExpected string) {
+		t.Helper()
+
+		result := dt.filterString()
+		if result != resultExpected {
+			t.Fatalf("unexpected filter\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+		if _, err := logstorage.ParseFilter(result); err != nil {
+			t.Fatalf("cannot parse filter %q: %s", result, err)
+		}
+	}
+
+	// trace IDs
+	f(&deleteTask{
+		TraceIDs: []string{"a", "b"},
+		Start:    0,
+		End:      1e9,
+	}, `(_time:[1970-01-01T00:00:00Z, 1970-01-01T00:00:01Z] trace_id:in("a","b")) OR (_time:[1970-01-01T00:00:00Z, 1970-01-01T00:00:01Z] trace_id_idx:in("a","b"))`)
+
+	// trace IDs on the time range starting later than a day after the unix epoch
+	f(&deleteTask{
+		TraceIDs: []string{"a"},
+		Start:    2 * 24 * 3600 * 1e9,
+		End:      3 * 24 * 3600 * 1e9,
+	}, `(_time:[1970-01-03T00:00:00Z, 1970-01-04T00:00:00Z] trace_id:in("a")) OR (_time:[1970-01-02T00:00:00Z, 1970-01-04T00:00:00Z] trace_id_idx:in("a"))`)
+
+	// filter without index trace IDs
+	f(&deleteTask{
+		Filter: `"resource_attr:service.name":=foo`,
+		Start:  0,
+		End:    1e9,
+	}, `(_time:[1970-01-01T00:00:00Z, 1970-01-01T00:00:01Z] ("resource_attr:service.name":=foo))`)
+
+	// filter with index trace IDs
+	f(&deleteTask{
+		Filter:        `"resource_attr:service.name":=foo`,
+		Start:         0,
+		End:           1e9,
+		IndexTraceIDs: []string{"a"},
+	}, `(_time:[1970-01-01T00:00:00Z, 1970-01-01T00:00:01Z] ("resource_attr:service.name":=foo)) OR (_time:[1970-01-01T00:00:00Z, 1970-01-01T00:00:01Z] trace_id_idx:in("a"))`)
+}
+
+func TestNewDeleteTaskFromRequest(t *testing.T) {
+	newRequest := func(args url.Values) *http.Request {
+		t.Helper()
+
+		r, err := http.NewRequest(http.MethodGet, "/internal/delete?"+args.Encode(), nil)
+		if err != nil {
+			t.Fatalf("cannot create request: %s", err)
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("cannot parse request args: %s", err)
+		}
+		return r
+	}
+
+	fFailure := func(args url.Values) {
+		t.Helper()
+
+		if _, err := newDeleteTaskFromRequest(newRequest(args)); err == nil {
+			t.Fatalf("expecting non-nil error for args %s", args.Encode())
+		}
+	}
+
+	// missing task_id
+	fFailure(url.Values{"trace_id": {"a"}})
+
+	// invalid task_id
+	fFailure(url.Values{"task_id": {"../foo"}, "trace_id": {"a"}})
+
+	// invalid tenant_id
+	fFailure(url.Values{"task_id": {"foo"}, "tenant_id": {"bar"}, "trace_id": {"a"}})
+
+	// missing trace_id and filter
+	fFailure(url.Values{"task_id": {"foo"}})
+
+	// both trace_id and filter
+	fFailure(url.Values{"task_id": {"foo"}, "trace_id": {"a"}, "filter": {"*"}, "start": {"0"}, "end": {"1"}})
+
+	// filter without time range
+	fFailure(url.Values{"task_id": {"foo"}, "filter": {"foo"}})
+
+	// filter with pipes
+	fFailure(url.Values{"task_id": {"foo"}, "filter": {"foo | count()"}, "start": {"0"}, "end": {"1"}})
+
+	// start exceeds end
+	fFailure(url.Values{"task_id": {"foo"}, "trace_id": {"a"}, "start": {"2"}, "end": {"1"}})
+
+	// trace IDs
+	dt, err := newDeleteTaskFromRequest(newRequest(url.Values{
+		"task_id":   {"foo"},
+		"tenant_id": {"12:34"},
+		"trace_id":  {"a,b", "c"},
+		"start":     {"1"},
+		"end":       {"2"},
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if dt.ID != "foo" || dt.TenantID != "12:34" {
+		t.Fatalf("unexpected task: %+v", dt)
+	}
+	if len(dt.TraceIDs) != 3 || dt.TraceIDs[0] != "a" || dt.TraceIDs[1] != "b" || dt.TraceIDs[2] != "c" {
+		t.Fatalf("unexpected trace IDs: %q", dt.TraceIDs)
+	}
+	if dt.Start != 1e9 || dt.End != 2e9 {
+		t.Fatalf("unexpected time range: [%d, %d]", dt.Start, dt.End)
+	}
+	if dt.Status != deleteTaskStatusPending {
+		t.Fatalf("unexpected status; got %q; want %q", dt.Status, deleteTaskStatusPending)
+	}
+
+	// filter
+	dt, err = newDeleteTaskFromRequest(newRequest(url.Values{
+		"task_id": {"bar"},
+		"filter":  {"name:=foo"},
+		"start":   {"1"},
+		"end":     {"2"},
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if dt.Filter != "name:=foo" {
+		t.Fatalf("unexpected filter; got %q; want %q", dt.Filter, "name:=foo")
+	}
+	if dt.Status != deleteTaskStatusPending {
+		t.Fatalf("unexpected status; got %q; want %q", dt.Status, deleteTaskStatusPending)
+	}
+}
+
+func TestDeleteTasksManagerPersistence(t *testing.T) {
+	dataPath := t.TempDir()
+
+	tenantID := logstorage.TenantID{AccountID: 1, ProjectID: 2}
+	dt := &deleteTask{
+		ID:       "foo",
+		TenantID: formatTenantID(tenantID),
+		TraceIDs: []string{"a"},
+		// The time range must be within the retention, since tasks for the expired data are removed.
+		Start:  time.Now().Add(-time.Hour).UnixNano(),
+		End:    time.Now().UnixNano(),
+		Status: deleteTaskStatusRunning,
+	}
+
+	dtm := mustOpenDeleteTasksManager(dataPath)
+	if err := dtm.addTask(dt); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	dtFinished := &deleteTask{
+		ID:       "baz",
+		TenantID: formatTenantID(logstorage.TenantID{AccountID: 4}),
+		TraceIDs: []string{"b"},
+		Start:    dt.Start,
+		End:      dt.End,
+		Status:   deleteTaskStatusDone,
+	}
+	if err := dtm.addTask(dtFinished); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := dtm.addTask(dt); err == nil {
+		t.Fatalf("expecting non-nil error when adding a task with duplicate task_id")
+	}
+	dtm.mustStop()
+
+	// Verify that the task is restored after the restart.
+	dtm = mustOpenDeleteTasksManager(dataPath)
+	defer dtm.mustStop()
+
+	tasks := dtm.getTasks("foo")
+	if len(tasks) != 1 || tasks[0].ID != "foo" || tasks[0].TraceIDs[0] != "a" {
+		t.Fatalf("unexpected tasks after the restart: %+v", tasks)
+	}
+	if tasks := dtm.getTasks("bar"); len(tasks) != 0 {
+		t.Fatalf("unexpected tasks for missing task_id: %+v", tasks)
+	}
+
+	if f := dtm.getFilter(logstorage.TenantID{AccountID: 3}); f != nil {
+		t.Fatalf("unexpected filter for the tenant without delete tasks: %s", f)
+	}
+	if f := dtm.getFilter(logstorage.TenantID{AccountID: 4}); f != nil {
+		t.Fatalf("unexpected filter for the tenant with finished delete tasks: %s", f)
+	}
+	f := dtm.getFilter(tenantID)
+	if f == nil {
+		t.Fatalf("missing filter for the tenant with delete tasks")
+	}
+	filterExpected := fmt.Sprintf(`!(_time:[%s,%s] trace_id:in(a) or _time:[%s,%s] trace_id_idx:in(a))`,
+		timestampToString(dt.Start), timestampToString(dt.End), timestampToString(dt.indexStart()), timestampToString(dt.End))
+	if s := f.String(); s != filterExpected {
+		t.Fatalf("unexpected filter\ngot\n%s\nwant\n%s", s, filterExpected)
+	}
+}
+
+func TestDeleteTasksManagerRewritePartitions(t *testing.T) {
+	dataPath := t.TempDir()
+	mustOpenTestStorage(t, dataPath, 30*24*time.Hour)
+
+	// Ingest spans for the trace "a" at two tenants together with the trace_id_idx entry.
+	tenantA := logstorage.TenantID{AccountID: 1}
+	tenantB := logstorage.TenantID{AccountID: 2}
+	day := time.Now().Add(-3 * 24 * time.Hour).Truncate(24 * time.Hour)
+	timestamp := day.Add(12 * time.Hour).UnixNano()
+	mustAddTestRows(func(lr *logstorage.LogRows) {
+		addTestSpan(lr, tenantA, timestamp, "a", "svc")
+		addTestSpan(lr, tenantA, timestamp, "a", "svc")
+		addTestSpan(lr, tenantA, timestamp, "b", "svc")
+		addTestSpan(lr, tenantB, timestamp, "a", "svc")
+		addTestTraceIDIndex(lr, tenantA, timestamp-1, "a")
+	})
+
+	getRowsCount := func(tenantIDs []logstorage.TenantID, filter string) uint64 {
+		t.Helper()
+
+		q, err := logstorage.ParseQuery(filter + " | count() rows")
+		if err != nil {
+			t.Fatalf("cannot parse query: %s", err)
+		}
+		var qs logstorage.QueryStats
+		qctx := logstorage.NewQueryContext(context.Background(), &qs, tenantIDs, q)
+		var rows uint64
+		writeBlock := func(_ uint, db *logstorage.DataBlock) {
+			if c := db.GetColumnByName("rows"); c != nil && len(c.Values) > 0 {
+				rows, _ = strconv.ParseUint(c.Values[0], 10, 64)
+			}
+		}
+		if err := runLocalQuery(qctx, writeBlock); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return rows
+	}
+
+	// Archive the trace "a", so its spans must be deleted from the archive too.
+	archive = mustOpenTraceArchive(dataPath)
+	defer func() {
+		archive.mustClose()
+		archive = nil
+	}()
+	if _, err := archive.addTrace(context.Background(), tenantA, "a", day.UnixNano(), time.Now().UnixNano()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	deleteTasks = mustOpenDeleteTasksManager(dataPath)
+	defer func() {
+		deleteTasks.mustStop()
+		deleteTasks = nil
+	}()
+	dt := &deleteTask{
+		ID:       "foo",
+		TenantID: formatTenantID(tenantA),
+		TraceIDs: []string{"a"},
+		Start:    day.UnixNano(),
+		End:      time.Now().UnixNano(),
+		Status:   deleteTaskStatusPending,
+	}
+	if err := deleteTasks.addTask(dt); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// The spans being deleted must be hidden only for the tenant of the delete task.
+	if n := getRowsCount([]logstorage.TenantID{tenantA, tenantB}, "trace_id:=a"); n != 1 {
+		t.Fatalf("unexpected number of spans for the trace being deleted at multi-tenant query; got %d; want 1", n)
+	}
+	if n := getRowsCount([]logstorage.TenantID{tenantA}, "*"); n != 1 {
+		t.Fatalf("unexpected number of rows at the tenant with the delete task; got %d; want 1", n)
+	}
+
+	ctx := context.Background()
+	deleteTasks.runPendingTask(ctx, deleteTasks.getPendingTask())
+	deleteTasks.processRunningTasks(ctx)
+
+	tasks := deleteTasks.getTasks("foo")
+	if len(tasks) != 1 || tasks[0].Status != deleteTaskStatusDone || tasks[0].Error != "" {
+		t.Fatalf("unexpected delete task state: %+v", tasks)
+	}
+	if tasks[0].RowsDeleted != 3 {
+		t.Fatalf("unexpected number of deleted rows; got %d; want 3", tasks[0].RowsDeleted)
+	}
+	if f := deleteTasks.getFilter(tenantA); f != nil {
+		t.Fatalf("unexpected filter for the finished delete task: %s", f)
+	}
+
+	// The spans must be physically deleted from the partition.
+	if n := getRowsCount([]logstorage.TenantID{tenantA}, "*"); n != 1 {
+		t.Fatalf("unexpected number of rows at the tenant after the delete; got %d; want 1", n)
+	}
+	if n := getRowsCount([]logstorage.TenantID{tenantB}, "*"); n != 1 {
+		t.Fatalf("unexpected number of rows at the tenant without delete tasks; got %d; want 1", n)
+	}
+	if partitions := localStorage.PartitionList(); len(partitions) != 1 || partitions[0] != day.Format("20060102") {
+		t.Fatalf("unexpected partitions after the delete: %q", partitions)
+	}
+	if fs.IsPathExist(filepath.Join(dataPath, partitionRewriteTmpDirname)) {
+		t.Fatalf("the temporary directory must be removed after the partition rewrite")
+	}
+
+	// The spans must be physically deleted from the archive.
+	if n, err := countRows(ctx, archive.storage, tenantA, day.UnixNano(), time.Now().UnixNano(), ""); err != nil || n != 0 {
+		t.Fatalf("unexpected number of archived rows after the delete; got %d; want 0; err: %v", n, err)
+	}
+	if traces := archive.listTraces(tenantA); len(traces) != 0 {
+		t.Fatalf("unexpected archived traces after the delete: %+v", traces)
+	}
+}
+
+func TestDeleteTasksManagerUnregisteredTenants(t *testing.T) {
+	dataPath := t.TempDir()
+
+	// Ingest spans bypassing the registration of tenants, like the data stored before the list of known tenants has been introduced.
+	tenantA := logstorage.TenantID{AccountID: 1}
+	tenantB := logstorage.TenantID{AccountID: 2, ProjectID: 3}
+	day := time.Now().Add(-3 * 24 * time.Hour).Truncate(24 * time.Hour)
+	timestamp := day.Add(12 * time.Hour).UnixNano()
+	s := logstorage.MustOpenStorage(dataPath, &logstorage.StorageConfig{
+		Retention: 30 * 24 * time.Hour,
+	})
+	lr := logstorage.GetLogRows(nil, nil, nil, nil, "")
+	for _, tenantID := range []logstorage.TenantID{tenantA, tenantA, tenantB} {
+		addTestSpan(lr, tenantID, timestamp, "a", "svc")
+	}
+	s.MustAddRows(lr)
+	logstorage.PutLogRows(lr)
+	s.MustClose()
+
+	// The tenants must be read from the stored partitions.
+	mustOpenTestStorage(t, dataPath, 30*24*time.Hour)
+	tenantIDsExpected := []logstorage.TenantID{{}, tenantA, tenantB}
+	if tenantIDs := knownTenantsInstance.getTenantIDs(); !slices.Equal(tenantIDs, tenantIDsExpected) {
+		t.Fatalf("unexpected tenants read from the stored partitions; got %v; want %v", tenantIDs, tenantIDsExpected)
+	}
+
+	archive = mustOpenTraceArchive(dataPath)
+	defer func() {
+		archive.mustClose()
+		archive = nil
+	}()
+	deleteTasks = mustOpenDeleteTasksManager(dataPath)
+	defer func() {
+		deleteTasks.mustStop()
+		deleteTasks = nil
+	}()
+
+	// Tenants seen during data ingestion must be persisted immediately.
+	tenantC := logstorage.TenantID{AccountID: 4}
+	mustAddTestRows(func(lr *logstorage.LogRows) {
+		addTestSpan(lr, tenantC, timestamp, "c", "svc")
+	})
+	tenantIDsExpected = []logstorage.TenantID{{}, tenantA, tenantB, tenantC}
+	if tenantIDs := mustLoadKnownTenants(dataPath).getTenantIDs(); !slices.Equal(tenantIDs, tenantIDsExpected) {
+		t.Fatalf("unexpected persisted tenants; got %v; want %v", tenantIDs, tenantIDsExpected)
+	}
+
+	dt := &deleteTask{
+		ID:       "foo",
+		TenantID: formatTenantID(tenantA),
+		TraceIDs: []string{"a"},
+		Start:    day.UnixNano(),
+		End:      time.Now().UnixNano(),
+		Status:   deleteTaskStatusPending,
+	}
+	if err := deleteTasks.addTask(dt); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	ctx := context.Background()
+	deleteTasks.runPendingTask(ctx, deleteTasks.getPendingTask())
+	deleteTasks.processRunningTasks(ctx)
+
+	tasks := deleteTasks.getTasks("foo")
+	if len(tasks) != 1 || tasks[0].Status != deleteTaskStatusDone || tasks[0].Error != "" {
+		t.Fatalf("unexpected delete task state: %+v", tasks)
+	}
+	if tasks[0].RowsDeleted != 2 {
+		t.Fatalf("unexpected number of deleted rows; got %d; want 2", tasks[0].RowsDeleted)
+	}
+
+	// Spans for the tenants without delete tasks must be kept.
+	start := day.UnixNano()
+	end := time.Now().UnixNano()
+	for _, tenantID := range []logstorage.TenantID{tenantB, tenantC} {
+		if n, err := countRows(ctx, localStorage, tenantID, start, end, ""); err != nil || n != 1 {
+			t.Fatalf("unexpected number of rows for tenant %s after the delete; got %d; want 1; err: %v", formatTenantID(tenantID), n, err)
+		}
+	}
+	if n, err := countRows(ctx, localStorage, tenantA, start, end, ""); err != nil || n != 0 {
+		t.Fatalf("unexpected number of rows for tenant %s after the delete; got %d; want 0; err: %v", formatTenantID(tenantA), n, err)
+	}
+}
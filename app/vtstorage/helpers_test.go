@@ -0,0 +1,57 @@
+package vtstorage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+
+	otelpb "github.com/VictoriaMetrics/VictoriaTraces/lib/protoparser/opentelemetry/pb"
+)
+
+// mustOpenTestStorage opens the local storage with the given retention at dataPath together with the known tenants for it.
+//
+// The storage is closed when the test finishes.
+func mustOpenTestStorage(t *testing.T, dataPath string, retention time.Duration) {
+	t.Helper()
+
+	// Known tenants must be loaded before opening the storage, since they may be read from the stored partitions.
+	knownTenantsInstance = mustLoadKnownTenants(dataPath)
+	localStorage = logstorage.MustOpenStorage(dataPath, &logstorage.StorageConfig{
+		Retention: retention,
+	})
+	t.Cleanup(func() {
+		localStorage.MustClose()
+		localStorage = nil
+		knownTenantsInstance = nil
+	})
+}
+
+// mustAddTestRows adds rows created by addRows to the local storage and makes them visible for search.
+func mustAddTestRows(addRows func(lr *logstorage.LogRows)) {
+	lr := logstorage.GetLogRows(nil, nil, nil, nil, "")
+	addRows(lr)
+	mustAddRowsLocal(lr)
+	logstorage.PutLogRows(lr)
+	localStorage.DebugFlush()
+}
+
+// addTestSpan adds the span with the given timestamp for the given traceID and service to lr.
+func addTestSpan(lr *logstorage.LogRows, tenantID logstorage.TenantID, timestamp int64, traceID, service string) {
+	lr.MustAdd(tenantID, timestamp, []logstorage.Field{
+		{Name: otelpb.TraceIDField, Value: traceID},
+		{Name: "_msg", Value: "-"},
+	}, []logstorage.Field{
+		{Name: otelpb.ResourceAttrServiceName, Value: service},
+	})
+}
+
+// addTestTraceIDIndex adds the trace_id_idx entry with the given timestamp for the given traceID to lr.
+func addTestTraceIDIndex(lr *logstorage.LogRows, tenantID logstorage.TenantID, timestamp int64, traceID string) {
+	lr.MustAdd(tenantID, timestamp, []logstorage.Field{
+		{Name: otelpb.TraceIDIndexFieldName, Value: traceID},
+		{Name: "_msg", Value: "-"},
+	}, []logstorage.Field{
+		{Name: otelpb.TraceIDIndexStreamName, Value: "1"},
+	})
+}
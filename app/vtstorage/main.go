@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"path/filepath"
+	"slices"
 	"time"
 
 	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
@@ -16,8 +18,10 @@ import (
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promauth"
 	"github.com/VictoriaMetrics/metrics"
 
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtstorage/capabilities"
 	"github.com/VictoriaMetrics/VictoriaTraces/app/vtstorage/netinsert"
 	"github.com/VictoriaMetrics/VictoriaTraces/app/vtstorage/netselect"
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtstorage/retention"
 )
 
 var (
@@ -55,9 +59,26 @@ var (
 	insertConcurrency        = flag.Int("insert.concurrency", 2, "The average number of concurrent data ingestion requests, which can be sent to every -storageNode")
 	insertDisableCompression = flag.Bool("insert.disableCompression", false, "Whether to disable compression when sending the ingested data to -storageNode nodes. "+
 		"Disabled compression reduces CPU usage at the cost of higher network usage")
+	insertTmpDataPath = flag.String("insert.tmpDataPath", "vtinsert-data", "Path to directory for storing pending data, which isn't sent to -storageNode nodes yet. "+
+		"The pending data survives vtinsert restarts and is sent to -storageNode nodes once they become available. See also -insert.maxDiskUsagePerNode")
+	insertMaxDiskUsagePerNode = flagutil.NewBytes("insert.maxDiskUsagePerNode", 0, "The maximum file-based buffer size in bytes at -insert.tmpDataPath "+
+		"for every -storageNode. When the buffer size reaches the configured maximum, then old data is dropped when adding new data to the buffer. "+
+		"Buffered data is stored in ~500MB chunks. It is recommended to set the value for this flag to a multiple of the block size 500MB. "+
+		"Disk usage is unlimited if the value is set to 0")
 	selectDisableCompression = flag.Bool("select.disableCompression", false, "Whether to disable compression for select query responses received from -storageNode nodes. "+
 		"Disabled compression reduces CPU usage at the cost of higher network usage")
 
+	storageGroups storageGroupsFlag
+
+	selectStorageGroups = flagutil.NewArrayString("select.storageGroup", "Optional names of -storageGroup groups to send select queries to in the order of preference. "+
+		"Select queries are sent to -storageNode nodes if they are set and then to the given groups until the query succeeds. "+
+		"By default, all the -storageGroup groups are used in the order they are specified. "+
+		"See https://docs.victoriametrics.com/victoriatraces/cluster/#mirroring-to-multiple-storage-groups")
+
+	storageNodeRegion = flagutil.NewArrayString("storageNode.region", "Optional region name for the corresponding -storageNode. "+
+		"The region name is returned together with spans from the corresponding -storageNode, so it is possible to determine which region answered the query. "+
+		"This is useful when -storageNode points to vtselect nodes in other regions. See https://docs.victoriametrics.com/victoriatraces/cluster/#multi-level-cluster-setup")
+
 	storageNodeUsername     = flagutil.NewArrayString("storageNode.username", "Optional basic auth username to use for the corresponding -storageNode")
 	storageNodePassword     = flagutil.NewArrayString("storageNode.password", "Optional basic auth password to use for the corresponding -storageNode")
 	storageNodePasswordFile = flagutil.NewArrayString("storageNode.passwordFile", "Optional path to basic auth password to use for the corresponding -storageNode. "+
@@ -78,18 +99,38 @@ var (
 	storageNodeTLSInsecureSkipVerify = flagutil.NewArrayBool("storageNode.tlsInsecureSkipVerify", "Whether to skip tls verification when connecting to the corresponding -storageNode")
 )
 
+func init() {
+	flag.Var(&storageGroups, "storageGroup", "Optional named group of storage nodes in the form name:addr1,addr2,... to mirror the ingested spans to; "+
+		"for example, -storageGroup=az1:node1:10491,node2:10491 -storageGroup=az2:node3:10491,node4:10491 . The flag can be specified multiple times. "+
+		"Every ingested span is written to every group and to -storageNode nodes if they are set. Every group has its own buffering at -insert.tmpDataPath, "+
+		"so an unavailable or slow group doesn't block data ingestion into other groups. Select queries are sent to -storageNode nodes "+
+		"and then to -storageGroup groups until the query succeeds; see -select.storageGroup. The -storageNode.* flags are applied to -storageGroup nodes "+
+		"by position in the order they are listed after -storageNode nodes. See https://docs.victoriametrics.com/victoriatraces/cluster/#mirroring-to-multiple-storage-groups")
+}
+
 var localStorage *logstorage.Storage
 var localStorageMetrics *metrics.Set
 
-var netstorageInsert *netinsert.Storage
+// netstorageInserts contains network storages for -storageNode and for every -storageGroup.
+var netstorageInserts []*netinsert.Storage
+
+// netstorageSelects contains network storages for -storageNode and for -storageGroup groups in the order of preference for select queries.
+//
+// See -select.storageGroup.
+var netstorageSelects []*selectGroup
 
-var netstorageSelect *netselect.Storage
+// netstorageAdmins contains network storages for -storageNode and for every -storageGroup.
+//
+// It is used for admin requests, which must be sent to all the storage nodes including groups unused for select queries.
+var netstorageAdmins []*selectGroup
 
 // Init initializes vtstorage.
 //
 // Stop must be called when vtstorage is no longer needed
 func Init() {
-	if len(*storageNodeAddrs) == 0 {
+	retention.Init(retentionPeriod.Duration())
+
+	if len(*storageNodeAddrs) == 0 && len(storageGroups) == 0 {
 		initLocalStorage()
 	} else {
 		initNetworkStorage()
@@ -112,7 +153,7 @@ func initLocalStorage() {
 		logger.Fatalf("-retention.maxDiskUsagePercent must be between 1 and 100; got %d", *maxDiskUsagePercent)
 	}
 	cfg := &logstorage.StorageConfig{
-		Retention:              retentionPeriod.Duration(),
+		Retention:              retention.MaxRetention(),
 		MaxDiskSpaceUsageBytes: maxDiskSpaceUsageBytes.N,
 		MaxDiskUsagePercent:    *maxDiskUsagePercent,
 		FlushInterval:          *inmemoryDataFlushInterval,
@@ -121,6 +162,13 @@ func initLocalStorage() {
 		LogIngestedRows:        *logIngestedRows,
 		MinFreeDiskSpaceBytes:  minFreeDiskSpaceBytes.N,
 	}
+	// Finish partition rewrites interrupted by unclean shutdown before opening the storage.
+	mustRecoverPartitionRewriteTmpDir(*storageDataPath)
+	retentionFilterInstance = mustInitRetentionFilter(*storageDataPath)
+	tieredStorageInstance = mustInitTieredStorage(*storageDataPath)
+	// Known tenants may be read from the stored partitions, so they must be loaded after finishing interrupted partition moves.
+	knownTenantsInstance = mustLoadKnownTenants(*storageDataPath)
+
 	logger.Infof("opening storage at -storageDataPath=%s", *storageDataPath)
 	startTime := time.Now()
 	localStorage = logstorage.MustOpenStorage(*storageDataPath, cfg)
@@ -138,25 +186,77 @@ func initLocalStorage() {
 		writeStorageMetrics(w, localStorage)
 	})
 	metrics.RegisterSet(localStorageMetrics)
+
+	deleteTasks = mustOpenDeleteTasksManager(*storageDataPath)
+	archive = mustOpenTraceArchive(*storageDataPath)
+	retentionEnforcerInstance = startRetentionEnforcer(*storageDataPath)
+	deleteTasks.start()
+	if retentionFilterInstance != nil {
+		retentionFilterInstance.start()
+	}
+	if tieredStorageInstance != nil {
+		tieredStorageInstance.start()
+	}
 }
 
 func initNetworkStorage() {
-	if netstorageInsert != nil || netstorageSelect != nil {
+	if netstorageInserts != nil || netstorageSelects != nil || netstorageAdmins != nil {
 		logger.Panicf("BUG: initNetworkStorage() has been already called")
 	}
 
-	authCfgs := make([]*promauth.Config, len(*storageNodeAddrs))
-	isTLSs := make([]bool, len(*storageNodeAddrs))
-	for i := range authCfgs {
-		authCfgs[i] = newAuthConfigForStorageNode(i)
-		isTLSs[i] = storageNodeTLS.GetOptionalArg(i)
+	initRetentionFilterFrozenAge()
+
+	// The -storageNode.* flags are applied to -storageNode nodes and then to -storageGroup nodes in the order they are specified.
+	argIdx := 0
+	newNodesConfig := func(addrs []string) ([]*promauth.Config, []bool, []string) {
+		authCfgs := make([]*promauth.Config, len(addrs))
+		isTLSs := make([]bool, len(addrs))
+		regions := make([]string, len(addrs))
+		for i := range authCfgs {
+			authCfgs[i] = newAuthConfigForStorageNode(argIdx)
+			isTLSs[i] = storageNodeTLS.GetOptionalArg(argIdx)
+			regions[i] = storageNodeRegion.GetOptionalArg(argIdx)
+			argIdx++
+		}
+		return authCfgs, isTLSs, regions
 	}
 
-	logger.Infof("starting insert service for nodes %s", *storageNodeAddrs)
-	netstorageInsert = netinsert.NewStorage(*storageNodeAddrs, authCfgs, isTLSs, *insertConcurrency, *insertDisableCompression)
+	selectGroupIdxs, err := getSelectGroupIdxs(storageGroups, *selectStorageGroups)
+	if err != nil {
+		logger.Fatalf("invalid -select.storageGroup: %s", err)
+	}
 
-	logger.Infof("initializing select service for nodes %s", *storageNodeAddrs)
-	netstorageSelect = netselect.NewStorage(*storageNodeAddrs, authCfgs, isTLSs, *selectDisableCompression)
+	if len(*storageNodeAddrs) > 0 {
+		authCfgs, isTLSs, regions := newNodesConfig(*storageNodeAddrs)
+		logger.Infof("starting insert service for nodes %s", *storageNodeAddrs)
+		sn := netinsert.NewStorage("", *storageNodeAddrs, authCfgs, isTLSs, *insertConcurrency, *insertDisableCompression, *insertTmpDataPath, insertMaxDiskUsagePerNode.N)
+		netstorageInserts = append(netstorageInserts, sn)
+
+		logger.Infof("initializing select service for nodes %s", *storageNodeAddrs)
+		sg := &selectGroup{
+			s: netselect.NewStorage(*storageNodeAddrs, regions, authCfgs, isTLSs, *selectDisableCompression),
+		}
+		netstorageSelects = append(netstorageSelects, sg)
+		netstorageAdmins = append(netstorageAdmins, sg)
+	}
+	groupSelects := make([]*selectGroup, len(storageGroups))
+	for i, sg := range storageGroups {
+		authCfgs, isTLSs, regions := newNodesConfig(sg.addrs)
+		logger.Infof("starting insert service for storage group %q with nodes %s", sg.name, sg.addrs)
+		sn := netinsert.NewStorage(sg.name, sg.addrs, authCfgs, isTLSs, *insertConcurrency, *insertDisableCompression, *insertTmpDataPath, insertMaxDiskUsagePerNode.N)
+		netstorageInserts = append(netstorageInserts, sn)
+
+		// The select service is initialized for every group, since admin requests are sent to all the groups.
+		logger.Infof("initializing select service for storage group %q with nodes %s", sg.name, sg.addrs)
+		groupSelects[i] = &selectGroup{
+			name: sg.name,
+			s:    netselect.NewStorage(sg.addrs, regions, authCfgs, isTLSs, *selectDisableCompression),
+		}
+		netstorageAdmins = append(netstorageAdmins, groupSelects[i])
+	}
+	for _, idx := range selectGroupIdxs {
+		netstorageSelects = append(netstorageSelects, groupSelects[idx])
+	}
 
 	logger.Infof("initialized all the network services")
 }
@@ -205,21 +305,52 @@ func Stop() {
 		metrics.UnregisterSet(localStorageMetrics, true)
 		localStorageMetrics = nil
 
+		retentionEnforcerInstance.mustStop()
+		retentionEnforcerInstance = nil
+
+		// Stop tiered storage before retention filter, since they share partitionsMoveLock.
+		if tieredStorageInstance != nil {
+			tieredStorageInstance.mustStop()
+			tieredStorageInstance = nil
+		}
+
+		if retentionFilterInstance != nil {
+			retentionFilterInstance.mustStop()
+			retentionFilterInstance = nil
+		}
+
+		deleteTasks.mustStop()
+		deleteTasks = nil
+
+		knownTenantsInstance = nil
+
+		archive.mustClose()
+		archive = nil
+
 		localStorage.MustClose()
 		localStorage = nil
 	} else {
-		netstorageInsert.MustStop()
-		netstorageInsert = nil
+		for _, sn := range netstorageInserts {
+			sn.MustStop()
+		}
+		netstorageInserts = nil
 
-		netstorageSelect.MustStop()
-		netstorageSelect = nil
+		for _, sg := range netstorageAdmins {
+			sg.s.MustStop()
+		}
+		netstorageSelects = nil
+		netstorageAdmins = nil
 	}
+
+	retention.Stop()
 }
 
 // RequestHandler is a storage request handler.
 func RequestHandler(w http.ResponseWriter, r *http.Request) bool {
 	path := r.URL.Path
 	switch path {
+	case capabilities.Path:
+		return processCapabilities(w, r)
 	case "/internal/force_merge":
 		return processForceMerge(w, r)
 	case "/internal/force_flush":
@@ -230,18 +361,50 @@ func RequestHandler(w http.ResponseWriter, r *http.Request) bool {
 		return processPartitionDetach(w, r)
 	case "/internal/partition/list":
 		return processPartitionList(w, r)
+	case "/internal/partition/tiers":
+		return processPartitionTiers(w, r)
 	case "/internal/partition/snapshot/create":
 		return processPartitionSnapshotCreate(w, r)
 	case "/internal/partition/snapshot/list":
 		return processPartitionSnapshotList(w, r)
+	case "/internal/partition/snapshot/delete":
+		return processPartitionSnapshotDelete(w, r)
+	case "/internal/delete":
+		return processDelete(w, r)
+	case "/internal/delete/status":
+		return processDeleteStatus(w, r)
+	case "/internal/archive/add":
+		return processArchiveAdd(w, r)
+	case "/internal/archive/delete":
+		return processArchiveDelete(w, r)
+	case "/internal/archive/list":
+		return processArchiveList(w, r)
+	case "/internal/archive/get":
+		return processArchiveGet(w, r)
 	}
 	return false
 }
 
+// processCapabilities returns protocol versions supported by the /internal/* HTTP endpoints of this node.
+//
+// This allows vtinsert and vtselect to negotiate protocol versions with this node, so the cluster keeps working
+// during rolling upgrades when nodes with different versions co-exist.
+func processCapabilities(w http.ResponseWriter, _ *http.Request) bool {
+	caps := &capabilities.Capabilities{
+		ProtocolVersions: map[string][]string{
+			"/internal/insert": netinsert.SupportedProtocolVersions,
+		},
+	}
+	for path, versions := range netselect.SupportedProtocolVersions {
+		caps.ProtocolVersions[path] = versions
+	}
+	caps.WriteResponse(w)
+	return true
+}
+
 func processForceMerge(w http.ResponseWriter, r *http.Request) bool {
 	if localStorage == nil {
-		// Force merge isn't supported by non-local storage
-		return false
+		return processClusterAdminRequest(w, r, forceMergeAuthKey)
 	}
 
 	if !httpserver.CheckAuthFlag(w, r, forceMergeAuthKey) {
@@ -263,8 +426,7 @@ func processForceMerge(w http.ResponseWriter, r *http.Request) bool {
 
 func processForceFlush(w http.ResponseWriter, r *http.Request) bool {
 	if localStorage == nil {
-		// Force merge isn't supported by non-local storage
-		return false
+		return processClusterAdminRequest(w, r, forceFlushAuthKey)
 	}
 
 	if !httpserver.CheckAuthFlag(w, r, forceFlushAuthKey) {
@@ -278,8 +440,7 @@ func processForceFlush(w http.ResponseWriter, r *http.Request) bool {
 
 func processPartitionAttach(w http.ResponseWriter, r *http.Request) bool {
 	if localStorage == nil {
-		// There are no partitions in non-local storage
-		return false
+		return processClusterAdminRequest(w, r, partitionManageAuthKey)
 	}
 
 	if !httpserver.CheckAuthFlag(w, r, partitionManageAuthKey) {
@@ -297,8 +458,7 @@ func processPartitionAttach(w http.ResponseWriter, r *http.Request) bool {
 
 func processPartitionDetach(w http.ResponseWriter, r *http.Request) bool {
 	if localStorage == nil {
-		// There are no partitions in non-local storage
-		return false
+		return processClusterAdminRequest(w, r, partitionManageAuthKey)
 	}
 
 	if !httpserver.CheckAuthFlag(w, r, partitionManageAuthKey) {
@@ -311,13 +471,15 @@ func processPartitionDetach(w http.ResponseWriter, r *http.Request) bool {
 		return true
 	}
 
+	// Cached query results may contain spans from the detached partition.
+	bumpDataGeneration()
+
 	return true
 }
 
 func processPartitionList(w http.ResponseWriter, r *http.Request) bool {
 	if localStorage == nil {
-		// There are no partitions in non-local storage
-		return false
+		return processClusterAdminRequest(w, r, partitionManageAuthKey)
 	}
 
 	if !httpserver.CheckAuthFlag(w, r, partitionManageAuthKey) {
@@ -336,8 +498,7 @@ func processPartitionList(w http.ResponseWriter, r *http.Request) bool {
 
 func processPartitionSnapshotCreate(w http.ResponseWriter, r *http.Request) bool {
 	if localStorage == nil {
-		// There are no partitions in non-local storage
-		return false
+		return processClusterAdminRequest(w, r, partitionManageAuthKey)
 	}
 
 	if !httpserver.CheckAuthFlag(w, r, partitionManageAuthKey) {
@@ -357,8 +518,7 @@ func processPartitionSnapshotCreate(w http.ResponseWriter, r *http.Request) bool
 
 func processPartitionSnapshotList(w http.ResponseWriter, r *http.Request) bool {
 	if localStorage == nil {
-		// There are no partitions in non-local storage
-		return false
+		return processClusterAdminRequest(w, r, partitionManageAuthKey)
 	}
 
 	if !httpserver.CheckAuthFlag(w, r, partitionManageAuthKey) {
@@ -375,6 +535,92 @@ func processPartitionSnapshotList(w http.ResponseWriter, r *http.Request) bool {
 	return true
 }
 
+func processPartitionSnapshotDelete(w http.ResponseWriter, r *http.Request) bool {
+	if localStorage == nil {
+		return processClusterAdminRequest(w, r, partitionManageAuthKey)
+	}
+
+	if !httpserver.CheckAuthFlag(w, r, partitionManageAuthKey) {
+		return true
+	}
+
+	// Allow deleting only snapshots returned by /internal/partition/snapshot/list, so arbitrary directories cannot be deleted.
+	snapshotPath := r.FormValue("path")
+	if !slices.Contains(localStorage.PartitionSnapshotList(), snapshotPath) {
+		httpserver.Errorf(w, r, "cannot find snapshot %q; see /internal/partition/snapshot/list for the list of available snapshots", snapshotPath)
+		return true
+	}
+	fs.MustRemoveDir(snapshotPath)
+	fs.MustSyncPath(filepath.Dir(snapshotPath))
+
+	return true
+}
+
+// clusterAdminResponse is the aggregated response for the admin request sent to all the -storageNode and -storageGroup nodes.
+type clusterAdminResponse struct {
+	// Status is "success" if all the storage nodes successfully processed the request,
+	// "partial_failure" if some of the storage nodes failed to process the request
+	// and "failure" if all the storage nodes failed to process the request.
+	Status string `json:"status"`
+
+	// Groups contains responses from every storage group.
+	Groups []groupAdminResponse `json:"groups"`
+}
+
+// processClusterAdminRequest sends the admin request r to all the -storageNode and -storageGroup nodes and writes the aggregated response to w.
+//
+// The request is protected by the given authKey in the same way as at the storage nodes.
+// The request args including authKey are passed to the storage nodes as is, so all the storage nodes must use the same authKey.
+func processClusterAdminRequest(w http.ResponseWriter, r *http.Request, authKey *flagutil.Password) bool {
+	if netstorageAdmins == nil {
+		return false
+	}
+
+	if !httpserver.CheckAuthFlag(w, r, authKey) {
+		return true
+	}
+
+	if err := r.ParseForm(); err != nil {
+		httpserver.Errorf(w, r, "cannot parse request args: %s", err)
+		return true
+	}
+
+	groups := runNetworkAdminRequest(r.Context(), r.URL.Path, r.Form)
+	var responses []netselect.AdminResponse
+	for _, g := range groups {
+		responses = append(responses, g.Nodes...)
+	}
+	resp := &clusterAdminResponse{
+		Status: getClusterAdminStatus(responses),
+		Groups: groups,
+	}
+	if resp.Status != "success" {
+		// Return non-2xx status code, so the caller could easily detect failures at some storage nodes.
+		// The response body contains the details for every storage node.
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	writeJSONResponse(w, resp)
+	return true
+}
+
+func getClusterAdminStatus(responses []netselect.AdminResponse) string {
+	failures := 0
+	for _, resp := range responses {
+		if resp.Error != "" {
+			failures++
+		}
+	}
+	switch {
+	case failures == 0:
+		return "success"
+	case failures == len(responses):
+		return "failure"
+	default:
+		return "partial_failure"
+	}
+}
+
 func writeJSONResponse(w http.ResponseWriter, response any) {
 	responseBody, err := json.Marshal(response)
 	if err != nil {
@@ -411,11 +657,71 @@ func (*Storage) CanWriteData() error {
 func (*Storage) MustAddRows(lr *logstorage.LogRows) {
 	if localStorage != nil {
 		// Store lr in the local storage.
-		localStorage.MustAddRows(lr)
+		mustAddRowsLocal(lr)
 	} else {
-		// Store lr across the remote storage nodes.
-		lr.ForEachRow(netstorageInsert.AddRow)
+		// Store lr across the remote storage nodes at every storage group.
+		for _, sn := range netstorageInserts {
+			lr.ForEachRow(sn.AddRow)
+		}
+	}
+}
+
+var (
+	rowsDroppedRetentionPolicy = metrics.NewCounter(`vt_rows_dropped_total{reason="retention_policy"}`)
+
+	rowsDroppedRetentionPolicyLogger = logger.WithThrottler("rows_dropped_retention_policy", 5*time.Second)
+)
+
+// mustAddRowsLocal adds lr to the local storage.
+//
+// Rows outside their retention policies are dropped and logged. Rows for the partition being rewritten are buffered,
+// so they are added to the rewritten partition.
+func mustAddRowsLocal(lr *logstorage.LogRows) {
+	knownTenantsInstance.registerTenants(lr)
+
+	rewritingPartitionLock.RLock()
+	defer rewritingPartitionLock.RUnlock()
+
+	rb := rewritingPartition
+
+	// dropped is nil if all the rows must be added.
+	var dropped []bool
+	i := 0
+	lr.ForEachRow(func(_ uint64, r *logstorage.InsertRow) {
+		drop := false
+		if retention.IsRowExpired(r.TenantID, r.Timestamp, r.Fields) {
+			rowsDroppedRetentionPolicy.Inc()
+			rowsDroppedRetentionPolicyLogger.Warnf("dropping span for tenant %s with timestamp %s outside its retention; see -retention.configFile and -retentionFilter.configFile",
+				r.TenantID, time.Unix(0, r.Timestamp).UTC().Format(time.RFC3339Nano))
+			drop = true
+		} else if rb != nil && rb.addRow(r) {
+			// The row is added to the rewritten partition.
+			drop = true
+		}
+		if drop {
+			if dropped == nil {
+				dropped = make([]bool, lr.RowsCount())
+			}
+			dropped[i] = true
+		}
+		i++
+	})
+	if dropped == nil {
+		localStorage.MustAddRows(lr)
+		return
 	}
+
+	// Slow path - add only the remaining rows.
+	lrKeep := logstorage.GetLogRows(nil, nil, nil, nil, "")
+	defer logstorage.PutLogRows(lrKeep)
+	i = 0
+	lr.ForEachRow(func(_ uint64, r *logstorage.InsertRow) {
+		if !dropped[i] {
+			lrKeep.MustAddInsertRow(r)
+		}
+		i++
+	})
+	localStorage.MustAddRows(lrKeep)
 }
 
 // RunQuery runs the given qctx and calls writeBlock for the returned data blocks
@@ -427,17 +733,19 @@ func RunQuery(qctx *logstorage.QueryContext, writeBlock logstorage.WriteDataBloc
 	}
 
 	if localStorage != nil {
-		return localStorage.RunQuery(qctx, writeBlock)
+		return runLocalQuery(qctx, writeBlock)
 	}
-	return netstorageSelect.RunQuery(qctx, writeBlock)
+	return runNetworkQuery(qctx, writeBlock)
 }
 
 // GetFieldNames executes qctx and returns field names seen in results.
 func GetFieldNames(qctx *logstorage.QueryContext) ([]logstorage.ValueWithHits, error) {
 	if localStorage != nil {
-		return localStorage.GetFieldNames(qctx)
+		return getLocalValuesWithHits(qctx, 0, false, localStorage.GetFieldNames)
 	}
-	return netstorageSelect.GetFieldNames(qctx)
+	return getNetworkValuesWithHits(qctx.Context, func(s *netselect.Storage) ([]logstorage.ValueWithHits, error) {
+		return s.GetFieldNames(qctx)
+	})
 }
 
 // GetFieldValues executes the given qctx and returns unique values for the fieldName seen in results.
@@ -445,17 +753,23 @@ func GetFieldNames(qctx *logstorage.QueryContext) ([]logstorage.ValueWithHits, e
 // If limit > 0, then up to limit unique values are returned.
 func GetFieldValues(qctx *logstorage.QueryContext, fieldName string, limit uint64) ([]logstorage.ValueWithHits, error) {
 	if localStorage != nil {
-		return localStorage.GetFieldValues(qctx, fieldName, limit)
+		return getLocalValuesWithHits(qctx, limit, true, func(qctx *logstorage.QueryContext) ([]logstorage.ValueWithHits, error) {
+			return localStorage.GetFieldValues(qctx, fieldName, limit)
+		})
 	}
-	return netstorageSelect.GetFieldValues(qctx, fieldName, limit)
+	return getNetworkValuesWithHits(qctx.Context, func(s *netselect.Storage) ([]logstorage.ValueWithHits, error) {
+		return s.GetFieldValues(qctx, fieldName, limit)
+	})
 }
 
 // GetStreamFieldNames executes the given qctx and returns stream field names seen in results.
 func GetStreamFieldNames(qctx *logstorage.QueryContext) ([]logstorage.ValueWithHits, error) {
 	if localStorage != nil {
-		return localStorage.GetStreamFieldNames(qctx)
+		return getLocalValuesWithHits(qctx, 0, false, localStorage.GetStreamFieldNames)
 	}
-	return netstorageSelect.GetStreamFieldNames(qctx)
+	return getNetworkValuesWithHits(qctx.Context, func(s *netselect.Storage) ([]logstorage.ValueWithHits, error) {
+		return s.GetStreamFieldNames(qctx)
+	})
 }
 
 // GetStreamFieldValues executes the given qctx and returns stream field values for the given fieldName seen in results.
@@ -463,9 +777,13 @@ func GetStreamFieldNames(qctx *logstorage.QueryContext) ([]logstorage.ValueWithH
 // If limit > 0, then up to limit unique stream field values are returned.
 func GetStreamFieldValues(qctx *logstorage.QueryContext, fieldName string, limit uint64) ([]logstorage.ValueWithHits, error) {
 	if localStorage != nil {
-		return localStorage.GetStreamFieldValues(qctx, fieldName, limit)
+		return getLocalValuesWithHits(qctx, limit, true, func(qctx *logstorage.QueryContext) ([]logstorage.ValueWithHits, error) {
+			return localStorage.GetStreamFieldValues(qctx, fieldName, limit)
+		})
 	}
-	return netstorageSelect.GetStreamFieldValues(qctx, fieldName, limit)
+	return getNetworkValuesWithHits(qctx.Context, func(s *netselect.Storage) ([]logstorage.ValueWithHits, error) {
+		return s.GetStreamFieldValues(qctx, fieldName, limit)
+	})
 }
 
 // GetStreams executes the given qctx and returns streams seen in query results.
@@ -473,9 +791,13 @@ func GetStreamFieldValues(qctx *logstorage.QueryContext, fieldName string, limit
 // If limit > 0, then up to limit unique streams are returned.
 func GetStreams(qctx *logstorage.QueryContext, limit uint64) ([]logstorage.ValueWithHits, error) {
 	if localStorage != nil {
-		return localStorage.GetStreams(qctx, limit)
+		return getLocalValuesWithHits(qctx, limit, true, func(qctx *logstorage.QueryContext) ([]logstorage.ValueWithHits, error) {
+			return localStorage.GetStreams(qctx, limit)
+		})
 	}
-	return netstorageSelect.GetStreams(qctx, limit)
+	return getNetworkValuesWithHits(qctx.Context, func(s *netselect.Storage) ([]logstorage.ValueWithHits, error) {
+		return s.GetStreams(qctx, limit)
+	})
 }
 
 // GetStreamIDs executes the given qctx and returns streamIDs seen in query results.
@@ -483,9 +805,13 @@ func GetStreams(qctx *logstorage.QueryContext, limit uint64) ([]logstorage.Value
 // If limit > 0, then up to limit unique streamIDs are returned.
 func GetStreamIDs(qctx *logstorage.QueryContext, limit uint64) ([]logstorage.ValueWithHits, error) {
 	if localStorage != nil {
-		return localStorage.GetStreamIDs(qctx, limit)
+		return getLocalValuesWithHits(qctx, limit, true, func(qctx *logstorage.QueryContext) ([]logstorage.ValueWithHits, error) {
+			return localStorage.GetStreamIDs(qctx, limit)
+		})
 	}
-	return netstorageSelect.GetStreamIDs(qctx, limit)
+	return getNetworkValuesWithHits(qctx.Context, func(s *netselect.Storage) ([]logstorage.ValueWithHits, error) {
+		return s.GetStreamIDs(qctx, limit)
+	})
 }
 
 func writeStorageMetrics(w io.Writer, strg *logstorage.Storage) {
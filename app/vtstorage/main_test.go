@@ -0,0 +1,26 @@
+package vtstorage
+
+import (
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtstorage/netselect"
+)
+
+func TestGetClusterAdminStatus(t *testing.T) {
+	f := func(errs []string, statusExpected string) {
+		t.Helper()
+
+		responses := make([]netselect.AdminResponse, len(errs))
+		for i, err := range errs {
+			responses[i].Error = err
+		}
+		if status := getClusterAdminStatus(responses); status != statusExpected {
+			t.Fatalf("unexpected status; got %q; want %q", status, statusExpected)
+		}
+	}
+
+	f([]string{""}, "success")
+	f([]string{"", ""}, "success")
+	f([]string{"", "error"}, "partial_failure")
+	f([]string{"error", "error"}, "failure")
+}
@@ -0,0 +1,139 @@
+package vtstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fasttime"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+// clusterMinTimestampUpdateInterval is the interval in seconds between updates of the minimum timestamp for spans at storage nodes.
+const clusterMinTimestampUpdateInterval = 60
+
+// clusterMinTimestampRetryInterval is the interval in seconds between updates of the minimum timestamp if some storage nodes are unavailable.
+const clusterMinTimestampRetryInterval = 5
+
+// MinTimestampPath is the path for the HTTP endpoint at storage nodes, which returns the minimum timestamp for the stored spans.
+const MinTimestampPath = "/internal/select/min_timestamp"
+
+var clusterMinTimestampLogger = logger.WithThrottler("cluster_min_timestamp", 5*time.Minute)
+
+var clusterMinTimestamp minTimestampCache
+
+// GetMinTimestamp returns the minimum timestamp in nanoseconds for spans, which may exist in the storage.
+//
+// The timestamp is obtained from the oldest per-day partition, so the callers may skip time ranges without data,
+// e.g. when searching for spans by trace_id. In cluster mode the timestamp is obtained from storage nodes via MinTimestampPath,
+// so it reflects the retention configured at storage nodes.
+//
+// An error is returned if the timestamp couldn't be obtained from all the storage nodes during the last update.
+// The returned timestamp is still usable in this case, but the storage may contain older spans, so the callers mustn't
+// rely on the absence of spans older than the returned timestamp.
+func GetMinTimestamp(ctx context.Context) (int64, error) {
+	if localStorage != nil {
+		return getMinPartitionTimestamp(localStorage.PartitionList()), nil
+	}
+	return clusterMinTimestamp.get(ctx)
+}
+
+// minTimestampCache caches the minimum timestamp for spans at storage nodes,
+// since it is expensive to request all the storage nodes on every request.
+type minTimestampCache struct {
+	mu sync.Mutex
+
+	minTimestamp int64
+
+	// hasMinTimestamp is set to true if minTimestamp has been obtained from all the storage nodes at least once.
+	hasMinTimestamp bool
+
+	// err is the error for the last update of minTimestamp. It is nil if minTimestamp has been obtained from all the storage nodes.
+	err error
+
+	// nextUpdateTime is the unix timestamp in seconds for the next update of minTimestamp.
+	nextUpdateTime uint64
+}
+
+func (c *minTimestampCache) get(ctx context.Context) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	currentTime := fasttime.UnixTimestamp()
+	if currentTime < c.nextUpdateTime {
+		return c.minTimestamp, c.err
+	}
+
+	minTimestamp, err := getClusterMinTimestamp(ctx)
+	c.update(minTimestamp, err)
+	if err != nil {
+		clusterMinTimestampLogger.Warnf("%s; using the minimum timestamp %s for the stored spans", err, time.Unix(0, c.minTimestamp).UTC().Format(time.RFC3339))
+		c.nextUpdateTime = currentTime + clusterMinTimestampRetryInterval
+	} else {
+		c.nextUpdateTime = currentTime + clusterMinTimestampUpdateInterval
+	}
+	return c.minTimestamp, c.err
+}
+
+// update updates c with the minTimestamp obtained from storage nodes.
+//
+// If err is non-nil, then minTimestamp is obtained only from the available storage nodes. In this case the previously obtained
+// minimum timestamp is kept if it is smaller, since the unavailable storage nodes may contain older spans.
+// If the minimum timestamp hasn't been obtained from all the storage nodes yet, then it is limited by -retentionPeriod instead.
+func (c *minTimestampCache) update(minTimestamp int64, err error) {
+	c.err = err
+	if err == nil {
+		c.minTimestamp = minTimestamp
+		c.hasMinTimestamp = true
+		return
+	}
+	if c.hasMinTimestamp {
+		minTimestamp = min(minTimestamp, c.minTimestamp)
+	} else {
+		minTimestamp = min(minTimestamp, time.Now().UnixNano()-retentionPeriod.Duration().Nanoseconds())
+	}
+	c.minTimestamp = minTimestamp
+}
+
+// getClusterMinTimestamp returns the minimum timestamp for spans across all the -storageNode and -storageGroup nodes.
+//
+// If some storage nodes are unavailable, then the minimum timestamp across the available storage nodes is returned together with an error.
+// The current timestamp is returned if all the storage nodes are unavailable.
+func getClusterMinTimestamp(ctx context.Context) (int64, error) {
+	minTimestamp := time.Now().UnixNano()
+	var errs []error
+	for _, g := range runNetworkAdminRequest(ctx, MinTimestampPath, nil) {
+		for _, resp := range g.Nodes {
+			if resp.Error != "" {
+				errs = append(errs, fmt.Errorf("cannot obtain the minimum timestamp from storage node %s: %s", resp.Addr, resp.Error))
+				continue
+			}
+			var timestamp int64
+			if err := json.Unmarshal(resp.Result, &timestamp); err != nil {
+				errs = append(errs, fmt.Errorf("cannot parse the minimum timestamp from storage node %s: %w", resp.Addr, err))
+				continue
+			}
+			minTimestamp = min(minTimestamp, timestamp)
+		}
+	}
+	return minTimestamp, errors.Join(errs...)
+}
+
+// getMinPartitionTimestamp returns the start timestamp in nanoseconds for the oldest partition from ptNames.
+//
+// The current timestamp is returned if there are no partitions.
+func getMinPartitionTimestamp(ptNames []string) int64 {
+	minTimestamp := time.Now().UnixNano()
+	for _, name := range ptNames {
+		t, err := time.Parse("20060102", name)
+		if err != nil {
+			logger.Warnf("skipping partition with unexpected name %q; want YYYYMMDD", name)
+			continue
+		}
+		minTimestamp = min(minTimestamp, t.UnixNano())
+	}
+	return minTimestamp
+}
@@ -0,0 +1,68 @@
+package vtstorage
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGetMinPartitionTimestamp(t *testing.T) {
+	f := func(ptNames []string, resultExpected int64) {
+		t.Helper()
+
+		if result := getMinPartitionTimestamp(ptNames); result != resultExpected {
+			t.Fatalf("unexpected min timestamp for %q; got %d; want %d", ptNames, result, resultExpected)
+		}
+	}
+
+	f([]string{"20251010"}, time.Date(2025, 10, 10, 0, 0, 0, 0, time.UTC).UnixNano())
+	f([]string{"20251012", "20251009", "20251011"}, time.Date(2025, 10, 9, 0, 0, 0, 0, time.UTC).UnixNano())
+
+	// invalid partition names are skipped
+	f([]string{"foo", "20251011"}, time.Date(2025, 10, 11, 0, 0, 0, 0, time.UTC).UnixNano())
+
+	// no partitions
+	startTime := time.Now().UnixNano()
+	if result := getMinPartitionTimestamp(nil); result < startTime {
+		t.Fatalf("unexpected min timestamp without partitions; got %d; want at least %d", result, startTime)
+	}
+}
+
+func TestMinTimestampCacheUpdate(t *testing.T) {
+	var c minTimestampCache
+	errUnavailable := errors.New("storage node is unavailable")
+
+	// The timestamp is limited by -retentionPeriod if all the storage nodes are unavailable before the timestamp is obtained from them.
+	c.update(time.Now().UnixNano(), errUnavailable)
+	if n := time.Now().UnixNano() - retentionPeriod.Duration().Nanoseconds(); c.minTimestamp > n {
+		t.Fatalf("unexpected min timestamp; got %d; want at most %d", c.minTimestamp, n)
+	}
+	if c.err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+
+	// The timestamp from the available storage nodes is used until the timestamp is obtained from all the storage nodes.
+	c.update(200, errUnavailable)
+	if c.minTimestamp != 200 {
+		t.Fatalf("unexpected min timestamp; got %d; want 200", c.minTimestamp)
+	}
+
+	c.update(100, nil)
+	if c.minTimestamp != 100 {
+		t.Fatalf("unexpected min timestamp; got %d; want 100", c.minTimestamp)
+	}
+	if c.err != nil {
+		t.Fatalf("unexpected error: %s", c.err)
+	}
+
+	// The previously obtained timestamp is kept if some storage nodes are unavailable, since they may contain older spans.
+	c.update(150, errUnavailable)
+	if c.minTimestamp != 100 {
+		t.Fatalf("unexpected min timestamp; got %d; want 100", c.minTimestamp)
+	}
+
+	c.update(150, nil)
+	if c.minTimestamp != 150 {
+		t.Fatalf("unexpected min timestamp; got %d; want 150", c.minTimestamp)
+	}
+}
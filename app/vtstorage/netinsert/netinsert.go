@@ -1,11 +1,15 @@
 package netinsert
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -13,34 +17,84 @@ import (
 	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/contextutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding/zstd"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fasttime"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httputil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/persistentqueue"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promauth"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/timerpool"
 	"github.com/VictoriaMetrics/metrics"
+	"github.com/cespare/xxhash/v2"
 	"github.com/valyala/fastrand"
+
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtstorage/capabilities"
 )
 
 // the maximum size of a single data block sent to storage node.
 const maxInsertBlockSize = 2 * 1024 * 1024
 
-// ProtocolVersion is the version of the data ingestion protocol.
+// the size of the header prepended to every data block stored in the persistent queue.
 //
-// It must be changed every time the data encoding at /internal/insert HTTP endpoint is changed.
-const ProtocolVersion = "v1"
+// The header contains the unix timestamp in seconds when the block has been added to the queue.
+const queueBlockHeaderSize = 8
+
+// the name of the directory inside tmpDataPath, which holds persistent queues for storage nodes.
+const persistentQueueDirname = "persistent-queue"
+
+// the suffix for files next to the persistent queue directory, which hold data blocks read from the queue,
+// but not sent to storage nodes before the stop. Every sender has its own file with the sender index appended to the suffix.
+const inflightBlockFileSuffix = ".inflight"
+
+const (
+	// ProtocolVersion is the version of the data ingestion protocol.
+	//
+	// It must be changed every time the data encoding at /internal/insert HTTP endpoint is changed.
+	// The previous version must be kept in SupportedProtocolVersions in this case, so vtinsert and vtstorage nodes
+	// could be upgraded in any order.
+	ProtocolVersion = "v2"
+
+	// protocolVersionWithoutQueueTimestamp is the previous version of the data ingestion protocol.
+	//
+	// It differs from the current version by the lack of the queue block header in front of the sent rows.
+	protocolVersionWithoutQueueTimestamp = "v1"
+)
+
+// SupportedProtocolVersions contains the supported versions of the data ingestion protocol ordered from the newest to the oldest.
+//
+// The version for every storage node is negotiated via capabilities.Path HTTP endpoint.
+var SupportedProtocolVersions = []string{ProtocolVersion, protocolVersionWithoutQueueTimestamp}
+
+// HasQueueTimestamp returns true if the data sent via the given protocol version starts with the unix timestamp in seconds
+// when the data has been added to the persistent queue at vtinsert.
+//
+// The timestamp is encoded with encoding.MarshalUint64 and occupies QueueTimestampSize bytes.
+func HasQueueTimestamp(version string) bool {
+	return version != protocolVersionWithoutQueueTimestamp
+}
+
+// QueueTimestampSize is the size of the queue timestamp in front of the data sent via protocol versions with HasQueueTimestamp.
+const QueueTimestampSize = queueBlockHeaderSize
+
+// the path for the data ingestion HTTP endpoint at storage nodes.
+const insertPath = "/internal/insert"
 
 // Storage is a network storage for sending data to remote storage nodes in the cluster.
+//
+// Every Storage has its own buffering, retry and health state, so multiple Storage instances
+// can be used for writing the same data to independent groups of storage nodes.
 type Storage struct {
+	// group is an optional name of the group of storage nodes.
+	group string
+
 	sns []*storageNode
 
 	disableCompression bool
 
 	srt *streamRowsTracker
 
-	pendingDataBuffers chan *bytesutil.ByteBuffer
-
 	stopCh chan struct{}
 	wg     sync.WaitGroup
 }
@@ -61,11 +115,39 @@ type storageNode struct {
 	// ac is auth config used for setting request headers such as Authorization and Host.
 	ac *promauth.Config
 
+	// negotiator negotiates the data ingestion protocol version with the storage node.
+	negotiator *capabilities.Negotiator
+
+	// protocolVersion is the numeric representation of the protocol version used for the last request to the storage node.
+	protocolVersion atomic.Uint64
+
 	// pendingData contains pending data, which must be sent to the storage node at the addr.
 	pendingDataMu        sync.Mutex
 	pendingData          *bytesutil.ByteBuffer
 	pendingDataLastFlush time.Time
 
+	// fq holds data blocks, which are ready to be sent to the storage node at the addr.
+	//
+	// It keeps blocks in memory while the storage node keeps up with the ingestion rate
+	// and spills them to disk when the storage node (and all the other storage nodes) are unavailable or slow.
+	fq *persistentqueue.FastQueue
+
+	// inflightBlockPathPrefix is the prefix for paths to files with data blocks, which have been read from fq, but haven't been sent before the stop.
+	//
+	// These blocks are sent before the rest of blocks in fq after the restart, since they have been read from fq before the rest of blocks.
+	inflightBlockPathPrefix string
+
+	// readMu serializes reading blocks from fq by concurrent senders.
+	//
+	// It is held while sending blocks replayed from the file-based part of fq, so these blocks are sent in the order they were added to fq.
+	readMu sync.Mutex
+
+	// inflightBlocks is the number of blocks read from fq, which are being sent to storage nodes.
+	inflightBlocks atomic.Int64
+
+	// lastReadBlockTimestamp is the unix timestamp when the last block read from fq has been added to fq.
+	lastReadBlockTimestamp atomic.Uint64
+
 	// sendErrors counts failed send attempts for this storage node.
 	sendErrors *metrics.Counter
 
@@ -76,7 +158,7 @@ type storageNode struct {
 	isReachable atomic.Bool
 }
 
-func newStorageNode(s *Storage, addr string, ac *promauth.Config, isTLS bool) *storageNode {
+func newStorageNode(s *Storage, idx int, addr string, ac *promauth.Config, isTLS bool, concurrency int, tmpDataPath string, maxPendingBytes int64) *storageNode {
 	tr := httputil.NewTransport(false, "vtinsert_backend")
 	tr.TLSHandshakeTimeout = 20 * time.Second
 	tr.DisableCompression = true
@@ -86,18 +168,34 @@ func newStorageNode(s *Storage, addr string, ac *promauth.Config, isTLS bool) *s
 		scheme = "https"
 	}
 
+	queuePath := filepath.Join(tmpDataPath, persistentQueueDirname, s.group, fmt.Sprintf("%d_%016X", idx+1, xxhash.Sum64String(addr)))
+	if maxPendingBytes != 0 && maxPendingBytes < persistentqueue.DefaultChunkFileSize {
+		logger.Warnf("rounding the -insert.maxDiskUsagePerNode=%d to the minimum supported value: %d", maxPendingBytes, persistentqueue.DefaultChunkFileSize)
+		maxPendingBytes = persistentqueue.DefaultChunkFileSize
+	}
+	// Keep up to 4 blocks per every concurrent connection in memory before spilling them to disk.
+	maxInmemoryBlocks := 4 * concurrency
+
+	labels := s.metricLabels(fmt.Sprintf("addr=%q", addr))
+
+	c := &http.Client{
+		Transport: ac.NewRoundTripper(tr),
+	}
 	sn := &storageNode{
 		scheme: scheme,
 		addr:   addr,
 		s:      s,
-		c: &http.Client{
-			Transport: ac.NewRoundTripper(tr),
-		},
-		ac: ac,
+		c:      c,
+		ac:     ac,
+
+		negotiator: capabilities.NewNegotiator(c, ac, scheme, addr),
 
-		sendErrors: metrics.GetOrCreateCounter(fmt.Sprintf(`vt_insert_remote_send_errors_total{addr=%q}`, addr)),
+		sendErrors: metrics.GetOrCreateCounter(fmt.Sprintf(`vt_insert_remote_send_errors_total{%s}`, labels)),
 
 		pendingData: &bytesutil.ByteBuffer{},
+
+		fq:                      persistentqueue.MustOpenFastQueue(queuePath, addr, maxInmemoryBlocks, maxPendingBytes, false),
+		inflightBlockPathPrefix: queuePath + inflightBlockFileSuffix,
 	}
 
 	sn.isReachable.Store(true)
@@ -108,16 +206,53 @@ func newStorageNode(s *Storage, addr string, ac *promauth.Config, isTLS bool) *s
 		sn.backgroundFlusher()
 	}()
 
-	_ = metrics.GetOrCreateGauge(fmt.Sprintf(`vt_insert_remote_is_reachable{addr=%q}`, addr), func() float64 {
+	for i := 0; i < concurrency; i++ {
+		s.wg.Add(1)
+		go func(senderIdx int) {
+			defer s.wg.Done()
+			sn.runSender(senderIdx)
+		}(i)
+	}
+
+	_ = metrics.GetOrCreateGauge(fmt.Sprintf(`vt_insert_remote_is_reachable{%s}`, labels), func() float64 {
 		if sn.isReachable.Load() {
 			return 1
 		}
 		return 0
 	})
+	_ = metrics.GetOrCreateGauge(fmt.Sprintf(`vt_insert_remote_protocol_version{%s}`, labels), func() float64 {
+		return float64(sn.protocolVersion.Load())
+	})
+	_ = metrics.GetOrCreateGauge(fmt.Sprintf(`vt_insert_remote_pending_data_bytes{%s}`, labels), func() float64 {
+		return float64(sn.fq.GetPendingBytes())
+	})
+	_ = metrics.GetOrCreateGauge(fmt.Sprintf(`vt_insert_remote_pending_inmemory_blocks{%s}`, labels), func() float64 {
+		return float64(sn.fq.GetInmemoryQueueLen())
+	})
+	_ = metrics.GetOrCreateGauge(fmt.Sprintf(`vt_insert_remote_oldest_pending_block_age_seconds{%s}`, labels), func() float64 {
+		return sn.getOldestPendingBlockAge()
+	})
 
 	return sn
 }
 
+// getOldestPendingBlockAge returns the approximate age in seconds of the oldest block, which isn't sent to storage nodes yet.
+func (sn *storageNode) getOldestPendingBlockAge() float64 {
+	if sn.inflightBlocks.Load() == 0 && sn.fq.GetPendingBytes() == 0 {
+		return 0
+	}
+	ts := sn.lastReadBlockTimestamp.Load()
+	if ts == 0 {
+		// No blocks have been read from the queue yet, so the age is unknown.
+		return 0
+	}
+	currentTime := fasttime.UnixTimestamp()
+	if ts > currentTime {
+		return 0
+	}
+	return float64(currentTime - ts)
+}
+
 func (sn *storageNode) backgroundFlusher() {
 	t := time.NewTicker(time.Second)
 	defer t.Stop()
@@ -134,16 +269,139 @@ func (sn *storageNode) backgroundFlusher() {
 
 func (sn *storageNode) flushPendingData() {
 	sn.pendingDataMu.Lock()
+	defer sn.pendingDataMu.Unlock()
+
 	if time.Since(sn.pendingDataLastFlush) < time.Second {
 		// nothing to flush
-		sn.pendingDataMu.Unlock()
+		return
+	}
+	sn.flushPendingDataLocked()
+}
+
+// runSender reads data blocks from sn.fq and sends them to storage nodes until sn.s.stopCh is closed.
+//
+// Multiple senders run concurrently per every storage node. Blocks read from the in-memory part of sn.fq are sent in parallel,
+// so they may be delivered out of order. Blocks replayed from the file-based part of sn.fq are sent one by one
+// in the order they were added to sn.fq, so the data buffered during storage node outages is replayed in order.
+func (sn *storageNode) runSender(senderIdx int) {
+	inflightBlockPath := fmt.Sprintf("%s.%d", sn.inflightBlockPathPrefix, senderIdx)
+
+	sn.readMu.Lock()
+	ok := sn.mustSendInflightBlocks()
+	sn.readMu.Unlock()
+	if !ok {
 		return
 	}
 
-	pendingData := sn.grabPendingDataForFlushLocked()
-	sn.pendingDataMu.Unlock()
+	var block []byte
+	for {
+		select {
+		case <-sn.s.stopCh:
+			return
+		default:
+		}
+
+		block, ok = sn.sendNextBlock(block[:0], inflightBlockPath)
+		if !ok {
+			return
+		}
+	}
+}
+
+// sendNextBlock reads the next block from sn.fq to dst and sends it to storage nodes.
+//
+// It returns false if sn.fq is closed or if the storage is stopped before the block is sent.
+// The block is persisted at inflightBlockPath in the latter case.
+func (sn *storageNode) sendNextBlock(dst []byte, inflightBlockPath string) ([]byte, bool) {
+	sn.readMu.Lock()
+
+	// The file-based part of sn.fq may be non-empty only if the in-memory part is empty.
+	isReplay := sn.fq.GetInmemoryQueueLen() == 0 && sn.fq.GetPendingBytes() > 0
+	if isReplay {
+		// Do not allow other senders to read the next block until the current block is sent.
+		defer sn.readMu.Unlock()
+	}
+
+	block, ok := sn.fq.MustReadBlock(dst)
+	if !isReplay {
+		sn.readMu.Unlock()
+	}
+	if !ok {
+		// The queue has been closed.
+		return block, false
+	}
+	if len(block) < queueBlockHeaderSize {
+		logger.Errorf("skipping too short data block read from the persistent queue at %q; its length is %d bytes; want at least %d bytes",
+			sn.fq.Dirname(), len(block), queueBlockHeaderSize)
+		return block, true
+	}
+
+	if !sn.sendBlock(block) {
+		// Do not return the block to sn.fq, since it would be sent after the blocks added to sn.fq later.
+		fs.MustWriteAtomic(inflightBlockPath, block, true)
+		return block, false
+	}
+	return block, true
+}
+
+// sendBlock sends the given block read from sn.fq to storage nodes.
+//
+// It returns false if the storage is stopped before the block is sent.
+func (sn *storageNode) sendBlock(block []byte) bool {
+	sn.inflightBlocks.Add(1)
+	defer sn.inflightBlocks.Add(-1)
+
+	sn.lastReadBlockTimestamp.Store(encoding.UnmarshalUint64(block[:queueBlockHeaderSize]))
+	return sn.mustSendInsertRequest(block)
+}
+
+// mustSendInflightBlocks sends blocks, which haven't been sent before the previous stop, and removes files with these blocks.
+//
+// The blocks are sent in the order they were added to sn.fq. sn.readMu must be locked by the caller,
+// so other senders do not read blocks from sn.fq until these blocks are sent.
+//
+// It returns false if the storage is stopped before all the blocks are sent. The remaining blocks are kept in their files in this case.
+func (sn *storageNode) mustSendInflightBlocks() bool {
+	paths, err := filepath.Glob(sn.inflightBlockPathPrefix + ".*")
+	if err != nil {
+		logger.Panicf("BUG: unexpected error when searching for pending data blocks: %s", err)
+	}
+	if len(paths) == 0 {
+		return true
+	}
+
+	type inflightBlock struct {
+		path  string
+		block []byte
+	}
+	var ibs []inflightBlock
+	for _, path := range paths {
+		block, err := os.ReadFile(path)
+		if err != nil {
+			logger.Panicf("FATAL: cannot read the pending data block: %s", err)
+		}
+		if len(block) < queueBlockHeaderSize {
+			logger.Errorf("skipping too short data block read from %q; its length is %d bytes; want at least %d bytes",
+				path, len(block), queueBlockHeaderSize)
+			fs.MustRemovePath(path)
+			continue
+		}
+		ibs = append(ibs, inflightBlock{
+			path:  path,
+			block: block,
+		})
+	}
+	sort.SliceStable(ibs, func(i, j int) bool {
+		return encoding.UnmarshalUint64(ibs[i].block) < encoding.UnmarshalUint64(ibs[j].block)
+	})
 
-	sn.mustSendInsertRequest(pendingData)
+	for _, ib := range ibs {
+		if !sn.sendBlock(ib.block) {
+			return false
+		}
+		fs.MustRemovePath(ib.path)
+	}
+	return true
 }
 
 func (sn *storageNode) addRow(r *logstorage.InsertRow) {
@@ -158,64 +416,72 @@ func (sn *storageNode) addRow(r *logstorage.InsertRow) {
 		return
 	}
 
-	var pendingData *bytesutil.ByteBuffer
 	sn.pendingDataMu.Lock()
 	if sn.pendingData.Len()+len(b) > maxInsertBlockSize {
-		pendingData = sn.grabPendingDataForFlushLocked()
+		sn.flushPendingDataLocked()
 	}
 	sn.pendingData.MustWrite(b)
 	sn.pendingDataMu.Unlock()
 
 	bb.B = b
 	bbPool.Put(bb)
-
-	if pendingData != nil {
-		sn.mustSendInsertRequest(pendingData)
-	}
 }
 
 var bbPool bytesutil.ByteBufferPool
 
-func (sn *storageNode) grabPendingDataForFlushLocked() *bytesutil.ByteBuffer {
+// flushPendingDataLocked moves sn.pendingData to sn.fq, so it could be sent to storage nodes.
+//
+// sn.pendingDataMu must be locked by the caller.
+func (sn *storageNode) flushPendingDataLocked() {
 	sn.pendingDataLastFlush = time.Now()
-	pendingData := sn.pendingData
-	sn.pendingData = <-sn.s.pendingDataBuffers
+	if sn.pendingData.Len() == 0 {
+		return
+	}
 
-	return pendingData
-}
+	bb := bbPool.Get()
+	bb.B = encoding.MarshalUint64(bb.B[:0], fasttime.UnixTimestamp())
+	bb.B = append(bb.B, sn.pendingData.B...)
+	sn.pendingData.Reset()
 
-func (sn *storageNode) mustSendInsertRequest(pendingData *bytesutil.ByteBuffer) {
-	defer func() {
-		pendingData.Reset()
-		sn.s.pendingDataBuffers <- pendingData
-	}()
+	// The persistent queue is always enabled, so the block is written either to the in-memory part of the queue
+	// or to the file-based part of the queue.
+	sn.fq.MustWriteBlockIgnoreDisabledPQ(bb.B)
+	bbPool.Put(bb)
+}
 
-	err := sn.sendInsertRequest(pendingData)
+// mustSendInsertRequest sends the given block read from sn.fq to sn or to any other available storage node.
+//
+// It returns false if the data couldn't be sent because the storage is stopped.
+func (sn *storageNode) mustSendInsertRequest(block []byte) bool {
+	err := sn.sendInsertRequest(block)
 	if err == nil {
-		return
+		return true
 	}
 
 	if !errors.Is(err, errTemporarilyDisabled) {
 		logger.Warnf("%s; re-routing the data block to the remaining nodes", err)
 	}
-	for !sn.s.sendInsertRequestToAnyNode(pendingData) {
-		logger.Errorf("cannot send pending data to storage nodes, since all of them are unavailable; re-trying to send the data in a second")
+	for !sn.s.sendInsertRequestToAnyNode(block) {
+		logger.Errorf("cannot send pending data to storage nodes%s, since all of them are unavailable; re-trying to send the data in a second", sn.s.groupLogSuffix())
 
 		t := timerpool.Get(time.Second)
 		select {
 		case <-sn.s.stopCh:
 			timerpool.Put(t)
-			logger.Errorf("dropping %d bytes of data, since there are no available storage nodes", pendingData.Len())
-			return
+			logger.Infof("keeping %d bytes of pending data in the persistent queue at %q, since there are no available storage nodes", len(block), sn.fq.Dirname())
+			return false
 		case <-t.C:
 			timerpool.Put(t)
 		}
 	}
+	return true
 }
 
-func (sn *storageNode) sendInsertRequest(pendingData *bytesutil.ByteBuffer) error {
-	dataLen := pendingData.Len()
-	if dataLen == 0 {
+// sendInsertRequest sends the given block read from sn.fq to sn.
+//
+// The block is sent in the encoding for the protocol version negotiated with sn.
+func (sn *storageNode) sendInsertRequest(block []byte) error {
+	if len(block) <= queueBlockHeaderSize {
 		// Nothing to send.
 		return nil
 	}
@@ -228,18 +494,28 @@ func (sn *storageNode) sendInsertRequest(pendingData *bytesutil.ByteBuffer) erro
 	ctx, cancel := contextutil.NewStopChanContext(sn.s.stopCh)
 	defer cancel()
 
+	// Storage nodes without capabilities support accept only the data without the queue timestamp.
+	version := sn.negotiator.GetVersion(ctx, insertPath, SupportedProtocolVersions, protocolVersionWithoutQueueTimestamp)
+	sn.protocolVersion.Store(uint64(capabilities.VersionNumber(version)))
+
+	data := block
+	if !HasQueueTimestamp(version) {
+		data = block[queueBlockHeaderSize:]
+	}
+	dataLen := len(data)
+
 	var body io.Reader
 	if !sn.s.disableCompression {
 		bb := zstdBufPool.Get()
 		defer zstdBufPool.Put(bb)
 
-		bb.B = zstd.CompressLevel(bb.B[:0], pendingData.B, 1)
+		bb.B = zstd.CompressLevel(bb.B[:0], data, 1)
 		body = bb.NewReader()
 	} else {
-		body = pendingData.NewReader()
+		body = bytes.NewReader(data)
 	}
 
-	reqURL := sn.getRequestURL("/internal/insert")
+	reqURL := sn.getRequestURL(insertPath, version)
 	req, err := http.NewRequestWithContext(ctx, "POST", reqURL, body)
 	if err != nil {
 		return fmt.Errorf("cannot create an http request for %q: %w", reqURL, err)
@@ -256,7 +532,7 @@ func (sn *storageNode) sendInsertRequest(pendingData *bytesutil.ByteBuffer) erro
 	resp, err := sn.c.Do(req)
 	if err != nil {
 		sn.setDisableTemporarily()
-		return fmt.Errorf("cannot send data block with the length %d to %q: %s", pendingData.Len(), reqURL, err)
+		return fmt.Errorf("cannot send data block with the length %d to %q: %s", dataLen, reqURL, err)
 	}
 	defer resp.Body.Close()
 
@@ -275,8 +551,8 @@ func (sn *storageNode) sendInsertRequest(pendingData *bytesutil.ByteBuffer) erro
 	return fmt.Errorf("unexpected status code returned when sending data block to %q: %d; want 2xx; response body: %q", reqURL, resp.StatusCode, respBody)
 }
 
-func (sn *storageNode) getRequestURL(path string) string {
-	return fmt.Sprintf("%s://%s%s?version=%s", sn.scheme, sn.addr, path, url.QueryEscape(ProtocolVersion))
+func (sn *storageNode) getRequestURL(path, version string) string {
+	return fmt.Sprintf("%s://%s%s?version=%s", sn.scheme, sn.addr, path, url.QueryEscape(version))
 }
 
 func (sn *storageNode) setDisableTemporarily() {
@@ -285,57 +561,108 @@ func (sn *storageNode) setDisableTemporarily() {
 
 	sn.sendErrors.Inc()
 	sn.isReachable.Store(false)
+
+	// The storage node may be restarted with another version, so re-negotiate the protocol version on the next request.
+	sn.negotiator.Reset()
 }
 
 var zstdBufPool bytesutil.ByteBufferPool
 
 // NewStorage returns new Storage for the given addrs with the given authCfgs.
 //
-// The concurrency is the average number of concurrent connections per every addr.
+// The group is an optional name of the group of storage nodes at addrs. It is used in metric labels
+// and for separating persistent queues of distinct groups at tmpDataPath.
+//
+// The concurrency is the number of concurrent senders per every addr. Data blocks buffered in memory are sent concurrently,
+// so they may be delivered to storage nodes out of order. This is OK, since the order of ingested spans doesn't matter.
+// Data blocks replayed from the file-based part of the persistent queue after storage node outages are sent in order.
 //
 // If disableCompression is set, then the data is sent uncompressed to the remote storage.
 //
+// Pending data, which cannot be sent to storage nodes in a timely manner, is stored in persistent queues at tmpDataPath.
+// If maxPendingBytesPerNode > 0, then the size of every persistent queue is limited by maxPendingBytesPerNode;
+// the oldest data is dropped when the limit is reached.
+//
 // Call MustStop on the returned storage when it is no longer needed.
-func NewStorage(addrs []string, authCfgs []*promauth.Config, isTLSs []bool, concurrency int, disableCompression bool) *Storage {
-	pendingDataBuffers := make(chan *bytesutil.ByteBuffer, concurrency*len(addrs))
-	for i := 0; i < cap(pendingDataBuffers); i++ {
-		pendingDataBuffers <- &bytesutil.ByteBuffer{}
+func NewStorage(group string, addrs []string, authCfgs []*promauth.Config, isTLSs []bool, concurrency int, disableCompression bool, tmpDataPath string, maxPendingBytesPerNode int64) *Storage {
+	if concurrency <= 0 {
+		concurrency = 1
 	}
 
 	s := &Storage{
+		group:              group,
 		disableCompression: disableCompression,
-		pendingDataBuffers: pendingDataBuffers,
 		stopCh:             make(chan struct{}),
 	}
 
 	sns := make([]*storageNode, len(addrs))
 	for i, addr := range addrs {
-		sns[i] = newStorageNode(s, addr, authCfgs[i], isTLSs[i])
+		sns[i] = newStorageNode(s, i, addr, authCfgs[i], isTLSs[i], concurrency, tmpDataPath, maxPendingBytesPerNode)
 	}
 	s.sns = sns
 
 	// active streams tracker
 	s.srt = newStreamRowsTracker(len(sns))
-	_ = metrics.GetOrCreateGauge(`vt_insert_active_streams`, func() float64 {
+	_ = metrics.GetOrCreateGauge(fmt.Sprintf(`vt_insert_active_streams%s`, s.metricLabelsSuffix()), func() float64 {
 		return float64(s.getActiveStreams())
 	})
+	_ = metrics.GetOrCreateGauge(fmt.Sprintf(`vt_insert_reachable_storage_nodes%s`, s.metricLabelsSuffix()), func() float64 {
+		return float64(s.getReachableNodes())
+	})
 
 	return s
 }
 
-// getActiveStreams returns the number of log streams being tracked since the Storage start.
-func (s *Storage) getActiveStreams() int {
-	s.srt.mu.Lock()
-	n := len(s.srt.rowsPerStream)
-	s.srt.mu.Unlock()
+// metricLabels returns labels for metrics exposed by s, which contain the given extraLabels.
+func (s *Storage) metricLabels(extraLabels string) string {
+	if s.group == "" {
+		return extraLabels
+	}
+	return fmt.Sprintf("group=%q,%s", s.group, extraLabels)
+}
 
+// metricLabelsSuffix returns labels in curly braces for metrics exposed by s without additional labels.
+func (s *Storage) metricLabelsSuffix() string {
+	if s.group == "" {
+		return ""
+	}
+	return fmt.Sprintf("{group=%q}", s.group)
+}
+
+// getReachableNodes returns the number of storage nodes at s, which are available for data writing.
+func (s *Storage) getReachableNodes() int {
+	n := 0
+	for _, sn := range s.sns {
+		if sn.isReachable.Load() {
+			n++
+		}
+	}
 	return n
 }
 
+// getActiveStreams returns the number of log streams, which received rows during the last hour or two.
+func (s *Storage) getActiveStreams() int {
+	return s.srt.getActiveStreams()
+}
+
 // MustStop stops the s.
+//
+// The pending data, which hasn't been sent to storage nodes yet, is persisted at tmpDataPath
+// and is sent to storage nodes after the next start.
 func (s *Storage) MustStop() {
 	close(s.stopCh)
+	for _, sn := range s.sns {
+		sn.fq.UnblockAllReaders()
+	}
 	s.wg.Wait()
+
+	for _, sn := range s.sns {
+		sn.pendingDataMu.Lock()
+		sn.flushPendingDataLocked()
+		sn.pendingDataMu.Unlock()
+
+		sn.fq.MustClose()
+	}
 	s.sns = nil
 }
 
@@ -346,12 +673,12 @@ func (s *Storage) AddRow(streamHash uint64, r *logstorage.InsertRow) {
 	sn.addRow(r)
 }
 
-func (s *Storage) sendInsertRequestToAnyNode(pendingData *bytesutil.ByteBuffer) bool {
+func (s *Storage) sendInsertRequestToAnyNode(block []byte) bool {
 	startIdx := int(fastrand.Uint32n(uint32(len(s.sns))))
 	for i := range s.sns {
 		idx := (startIdx + i) % len(s.sns)
 		sn := s.sns[idx]
-		err := sn.sendInsertRequest(pendingData)
+		err := sn.sendInsertRequest(block)
 		if err == nil {
 			return true
 		}
@@ -362,20 +689,89 @@ func (s *Storage) sendInsertRequestToAnyNode(pendingData *bytesutil.ByteBuffer)
 	return false
 }
 
+// groupLogSuffix returns a suffix for log messages, which identifies the group of storage nodes at s.
+func (s *Storage) groupLogSuffix() string {
+	if s.group == "" {
+		return ""
+	}
+	return fmt.Sprintf(" at the group %q", s.group)
+}
+
 var errTemporarilyDisabled = fmt.Errorf("writing to the node is temporarily disabled")
 
+const (
+	// streamRowsTrackerRotationInterval is the interval in seconds for rotating generations at streamRowsTracker.
+	//
+	// Streams without new rows during the last two intervals are forgotten by streamRowsTracker.
+	streamRowsTrackerRotationInterval = 3600
+
+	// streamRowsTrackerMaxStreams is the maximum number of streams in a single streamRowsTracker generation.
+	//
+	// The generation is rotated when it reaches this limit, so streamRowsTracker memory usage stays bounded
+	// even if the ingested streams have high cardinality.
+	streamRowsTrackerMaxStreams = 1 << 20
+)
+
+// streamRowsTracker tracks the number of rows per stream in order to route the initial rows for every stream
+// to a single storage node.
+//
+// It keeps per-stream counters in two generations - the current one and the previous one.
+// The current generation becomes the previous one every streamRowsTrackerRotationInterval seconds
+// or when it reaches streamRowsTrackerMaxStreams entries, while the previous generation is dropped.
+// Counters for streams, which continue receiving rows, are moved from the previous generation to the current one.
 type streamRowsTracker struct {
 	mu sync.Mutex
 
-	nodesCount    int64
+	nodesCount int64
+
+	// rowsPerStream contains per-stream row counters for the current generation.
 	rowsPerStream map[uint64]uint64
+
+	// prevRowsPerStream contains per-stream row counters for the previous generation,
+	// which didn't receive new rows during the current generation.
+	prevRowsPerStream map[uint64]uint64
+
+	// nextRotationTime is the unix timestamp in seconds when the current generation must be rotated.
+	nextRotationTime uint64
+
+	// rotationInterval is the interval in seconds between generation rotations.
+	rotationInterval uint64
+
+	// maxStreams is the maximum number of streams in the current generation.
+	maxStreams int
 }
 
 func newStreamRowsTracker(nodesCount int) *streamRowsTracker {
 	return &streamRowsTracker{
-		nodesCount:    int64(nodesCount),
-		rowsPerStream: make(map[uint64]uint64),
+		nodesCount:        int64(nodesCount),
+		rowsPerStream:     make(map[uint64]uint64),
+		prevRowsPerStream: make(map[uint64]uint64),
+		nextRotationTime:  fasttime.UnixTimestamp() + streamRowsTrackerRotationInterval,
+		rotationInterval:  streamRowsTrackerRotationInterval,
+		maxStreams:        streamRowsTrackerMaxStreams,
+	}
+}
+
+// getActiveStreams returns the number of streams tracked by srt.
+func (srt *streamRowsTracker) getActiveStreams() int {
+	srt.mu.Lock()
+	n := len(srt.rowsPerStream) + len(srt.prevRowsPerStream)
+	srt.mu.Unlock()
+
+	return n
+}
+
+// rotateIfNeededLocked rotates srt generations if needed.
+//
+// srt.mu must be locked by the caller.
+func (srt *streamRowsTracker) rotateIfNeededLocked(currentTime uint64) {
+	if currentTime < srt.nextRotationTime && len(srt.rowsPerStream) < srt.maxStreams {
+		return
 	}
+
+	srt.prevRowsPerStream = srt.rowsPerStream
+	srt.rowsPerStream = make(map[uint64]uint64)
+	srt.nextRotationTime = currentTime + srt.rotationInterval
 }
 
 func (srt *streamRowsTracker) getNodeIdx(streamHash uint64) uint64 {
@@ -387,7 +783,18 @@ func (srt *streamRowsTracker) getNodeIdx(streamHash uint64) uint64 {
 	srt.mu.Lock()
 	defer srt.mu.Unlock()
 
-	streamRows := srt.rowsPerStream[streamHash] + 1
+	srt.rotateIfNeededLocked(fasttime.UnixTimestamp())
+
+	streamRows, ok := srt.rowsPerStream[streamHash]
+	if !ok {
+		// Move the counter for the stream from the previous generation, so it isn't forgotten on the next rotation.
+		streamRows = srt.prevRowsPerStream[streamHash]
+		delete(srt.prevRowsPerStream, streamHash)
+	}
+	if streamRows <= 1000 {
+		// There is no need in counting rows above 1000, since they are distributed at random.
+		streamRows++
+	}
 	srt.rowsPerStream[streamHash] = streamRows
 
 	if streamRows <= 1000 {
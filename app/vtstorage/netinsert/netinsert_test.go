@@ -2,11 +2,23 @@ package netinsert
 
 import (
 	"fmt"
+	"io"
 	"math"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promauth"
 	"github.com/cespare/xxhash/v2"
+
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtstorage/capabilities"
 )
 
 func TestStreamRowsTracker(t *testing.T) {
@@ -55,3 +67,410 @@ func TestStreamRowsTracker(t *testing.T) {
 	nodesCount = 9
 	f(rowsCount, streamsCount, nodesCount)
 }
+
+func TestStreamRowsTrackerRotation(t *testing.T) {
+	const nodesCount = 5
+	srt := newStreamRowsTracker(nodesCount)
+	srt.maxStreams = 100
+
+	activeStream := xxhash.Sum64String("active stream")
+
+	// Ingest rows for many short-lived streams together with a single long-lived stream.
+	for i := 0; i < 10000; i++ {
+		h := xxhash.Sum64([]byte(fmt.Sprintf("stream %d.", i)))
+		srt.getNodeIdx(h)
+
+		if i%10 == 0 {
+			// The initial rows for the long-lived stream must go to the same node regardless of generation rotations.
+			nodeIdx := srt.getNodeIdx(activeStream)
+			if nodeIdx != activeStream%nodesCount {
+				t.Fatalf("unexpected node for row #%d of the active stream; got %d; want %d", i/10, nodeIdx, activeStream%nodesCount)
+			}
+		}
+
+		if n := srt.getActiveStreams(); n > 2*srt.maxStreams {
+			t.Fatalf("too many tracked streams: %d; mustn't exceed %d", n, 2*srt.maxStreams)
+		}
+	}
+
+	// The long-lived stream must be spread among all the nodes after it receives more than 1000 rows.
+	rowsPerNode := make([]int, nodesCount)
+	for i := 0; i < 10000; i++ {
+		rowsPerNode[srt.getNodeIdx(activeStream)]++
+	}
+	for nodeIdx, n := range rowsPerNode {
+		if n == 0 {
+			t.Fatalf("missing rows for the active stream at node %d; rowsPerNode=%d", nodeIdx, rowsPerNode)
+		}
+	}
+
+	// Time-based rotation must forget inactive streams.
+	srt.rotateIfNeededLocked(srt.nextRotationTime)
+	srt.rotateIfNeededLocked(srt.nextRotationTime)
+	if n := srt.getActiveStreams(); n != 0 {
+		t.Fatalf("unexpected number of tracked streams after two rotations; got %d; want 0", n)
+	}
+}
+
+func TestStoragePersistentQueue(t *testing.T) {
+	var isAvailable atomic.Bool
+	var receivedRowsLock sync.Mutex
+	var receivedRows []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isAvailable.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("cannot read request body: %s", err)
+			return
+		}
+
+		ir := logstorage.GetInsertRow()
+		defer logstorage.PutInsertRow(ir)
+		src := data
+		for len(src) > 0 {
+			tail, err := ir.UnmarshalInplace(src)
+			if err != nil {
+				t.Errorf("cannot unmarshal row: %s", err)
+				return
+			}
+			src = tail
+			receivedRowsLock.Lock()
+			receivedRows = append(receivedRows, ir.Fields[0].Value)
+			receivedRowsLock.Unlock()
+		}
+	}))
+	defer srv.Close()
+
+	addr := strings.TrimPrefix(srv.URL, "http://")
+	tmpDataPath := t.TempDir()
+	newStorage := func() *Storage {
+		ac, err := (&promauth.Options{}).NewConfig()
+		if err != nil {
+			t.Fatalf("cannot create auth config: %s", err)
+		}
+		return NewStorage("", []string{addr}, []*promauth.Config{ac}, []bool{false}, 1, true, tmpDataPath, 0)
+	}
+
+	addRows := func(s *Storage, start, end int) {
+		for i := start; i < end; i++ {
+			s.AddRow(0, &logstorage.InsertRow{
+				Fields: []logstorage.Field{{Name: "_msg", Value: fmt.Sprintf("row %d", i)}},
+			})
+		}
+	}
+	waitFor := func(f func() bool, what string) {
+		t.Helper()
+
+		deadline := time.Now().Add(10 * time.Second)
+		for !f() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timeout while waiting for %s", what)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	getReceivedRows := func() int {
+		receivedRowsLock.Lock()
+		defer receivedRowsLock.Unlock()
+		return len(receivedRows)
+	}
+
+	// Ingest rows while the storage node is unavailable and restart the Storage.
+	s := newStorage()
+	sn := s.sns[0]
+	addRows(s, 0, 10)
+	waitFor(func() bool {
+		return sn.inflightBlocks.Load() == 1
+	}, "the sender to read the first block from the queue")
+	addRows(s, 10, 20)
+	waitFor(func() bool {
+		return sn.fq.GetPendingBytes() > 0
+	}, "the background flusher to put the second block to the queue")
+	s.MustStop()
+
+	// The pending rows must be sent in the original order after the restart once the storage node becomes available.
+	isAvailable.Store(true)
+	s = newStorage()
+	defer s.MustStop()
+
+	waitFor(func() bool {
+		return getReceivedRows() == 20
+	}, "pending rows")
+
+	for i, row := range receivedRows {
+		want := fmt.Sprintf("row %d", i)
+		if row != want {
+			t.Fatalf("unexpected row #%d; got %q; want %q", i, row, want)
+		}
+	}
+}
+
+func TestStorageConcurrentSenders(t *testing.T) {
+	f := func(concurrency int, minConcurrentRequestsExpected int64) {
+		t.Helper()
+
+		var concurrentRequests, maxConcurrentRequests atomic.Int64
+		var receivedRows atomic.Int64
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != insertPath {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			n := concurrentRequests.Add(1)
+			defer concurrentRequests.Add(-1)
+			for {
+				maxN := maxConcurrentRequests.Load()
+				if n <= maxN || maxConcurrentRequests.CompareAndSwap(maxN, n) {
+					break
+				}
+			}
+
+			data, err := io.ReadAll(r.Body)
+			if err != nil {
+				t.Errorf("cannot read request body: %s", err)
+				return
+			}
+			if HasQueueTimestamp(r.FormValue("version")) {
+				data = data[QueueTimestampSize:]
+			}
+
+			// Emulate the storage node with noticeable latency.
+			time.Sleep(100 * time.Millisecond)
+
+			ir := logstorage.GetInsertRow()
+			defer logstorage.PutInsertRow(ir)
+			for len(data) > 0 {
+				tail, err := ir.UnmarshalInplace(data)
+				if err != nil {
+					t.Errorf("cannot unmarshal row: %s", err)
+					return
+				}
+				data = tail
+				receivedRows.Add(1)
+			}
+		}))
+		defer srv.Close()
+
+		ac, err := (&promauth.Options{}).NewConfig()
+		if err != nil {
+			t.Fatalf("cannot create auth config: %s", err)
+		}
+		addr := strings.TrimPrefix(srv.URL, "http://")
+		s := NewStorage("", []string{addr}, []*promauth.Config{ac}, []bool{false}, concurrency, true, t.TempDir(), 0)
+		defer s.MustStop()
+
+		// Ingest rows, which occupy multiple data blocks fitting the in-memory part of the queue.
+		value := strings.Repeat("x", 100*1024)
+		rowsCount := int64(8 * maxInsertBlockSize / len(value))
+		startTime := time.Now()
+		for i := int64(0); i < rowsCount; i++ {
+			s.AddRow(0, &logstorage.InsertRow{
+				Fields: []logstorage.Field{{Name: "_msg", Value: value}},
+			})
+		}
+
+		deadline := time.Now().Add(10 * time.Second)
+		for receivedRows.Load() != rowsCount {
+			if time.Now().After(deadline) {
+				t.Fatalf("timeout while waiting for rows; got %d rows; want %d rows", receivedRows.Load(), rowsCount)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Logf("concurrency=%d: sent %d rows in %.3f seconds; max concurrent requests: %d", concurrency, rowsCount, time.Since(startTime).Seconds(), maxConcurrentRequests.Load())
+
+		if n := maxConcurrentRequests.Load(); n < minConcurrentRequestsExpected || n > int64(concurrency) {
+			t.Fatalf("unexpected max number of concurrent requests; got %d; want from %d to %d", n, minConcurrentRequestsExpected, concurrency)
+		}
+	}
+
+	// A single sender sends blocks one by one
+	f(1, 1)
+
+	// Multiple senders send blocks in parallel
+	f(4, 2)
+}
+
+func TestStorageGroupsIndependence(t *testing.T) {
+	newServer := func(isAvailable *atomic.Bool, receivedRows *atomic.Int64) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !isAvailable.Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			data, err := io.ReadAll(r.Body)
+			if err != nil {
+				t.Errorf("cannot read request body: %s", err)
+				return
+			}
+
+			ir := logstorage.GetInsertRow()
+			defer logstorage.PutInsertRow(ir)
+			for len(data) > 0 {
+				tail, err := ir.UnmarshalInplace(data)
+				if err != nil {
+					t.Errorf("cannot unmarshal row: %s", err)
+					return
+				}
+				data = tail
+				receivedRows.Add(1)
+			}
+		}))
+	}
+
+	var isAvailable1, isAvailable2 atomic.Bool
+	var receivedRows1, receivedRows2 atomic.Int64
+	isAvailable2.Store(true)
+	srv1 := newServer(&isAvailable1, &receivedRows1)
+	defer srv1.Close()
+	srv2 := newServer(&isAvailable2, &receivedRows2)
+	defer srv2.Close()
+
+	ac, err := (&promauth.Options{}).NewConfig()
+	if err != nil {
+		t.Fatalf("cannot create auth config: %s", err)
+	}
+	tmpDataPath := t.TempDir()
+	newGroup := func(group string, srv *httptest.Server) *Storage {
+		addr := strings.TrimPrefix(srv.URL, "http://")
+		return NewStorage(group, []string{addr}, []*promauth.Config{ac}, []bool{false}, 1, true, tmpDataPath, 0)
+	}
+	groups := []*Storage{
+		newGroup("az1", srv1),
+		newGroup("az2", srv2),
+	}
+	defer func() {
+		for _, s := range groups {
+			s.MustStop()
+		}
+	}()
+
+	for i := 0; i < 10; i++ {
+		r := &logstorage.InsertRow{
+			Fields: []logstorage.Field{{Name: "_msg", Value: fmt.Sprintf("row %d", i)}},
+		}
+		for _, s := range groups {
+			s.AddRow(0, r)
+		}
+	}
+
+	waitForRows := func(receivedRows *atomic.Int64) {
+		t.Helper()
+
+		// The storage node is disabled for 10 seconds after a failed request, so wait for a longer time.
+		deadline := time.Now().Add(30 * time.Second)
+		for receivedRows.Load() != 10 {
+			if time.Now().After(deadline) {
+				t.Fatalf("timeout while waiting for rows; got %d rows; want 10 rows", receivedRows.Load())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// The unavailable group must not block data ingestion into the available group.
+	waitForRows(&receivedRows2)
+	if n := receivedRows1.Load(); n != 0 {
+		t.Fatalf("unexpected rows received by the unavailable group; got %d; want 0", n)
+	}
+
+	// The unavailable group must receive the buffered rows after it becomes available.
+	isAvailable1.Store(true)
+	waitForRows(&receivedRows1)
+}
+
+func TestStorageProtocolVersionDowngrade(t *testing.T) {
+	f := func(remoteVersions []string, versionExpected string) {
+		t.Helper()
+
+		var receivedRows atomic.Int64
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case capabilities.Path:
+				if remoteVersions == nil {
+					// Emulate the storage node without capabilities support.
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				c := &capabilities.Capabilities{
+					ProtocolVersions: map[string][]string{
+						insertPath: remoteVersions,
+					},
+				}
+				c.WriteResponse(w)
+			case insertPath:
+				version := r.FormValue("version")
+				if version != versionExpected {
+					t.Errorf("unexpected version; got %q; want %q", version, versionExpected)
+				}
+				data, err := io.ReadAll(r.Body)
+				if err != nil {
+					t.Errorf("cannot read request body: %s", err)
+					return
+				}
+				if HasQueueTimestamp(version) {
+					if len(data) < QueueTimestampSize {
+						t.Errorf("missing queue timestamp in the request body")
+						return
+					}
+					ts := int64(encoding.UnmarshalUint64(data[:QueueTimestampSize]))
+					if d := time.Now().Unix() - ts; d < 0 || d > 60 {
+						t.Errorf("unexpected queue timestamp %d", ts)
+					}
+					data = data[QueueTimestampSize:]
+				}
+
+				ir := logstorage.GetInsertRow()
+				defer logstorage.PutInsertRow(ir)
+				for len(data) > 0 {
+					tail, err := ir.UnmarshalInplace(data)
+					if err != nil {
+						t.Errorf("cannot unmarshal row: %s", err)
+						return
+					}
+					data = tail
+					receivedRows.Add(1)
+				}
+			default:
+				t.Errorf("unexpected path: %q", r.URL.Path)
+			}
+		}))
+		defer srv.Close()
+
+		ac, err := (&promauth.Options{}).NewConfig()
+		if err != nil {
+			t.Fatalf("cannot create auth config: %s", err)
+		}
+		addr := strings.TrimPrefix(srv.URL, "http://")
+		s := NewStorage("", []string{addr}, []*promauth.Config{ac}, []bool{false}, 1, true, t.TempDir(), 0)
+		defer s.MustStop()
+
+		for i := 0; i < 10; i++ {
+			s.AddRow(0, &logstorage.InsertRow{
+				Fields: []logstorage.Field{{Name: "_msg", Value: fmt.Sprintf("row %d", i)}},
+			})
+		}
+
+		deadline := time.Now().Add(10 * time.Second)
+		for receivedRows.Load() != 10 {
+			if time.Now().After(deadline) {
+				t.Fatalf("timeout while waiting for rows; got %d rows; want 10 rows", receivedRows.Load())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// The storage node supports the newest version
+	f([]string{ProtocolVersion, protocolVersionWithoutQueueTimestamp}, ProtocolVersion)
+
+	// The storage node supports only the previous version
+	f([]string{protocolVersionWithoutQueueTimestamp}, protocolVersionWithoutQueueTimestamp)
+
+	// The storage node supports a newer version
+	f([]string{"v3", ProtocolVersion, protocolVersionWithoutQueueTimestamp}, ProtocolVersion)
+
+	// The storage node doesn't support capabilities
+	f(nil, protocolVersionWithoutQueueTimestamp)
+}
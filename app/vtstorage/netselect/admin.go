@@ -0,0 +1,76 @@
+package netselect
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"sync"
+)
+
+// AdminResponse contains the response from a single storage node for the admin request.
+type AdminResponse struct {
+	// Addr is the address of the storage node.
+	Addr string `json:"addr"`
+
+	// Result contains JSON response from the storage node.
+	//
+	// It is empty if the storage node returned an empty response or an error.
+	Result json.RawMessage `json:"result,omitempty"`
+
+	// Error contains an error message if the request to the storage node failed.
+	Error string `json:"error,omitempty"`
+}
+
+// RunAdminRequest sends the request with the given args to the given path at all the storage nodes in parallel.
+//
+// It returns responses from all the storage nodes in the order of storage nodes. Failed requests to some storage nodes
+// do not cancel requests to the remaining storage nodes, so the caller can report partial failures.
+func (s *Storage) RunAdminRequest(ctx context.Context, path string, args url.Values) []AdminResponse {
+	responses := make([]AdminResponse, len(s.sns))
+
+	var wg sync.WaitGroup
+	for i := range s.sns {
+		wg.Add(1)
+		go func(nodeIdx int) {
+			defer wg.Done()
+
+			sn := s.sns[nodeIdx]
+			resp := &responses[nodeIdx]
+			resp.Addr = sn.addr
+
+			result, err := sn.runAdminRequest(ctx, path, args)
+			if err != nil {
+				sn.sendErrors.Inc()
+				resp.Error = err.Error()
+				return
+			}
+			resp.Result = result
+		}(i)
+	}
+	wg.Wait()
+
+	return responses
+}
+
+func (sn *storageNode) runAdminRequest(ctx context.Context, path string, args url.Values) (json.RawMessage, error) {
+	responseBody, reqURL, err := sn.getResponseBodyForPathAndArgs(ctx, path, args)
+	if err != nil {
+		return nil, err
+	}
+	defer responseBody.Close()
+
+	data, err := io.ReadAll(responseBody)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read response from %q: %w", reqURL, err)
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if !json.Valid(data) {
+		// Wrap non-JSON response into JSON string, so it could be embedded into the aggregated response.
+		data, _ = json.Marshal(string(data))
+	}
+	return data, nil
+}
@@ -0,0 +1,68 @@
+package netselect
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promauth"
+)
+
+func TestStorageRunAdminRequest(t *testing.T) {
+	newServer := func(statusCode int, response string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/internal/partition/list" {
+				t.Errorf("unexpected path: %q", r.URL.Path)
+			}
+			if name := r.FormValue("name"); name != "20250101" {
+				t.Errorf("unexpected name arg; got %q; want %q", name, "20250101")
+			}
+			w.WriteHeader(statusCode)
+			w.Write([]byte(response))
+		}))
+	}
+	servers := []*httptest.Server{
+		newServer(http.StatusOK, `["20250101","20250102"]`),
+		newServer(http.StatusOK, ``),
+		newServer(http.StatusOK, `not json`),
+		newServer(http.StatusBadRequest, `cannot find partition`),
+	}
+
+	ac, err := (&promauth.Options{}).NewConfig()
+	if err != nil {
+		t.Fatalf("cannot create auth config: %s", err)
+	}
+	addrs := make([]string, len(servers))
+	authCfgs := make([]*promauth.Config, len(servers))
+	for i, srv := range servers {
+		defer srv.Close()
+		addrs[i] = strings.TrimPrefix(srv.URL, "http://")
+		authCfgs[i] = ac
+	}
+	s := NewStorage(addrs, make([]string, len(addrs)), authCfgs, make([]bool, len(addrs)), true)
+	defer s.MustStop()
+
+	args := url.Values{}
+	args.Set("name", "20250101")
+	responses := s.RunAdminRequest(context.Background(), "/internal/partition/list", args)
+	if len(responses) != len(servers) {
+		t.Fatalf("unexpected number of responses; got %d; want %d", len(responses), len(servers))
+	}
+
+	resultsExpected := []string{`["20250101","20250102"]`, ``, `"not json"`, ``}
+	for i, resp := range responses {
+		if resp.Addr != addrs[i] {
+			t.Fatalf("unexpected addr for response #%d; got %q; want %q", i, resp.Addr, addrs[i])
+		}
+		if string(resp.Result) != resultsExpected[i] {
+			t.Fatalf("unexpected result for response #%d; got %s; want %s", i, resp.Result, resultsExpected[i])
+		}
+		isErrorExpected := i == len(servers)-1
+		if (resp.Error != "") != isErrorExpected {
+			t.Fatalf("unexpected error for response #%d: %q", i, resp.Error)
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
@@ -22,6 +23,9 @@ import (
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promauth"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/slicesutil"
 	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtstorage/capabilities"
+	otelpb "github.com/VictoriaMetrics/VictoriaTraces/lib/protoparser/opentelemetry/pb"
 )
 
 const (
@@ -59,13 +63,40 @@ const (
 	//
 	// It must be updated every time the protocol changes.
 	QueryProtocolVersion = "v2"
+
+	// protocolVersionWithoutQueryStats is the previous version of the protocol for all the /internal/select/* HTTP endpoints.
+	//
+	// It differs from the current version by the lack of query stats in responses.
+	protocolVersionWithoutQueryStats = "v1"
 )
 
+// SupportedProtocolVersions contains the supported protocol versions per every /internal/select/* HTTP endpoint.
+//
+// The versions are ordered from the newest to the oldest. The previous version is kept, so vtselect and vtstorage nodes
+// could be upgraded in any order. The version for every storage node is negotiated via capabilities.Path HTTP endpoint.
+var SupportedProtocolVersions = map[string][]string{
+	"/internal/select/query":               {QueryProtocolVersion, protocolVersionWithoutQueryStats},
+	"/internal/select/field_names":         {FieldNamesProtocolVersion, protocolVersionWithoutQueryStats},
+	"/internal/select/field_values":        {FieldValuesProtocolVersion, protocolVersionWithoutQueryStats},
+	"/internal/select/stream_field_names":  {StreamFieldNamesProtocolVersion, protocolVersionWithoutQueryStats},
+	"/internal/select/stream_field_values": {StreamFieldValuesProtocolVersion, protocolVersionWithoutQueryStats},
+	"/internal/select/streams":             {StreamsProtocolVersion, protocolVersionWithoutQueryStats},
+	"/internal/select/stream_ids":          {StreamIDsProtocolVersion, protocolVersionWithoutQueryStats},
+}
+
+// HasQueryStats returns true if responses for the given protocol version contain query stats.
+func HasQueryStats(version string) bool {
+	return version != protocolVersionWithoutQueryStats
+}
+
 // Storage is a network storage for querying remote storage nodes in the cluster.
 type Storage struct {
 	sns []*storageNode
 
 	disableCompression bool
+
+	// hasRegions is set to true if at least a single storage node has non-empty region.
+	hasRegions bool
 }
 
 type storageNode struct {
@@ -75,6 +106,11 @@ type storageNode struct {
 	// addr is TCP address of the storage node to query
 	addr string
 
+	// region is an optional region name for the storage node.
+	//
+	// It is added to query results in otelpb.RegionField field, so it is possible to determine which region returned the data.
+	region string
+
 	// s is a storage, which holds the given storageNode
 	s *Storage
 
@@ -84,11 +120,17 @@ type storageNode struct {
 	// ac is auth config used for setting request headers such as Authorization and Host.
 	ac *promauth.Config
 
+	// negotiator negotiates protocol versions with the storage node.
+	negotiator *capabilities.Negotiator
+
+	// protocolVersion is the numeric representation of the protocol version used for the last query to the storage node.
+	protocolVersion atomic.Uint64
+
 	// sendErrors counts failed send attempts for this storage node.
 	sendErrors *metrics.Counter
 }
 
-func newStorageNode(s *Storage, addr string, ac *promauth.Config, isTLS bool) *storageNode {
+func newStorageNode(s *Storage, addr, region string, ac *promauth.Config, isTLS bool) *storageNode {
 	tr := httputil.NewTransport(false, "vtselect_backend")
 	tr.TLSHandshakeTimeout = 20 * time.Second
 	tr.DisableCompression = true
@@ -98,27 +140,47 @@ func newStorageNode(s *Storage, addr string, ac *promauth.Config, isTLS bool) *s
 		scheme = "https"
 	}
 
+	c := &http.Client{
+		Transport: ac.NewRoundTripper(tr),
+	}
 	sn := &storageNode{
 		scheme: scheme,
 		addr:   addr,
+		region: region,
 		s:      s,
-		c: &http.Client{
-			Transport: ac.NewRoundTripper(tr),
-		},
-		ac: ac,
+		c:      c,
+		ac:     ac,
+
+		negotiator: capabilities.NewNegotiator(c, ac, scheme, addr),
 
 		sendErrors: metrics.GetOrCreateCounter(fmt.Sprintf(`vt_select_remote_send_errors_total{addr=%q}`, addr)),
 	}
+
+	_ = metrics.GetOrCreateGauge(fmt.Sprintf(`vt_select_remote_protocol_version{addr=%q}`, addr), func() float64 {
+		return float64(sn.protocolVersion.Load())
+	})
+
 	return sn
 }
 
+// getProtocolVersion returns the protocol version for the given path, which is supported by the storage node.
+func (sn *storageNode) getProtocolVersion(ctx context.Context, path string) string {
+	// Storage nodes without capabilities support use the newest protocol version, since it hasn't changed since then.
+	versions := SupportedProtocolVersions[path]
+	version := sn.negotiator.GetVersion(ctx, path, versions, versions[0])
+	sn.protocolVersion.Store(uint64(capabilities.VersionNumber(version)))
+	return version
+}
+
 func (sn *storageNode) runQuery(qctx *logstorage.QueryContext, processBlock func(db *logstorage.DataBlock)) error {
-	args := sn.getCommonArgs(QueryProtocolVersion, qctx)
+	path := "/internal/select/query"
+	version := sn.getProtocolVersion(qctx.Context, path)
+	hasQueryStats := HasQueryStats(version)
+	args := sn.getCommonArgs(version, qctx)
 
 	qsLocal := &logstorage.QueryStats{}
 	defer qctx.QueryStats.UpdateAtomic(qsLocal)
 
-	path := "/internal/select/query"
 	responseBody, reqURL, err := sn.getResponseBodyForPathAndArgs(qctx.Context, path, args)
 	if err != nil {
 		return err
@@ -160,16 +222,18 @@ func (sn *storageNode) runQuery(qctx *logstorage.QueryContext, processBlock func
 		}
 
 		for len(src) > 0 {
-			isQueryStatsBlock := (src[0] == 1)
-			src = src[1:]
-
-			if isQueryStatsBlock {
-				tail, err := unmarshalQueryStats(qsLocal, src)
-				if err != nil {
-					return fmt.Errorf("cannot unmarshal query stats received from %q: %w", reqURL, err)
+			if hasQueryStats {
+				isQueryStatsBlock := (src[0] == 1)
+				src = src[1:]
+
+				if isQueryStatsBlock {
+					tail, err := unmarshalQueryStats(qsLocal, src)
+					if err != nil {
+						return fmt.Errorf("cannot unmarshal query stats received from %q: %w", reqURL, err)
+					}
+					src = tail
+					continue
 				}
-				src = tail
-				continue
 			}
 
 			tail, vb, err := db.UnmarshalInplace(src, valuesBuf[:0])
@@ -187,45 +251,37 @@ func (sn *storageNode) runQuery(qctx *logstorage.QueryContext, processBlock func
 }
 
 func (sn *storageNode) getFieldNames(qctx *logstorage.QueryContext) ([]logstorage.ValueWithHits, error) {
-	args := sn.getCommonArgs(FieldNamesProtocolVersion, qctx)
-
-	return sn.getValuesWithHits(qctx, "/internal/select/field_names", args)
+	return sn.getValuesWithHits(qctx, "/internal/select/field_names", nil)
 }
 
 func (sn *storageNode) getFieldValues(qctx *logstorage.QueryContext, fieldName string, limit uint64) ([]logstorage.ValueWithHits, error) {
-	args := sn.getCommonArgs(FieldValuesProtocolVersion, qctx)
-	args.Set("field", fieldName)
-	args.Set("limit", fmt.Sprintf("%d", limit))
-
-	return sn.getValuesWithHits(qctx, "/internal/select/field_values", args)
+	return sn.getValuesWithHits(qctx, "/internal/select/field_values", func(args url.Values) {
+		args.Set("field", fieldName)
+		args.Set("limit", fmt.Sprintf("%d", limit))
+	})
 }
 
 func (sn *storageNode) getStreamFieldNames(qctx *logstorage.QueryContext) ([]logstorage.ValueWithHits, error) {
-	args := sn.getCommonArgs(StreamFieldNamesProtocolVersion, qctx)
-
-	return sn.getValuesWithHits(qctx, "/internal/select/stream_field_names", args)
+	return sn.getValuesWithHits(qctx, "/internal/select/stream_field_names", nil)
 }
 
 func (sn *storageNode) getStreamFieldValues(qctx *logstorage.QueryContext, fieldName string, limit uint64) ([]logstorage.ValueWithHits, error) {
-	args := sn.getCommonArgs(StreamFieldValuesProtocolVersion, qctx)
-	args.Set("field", fieldName)
-	args.Set("limit", fmt.Sprintf("%d", limit))
-
-	return sn.getValuesWithHits(qctx, "/internal/select/stream_field_values", args)
+	return sn.getValuesWithHits(qctx, "/internal/select/stream_field_values", func(args url.Values) {
+		args.Set("field", fieldName)
+		args.Set("limit", fmt.Sprintf("%d", limit))
+	})
 }
 
 func (sn *storageNode) getStreams(qctx *logstorage.QueryContext, limit uint64) ([]logstorage.ValueWithHits, error) {
-	args := sn.getCommonArgs(StreamsProtocolVersion, qctx)
-	args.Set("limit", fmt.Sprintf("%d", limit))
-
-	return sn.getValuesWithHits(qctx, "/internal/select/streams", args)
+	return sn.getValuesWithHits(qctx, "/internal/select/streams", func(args url.Values) {
+		args.Set("limit", fmt.Sprintf("%d", limit))
+	})
 }
 
 func (sn *storageNode) getStreamIDs(qctx *logstorage.QueryContext, limit uint64) ([]logstorage.ValueWithHits, error) {
-	args := sn.getCommonArgs(StreamIDsProtocolVersion, qctx)
-	args.Set("limit", fmt.Sprintf("%d", limit))
-
-	return sn.getValuesWithHits(qctx, "/internal/select/stream_ids", args)
+	return sn.getValuesWithHits(qctx, "/internal/select/stream_ids", func(args url.Values) {
+		args.Set("limit", fmt.Sprintf("%d", limit))
+	})
 }
 
 func (sn *storageNode) getCommonArgs(version string, qctx *logstorage.QueryContext) url.Values {
@@ -238,12 +294,21 @@ func (sn *storageNode) getCommonArgs(version string, qctx *logstorage.QueryConte
 	return args
 }
 
-func (sn *storageNode) getValuesWithHits(qctx *logstorage.QueryContext, path string, args url.Values) ([]logstorage.ValueWithHits, error) {
+// getValuesWithHits requests values with hits from the given path at the storage node.
+//
+// If addArgs isn't nil, then it is called for adding path-specific args to the request.
+func (sn *storageNode) getValuesWithHits(qctx *logstorage.QueryContext, path string, addArgs func(args url.Values)) ([]logstorage.ValueWithHits, error) {
+	version := sn.getProtocolVersion(qctx.Context, path)
+	args := sn.getCommonArgs(version, qctx)
+	if addArgs != nil {
+		addArgs(args)
+	}
+
 	data, err := sn.getResponseForPathAndArgs(qctx.Context, path, args)
 	if err != nil {
 		return nil, err
 	}
-	return unmarshalValuesWithHits(qctx, data)
+	return unmarshalValuesWithHits(qctx, data, HasQueryStats(version))
 }
 
 func (sn *storageNode) getResponseForPathAndArgs(ctx context.Context, path string, args url.Values) ([]byte, error) {
@@ -298,6 +363,10 @@ func (sn *storageNode) getResponseBodyForPathAndArgs(ctx context.Context, path s
 			responseBody = []byte(err.Error())
 		}
 		_ = resp.Body.Close()
+
+		// The storage node may be restarted with another version, so re-negotiate the protocol version on the next request.
+		sn.negotiator.Reset()
+
 		return nil, "", fmt.Errorf("unexpected response status code from %q: %d; want %d; response: %q", reqURL, resp.StatusCode, http.StatusOK, responseBody)
 	}
 
@@ -310,17 +379,23 @@ func (sn *storageNode) getRequestURL(path string) string {
 
 // NewStorage returns new Storage for the given addrs and the given authCfgs.
 //
+// regions contains optional region names for the given addrs. Non-empty region names are added
+// to the results of queries without pipes in otelpb.RegionField field.
+//
 // If disableCompression is set, then uncompressed responses are received from storage nodes.
 //
 // Call MustStop on the returned storage when it is no longer needed.
-func NewStorage(addrs []string, authCfgs []*promauth.Config, isTLSs []bool, disableCompression bool) *Storage {
+func NewStorage(addrs, regions []string, authCfgs []*promauth.Config, isTLSs []bool, disableCompression bool) *Storage {
 	s := &Storage{
 		disableCompression: disableCompression,
 	}
 
 	sns := make([]*storageNode, len(addrs))
 	for i, addr := range addrs {
-		sns[i] = newStorageNode(s, addr, authCfgs[i], isTLSs[i])
+		sns[i] = newStorageNode(s, addr, regions[i], authCfgs[i], isTLSs[i])
+		if regions[i] != "" {
+			s.hasRegions = true
+		}
 	}
 	s.sns = sns
 
@@ -339,16 +414,20 @@ func (s *Storage) RunQuery(qctx *logstorage.QueryContext, writeBlock logstorage.
 		return err
 	}
 
+	// Region names can be safely added only to the results of queries without pipes,
+	// since pipes may rely on the exact set of columns returned from storage nodes.
+	addRegion := s.hasRegions && !hasPipes(qctx.Query)
+
 	search := func(stopCh <-chan struct{}, q *logstorage.Query, writeBlock logstorage.WriteDataBlockFunc) error {
 		qctxLocal := qctx.WithQuery(q)
-		return s.runQuery(stopCh, qctxLocal, writeBlock)
+		return s.runQuery(stopCh, qctxLocal, addRegion, writeBlock)
 	}
 
 	concurrency := qctx.Query.GetConcurrency()
 	return nqr.Run(qctx.Context, concurrency, search)
 }
 
-func (s *Storage) runQuery(stopCh <-chan struct{}, qctx *logstorage.QueryContext, writeBlock logstorage.WriteDataBlockFunc) error {
+func (s *Storage) runQuery(stopCh <-chan struct{}, qctx *logstorage.QueryContext, addRegion bool, writeBlock logstorage.WriteDataBlockFunc) error {
 	ctxWithCancel, cancel := contextutil.NewStopChanContext(stopCh)
 	defer cancel()
 
@@ -363,7 +442,11 @@ func (s *Storage) runQuery(stopCh <-chan struct{}, qctx *logstorage.QueryContext
 			defer wg.Done()
 
 			sn := s.sns[nodeIdx]
+			var regionValues []string
 			err := sn.runQuery(qctxLocal, func(db *logstorage.DataBlock) {
+				if addRegion && sn.region != "" {
+					regionValues = addRegionColumn(db, sn.region, regionValues)
+				}
 				writeBlock(uint(nodeIdx), db)
 			})
 			if err != nil {
@@ -480,6 +563,34 @@ func (s *Storage) getValuesWithHits(qctx *logstorage.QueryContext, limit uint64,
 	return vhs, nil
 }
 
+// hasPipes returns true if q contains pipes.
+func hasPipes(q *logstorage.Query) bool {
+	qNoPipes := q.Clone(q.GetTimestamp())
+	qNoPipes.DropAllPipes()
+	return qNoPipes.String() != q.String()
+}
+
+// addRegionColumn adds otelpb.RegionField column with the given region to db, if db doesn't contain such a column yet.
+//
+// The column may already exist if the storage node is a lower-level vtselect, which adds its own regions.
+//
+// It returns the values buffer, which can be re-used for the next call.
+func addRegionColumn(db *logstorage.DataBlock, region string, valuesBuf []string) []string {
+	if db.GetColumnByName(otelpb.RegionField) != nil {
+		return valuesBuf
+	}
+
+	rowsCount := db.RowsCount()
+	for len(valuesBuf) < rowsCount {
+		valuesBuf = append(valuesBuf, region)
+	}
+	db.Columns = append(db.Columns, logstorage.BlockColumn{
+		Name:   otelpb.RegionField,
+		Values: valuesBuf[:rowsCount],
+	})
+	return valuesBuf
+}
+
 func getFirstNonCancelError(errs []error) error {
 	for _, err := range errs {
 		if err != nil && !errors.Is(err, context.Canceled) {
@@ -489,7 +600,10 @@ func getFirstNonCancelError(errs []error) error {
 	return nil
 }
 
-func unmarshalValuesWithHits(qctx *logstorage.QueryContext, src []byte) ([]logstorage.ValueWithHits, error) {
+// unmarshalValuesWithHits unmarshals ValueWithHits entries from src.
+//
+// If hasQueryStats is set, then query stats are unmarshaled from src after ValueWithHits entries and are registered at qctx.
+func unmarshalValuesWithHits(qctx *logstorage.QueryContext, src []byte, hasQueryStats bool) ([]logstorage.ValueWithHits, error) {
 	// Unmarshal ValuesWithHits at first
 	if len(src) < 8 {
 		return nil, fmt.Errorf("missing length of ValueWithHits entries")
@@ -511,6 +625,13 @@ func unmarshalValuesWithHits(qctx *logstorage.QueryContext, src []byte) ([]logst
 		vh.Value = strings.Clone(vh.Value)
 	}
 
+	if !hasQueryStats {
+		if len(src) > 0 {
+			return nil, fmt.Errorf("unexpected tail left after ValueWithHits entries; len(tail)=%d", len(src))
+		}
+		return vhs, nil
+	}
+
 	// Unmarshal query stats
 	qsLocal := &logstorage.QueryStats{}
 	defer qctx.QueryStats.UpdateAtomic(qsLocal)
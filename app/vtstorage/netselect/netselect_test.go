@@ -0,0 +1,204 @@
+package netselect
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promauth"
+
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtstorage/capabilities"
+	otelpb "github.com/VictoriaMetrics/VictoriaTraces/lib/protoparser/opentelemetry/pb"
+)
+
+func TestHasPipes(t *testing.T) {
+	f := func(qStr string, resultExpected bool) {
+		t.Helper()
+
+		q, err := logstorage.ParseQuery(qStr)
+		if err != nil {
+			t.Fatalf("cannot parse query %q: %s", qStr, err)
+		}
+		if result := hasPipes(q); result != resultExpected {
+			t.Fatalf("unexpected result for hasPipes(%q); got %v; want %v", qStr, result, resultExpected)
+		}
+	}
+
+	f(`*`, false)
+	f(`trace_id:"1234"`, false)
+	f(`trace_id:in(1234, 5678) AND _time:5m`, false)
+	f(`* | fields _time`, true)
+	f(`* | count()`, true)
+}
+
+func TestAddRegionColumn(t *testing.T) {
+	db := &logstorage.DataBlock{
+		Columns: []logstorage.BlockColumn{
+			{Name: otelpb.TraceIDField, Values: []string{"1", "2", "3"}},
+		},
+	}
+
+	valuesBuf := addRegionColumn(db, "eu-west", nil)
+	c := db.GetColumnByName(otelpb.RegionField)
+	if c == nil {
+		t.Fatalf("missing %q column", otelpb.RegionField)
+	}
+	if !reflect.DeepEqual(c.Values, []string{"eu-west", "eu-west", "eu-west"}) {
+		t.Fatalf("unexpected region values: %q", c.Values)
+	}
+
+	// The existing region column must be preserved.
+	db = &logstorage.DataBlock{
+		Columns: []logstorage.BlockColumn{
+			{Name: otelpb.TraceIDField, Values: []string{"1"}},
+			{Name: otelpb.RegionField, Values: []string{"us-east"}},
+		},
+	}
+	addRegionColumn(db, "eu-west", valuesBuf)
+	if len(db.Columns) != 2 {
+		t.Fatalf("unexpected number of columns; got %d; want 2", len(db.Columns))
+	}
+	if v := db.GetColumnByName(otelpb.RegionField).Values[0]; v != "us-east" {
+		t.Fatalf("unexpected region; got %q; want %q", v, "us-east")
+	}
+}
+
+func TestUnmarshalValuesWithHits(t *testing.T) {
+	f := func(hasQueryStats bool) {
+		t.Helper()
+
+		vhs := []logstorage.ValueWithHits{
+			{Value: "foo", Hits: 10},
+			{Value: "bar", Hits: 2},
+		}
+		var data []byte
+		data = encoding.MarshalUint64(data, uint64(len(vhs)))
+		for i := range vhs {
+			data = vhs[i].Marshal(data)
+		}
+		if hasQueryStats {
+			var qs logstorage.QueryStats
+			db := qs.CreateDataBlock(0)
+			data = db.Marshal(data)
+		}
+
+		q, err := logstorage.ParseQuery("*")
+		if err != nil {
+			t.Fatalf("cannot parse query: %s", err)
+		}
+		var qs logstorage.QueryStats
+		qctx := logstorage.NewQueryContext(context.Background(), &qs, nil, q)
+
+		result, err := unmarshalValuesWithHits(qctx, data, hasQueryStats)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !reflect.DeepEqual(result, vhs) {
+			t.Fatalf("unexpected result\ngot\n%v\nwant\n%v", result, vhs)
+		}
+
+		// The response for the other protocol version must be rejected.
+		if _, err := unmarshalValuesWithHits(qctx, data, !hasQueryStats); err == nil {
+			t.Fatalf("expecting non-nil error when unmarshaling response with hasQueryStats=%v", !hasQueryStats)
+		}
+	}
+
+	f(true)
+	f(false)
+}
+
+func TestSupportedProtocolVersions(t *testing.T) {
+	for path, versions := range SupportedProtocolVersions {
+		if len(versions) != 2 {
+			t.Fatalf("unexpected number of supported versions for %s; got %d; want 2", path, len(versions))
+		}
+		if !HasQueryStats(versions[0]) {
+			t.Fatalf("the newest version %q for %s must support query stats", versions[0], path)
+		}
+		if HasQueryStats(versions[1]) {
+			t.Fatalf("the previous version %q for %s mustn't support query stats", versions[1], path)
+		}
+	}
+}
+
+func TestStorageNodeRunQueryProtocolVersions(t *testing.T) {
+	f := func(remoteVersions []string, versionExpected string) {
+		t.Helper()
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case capabilities.Path:
+				c := &capabilities.Capabilities{
+					ProtocolVersions: map[string][]string{
+						"/internal/select/query": remoteVersions,
+					},
+				}
+				c.WriteResponse(w)
+			case "/internal/select/query":
+				version := r.FormValue("version")
+				if version != versionExpected {
+					t.Errorf("unexpected version; got %q; want %q", version, versionExpected)
+				}
+
+				// Emulate the response from the storage node with the given protocol version.
+				hasQueryStats := HasQueryStats(version)
+				db := &logstorage.DataBlock{
+					Columns: []logstorage.BlockColumn{
+						{Name: otelpb.TraceIDField, Values: []string{"1", "2"}},
+					},
+				}
+				var data []byte
+				if hasQueryStats {
+					data = append(data, 0)
+				}
+				data = db.Marshal(data)
+				if hasQueryStats {
+					var qs logstorage.QueryStats
+					data = append(data, 1)
+					data = qs.CreateDataBlock(0).Marshal(data)
+				}
+				w.Write(encoding.MarshalUint64(nil, uint64(len(data))))
+				w.Write(data)
+			default:
+				t.Errorf("unexpected path: %q", r.URL.Path)
+			}
+		}))
+		defer srv.Close()
+
+		ac, err := (&promauth.Options{}).NewConfig()
+		if err != nil {
+			t.Fatalf("cannot create auth config: %s", err)
+		}
+		s := NewStorage([]string{strings.TrimPrefix(srv.URL, "http://")}, []string{""}, []*promauth.Config{ac}, []bool{false}, true)
+		defer s.MustStop()
+
+		q, err := logstorage.ParseQuery("*")
+		if err != nil {
+			t.Fatalf("cannot parse query: %s", err)
+		}
+		var qs logstorage.QueryStats
+		qctx := logstorage.NewQueryContext(context.Background(), &qs, nil, q)
+
+		var traceIDs []string
+		err = s.sns[0].runQuery(qctx, func(db *logstorage.DataBlock) {
+			traceIDs = append(traceIDs, db.GetColumnByName(otelpb.TraceIDField).Values...)
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !reflect.DeepEqual(traceIDs, []string{"1", "2"}) {
+			t.Fatalf("unexpected trace ids; got %q; want %q", traceIDs, []string{"1", "2"})
+		}
+	}
+
+	// The storage node supports the current version
+	f([]string{QueryProtocolVersion, protocolVersionWithoutQueryStats}, QueryProtocolVersion)
+
+	// The storage node supports only the previous version
+	f([]string{protocolVersionWithoutQueryStats}, protocolVersionWithoutQueryStats)
+}
@@ -0,0 +1,439 @@
+package vtstorage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// partitionRewriteTmpDirname is the name of the directory at -storageDataPath, which is used for rewriting partitions.
+const partitionRewriteTmpDirname = "partition-rewrite-tmp"
+
+// partitionRewriteMaxBufferedRows is the maximum number of rows, which may be ingested into the partition while it is rewritten.
+//
+// The rewrite is canceled if more rows are ingested into the partition, since such a partition is actively written.
+const partitionRewriteMaxBufferedRows = 100_000
+
+var partitionRewriteBufferedRows = metrics.NewCounter(`vt_partition_rewrite_buffered_rows_total`)
+
+var (
+	// rewritingPartitionLock protects rewritingPartition.
+	//
+	// It is held in read mode while adding rows to the local storage, so the state of the partition rewrite cannot change
+	// while rows are added to the local storage.
+	rewritingPartitionLock sync.RWMutex
+
+	// rewritingPartition holds rows ingested into the partition being rewritten. It is nil if no partitions are rewritten.
+	rewritingPartition *partitionRewriteBuffer
+)
+
+// partitionRewriteBuffer holds rows ingested into the partition being rewritten, so they aren't lost after the rewrite.
+type partitionRewriteBuffer struct {
+	// day is the day since the unix epoch for the partition being rewritten.
+	day int64
+
+	mu sync.Mutex
+
+	// lr contains the buffered rows for the day.
+	lr *logstorage.LogRows
+
+	// swapping is set when the rewritten partition replaces the original partition.
+	//
+	// The original partition is detached in this case, so the rows for the day must be added only to lr.
+	// Otherwise the storage creates a new directory for the partition, which prevents the rewritten partition from being moved in place.
+	swapping bool
+
+	// overflow is set if more than partitionRewriteMaxBufferedRows rows are ingested into the partition before swapping.
+	overflow bool
+}
+
+// addRow adds r to rb if r belongs to rb.day.
+//
+// It returns true if r mustn't be added to the local storage. rewritingPartitionLock must be held by the caller.
+func (rb *partitionRewriteBuffer) addRow(r *logstorage.InsertRow) bool {
+	if r.Timestamp/(24*3600*1e9) != rb.day {
+		return false
+	}
+
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	if rb.swapping {
+		rb.lr.MustAddInsertRow(r)
+		partitionRewriteBufferedRows.Inc()
+		return true
+	}
+	if rb.overflow {
+		return false
+	}
+	rb.lr.MustAddInsertRow(r)
+	partitionRewriteBufferedRows.Inc()
+	if rb.lr.RowsCount() > partitionRewriteMaxBufferedRows {
+		// The original partition contains all the rows, so the rewrite can be safely canceled.
+		rb.overflow = true
+	}
+	return false
+}
+
+// startSwapping makes rb to hold all the rows for rb.day and returns the rows buffered before the call.
+//
+// The caller must return the returned rows to the pool via logstorage.PutLogRows.
+func (rb *partitionRewriteBuffer) startSwapping() (*logstorage.LogRows, error) {
+	rewritingPartitionLock.Lock()
+	defer rewritingPartitionLock.Unlock()
+
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	if rb.overflow {
+		return nil, fmt.Errorf("more than %d rows have been ingested into the partition during the rewrite; the rewrite will be retried later", partitionRewriteMaxBufferedRows)
+	}
+	lr := rb.lr
+	rb.lr = logstorage.GetLogRows(nil, nil, nil, nil, "")
+	rb.swapping = true
+	return lr, nil
+}
+
+// startPartitionRewrite starts buffering rows for the partition with the given name and creates a snapshot for the partition.
+//
+// The buffering starts atomically with the snapshot creation, so every row is either in the snapshot or in the returned buffer.
+func startPartitionRewrite(name string, day int64) (*partitionRewriteBuffer, string, error) {
+	rewritingPartitionLock.Lock()
+	defer rewritingPartitionLock.Unlock()
+
+	if rewritingPartition != nil {
+		logger.Panicf("BUG: partitions cannot be rewritten concurrently")
+	}
+
+	// Flush the buffered rows, since they aren't included in the snapshot.
+	localStorage.DebugFlush()
+	snapshotPath, err := localStorage.PartitionSnapshotCreate(name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	rb := &partitionRewriteBuffer{
+		day: day,
+		lr:  logstorage.GetLogRows(nil, nil, nil, nil, ""),
+	}
+	rewritingPartition = rb
+	return rb, snapshotPath, nil
+}
+
+// finishPartitionRewrite stops buffering rows for the partition being rewritten and adds the buffered rows to the local storage.
+func finishPartitionRewrite(rb *partitionRewriteBuffer) {
+	rewritingPartitionLock.Lock()
+	rewritingPartition = nil
+	rewritingPartitionLock.Unlock()
+
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	if rb.swapping && rb.lr.RowsCount() > 0 {
+		// These rows haven't been added to the local storage yet.
+		localStorage.MustAddRows(rb.lr)
+	}
+	logstorage.PutLogRows(rb.lr)
+	rb.lr = nil
+}
+
+// partitionRewriteStats contains stats for the rewritten partition.
+type partitionRewriteStats struct {
+	RowsBefore  uint64 `json:"rows_before"`
+	RowsAfter   uint64 `json:"rows_after"`
+	BytesBefore uint64 `json:"bytes_before"`
+	BytesAfter  uint64 `json:"bytes_after"`
+	FinishedAt  string `json:"finished_at"`
+}
+
+// copyTenantRowsFunc must copy rows for the given tenantID from src to dst.
+//
+// It must return the number of rows for the tenantID at src including the rows, which weren't copied.
+type copyTenantRowsFunc func(ctx context.Context, src, dst *logstorage.Storage, tenantID logstorage.TenantID) (uint64, error)
+
+// mustRecoverPartitionRewriteTmpDir finishes or rolls back the partition rewrite interrupted by unclean shutdown.
+//
+// It must be called before opening the storage at dataPath.
+func mustRecoverPartitionRewriteTmpDir(dataPath string) {
+	tmpPath := filepath.Join(dataPath, partitionRewriteTmpDirname)
+	if !fs.IsPathExist(tmpPath) {
+		return
+	}
+
+	oldPartitionsPath := filepath.Join(tmpPath, "old")
+	if fs.IsPathExist(oldPartitionsPath) {
+		for _, de := range fs.MustReadDir(oldPartitionsPath) {
+			name := de.Name()
+			partitionPath := filepath.Join(dataPath, "partitions", name)
+			if fs.IsPathExist(partitionPath) {
+				// The rewritten partition is already in place.
+				continue
+			}
+			// The partition swap has been interrupted. Restore the original partition.
+			mustRenamePath(filepath.Join(oldPartitionsPath, name), partitionPath)
+			logger.Infof("restored partition %q after the interrupted rewrite", name)
+		}
+	}
+
+	// Remove snapshots referred by the temporary storage.
+	srcPartitionsPath := filepath.Join(tmpPath, "src", "partitions")
+	if fs.IsPathExist(srcPartitionsPath) {
+		for _, de := range fs.MustReadDir(srcPartitionsPath) {
+			target, err := os.Readlink(filepath.Join(srcPartitionsPath, de.Name(), "datadb"))
+			if err == nil && fs.IsPathExist(target) {
+				fs.MustRemoveDir(filepath.Dir(target))
+			}
+		}
+	}
+	fs.MustRemoveDir(tmpPath)
+}
+
+// rewritePartition rewrites the partition with the given name at the storage s located at dataPath
+// by copying rows for every tenant from tenantIDs via copyTenantRows.
+//
+// Rows are copied from the snapshot of the partition, so the original partition remains available for queries
+// during the rewrite. Rows ingested into the partition of the local storage during the rewrite are copied via copyTenantRows
+// to the rewritten partition, which then replaces the original partition. The original partition is kept if the rewrite fails.
+//
+// The caller must prevent data ingestion into s if s isn't the local storage. partitionsMoveLock must be held by the caller
+// if s is the local storage.
+func rewritePartition(ctx context.Context, s *logstorage.Storage, dataPath, name string, tenantIDs []logstorage.TenantID, copyTenantRows copyTenantRowsFunc) (*partitionRewriteStats, error) {
+	day, err := time.Parse("20060102", name)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse partition name %q: %w", name, err)
+	}
+
+	tmpPath := filepath.Join(dataPath, partitionRewriteTmpDirname)
+	if fs.IsPathExist(tmpPath) {
+		fs.MustRemoveDir(tmpPath)
+	}
+
+	var rb *partitionRewriteBuffer
+	var snapshotPath string
+	if s == localStorage {
+		rb, snapshotPath, err = startPartitionRewrite(name, day.Unix()/(24*3600))
+		if err != nil {
+			return nil, err
+		}
+		defer finishPartitionRewrite(rb)
+	} else {
+		// Flush the buffered rows, since they aren't included in the snapshot.
+		s.DebugFlush()
+		snapshotPath, err = s.PartitionSnapshotCreate(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer func() {
+		// The snapshot is removed together with the original partition after the successful rewrite.
+		if fs.IsPathExist(snapshotPath) {
+			fs.MustRemoveDir(snapshotPath)
+		}
+		fs.MustRemoveDir(tmpPath)
+	}()
+
+	// Open the snapshot as a temporary storage. Symlinks are used, since the snapshot may be located at -storageDataPath.cold.
+	srcPath := filepath.Join(tmpPath, "src")
+	srcPartitionPath := filepath.Join(srcPath, "partitions", name)
+	fs.MustMkdirIfNotExist(srcPartitionPath)
+	for _, subdir := range []string{"indexdb", "datadb"} {
+		if err := os.Symlink(filepath.Join(snapshotPath, subdir), filepath.Join(srcPartitionPath, subdir)); err != nil {
+			logger.Panicf("FATAL: cannot create symlink to the partition snapshot: %s", err)
+		}
+	}
+	fs.MustSyncPath(srcPartitionPath)
+
+	tmpCfg := &logstorage.StorageConfig{
+		// Use the maximum retention, so the temporary storage doesn't drop old partitions.
+		Retention:     100 * 365 * 24 * time.Hour,
+		FlushInterval: time.Second,
+	}
+	src := logstorage.MustOpenStorage(srcPath, tmpCfg)
+	dstPath := filepath.Join(tmpPath, "dst")
+	dst := logstorage.MustOpenStorage(dstPath, tmpCfg)
+	mustCloseStorages := func() {
+		if src != nil {
+			src.MustClose()
+			src = nil
+		}
+		if dst != nil {
+			dst.MustClose()
+			dst = nil
+		}
+	}
+	defer mustCloseStorages()
+
+	var srcStats logstorage.StorageStats
+	src.UpdateStats(&srcStats)
+	stats := &partitionRewriteStats{
+		RowsBefore:  srcStats.SmallPartRowsCount + srcStats.BigPartRowsCount + srcStats.InmemoryRowsCount,
+		BytesBefore: srcStats.CompressedSmallPartSize + srcStats.CompressedBigPartSize + srcStats.CompressedInmemorySize,
+	}
+
+	rowsRead := uint64(0)
+	for _, tenantID := range tenantIDs {
+		n, err := copyTenantRows(ctx, src, dst, tenantID)
+		if err != nil {
+			return nil, err
+		}
+		rowsRead += n
+	}
+	if rowsRead != stats.RowsBefore {
+		// The partition contains rows for unknown tenants. They would be lost after the rewrite.
+		return nil, fmt.Errorf("the partition contains %d rows, while only %d rows belong to the known tenants; "+
+			"the list of known tenants is stored at %s file at -storageDataPath", stats.RowsBefore, rowsRead, knownTenantsFilename)
+	}
+
+	if rb != nil {
+		// Copy rows ingested into the original partition during the rewrite.
+		lr, err := rb.startSwapping()
+		if err != nil {
+			return nil, err
+		}
+		n, err := copyIngestedRows(ctx, filepath.Join(tmpPath, "ingested"), tmpCfg, lr, dst, copyTenantRows)
+		logstorage.PutLogRows(lr)
+		if err != nil {
+			return nil, err
+		}
+		stats.RowsBefore += n
+	}
+
+	dst.DebugFlush()
+	var dstStats logstorage.StorageStats
+	dst.UpdateStats(&dstStats)
+	stats.RowsAfter = dstStats.SmallPartRowsCount + dstStats.BigPartRowsCount + dstStats.InmemoryRowsCount
+	stats.BytesAfter = dstStats.CompressedSmallPartSize + dstStats.CompressedBigPartSize + dstStats.CompressedInmemorySize
+	mustCloseStorages()
+
+	// Replace the original partition with the rewritten one. Rows for the partition are buffered at rb during the swap.
+	if err := s.PartitionDetach(name); err != nil {
+		return nil, err
+	}
+	partitionPath := filepath.Join(dataPath, "partitions", name)
+	oldPartitionPath := filepath.Join(tmpPath, "old", name)
+	fs.MustMkdirIfNotExist(filepath.Dir(oldPartitionPath))
+	mustRenamePath(partitionPath, oldPartitionPath)
+	dstPartitionPath := filepath.Join(dstPath, "partitions", name)
+	if fs.IsPathExist(dstPartitionPath) {
+		mustRenamePath(dstPartitionPath, partitionPath)
+		if err := s.PartitionAttach(name); err != nil {
+			logger.Panicf("FATAL: cannot attach the rewritten partition %q: %s", name, err)
+		}
+	}
+
+	// Cached query results may contain the deleted spans.
+	bumpDataGeneration()
+	stats.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+
+	return stats, nil
+}
+
+// copyIngestedRows copies rows from lr, which were ingested into the partition during the rewrite, to dst via copyTenantRows.
+//
+// The rows are copied via a temporary storage at path, so copyTenantRows applies the same rules to them as to the rows from the original partition.
+// It returns the number of rows in lr.
+func copyIngestedRows(ctx context.Context, path string, cfg *logstorage.StorageConfig, lr *logstorage.LogRows, dst *logstorage.Storage,
+	copyTenantRows copyTenantRowsFunc) (uint64, error) {
+	if lr.RowsCount() == 0 {
+		return 0, nil
+	}
+
+	var tenantIDs []logstorage.TenantID
+	lr.ForEachRow(func(_ uint64, r *logstorage.InsertRow) {
+		if !slices.Contains(tenantIDs, r.TenantID) {
+			tenantIDs = append(tenantIDs, r.TenantID)
+		}
+	})
+
+	s := logstorage.MustOpenStorage(path, cfg)
+	defer s.MustClose()
+	s.MustAddRows(lr)
+	s.DebugFlush()
+
+	for _, tenantID := range tenantIDs {
+		if _, err := copyTenantRows(ctx, s, dst, tenantID); err != nil {
+			return 0, err
+		}
+	}
+	return uint64(lr.RowsCount()), nil
+}
+
+// rewritePartitionExcluding rewrites the partition with the given name on the time range [start, end) at the storage s located at dataPath,
+// so it doesn't contain rows matching any of the filters for the corresponding tenant from filtersPerTenant.
+//
+// See rewritePartition for details.
+func rewritePartitionExcluding(ctx context.Context, s *logstorage.Storage, dataPath, name string, start, end int64, tenantIDs []logstorage.TenantID,
+	filtersPerTenant map[logstorage.TenantID][]string) (*partitionRewriteStats, error) {
+	copyTenantRows := func(ctx context.Context, src, dst *logstorage.Storage, tenantID logstorage.TenantID) (uint64, error) {
+		a := filtersPerTenant[tenantID]
+		if len(a) == 0 {
+			return copyPartitionRows(ctx, src, dst, tenantID, start, end, "")
+		}
+		rows, err := countRows(ctx, src, tenantID, start, end, "")
+		if err != nil {
+			return 0, err
+		}
+		filter := fmt.Sprintf("!(%s)", strings.Join(a, " OR "))
+		if _, err := copyPartitionRows(ctx, src, dst, tenantID, start, end, filter); err != nil {
+			return 0, err
+		}
+		return rows, nil
+	}
+	return rewritePartition(ctx, s, dataPath, name, tenantIDs, copyTenantRows)
+}
+
+// copyPartitionRows copies rows for the given tenantID on the time range [start, end) matching the given filter from src to dst.
+//
+// It returns the number of copied rows.
+func copyPartitionRows(ctx context.Context, src, dst *logstorage.Storage, tenantID logstorage.TenantID, start, end int64, filter string) (uint64, error) {
+	qStr := fmt.Sprintf("_time:[%s, %s) %s", timestampToString(start), timestampToString(end), filter)
+	q, err := logstorage.ParseQueryAtTimestamp(qStr, time.Now().UnixNano())
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse query [%s]: %w", qStr, err)
+	}
+
+	var qs logstorage.QueryStats
+	qctx := logstorage.NewQueryContext(ctx, &qs, []logstorage.TenantID{tenantID}, q)
+	_, rowsCopied, err := copyQueryRows(qctx, src.RunQuery, dst, nil)
+	return rowsCopied, err
+}
+
+// countRows returns the number of rows for the given tenantID on the time range [start, end) matching the given filter at s.
+func countRows(ctx context.Context, s *logstorage.Storage, tenantID logstorage.TenantID, start, end int64, filter string) (uint64, error) {
+	qStr := fmt.Sprintf("_time:[%s, %s) %s | count() rows", timestampToString(start), timestampToString(end), filter)
+	q, err := logstorage.ParseQueryAtTimestamp(qStr, time.Now().UnixNano())
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse query [%s]: %w", qStr, err)
+	}
+
+	var rowsStr string
+	var resultLock sync.Mutex
+	writeBlock := func(_ uint, db *logstorage.DataBlock) {
+		resultLock.Lock()
+		defer resultLock.Unlock()
+
+		if c := db.GetColumnByName("rows"); c != nil && len(c.Values) > 0 {
+			rowsStr = c.Values[0]
+		}
+	}
+
+	var qs logstorage.QueryStats
+	qctx := logstorage.NewQueryContext(ctx, &qs, []logstorage.TenantID{tenantID}, q)
+	if err := s.RunQuery(qctx, writeBlock); err != nil {
+		return 0, fmt.Errorf("cannot execute query [%s]: %w", qStr, err)
+	}
+
+	rows, _ := strconv.ParseUint(rowsStr, 10, 64)
+	return rows, nil
+}
@@ -0,0 +1,106 @@
+package vtstorage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+)
+
+func TestRewritePartitionConcurrentIngestion(t *testing.T) {
+	dataPath := t.TempDir()
+
+	mustOpenTestStorage(t, dataPath, 30*24*time.Hour)
+
+	tenantID := logstorage.TenantID{AccountID: 1}
+	day := time.Now().Add(-3 * 24 * time.Hour).Truncate(24 * time.Hour)
+	start := day.UnixNano()
+	end := day.Add(24 * time.Hour).UnixNano()
+	addSpan := func(traceID string) {
+		mustAddTestRows(func(lr *logstorage.LogRows) {
+			addTestSpan(lr, tenantID, start+12*3600*1e9, traceID, "svc")
+		})
+	}
+	addSpan("a")
+	addSpan("b")
+
+	getRowsCount := func(ctx context.Context, s *logstorage.Storage, filter string) uint64 {
+		t.Helper()
+
+		rows, err := countRows(ctx, s, tenantID, start, end, filter)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return rows
+	}
+
+	// Delete the trace "a", while ingesting spans into the partition during the rewrite.
+	copyCalls := 0
+	copyTenantRows := func(ctx context.Context, src, dst *logstorage.Storage, tid logstorage.TenantID) (uint64, error) {
+		if tid != tenantID {
+			return copyPartitionRows(ctx, src, dst, tid, start, end, "")
+		}
+		copyCalls++
+		switch copyCalls {
+		case 1:
+			// The original partition must remain available for queries during the rewrite.
+			localStorage.DebugFlush()
+			if n := getRowsCount(ctx, localStorage, ""); n != 2 {
+				t.Fatalf("unexpected number of rows at the partition being rewritten; got %d; want 2", n)
+			}
+			// These spans are ingested into the original partition and must be copied to the rewritten partition.
+			addSpan("a")
+			addSpan("c")
+			localStorage.DebugFlush()
+			if n := getRowsCount(ctx, localStorage, ""); n != 4 {
+				t.Fatalf("unexpected number of rows at the partition being rewritten after the ingestion; got %d; want 4", n)
+			}
+		case 2:
+			// This span is ingested during the swap of partitions. It must be added to the rewritten partition.
+			addSpan("d")
+		}
+		rows := getRowsCount(ctx, src, "")
+		_, err := copyPartitionRows(ctx, src, dst, tid, start, end, "!trace_id:=a")
+		return rows, err
+	}
+
+	name := day.Format("20060102")
+	generation := GetDataGeneration(context.Background())
+	stats, err := rewritePartition(context.Background(), localStorage, dataPath, name, knownTenantsInstance.getTenantIDs(), copyTenantRows)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if copyCalls != 2 {
+		t.Fatalf("unexpected number of copy calls for the tenant; got %d; want 2", copyCalls)
+	}
+	if stats.RowsBefore != 4 || stats.RowsAfter != 2 {
+		t.Fatalf("unexpected rewrite stats: %+v", stats)
+	}
+	if GetDataGeneration(context.Background()) == generation {
+		t.Fatalf("the data generation must change after the rewrite")
+	}
+
+	localStorage.DebugFlush()
+	ctx := context.Background()
+	if n := getRowsCount(ctx, localStorage, "trace_id:=a"); n != 0 {
+		t.Fatalf("unexpected number of rows for the deleted trace; got %d; want 0", n)
+	}
+	if n := getRowsCount(ctx, localStorage, ""); n != 3 {
+		t.Fatalf("unexpected number of rows after the rewrite; got %d; want 3", n)
+	}
+	if partitions := localStorage.PartitionList(); len(partitions) != 1 || partitions[0] != name {
+		t.Fatalf("unexpected partitions after the rewrite: %q", partitions)
+	}
+	if snapshots := localStorage.PartitionSnapshotList(); len(snapshots) != 0 {
+		t.Fatalf("unexpected snapshots after the rewrite: %q", snapshots)
+	}
+	if fs.IsPathExist(filepath.Join(dataPath, partitionRewriteTmpDirname)) {
+		t.Fatalf("the temporary directory must be removed after the partition rewrite")
+	}
+	if rewritingPartition != nil {
+		t.Fatalf("rows must not be buffered after the partition rewrite")
+	}
+}
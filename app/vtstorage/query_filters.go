@@ -0,0 +1,128 @@
+package vtstorage
+
+import (
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/contextutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtstorage/retention"
+)
+
+// getExtraFilters returns filters, which exclude spans being deleted and spans outside their retention policies, for the given tenantIDs.
+//
+// Tenants without such spans are missing in the returned map.
+func getExtraFilters(tenantIDs []logstorage.TenantID, timestamp int64) map[logstorage.TenantID][]*logstorage.Filter {
+	var m map[logstorage.TenantID][]*logstorage.Filter
+	for _, tenantID := range tenantIDs {
+		var filters []*logstorage.Filter
+		if deleteTasks != nil {
+			if f := deleteTasks.getFilter(tenantID); f != nil {
+				filters = append(filters, f)
+			}
+		}
+		if f := retention.GetTenantFilter(tenantID, timestamp); f != nil {
+			filters = append(filters, f)
+		}
+		if len(filters) == 0 {
+			continue
+		}
+		if m == nil {
+			m = make(map[logstorage.TenantID][]*logstorage.Filter)
+		}
+		m[tenantID] = filters
+	}
+	return m
+}
+
+// withTenantFilters returns qctx for the given tenantID with the given filters.
+func withTenantFilters(qctx *logstorage.QueryContext, tenantID logstorage.TenantID, filters []*logstorage.Filter) *logstorage.QueryContext {
+	q := qctx.Query
+	if len(filters) > 0 {
+		q = q.Clone(q.GetTimestamp())
+		for _, f := range filters {
+			q.AddExtraFilters(f)
+		}
+	}
+	return logstorage.NewQueryContext(qctx.Context, qctx.QueryStats, []logstorage.TenantID{tenantID}, q)
+}
+
+// withExtraFilters returns qctx with the filters, which exclude spans being deleted and spans outside their retention policies.
+//
+// qctx must contain a single tenant, since the filters are tenant-specific.
+func withExtraFilters(qctx *logstorage.QueryContext) *logstorage.QueryContext {
+	if len(qctx.TenantIDs) != 1 {
+		logger.Panicf("BUG: unexpected number of tenants: %d; want 1", len(qctx.TenantIDs))
+	}
+	tenantID := qctx.TenantIDs[0]
+	filters := getExtraFilters(qctx.TenantIDs, qctx.Query.GetTimestamp())[tenantID]
+	if len(filters) == 0 {
+		return qctx
+	}
+	return withTenantFilters(qctx, tenantID, filters)
+}
+
+// runLocalQuery runs qctx at the local storage and calls writeBlock for the returned data blocks.
+//
+// Spans being deleted and spans outside their retention policies are excluded from the results.
+func runLocalQuery(qctx *logstorage.QueryContext, writeBlock logstorage.WriteDataBlockFunc) error {
+	filters := getExtraFilters(qctx.TenantIDs, qctx.Query.GetTimestamp())
+	if len(filters) == 0 {
+		// Fast path - there is no need in filtering the results.
+		return localStorage.RunQuery(qctx, writeBlock)
+	}
+	if len(qctx.TenantIDs) == 1 {
+		tenantID := qctx.TenantIDs[0]
+		return localStorage.RunQuery(withTenantFilters(qctx, tenantID, filters[tenantID]), writeBlock)
+	}
+
+	// Slow path - the filters differ per tenant, so run the query in the same way as in cluster mode, where every tenant is queried
+	// separately with its own filters, while the results are merged locally.
+	nqr, err := logstorage.NewNetQueryRunner(qctx, runLocalQuery, writeBlock)
+	if err != nil {
+		return err
+	}
+	search := func(stopCh <-chan struct{}, q *logstorage.Query, writeBlock logstorage.WriteDataBlockFunc) error {
+		ctxWithCancel, cancel := contextutil.NewStopChanContext(stopCh)
+		defer cancel()
+
+		qctxLocal := qctx.WithContextAndQuery(ctxWithCancel, q)
+
+		// Tenants are queried sequentially, since the same worker ids are passed to writeBlock for every tenant.
+		for _, tenantID := range qctx.TenantIDs {
+			if err := localStorage.RunQuery(withTenantFilters(qctxLocal, tenantID, filters[tenantID]), writeBlock); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	concurrency := qctx.Query.GetConcurrency()
+	return nqr.Run(qctx.Context, concurrency, search)
+}
+
+// getLocalValuesWithHits calls getValues for qctx at the local storage.
+//
+// Spans being deleted and spans outside their retention policies are excluded from the results.
+func getLocalValuesWithHits(qctx *logstorage.QueryContext, limit uint64, resetHitsOnLimitExceeded bool,
+	getValues func(qctx *logstorage.QueryContext) ([]logstorage.ValueWithHits, error)) ([]logstorage.ValueWithHits, error) {
+
+	filters := getExtraFilters(qctx.TenantIDs, qctx.Query.GetTimestamp())
+	if len(filters) == 0 {
+		// Fast path - there is no need in filtering the results.
+		return getValues(qctx)
+	}
+	if len(qctx.TenantIDs) == 1 {
+		tenantID := qctx.TenantIDs[0]
+		return getValues(withTenantFilters(qctx, tenantID, filters[tenantID]))
+	}
+
+	// Slow path - the filters differ per tenant, so query every tenant separately and merge the results.
+	results := make([][]logstorage.ValueWithHits, 0, len(qctx.TenantIDs))
+	for _, tenantID := range qctx.TenantIDs {
+		vhs, err := getValues(withTenantFilters(qctx, tenantID, filters[tenantID]))
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, vhs)
+	}
+	return logstorage.MergeValuesWithHits(results, limit, resetHitsOnLimitExceeded), nil
+}
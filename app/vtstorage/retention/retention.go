@@ -0,0 +1,342 @@
+package retention
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs/fscore"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+var configFile = flag.String("retention.configFile", "", "Optional path to JSON file with retention policies per tenant and per stream fields such as resource_attr:service.name . "+
+	"Spans, which do not match any policy, are kept for -retentionPeriod . See https://docs.victoriametrics.com/victoriatraces/#retention-policies")
+
+// defaultPolicyName is the name used in metrics for spans, which do not match any policy.
+const defaultPolicyName = "default"
+
+var policyNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Policy is a retention policy for spans with the given tenant and stream fields.
+type Policy struct {
+	// Name is the policy name. It is used in metrics.
+	Name string `json:"name"`
+
+	// Tenant is optional tenant in the form accountID:projectID. The policy is applied to all the tenants if it is empty.
+	Tenant string `json:"tenant,omitempty"`
+
+	// StreamFields contains optional stream fields, which must match the span. The policy is applied to all the spans of the tenant if it is empty.
+	StreamFields map[string]string `json:"stream_fields,omitempty"`
+
+	// Retention is the retention for spans matching the policy.
+	Retention flagutil.ExtendedDuration `json:"retention"`
+
+	// tenantID is nil if the policy is applied to all the tenants.
+	tenantID *logstorage.TenantID
+
+	// isDefault is set for the policy, which is applied to spans not matching other policies.
+	isDefault bool
+
+	// fields contains StreamFields sorted by name.
+	fields []logstorage.Field
+
+	// streamFilter is LogsQL stream filter for fields. It is empty if fields is empty.
+	streamFilter string
+
+	rowsRejectedTotal *metrics.Counter
+	rowsExpiredTotal  *metrics.Counter
+	bytesExpiredTotal *metrics.Counter
+}
+
+// AddExpired registers the given number of rows and compressed bytes, which were deleted because they went out of the retention for p.
+func (p *Policy) AddExpired(rows, bytes uint64) {
+	p.rowsExpiredTotal.AddInt64(int64(rows))
+	p.bytesExpiredTotal.AddInt64(int64(bytes))
+}
+
+// AppliesToTenant returns true if p is applied to the given tenantID.
+func (p *Policy) AppliesToTenant(tenantID logstorage.TenantID) bool {
+	return p.tenantID == nil || *p.tenantID == tenantID
+}
+
+func (p *Policy) matchFields(fields []logstorage.Field) bool {
+	for _, pf := range p.fields {
+		if !slices.ContainsFunc(fields, func(f logstorage.Field) bool { return f.Name == pf.Name && f.Value == pf.Value }) {
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	policies         []*Policy
+	defaultRetention time.Duration
+
+	// defaultPolicy is applied to spans, which do not match any policy. It is nil if there are no policies.
+	defaultPolicy *Policy
+
+	// frozenAge is the age in nanoseconds for rows, which must be rejected during data ingestion. It is disabled if set to 0.
+	frozenAge atomic.Int64
+
+	frozenRowsRejectedTotal = metrics.NewCounter(`vt_retention_filter_rows_rejected_total`)
+)
+
+// SetFrozenAge instructs rejecting rows older than d during data ingestion.
+//
+// This is needed for per-day partitions, which are rewritten in background, since rows ingested into such partitions may be lost.
+func SetFrozenAge(d time.Duration) {
+	frozenAge.Store(d.Nanoseconds())
+}
+
+// Init loads retention policies from -retention.configFile.
+//
+// defaultRetention is applied to spans, which do not match any policy.
+func Init(defaultRetentionPeriod time.Duration) {
+	defaultRetention = defaultRetentionPeriod
+	if *configFile == "" {
+		return
+	}
+
+	data, err := fscore.ReadFileOrHTTP(*configFile)
+	if err != nil {
+		logger.Fatalf("cannot read -retention.configFile: %s", err)
+	}
+	ps, err := parseConfig(data)
+	if err != nil {
+		logger.Fatalf("cannot parse -retention.configFile=%q: %s", *configFile, err)
+	}
+	setPolicies(ps)
+	logger.Infof("loaded %d retention policies from -retention.configFile=%q", len(policies), *configFile)
+}
+
+func setPolicies(ps []*Policy) {
+	policies = ps
+	defaultPolicy = nil
+	if len(ps) == 0 {
+		return
+	}
+
+	defaultPolicy = &Policy{
+		Name:      defaultPolicyName,
+		isDefault: true,
+	}
+	if err := defaultPolicy.Retention.Set(fmt.Sprintf("%ds", int64(defaultRetention.Seconds()))); err != nil {
+		logger.Panicf("BUG: cannot set default retention %s: %s", defaultRetention, err)
+	}
+	defaultPolicy.initMetrics()
+}
+
+// Stop resets the loaded retention policies.
+func Stop() {
+	policies = nil
+	defaultPolicy = nil
+	frozenAge.Store(0)
+}
+
+func parseConfig(data []byte) ([]*Policy, error) {
+	var ps []*Policy
+	if err := json.Unmarshal(data, &ps); err != nil {
+		return nil, err
+	}
+
+	seenNames := make(map[string]bool)
+	for i, p := range ps {
+		if !policyNameRegexp.MatchString(p.Name) {
+			return nil, fmt.Errorf("policy #%d: name=%q must contain only alphanumeric chars, '_' and '-'", i, p.Name)
+		}
+		if p.Name == defaultPolicyName {
+			return nil, fmt.Errorf("policy #%d: name=%q is reserved for spans, which do not match any policy", i, p.Name)
+		}
+		if seenNames[p.Name] {
+			return nil, fmt.Errorf("policy #%d: duplicate name=%q", i, p.Name)
+		}
+		seenNames[p.Name] = true
+
+		if p.Retention.Duration() <= 0 {
+			return nil, fmt.Errorf("policy %q: retention must be positive; got %q", p.Name, p.Retention.String())
+		}
+
+		if p.Tenant != "" {
+			tenantID, err := logstorage.ParseTenantID(p.Tenant)
+			if err != nil {
+				return nil, fmt.Errorf("policy %q: cannot parse tenant: %w", p.Name, err)
+			}
+			p.tenantID = &tenantID
+		}
+
+		for name, value := range p.StreamFields {
+			p.fields = append(p.fields, logstorage.Field{Name: name, Value: value})
+		}
+		slices.SortFunc(p.fields, func(a, b logstorage.Field) int {
+			return strings.Compare(a.Name, b.Name)
+		})
+		if len(p.fields) > 0 {
+			a := make([]string, len(p.fields))
+			for i, f := range p.fields {
+				a[i] = fmt.Sprintf("%q=%q", f.Name, f.Value)
+			}
+			p.streamFilter = "{" + strings.Join(a, ",") + "}"
+			if _, err := logstorage.ParseFilter(p.streamFilter); err != nil {
+				return nil, fmt.Errorf("policy %q: cannot parse stream_fields: %w", p.Name, err)
+			}
+		}
+
+		p.initMetrics()
+	}
+	return ps, nil
+}
+
+func (p *Policy) initMetrics() {
+	p.rowsRejectedTotal = metrics.GetOrCreateCounter(fmt.Sprintf(`vt_retention_policy_rows_rejected_total{policy=%q}`, p.Name))
+	p.rowsExpiredTotal = metrics.GetOrCreateCounter(fmt.Sprintf(`vt_retention_policy_rows_expired_total{policy=%q}`, p.Name))
+	p.bytesExpiredTotal = metrics.GetOrCreateCounter(fmt.Sprintf(`vt_retention_policy_bytes_expired_total{policy=%q}`, p.Name))
+}
+
+// GetPolicies returns the loaded retention policies followed by the policy for spans, which do not match any policy.
+//
+// nil is returned if there are no loaded policies.
+func GetPolicies() []*Policy {
+	if defaultPolicy == nil {
+		return nil
+	}
+	return append(slices.Clone(policies), defaultPolicy)
+}
+
+// MaxRetention returns the maximum retention across the default retention and the loaded policies.
+//
+// The storage must keep data for the returned duration.
+func MaxRetention() time.Duration {
+	d := defaultRetention
+	for _, p := range policies {
+		d = max(d, p.Retention.Duration())
+	}
+	return d
+}
+
+// IsRowExpired returns true if the row with the given tenantID, timestamp and fields is outside its retention.
+//
+// The first policy matching the row is used. The default retention is used if the row doesn't match any policy.
+//
+// It also returns true if the row is older than the age set via SetFrozenAge.
+func IsRowExpired(tenantID logstorage.TenantID, timestamp int64, fields []logstorage.Field) bool {
+	now := time.Now()
+	if d := frozenAge.Load(); d > 0 && timestamp < now.UnixNano()-d {
+		frozenRowsRejectedTotal.Inc()
+		return true
+	}
+
+	if len(policies) == 0 {
+		// The storage drops rows outside the default retention.
+		return false
+	}
+
+	for _, p := range policies {
+		if !p.AppliesToTenant(tenantID) || !p.matchFields(fields) {
+			continue
+		}
+		if timestamp >= now.Add(-p.Retention.Duration()).UnixNano() {
+			return false
+		}
+		p.rowsRejectedTotal.Inc()
+		return true
+	}
+	if timestamp >= now.Add(-defaultRetention).UnixNano() {
+		return false
+	}
+	defaultPolicy.rowsRejectedTotal.Inc()
+	return true
+}
+
+// GetPolicyFilter returns LogsQL filter, which matches spans for the policy p at the given tenantID.
+//
+// It returns false if p isn't applied to spans at the given tenantID, e.g. if p doesn't match the tenantID
+// or if the spans matching p are covered by the preceding policies.
+func GetPolicyFilter(tenantID logstorage.TenantID, p *Policy) (string, bool) {
+	var negations []string
+	for _, x := range policies {
+		if !x.AppliesToTenant(tenantID) {
+			continue
+		}
+		if x == p {
+			a := append([]string{}, negations...)
+			if x.streamFilter != "" {
+				a = append(a, x.streamFilter)
+			}
+			return joinFilters(a), true
+		}
+		if x.streamFilter == "" {
+			// x covers all the remaining spans at the tenant.
+			return "", false
+		}
+		negations = append(negations, "!"+x.streamFilter)
+	}
+	if p.isDefault {
+		return joinFilters(negations), true
+	}
+	return "", false
+}
+
+func joinFilters(a []string) string {
+	if len(a) == 0 {
+		return "*"
+	}
+	return strings.Join(a, " ")
+}
+
+// GetTenantFilter returns filter, which excludes spans outside their retention for the given tenantID at the given timestamp.
+//
+// nil is returned if there are no retention policies.
+func GetTenantFilter(tenantID logstorage.TenantID, timestamp int64) *logstorage.Filter {
+	if len(policies) == 0 {
+		return nil
+	}
+
+	s := getTenantFilterString(tenantID, timestamp)
+	f, err := logstorage.ParseFilter(s)
+	if err != nil {
+		logger.Panicf("BUG: cannot parse retention filter %q: %s", s, err)
+	}
+	return f
+}
+
+func getTenantFilterString(tenantID logstorage.TenantID, timestamp int64) string {
+	var expired []string
+	var negations []string
+	coversAll := false
+	for _, p := range policies {
+		if !p.AppliesToTenant(tenantID) {
+			continue
+		}
+		a := []string{getTimeFilter(timestamp, p.Retention.Duration())}
+		a = append(a, negations...)
+		if p.streamFilter != "" {
+			a = append(a, p.streamFilter)
+		}
+		expired = append(expired, "("+strings.Join(a, " ")+")")
+		if p.streamFilter == "" {
+			// p covers all the remaining spans at the tenant.
+			coversAll = true
+			break
+		}
+		negations = append(negations, "!"+p.streamFilter)
+	}
+	if !coversAll {
+		a := []string{getTimeFilter(timestamp, defaultRetention)}
+		a = append(a, negations...)
+		expired = append(expired, "("+strings.Join(a, " ")+")")
+	}
+	return "!(" + strings.Join(expired, " OR ") + ")"
+}
+
+func getTimeFilter(timestamp int64, retention time.Duration) string {
+	deadline := timestamp - retention.Nanoseconds()
+	return "_time:<" + time.Unix(0, deadline).UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,171 @@
+package retention
+
+import (
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+func TestParseConfigFailure(t *testing.T) {
+	f := func(data string) {
+		t.Helper()
+
+		if _, err := parseConfig([]byte(data)); err == nil {
+			t.Fatalf("expecting non-nil error for config %s", data)
+		}
+	}
+
+	// invalid json
+	f(`{`)
+
+	// missing name
+	f(`[{"retention":"1d"}]`)
+
+	// invalid name
+	f(`[{"name":"foo bar","retention":"1d"}]`)
+
+	// reserved name
+	f(`[{"name":"default","retention":"1d"}]`)
+
+	// duplicate name
+	f(`[{"name":"foo","retention":"1d"},{"name":"foo","retention":"2d"}]`)
+
+	// missing retention
+	f(`[{"name":"foo"}]`)
+
+	// invalid retention
+	f(`[{"name":"foo","retention":"1"}]`)
+
+	// invalid tenant
+	f(`[{"name":"foo","tenant":"bar","retention":"1d"}]`)
+}
+
+func TestIsRowExpired(t *testing.T) {
+	mustInitPolicies(t, `[
+		{"name":"load-generator","stream_fields":{"resource_attr:service.name":"load-generator"},"retention":"1d"},
+		{"name":"staging","tenant":"1:0","retention":"3d"},
+		{"name":"prod","tenant":"2:0","retention":"30d"}
+	]`, 7*24*time.Hour)
+	defer Stop()
+
+	f := func(tenant string, age time.Duration, serviceName string, resultExpected bool) {
+		t.Helper()
+
+		tenantID, err := logstorage.ParseTenantID(tenant)
+		if err != nil {
+			t.Fatalf("cannot parse tenant: %s", err)
+		}
+		timestamp := time.Now().Add(-age).UnixNano()
+		fields := []logstorage.Field{
+			{Name: "resource_attr:service.name", Value: serviceName},
+		}
+		result := IsRowExpired(tenantID, timestamp, fields)
+		if result != resultExpected {
+			t.Fatalf("unexpected result for tenant=%s, age=%s, service=%q; got %v; want %v", tenant, age, serviceName, result, resultExpected)
+		}
+	}
+
+	// The first matching policy wins.
+	f("1:0", 2*24*time.Hour, "load-generator", true)
+	f("1:0", 12*time.Hour, "load-generator", false)
+
+	// Tenant policies.
+	f("1:0", 2*24*time.Hour, "foo", false)
+	f("1:0", 4*24*time.Hour, "foo", true)
+	f("2:0", 20*24*time.Hour, "foo", false)
+	f("2:0", 40*24*time.Hour, "foo", true)
+
+	// The default retention.
+	f("3:0", 5*24*time.Hour, "foo", false)
+	f("3:0", 8*24*time.Hour, "foo", true)
+
+	if d := MaxRetention(); d != 30*24*time.Hour {
+		t.Fatalf("unexpected max retention; got %s; want %s", d, 30*24*time.Hour)
+	}
+}
+
+func TestGetFilter(t *testing.T) {
+	mustInitPolicies(t, `[
+		{"name":"load-generator","stream_fields":{"resource_attr:service.name":"load-generator"},"retention":"1d"},
+		{"name":"staging","tenant":"1:0","retention":"3d"}
+	]`, 7*24*time.Hour)
+	defer Stop()
+
+	timestamp := time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC).UnixNano()
+	f := func(tenant string, resultExpected string) {
+		t.Helper()
+
+		tenantID, err := logstorage.ParseTenantID(tenant)
+		if err != nil {
+			t.Fatalf("cannot parse tenant: %s", err)
+		}
+		result := GetTenantFilter(tenantID, timestamp).String()
+		if result != resultExpected {
+			t.Fatalf("unexpected filter for tenant=%s\ngot\n%s\nwant\n%s", tenant, result, resultExpected)
+		}
+	}
+
+	// The tenant with both policies. The default retention isn't applied, since the staging policy covers all the remaining spans.
+	f("1:0", `!(_time:<2025-01-09T00:00:00Z {"resource_attr:service.name"="load-generator"} or _time:<2025-01-07T00:00:00Z !{"resource_attr:service.name"="load-generator"})`)
+
+	// The tenant with the load-generator policy and the default retention.
+	f("0:0", `!(_time:<2025-01-09T00:00:00Z {"resource_attr:service.name"="load-generator"} or _time:<2025-01-03T00:00:00Z !{"resource_attr:service.name"="load-generator"})`)
+
+	// Policy filters for accounting expired spans.
+	fPolicy := func(tenant, policyName string, resultExpected string, okExpected bool) {
+		t.Helper()
+
+		tenantID, err := logstorage.ParseTenantID(tenant)
+		if err != nil {
+			t.Fatalf("cannot parse tenant: %s", err)
+		}
+		var p *Policy
+		for _, x := range GetPolicies() {
+			if x.Name == policyName {
+				p = x
+			}
+		}
+		result, ok := GetPolicyFilter(tenantID, p)
+		if ok != okExpected {
+			t.Fatalf("unexpected ok for tenant=%s, policy=%s; got %v; want %v", tenant, policyName, ok, okExpected)
+		}
+		if result != resultExpected {
+			t.Fatalf("unexpected policy filter for tenant=%s, policy=%s\ngot\n%s\nwant\n%s", tenant, policyName, result, resultExpected)
+		}
+	}
+	fPolicy("1:0", "load-generator", `{"resource_attr:service.name"="load-generator"}`, true)
+	fPolicy("1:0", "staging", `!{"resource_attr:service.name"="load-generator"}`, true)
+	fPolicy("0:0", "staging", "", false)
+	fPolicy("0:0", "default", `!{"resource_attr:service.name"="load-generator"}`, true)
+	fPolicy("1:0", "default", "", false)
+}
+
+func TestGetFilterNoPolicies(t *testing.T) {
+	mustInitPolicies(t, `[]`, 7*24*time.Hour)
+	defer Stop()
+
+	if f := GetTenantFilter(logstorage.TenantID{}, time.Now().UnixNano()); f != nil {
+		t.Fatalf("unexpected filter without policies: %s", f)
+	}
+	if IsRowExpired(logstorage.TenantID{}, 0, nil) {
+		t.Fatalf("rows mustn't be expired by policies without policies")
+	}
+	if d := MaxRetention(); d != 7*24*time.Hour {
+		t.Fatalf("unexpected max retention; got %s; want %s", d, 7*24*time.Hour)
+	}
+	if ps := GetPolicies(); ps != nil {
+		t.Fatalf("unexpected policies: %v", ps)
+	}
+}
+
+func mustInitPolicies(t *testing.T, data string, defaultRetentionPeriod time.Duration) {
+	t.Helper()
+
+	ps, err := parseConfig([]byte(data))
+	if err != nil {
+		t.Fatalf("cannot parse config: %s", err)
+	}
+	defaultRetention = defaultRetentionPeriod
+	setPolicies(ps)
+}
@@ -0,0 +1,222 @@
+package vtstorage
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/timeutil"
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtstorage/retention"
+)
+
+// retentionEnforcerInterval is the interval between checks for partitions with spans outside the retention policies.
+const retentionEnforcerInterval = time.Hour
+
+var (
+	retentionPolicyPartitionsRewritten = metrics.NewCounter(`vt_retention_policy_partitions_rewritten_total`)
+	retentionPolicyPartitionsFailed    = metrics.NewCounter(`vt_retention_policy_partitions_failed_total`)
+)
+
+// retentionEnforcer periodically deletes spans, which go out of the retention policies from -retention.configFile.
+//
+// Per-day partitions are rewritten without spans, which are outside their retention for the whole day.
+// Spans outside their retention for a part of the day are excluded from query results until the whole day goes out of the retention.
+// Partitions outside the maximum retention across all the policies are dropped by the storage.
+type retentionEnforcer struct {
+	dataPath string
+
+	// enforced contains policies, which have been already enforced per every partition name.
+	enforced map[string]map[*retention.Policy]bool
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+var retentionEnforcerInstance *retentionEnforcer
+
+func startRetentionEnforcer(dataPath string) *retentionEnforcer {
+	re := &retentionEnforcer{
+		dataPath: dataPath,
+		enforced: make(map[string]map[*retention.Policy]bool),
+		stopCh:   make(chan struct{}),
+	}
+	if len(retention.GetPolicies()) == 0 {
+		// Nothing to enforce.
+		return re
+	}
+
+	re.wg.Add(1)
+	go func() {
+		defer re.wg.Done()
+		re.run()
+	}()
+	return re
+}
+
+// mustStop stops re. The interrupted partition rewrite is rolled back.
+func (re *retentionEnforcer) mustStop() {
+	close(re.stopCh)
+	re.wg.Wait()
+}
+
+func (re *retentionEnforcer) run() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-re.stopCh
+		cancel()
+	}()
+
+	d := timeutil.AddJitterToDuration(retentionEnforcerInterval)
+	t := time.NewTicker(d)
+	defer t.Stop()
+	for {
+		re.processPartitions(ctx)
+
+		select {
+		case <-re.stopCh:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// getTenantIDs returns tenants to process.
+func (re *retentionEnforcer) getTenantIDs() []logstorage.TenantID {
+	tenantIDs := knownTenantsInstance.getTenantIDs()
+	for _, p := range retention.GetPolicies() {
+		if p.Tenant != "" {
+			tenantID, _ := logstorage.ParseTenantID(p.Tenant)
+			tenantIDs = append(tenantIDs, tenantID)
+		}
+	}
+	slices.SortFunc(tenantIDs, compareTenantIDs)
+	return slices.Compact(tenantIDs)
+}
+
+// processPartitions rewrites partitions with spans, which went out of the retention policies for the whole day.
+func (re *retentionEnforcer) processPartitions(ctx context.Context) {
+	policies := retention.GetPolicies()
+	tenantIDs := re.getTenantIDs()
+	partitionNames := localStorage.PartitionList()
+
+	// Drop the state for partitions, which do not exist anymore.
+	for name := range re.enforced {
+		if !slices.Contains(partitionNames, name) {
+			delete(re.enforced, name)
+		}
+	}
+
+	now := time.Now()
+	for _, name := range partitionNames {
+		day, err := time.Parse("20060102", name)
+		if err != nil {
+			continue
+		}
+		end := day.Add(24 * time.Hour)
+		if !end.After(now.Add(-retention.MaxRetention())) {
+			// The partition is dropped by the storage.
+			continue
+		}
+
+		// Collect policies, which cover the whole partition.
+		var expiredPolicies []*retention.Policy
+		for _, p := range policies {
+			if re.enforced[name][p] || end.After(now.Add(-p.Retention.Duration())) {
+				continue
+			}
+			expiredPolicies = append(expiredPolicies, p)
+		}
+		if len(expiredPolicies) == 0 {
+			continue
+		}
+
+		if err := re.enforcePolicies(ctx, name, day, tenantIDs, expiredPolicies); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Errorf("cannot enforce retention policies at partition %q: %s", name, err)
+			retentionPolicyPartitionsFailed.Inc()
+			continue
+		}
+
+		m := re.enforced[name]
+		if m == nil {
+			m = make(map[*retention.Policy]bool)
+			re.enforced[name] = m
+		}
+		for _, p := range expiredPolicies {
+			m[p] = true
+		}
+	}
+}
+
+// enforcePolicies deletes spans matching the given policies from the partition with the given name for the given day.
+//
+// The partition is rewritten only if it contains such spans.
+func (re *retentionEnforcer) enforcePolicies(ctx context.Context, name string, day time.Time, tenantIDs []logstorage.TenantID, policies []*retention.Policy) error {
+	start := day.UnixNano()
+	end := day.Add(24 * time.Hour).UnixNano()
+
+	filtersPerTenant := make(map[logstorage.TenantID][]string)
+	rowsPerPolicy := make(map[*retention.Policy]uint64)
+	rowsTotal := uint64(0)
+	for _, tenantID := range tenantIDs {
+		for _, p := range policies {
+			filter, ok := retention.GetPolicyFilter(tenantID, p)
+			if !ok {
+				continue
+			}
+			filter = "(" + filter + ")"
+			rows, err := countRows(ctx, localStorage, tenantID, start, end, filter)
+			if err != nil {
+				return err
+			}
+			if rows == 0 {
+				continue
+			}
+			filtersPerTenant[tenantID] = append(filtersPerTenant[tenantID], filter)
+			rowsPerPolicy[p] += rows
+			rowsTotal += rows
+		}
+	}
+	if rowsTotal == 0 {
+		return nil
+	}
+
+	logger.Infof("rewriting partition %q by retention policies", name)
+	startTime := time.Now()
+	partitionsMoveLock.Lock()
+	stats, err := rewritePartitionExcluding(ctx, localStorage, re.dataPath, name, start, end, tenantIDs, filtersPerTenant)
+	partitionsMoveLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	retentionPolicyPartitionsRewritten.Inc()
+	bytesReclaimed := uint64(0)
+	if stats.BytesBefore > stats.BytesAfter {
+		bytesReclaimed = stats.BytesBefore - stats.BytesAfter
+	}
+	for _, p := range policies {
+		// The reclaimed bytes are split among policies proportionally to the number of deleted rows.
+		rows := rowsPerPolicy[p]
+		p.AddExpired(rows, uint64(float64(bytesReclaimed)*float64(rows)/float64(rowsTotal)))
+	}
+	logger.Infof("partition %q has been rewritten by retention policies in %.3f seconds; rows: %d -> %d; bytes: %d -> %d",
+		name, time.Since(startTime).Seconds(), stats.RowsBefore, stats.RowsAfter, stats.BytesBefore, stats.BytesAfter)
+	return nil
+}
+
+func compareTenantIDs(a, b logstorage.TenantID) int {
+	if a.AccountID != b.AccountID {
+		return cmp.Compare(a.AccountID, b.AccountID)
+	}
+	return cmp.Compare(a.ProjectID, b.ProjectID)
+}
@@ -0,0 +1,92 @@
+package vtstorage
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtstorage/retention"
+)
+
+func TestRetentionEnforcerProcessPartitions(t *testing.T) {
+	dataPath := t.TempDir()
+
+	mustOpenTestStorage(t, dataPath, 30*24*time.Hour)
+
+	// Ingest spans for two services at two tenants before loading retention policies, so they aren't rejected.
+	tenantA := logstorage.TenantID{AccountID: 1}
+	tenantB := logstorage.TenantID{AccountID: 2}
+	day := time.Now().Add(-3 * 24 * time.Hour).Truncate(24 * time.Hour)
+	start := day.UnixNano()
+	end := day.Add(24 * time.Hour).UnixNano()
+	mustAddTestRows(func(lr *logstorage.LogRows) {
+		timestamp := start + 12*3600*1e9
+		addTestSpan(lr, tenantA, timestamp, "a", "load-generator")
+		addTestSpan(lr, tenantA, timestamp, "a", "load-generator")
+		addTestSpan(lr, tenantA, timestamp, "a", "svc")
+		addTestSpan(lr, tenantB, timestamp, "a", "load-generator")
+		addTestSpan(lr, tenantB, timestamp, "a", "svc")
+	})
+
+	configPath := filepath.Join(dataPath, "retention.json")
+	config := `[
+  {"name": "enforcer-load-generator", "tenant": "1:0", "stream_fields": {"resource_attr:service.name": "load-generator"}, "retention": "2d"}
+]`
+	if err := os.WriteFile(configPath, []byte(config), 0o600); err != nil {
+		t.Fatalf("cannot write retention config: %s", err)
+	}
+	if err := flag.Set("retention.configFile", configPath); err != nil {
+		t.Fatalf("cannot set -retention.configFile: %s", err)
+	}
+	retention.Init(30 * 24 * time.Hour)
+	defer func() {
+		retention.Stop()
+		_ = flag.Set("retention.configFile", "")
+	}()
+
+	re := &retentionEnforcer{
+		dataPath: dataPath,
+		enforced: make(map[string]map[*retention.Policy]bool),
+		stopCh:   make(chan struct{}),
+	}
+	ctx := context.Background()
+	re.processPartitions(ctx)
+
+	getRowsCount := func(tenantID logstorage.TenantID) uint64 {
+		t.Helper()
+
+		rows, err := countRows(ctx, localStorage, tenantID, start, end, "")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return rows
+	}
+
+	// Only spans for the policy at the tenant of the policy must be physically deleted.
+	if n := getRowsCount(tenantA); n != 1 {
+		t.Fatalf("unexpected number of rows at the tenant with the expired policy; got %d; want 1", n)
+	}
+	if n := getRowsCount(tenantB); n != 2 {
+		t.Fatalf("unexpected number of rows at the tenant without expired policies; got %d; want 2", n)
+	}
+	rowsExpired := metrics.GetOrCreateCounter(`vt_retention_policy_rows_expired_total{policy="enforcer-load-generator"}`).Get()
+	if rowsExpired != 2 {
+		t.Fatalf("unexpected number of expired rows; got %d; want 2", rowsExpired)
+	}
+	if !re.enforced[day.Format("20060102")][retention.GetPolicies()[0]] {
+		t.Fatalf("the policy must be marked as enforced for the partition")
+	}
+
+	// The enforced policy mustn't rewrite the partition again.
+	partitionsRewritten := retentionPolicyPartitionsRewritten.Get()
+	re.processPartitions(ctx)
+	if n := retentionPolicyPartitionsRewritten.Get(); n != partitionsRewritten {
+		t.Fatalf("unexpected partition rewrite for the enforced policy")
+	}
+}
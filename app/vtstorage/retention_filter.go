@@ -0,0 +1,517 @@
+package vtstorage
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs/fscore"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/timeutil"
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/cespare/xxhash/v2"
+
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtstorage/retention"
+	otelpb "github.com/VictoriaMetrics/VictoriaTraces/lib/protoparser/opentelemetry/pb"
+)
+
+var retentionFilterConfigFile = flag.String("retentionFilter.configFile", "", "Optional path to JSON file with retention filter config. Per-day partitions older than the configured age "+
+	"are rewritten, so they keep only traces matching the configured rules plus a sample of the remaining traces. See https://docs.victoriametrics.com/victoriatraces/#retention-filters")
+
+// retentionFilterStateFilename is the name of the file at -storageDataPath, which holds the retention filter state.
+const retentionFilterStateFilename = "retention-filter-state.json"
+
+// retentionFilterInterval is the interval between checks for partitions, which must be rewritten.
+const retentionFilterInterval = time.Hour
+
+// retentionFilterSampleBuckets is the number of buckets for trace_id hashes used for sampling.
+const retentionFilterSampleBuckets = 1_000_000
+
+var retentionFilterRuleNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+var (
+	retentionFilterPartitionsRewritten = metrics.NewCounter(`vt_retention_filter_partitions_rewritten_total`)
+	retentionFilterPartitionsFailed    = metrics.NewCounter(`vt_retention_filter_partitions_failed_total`)
+	retentionFilterRowsDeleted         = metrics.NewCounter(`vt_retention_filter_rows_deleted_total`)
+	retentionFilterBytesReclaimed      = metrics.NewCounter(`vt_retention_filter_bytes_reclaimed_total`)
+)
+
+// retentionFilterConfig is the config for the retention filter.
+type retentionFilterConfig struct {
+	// Age is the minimum age for partitions to rewrite.
+	Age flagutil.ExtendedDuration `json:"age"`
+
+	// Rules contains rules for traces to keep.
+	Rules []*retentionFilterRule `json:"rules"`
+
+	// SampleRatio is the ratio of the remaining traces to keep.
+	SampleRatio float64 `json:"sample_ratio"`
+
+	// Tenants contains additional tenants to process in the form accountID:projectID.
+	//
+	// Tenants seen during data ingestion are processed automatically. See knownTenants.
+	Tenants []string `json:"tenants"`
+
+	tenantIDs []logstorage.TenantID
+}
+
+// retentionFilterRule is a rule for traces to keep.
+//
+// The trace is kept if at least a single span of the trace matches the rule.
+type retentionFilterRule struct {
+	// Name is the rule name. It is used in metrics.
+	Name string `json:"name"`
+
+	// Filter is LogsQL filter for spans.
+	Filter string `json:"filter,omitempty"`
+
+	// MinDuration is the minimum trace duration.
+	//
+	// The trace duration is the duration between the earliest span start and the latest span end across the trace spans.
+	MinDuration flagutil.ExtendedDuration `json:"min_duration"`
+
+	// filter is LogsQL filter for the rule.
+	filter string
+
+	tracesMatchedTotal *metrics.Counter
+}
+
+func parseRetentionFilterConfig(data []byte) (*retentionFilterConfig, error) {
+	var cfg retentionFilterConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+
+	if cfg.Age.Duration() < 24*time.Hour {
+		return nil, fmt.Errorf("age cannot be smaller than 1d; got %q", cfg.Age.String())
+	}
+	if cfg.SampleRatio < 0 || cfg.SampleRatio > 1 {
+		return nil, fmt.Errorf("sample_ratio must be in the range [0..1]; got %v", cfg.SampleRatio)
+	}
+
+	seenNames := make(map[string]bool)
+	for i, r := range cfg.Rules {
+		if !retentionFilterRuleNameRegexp.MatchString(r.Name) {
+			return nil, fmt.Errorf("rule #%d: name=%q must contain only alphanumeric chars, '_' and '-'", i, r.Name)
+		}
+		if seenNames[r.Name] {
+			return nil, fmt.Errorf("rule #%d: duplicate name=%q", i, r.Name)
+		}
+		seenNames[r.Name] = true
+
+		switch {
+		case r.Filter != "" && r.MinDuration.Duration() > 0:
+			return nil, fmt.Errorf("rule %q: filter and min_duration cannot be set simultaneously", r.Name)
+		case r.Filter != "":
+			f, err := logstorage.ParseFilter(r.Filter)
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: cannot parse filter: %w", r.Name, err)
+			}
+			r.filter = f.String()
+		case r.MinDuration.Duration() > 0:
+			// The trace duration is checked at getTraceIDsQuery.
+			r.filter = fmt.Sprintf("%s:*", otelpb.TraceIDField)
+		default:
+			return nil, fmt.Errorf("rule %q: filter or min_duration must be set", r.Name)
+		}
+
+		r.tracesMatchedTotal = metrics.GetOrCreateCounter(fmt.Sprintf(`vt_retention_filter_traces_matched_total{rule=%q}`, r.Name))
+	}
+
+	for _, s := range cfg.Tenants {
+		tenantID, err := logstorage.ParseTenantID(s)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse tenant: %w", err)
+		}
+		cfg.tenantIDs = append(cfg.tenantIDs, tenantID)
+	}
+
+	return &cfg, nil
+}
+
+// getTraceIDsQuery returns LogsQL query, which selects trace_id values for traces matching r on the time range [start, end).
+func (r *retentionFilterRule) getTraceIDsQuery(start, end int64) string {
+	qStr := fmt.Sprintf("_time:[%s, %s) (%s)", timestampToString(start), timestampToString(end), r.filter)
+	if d := r.MinDuration.Duration(); d > 0 {
+		// Spans of the trace may be stored in any order, so the trace duration is calculated from all the spans of the trace.
+		return fmt.Sprintf("%s | stats by (%s) min(%s) as trace_start, max(%s) as trace_end | math (trace_end - trace_start) as trace_duration | filter trace_duration:>=%d | fields %s",
+			qStr, otelpb.TraceIDField, otelpb.StartTimeUnixNanoField, otelpb.EndTimeUnixNanoField, d.Nanoseconds(), otelpb.TraceIDField)
+	}
+	return fmt.Sprintf("%s | uniq by (%s)", qStr, otelpb.TraceIDField)
+}
+
+// keepTraceSample returns true if the trace with the given traceID must be kept according to the sample_ratio.
+func (cfg *retentionFilterConfig) keepTraceSample(traceID string) bool {
+	h := xxhash.Sum64String(traceID) % retentionFilterSampleBuckets
+	return float64(h) < cfg.SampleRatio*retentionFilterSampleBuckets
+}
+
+// retentionFilterState is the persistent state of the retention filter.
+type retentionFilterState struct {
+	// Partitions contains stats for the rewritten partitions.
+	Partitions map[string]*partitionRewriteStats `json:"partitions"`
+}
+
+// retentionFilter rewrites per-day partitions older than the configured age, so they contain only traces to keep.
+type retentionFilter struct {
+	cfg *retentionFilterConfig
+
+	// dataPath is the path to -storageDataPath.
+	dataPath string
+
+	state *retentionFilterState
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+var retentionFilterInstance *retentionFilter
+
+// mustInitRetentionFilter loads the retention filter config from -retentionFilter.configFile.
+//
+// nil is returned if -retentionFilter.configFile isn't set.
+func mustInitRetentionFilter(dataPath string) *retentionFilter {
+	if *retentionFilterConfigFile == "" {
+		return nil
+	}
+	cfg := mustLoadRetentionFilterConfig()
+
+	rf := &retentionFilter{
+		cfg:      cfg,
+		dataPath: dataPath,
+		state: &retentionFilterState{
+			Partitions: make(map[string]*partitionRewriteStats),
+		},
+		stopCh: make(chan struct{}),
+	}
+	statePath := rf.statePath()
+	if fs.IsPathExist(statePath) {
+		data, err := os.ReadFile(statePath)
+		if err != nil {
+			logger.Panicf("FATAL: cannot read retention filter state: %s", err)
+		}
+		if err := json.Unmarshal(data, rf.state); err != nil {
+			logger.Panicf("FATAL: cannot parse retention filter state from %q: %s", statePath, err)
+		}
+	}
+
+	// Reject spans, which could be ingested into partitions being rewritten.
+	retention.SetFrozenAge(cfg.Age.Duration())
+
+	return rf
+}
+
+// initRetentionFilterFrozenAge instructs rejecting spans older than the age from -retentionFilter.configFile at vtinsert nodes
+// in VictoriaTraces cluster, so the client is notified about these spans instead of silent drop of these spans at vtstorage nodes.
+func initRetentionFilterFrozenAge() {
+	if *retentionFilterConfigFile == "" {
+		return
+	}
+	cfg := mustLoadRetentionFilterConfig()
+	retention.SetFrozenAge(cfg.Age.Duration())
+}
+
+func mustLoadRetentionFilterConfig() *retentionFilterConfig {
+	data, err := fscore.ReadFileOrHTTP(*retentionFilterConfigFile)
+	if err != nil {
+		logger.Fatalf("cannot read -retentionFilter.configFile: %s", err)
+	}
+	cfg, err := parseRetentionFilterConfig(data)
+	if err != nil {
+		logger.Fatalf("cannot parse -retentionFilter.configFile=%q: %s", *retentionFilterConfigFile, err)
+	}
+	return cfg
+}
+
+func (rf *retentionFilter) statePath() string {
+	return filepath.Join(rf.dataPath, retentionFilterStateFilename)
+}
+
+func (rf *retentionFilter) mustSaveState() {
+	data, err := json.Marshal(rf.state)
+	if err != nil {
+		logger.Panicf("BUG: cannot marshal retention filter state: %s", err)
+	}
+	fs.MustWriteAtomic(rf.statePath(), data, true)
+}
+
+// start starts rewriting partitions in background.
+func (rf *retentionFilter) start() {
+	rf.wg.Add(1)
+	go func() {
+		defer rf.wg.Done()
+		rf.run()
+	}()
+}
+
+// mustStop stops rf. The interrupted partition rewrite is rolled back.
+func (rf *retentionFilter) mustStop() {
+	close(rf.stopCh)
+	rf.wg.Wait()
+}
+
+func (rf *retentionFilter) run() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-rf.stopCh
+		cancel()
+	}()
+
+	d := timeutil.AddJitterToDuration(retentionFilterInterval)
+	t := time.NewTicker(d)
+	defer t.Stop()
+	for {
+		rf.processPartitions(ctx)
+
+		select {
+		case <-rf.stopCh:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// getTenantIDs returns tenants to process.
+func (rf *retentionFilter) getTenantIDs() []logstorage.TenantID {
+	tenantIDs := append(knownTenantsInstance.getTenantIDs(), rf.cfg.tenantIDs...)
+	slices.SortFunc(tenantIDs, compareTenantIDs)
+	return slices.Compact(tenantIDs)
+}
+
+func (rf *retentionFilter) processPartitions(ctx context.Context) {
+	tenantIDs := rf.getTenantIDs()
+	partitionNames := localStorage.PartitionList()
+
+	// Drop stats for partitions, which do not exist anymore.
+	n := len(rf.state.Partitions)
+	for name := range rf.state.Partitions {
+		if !slices.Contains(partitionNames, name) {
+			delete(rf.state.Partitions, name)
+		}
+	}
+	if n != len(rf.state.Partitions) {
+		rf.mustSaveState()
+	}
+
+	deadline := time.Now().Add(-rf.cfg.Age.Duration())
+	for _, name := range partitionNames {
+		if _, ok := rf.state.Partitions[name]; ok {
+			// The partition has been already rewritten.
+			continue
+		}
+		day, err := time.Parse("20060102", name)
+		if err != nil {
+			continue
+		}
+		if day.Add(24 * time.Hour).After(deadline) {
+			// The partition may contain spans younger than the age.
+			continue
+		}
+
+		logger.Infof("rewriting partition %q by retention filter", name)
+		startTime := time.Now()
+		partitionsMoveLock.Lock()
+		stats, err := rf.rewritePartition(ctx, name, day, tenantIDs)
+		partitionsMoveLock.Unlock()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Errorf("cannot rewrite partition %q by retention filter: %s", name, err)
+			retentionFilterPartitionsFailed.Inc()
+			continue
+		}
+
+		rf.state.Partitions[name] = stats
+		rf.mustSaveState()
+
+		retentionFilterPartitionsRewritten.Inc()
+		retentionFilterRowsDeleted.AddInt64(int64(stats.RowsBefore - stats.RowsAfter))
+		if stats.BytesBefore > stats.BytesAfter {
+			retentionFilterBytesReclaimed.AddInt64(int64(stats.BytesBefore - stats.BytesAfter))
+		}
+		logger.Infof("partition %q has been rewritten by retention filter in %.3f seconds; rows: %d -> %d; bytes: %d -> %d",
+			name, time.Since(startTime).Seconds(), stats.RowsBefore, stats.RowsAfter, stats.BytesBefore, stats.BytesAfter)
+	}
+}
+
+// rewritePartition rewrites the partition with the given name for the given day, so it keeps only traces matching the configured rules
+// plus a sample of the remaining traces.
+func (rf *retentionFilter) rewritePartition(ctx context.Context, name string, day time.Time, tenantIDs []logstorage.TenantID) (*partitionRewriteStats, error) {
+	start := day.UnixNano()
+	end := day.Add(24 * time.Hour).UnixNano()
+
+	// Select traces to keep. Spans from the adjacent days are taken into account, so traces crossing the day boundary are kept together.
+	keepTraceIDs := make(map[logstorage.TenantID]map[string]struct{})
+	for _, tenantID := range tenantIDs {
+		m := make(map[string]struct{})
+		for _, r := range rf.cfg.Rules {
+			qStr := r.getTraceIDsQuery(start-24*3600*1e9, end+24*3600*1e9)
+			traceIDs, err := getUniqTraceIDs(ctx, []logstorage.TenantID{tenantID}, qStr)
+			if err != nil {
+				return nil, err
+			}
+			r.tracesMatchedTotal.Add(len(traceIDs))
+			for _, traceID := range traceIDs {
+				m[traceID] = struct{}{}
+			}
+		}
+		keepTraceIDs[tenantID] = m
+	}
+
+	copyTenantRows := func(ctx context.Context, src, dst *logstorage.Storage, tenantID logstorage.TenantID) (uint64, error) {
+		return rf.copyRows(ctx, src, dst, tenantID, start, end, keepTraceIDs[tenantID])
+	}
+	return rewritePartition(ctx, localStorage, rf.dataPath, name, tenantIDs, copyTenantRows)
+}
+
+// copyRows copies rows for the given tenantID on the time range [start, end) from src to dst, which belong to traces to keep.
+//
+// It returns the number of rows read from src.
+func (rf *retentionFilter) copyRows(ctx context.Context, src, dst *logstorage.Storage, tenantID logstorage.TenantID, start, end int64, keepTraceIDs map[string]struct{}) (uint64, error) {
+	qStr := fmt.Sprintf("_time:[%s, %s)", timestampToString(start), timestampToString(end))
+	q, err := logstorage.ParseQueryAtTimestamp(qStr, time.Now().UnixNano())
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse query [%s]: %w", qStr, err)
+	}
+
+	var qs logstorage.QueryStats
+	qctx := logstorage.NewQueryContext(ctx, &qs, []logstorage.TenantID{tenantID}, q)
+	keepRow := func(traceID string, _ int64, _ []logstorage.Field) bool {
+		if traceID == "" {
+			return true
+		}
+		_, ok := keepTraceIDs[traceID]
+		return ok || rf.cfg.keepTraceSample(traceID)
+	}
+	rowsRead, _, err := copyQueryRows(qctx, src.RunQuery, dst, keepRow)
+	return rowsRead, err
+}
+
+// copyQueryRows copies rows returned by qctx via runQuery to dst.
+//
+// Rows are copied to the tenant from qctx, so qctx must contain a single tenant.
+// Rows rejected by keepRow are skipped. keepRow receives the trace ID of the row, which is empty for rows without trace ID,
+// together with the row timestamp and fields. All the rows are copied if keepRow is nil.
+//
+// It returns the number of rows read and the number of rows copied.
+func copyQueryRows(qctx *logstorage.QueryContext, runQuery func(qctx *logstorage.QueryContext, writeBlock logstorage.WriteDataBlockFunc) error,
+	dst *logstorage.Storage, keepRow func(traceID string, timestamp int64, fields []logstorage.Field) bool) (uint64, uint64, error) {
+	if len(qctx.TenantIDs) != 1 {
+		logger.Panicf("BUG: unexpected number of tenants: %d; want 1", len(qctx.TenantIDs))
+	}
+	tenantID := qctx.TenantIDs[0]
+
+	var lrLock sync.Mutex
+	lr := logstorage.GetLogRows(nil, nil, nil, nil, "")
+	defer logstorage.PutLogRows(lr)
+
+	rowsRead := uint64(0)
+	rowsCopied := uint64(0)
+	var parseErr error
+	writeBlock := func(_ uint, db *logstorage.DataBlock) {
+		timestamps, _ := db.GetTimestamps(nil)
+
+		lrLock.Lock()
+		defer lrLock.Unlock()
+
+		var fields, streamFields []logstorage.Field
+		for i, timestamp := range timestamps {
+			rowsRead++
+
+			fields = fields[:0]
+			streamFields = streamFields[:0]
+			traceID := ""
+			for _, c := range db.Columns {
+				v := c.Values[i]
+				switch c.Name {
+				case "_time", "_stream_id":
+					continue
+				case "_stream":
+					var err error
+					streamFields, err = appendStreamFields(streamFields, v)
+					if err != nil && parseErr == nil {
+						parseErr = err
+					}
+					continue
+				case otelpb.TraceIDField, otelpb.TraceIDIndexFieldName:
+					traceID = v
+				}
+				fields = append(fields, logstorage.Field{Name: c.Name, Value: v})
+			}
+
+			if keepRow != nil && !keepRow(traceID, timestamp, fields) {
+				continue
+			}
+
+			lr.MustAdd(tenantID, timestamp, fields, streamFields)
+			rowsCopied++
+			if lr.NeedFlush() {
+				dst.MustAddRows(lr)
+				lr.ResetKeepSettings()
+			}
+		}
+	}
+
+	if err := runQuery(qctx, writeBlock); err != nil {
+		return 0, 0, fmt.Errorf("cannot execute query [%s]: %w", qctx.Query, err)
+	}
+	if parseErr != nil {
+		return 0, 0, parseErr
+	}
+	dst.MustAddRows(lr)
+
+	return rowsRead, rowsCopied, nil
+}
+
+// appendStreamFields appends stream fields from the _stream field value s in the form {name1="value1",...,nameN="valueN"} to dst.
+func appendStreamFields(dst []logstorage.Field, s string) ([]logstorage.Field, error) {
+	tail, ok := strings.CutPrefix(s, "{")
+	if !ok {
+		return dst, fmt.Errorf("missing '{' at the beginning of _stream=%q", s)
+	}
+	for tail != "}" {
+		n := strings.IndexByte(tail, '=')
+		if n < 0 {
+			return dst, fmt.Errorf("missing '=' after the stream field name at _stream=%q", s)
+		}
+		name := tail[:n]
+		tail = tail[n+1:]
+
+		quoted, err := strconv.QuotedPrefix(tail)
+		if err != nil {
+			return dst, fmt.Errorf("cannot parse stream field value at _stream=%q: %w", s, err)
+		}
+		value, _ := strconv.Unquote(quoted)
+		tail = tail[len(quoted):]
+		dst = append(dst, logstorage.Field{Name: name, Value: value})
+
+		if strings.HasPrefix(tail, ",") {
+			tail = tail[1:]
+		} else if tail != "}" {
+			return dst, fmt.Errorf("missing ',' or '}' after the stream field value at _stream=%q", s)
+		}
+	}
+	return dst, nil
+}
+
+func mustRenamePath(srcPath, dstPath string) {
+	if err := os.Rename(srcPath, dstPath); err != nil {
+		logger.Panicf("FATAL: cannot rename %q to %q: %s", srcPath, dstPath, err)
+	}
+	fs.MustSyncPathAndParentDir(dstPath)
+	fs.MustSyncPath(filepath.Dir(srcPath))
+}
@@ -0,0 +1,203 @@
+package vtstorage
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+
+	otelpb "github.com/VictoriaMetrics/VictoriaTraces/lib/protoparser/opentelemetry/pb"
+)
+
+func TestParseRetentionFilterConfigFailure(t *testing.T) {
+	f := func(data string) {
+		t.Helper()
+
+		if _, err := parseRetentionFilterConfig([]byte(data)); err == nil {
+			t.Fatalf("expecting non-nil error for config %s", data)
+		}
+	}
+
+	// invalid json
+	f(`{`)
+
+	// missing age
+	f(`{"rules":[{"name":"foo","min_duration":"1s"}]}`)
+
+	// too small age
+	f(`{"age":"1h","rules":[{"name":"foo","min_duration":"1s"}]}`)
+
+	// invalid sample_ratio
+	f(`{"age":"1d","sample_ratio":2}`)
+	f(`{"age":"1d","sample_ratio":-0.1}`)
+
+	// invalid rule name
+	f(`{"age":"1d","rules":[{"name":"foo bar","min_duration":"1s"}]}`)
+
+	// duplicate rule name
+	f(`{"age":"1d","rules":[{"name":"foo","min_duration":"1s"},{"name":"foo","filter":"bar"}]}`)
+
+	// missing filter and min_duration
+	f(`{"age":"1d","rules":[{"name":"foo"}]}`)
+
+	// both filter and min_duration
+	f(`{"age":"1d","rules":[{"name":"foo","filter":"bar","min_duration":"1s"}]}`)
+
+	// invalid filter
+	f(`{"age":"1d","rules":[{"name":"foo","filter":"bar | count()"}]}`)
+
+	// invalid tenant
+	f(`{"age":"1d","tenants":["foo"]}`)
+}
+
+func TestParseRetentionFilterConfigSuccess(t *testing.T) {
+	cfg, err := parseRetentionFilterConfig([]byte(`{
+		"age": "3d",
+		"rules": [
+			{"name": "errors", "filter": "status_code:=2"},
+			{"name": "slow", "min_duration": "5s"}
+		],
+		"sample_ratio": 0.01,
+		"tenants": ["1:2"]
+	}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(cfg.Rules) != 2 {
+		t.Fatalf("unexpected number of rules; got %d; want 2", len(cfg.Rules))
+	}
+	if s := cfg.Rules[0].filter; s != "status_code:=2" {
+		t.Fatalf("unexpected filter for the errors rule; got %q", s)
+	}
+	if s := cfg.Rules[1].filter; s != "trace_id:*" {
+		t.Fatalf("unexpected filter for the slow rule; got %q", s)
+	}
+	tenantIDsExpected := []logstorage.TenantID{{AccountID: 1, ProjectID: 2}}
+	if !reflect.DeepEqual(cfg.tenantIDs, tenantIDsExpected) {
+		t.Fatalf("unexpected tenants; got %v; want %v", cfg.tenantIDs, tenantIDsExpected)
+	}
+}
+
+func TestRetentionFilterRuleGetTraceIDsQuery(t *testing.T) {
+	dataPath := t.TempDir()
+
+	mustOpenTestStorage(t, dataPath, 30*24*time.Hour)
+
+	tenantID := logstorage.TenantID{AccountID: 1}
+	start := time.Now().Add(-3 * 24 * time.Hour).Truncate(24 * time.Hour).UnixNano()
+	end := start + 24*3600*1e9
+	mustAddTestRows(func(lr *logstorage.LogRows) {
+		addSpan := func(traceID string, spanStart, spanEnd int64, statusCode string) {
+			lr.MustAdd(tenantID, start+spanEnd, []logstorage.Field{
+				{Name: otelpb.TraceIDField, Value: traceID},
+				{Name: otelpb.StartTimeUnixNanoField, Value: fmt.Sprintf("%d", start+spanStart)},
+				{Name: otelpb.EndTimeUnixNanoField, Value: fmt.Sprintf("%d", start+spanEnd)},
+				{Name: otelpb.DurationField, Value: fmt.Sprintf("%d", spanEnd-spanStart)},
+				{Name: otelpb.StatusCodeField, Value: statusCode},
+				{Name: "_msg", Value: "-"},
+			}, nil)
+		}
+		// The trace "a" lasts for 6 seconds, while every its span lasts for 3 seconds.
+		addSpan("a", 0, 3e9, "0")
+		addSpan("a", 3e9, 6e9, "0")
+		// The trace "b" lasts for 5 seconds.
+		addSpan("b", 0, 5e9, "2")
+		// The trace "c" lasts for 4 seconds.
+		addSpan("c", 1e9, 5e9, "0")
+	})
+
+	f := func(rule string, traceIDsExpected []string) {
+		t.Helper()
+
+		cfg, err := parseRetentionFilterConfig([]byte(`{"age":"1d","rules":[` + rule + `]}`))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		qStr := cfg.Rules[0].getTraceIDsQuery(start, end)
+		traceIDs, err := getUniqTraceIDs(context.Background(), []logstorage.TenantID{tenantID}, qStr)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		slices.Sort(traceIDs)
+		if !slices.Equal(traceIDs, traceIDsExpected) {
+			t.Fatalf("unexpected trace_id values for %s; got %q; want %q", rule, traceIDs, traceIDsExpected)
+		}
+	}
+
+	// min_duration is compared to the trace duration instead of the span duration.
+	f(`{"name":"slow","min_duration":"5s"}`, []string{"a", "b"})
+	f(`{"name":"slow","min_duration":"6s"}`, []string{"a"})
+	f(`{"name":"slow","min_duration":"7s"}`, nil)
+
+	f(`{"name":"errors","filter":"status_code:=2"}`, []string{"b"})
+}
+
+func TestRetentionFilterKeepTraceSample(t *testing.T) {
+	f := func(sampleRatio float64, minKept, maxKept int) {
+		t.Helper()
+
+		cfg := &retentionFilterConfig{
+			SampleRatio: sampleRatio,
+		}
+		kept := 0
+		for i := 0; i < 10_000; i++ {
+			traceID := fmt.Sprintf("%032x", i)
+			ok := cfg.keepTraceSample(traceID)
+			if ok != cfg.keepTraceSample(traceID) {
+				t.Fatalf("sampling must be deterministic for trace_id=%q", traceID)
+			}
+			if ok {
+				kept++
+			}
+		}
+		if kept < minKept || kept > maxKept {
+			t.Fatalf("unexpected number of kept traces for sample_ratio=%v; got %d; want [%d..%d]", sampleRatio, kept, minKept, maxKept)
+		}
+	}
+
+	f(0, 0, 0)
+	f(0.1, 800, 1200)
+	f(1, 10_000, 10_000)
+}
+
+func TestAppendStreamFields(t *testing.T) {
+	f := func(s string, resultExpected []logstorage.Field) {
+		t.Helper()
+
+		result, err := appendStreamFields(nil, s)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !reflect.DeepEqual(result, resultExpected) {
+			t.Fatalf("unexpected result for %q\ngot\n%v\nwant\n%v", s, result, resultExpected)
+		}
+	}
+
+	f(`{}`, nil)
+	f(`{trace_id_idx_stream="53"}`, []logstorage.Field{
+		{Name: "trace_id_idx_stream", Value: "53"},
+	})
+	f(`{name="op \"x\", y}",resource_attr:service.name="svc"}`, []logstorage.Field{
+		{Name: "name", Value: `op "x", y}`},
+		{Name: "resource_attr:service.name", Value: "svc"},
+	})
+
+	fFailure := func(s string) {
+		t.Helper()
+
+		if _, err := appendStreamFields(nil, s); err == nil {
+			t.Fatalf("expecting non-nil error for %q", s)
+		}
+	}
+
+	fFailure(``)
+	fFailure(`{foo}`)
+	fFailure(`{foo=bar}`)
+	fFailure(`{foo="bar"`)
+	fFailure(`{foo="bar" baz="x"}`)
+}
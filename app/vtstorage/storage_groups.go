@@ -0,0 +1,205 @@
+package vtstorage
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtstorage/netselect"
+)
+
+// storageGroup is a named group of storage nodes specified via -storageGroup command-line flag.
+type storageGroup struct {
+	name  string
+	addrs []string
+}
+
+// storageGroupsFlag implements flag.Value for -storageGroup command-line flag.
+//
+// Every flag value must have the form name:addr1,...,addrN. Multiple groups are specified via multiple flags.
+type storageGroupsFlag []storageGroup
+
+// String implements flag.Value interface
+func (sgf *storageGroupsFlag) String() string {
+	a := make([]string, len(*sgf))
+	for i, sg := range *sgf {
+		a[i] = sg.name + ":" + strings.Join(sg.addrs, ",")
+	}
+	return strings.Join(a, " ")
+}
+
+// Set implements flag.Value interface
+func (sgf *storageGroupsFlag) Set(value string) error {
+	sg, err := parseStorageGroup(value)
+	if err != nil {
+		return err
+	}
+	for _, x := range *sgf {
+		if x.name == sg.name {
+			return fmt.Errorf("duplicate storage group name %q", sg.name)
+		}
+	}
+	*sgf = append(*sgf, *sg)
+	return nil
+}
+
+func parseStorageGroup(s string) (*storageGroup, error) {
+	n := strings.IndexByte(s, ':')
+	if n < 0 {
+		return nil, fmt.Errorf("missing ':' after the storage group name in %q; the storage group must have the form name:addr1,...,addrN", s)
+	}
+	name := s[:n]
+	if name == "" {
+		return nil, fmt.Errorf("storage group name cannot be empty in %q", s)
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			return nil, fmt.Errorf("unsupported char %q in the storage group name %q; only alphanumeric chars, '_' and '-' are allowed", c, name)
+		}
+	}
+
+	var addrs []string
+	for _, addr := range strings.Split(s[n+1:], ",") {
+		addr = strings.TrimSpace(addr)
+		if addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("storage group %q must contain at least a single storage node address", name)
+	}
+
+	return &storageGroup{
+		name:  name,
+		addrs: addrs,
+	}, nil
+}
+
+// selectGroup is a group of storage nodes for select queries.
+type selectGroup struct {
+	// name is the -storageGroup name. It is empty for -storageNode nodes.
+	name string
+
+	s *netselect.Storage
+}
+
+// String returns human-readable name for sg.
+func (sg *selectGroup) String() string {
+	if sg.name == "" {
+		return "-storageNode nodes"
+	}
+	return fmt.Sprintf("the storage group %q", sg.name)
+}
+
+// getSelectGroupIdxs returns indexes of groups to send select queries to in the order of preference.
+//
+// The groups are returned in the order of the given names. All the groups are returned in the original order if names are empty.
+func getSelectGroupIdxs(groups []storageGroup, names []string) ([]int, error) {
+	if len(names) == 0 {
+		idxs := make([]int, len(groups))
+		for i := range groups {
+			idxs[i] = i
+		}
+		return idxs, nil
+	}
+
+	idxs := make([]int, 0, len(names))
+	for _, name := range names {
+		idx := slices.IndexFunc(groups, func(sg storageGroup) bool {
+			return sg.name == name
+		})
+		if idx < 0 {
+			return nil, fmt.Errorf("cannot find -storageGroup with the name %q", name)
+		}
+		if slices.Contains(idxs, idx) {
+			return nil, fmt.Errorf("duplicate storage group name %q", name)
+		}
+		idxs = append(idxs, idx)
+	}
+	return idxs, nil
+}
+
+var selectGroupFailovers = metrics.NewCounter(`vt_select_storage_group_failovers_total`)
+
+// runNetworkQuery runs qctx at netstorageSelects in the order of preference and calls writeBlock for the returned data blocks.
+//
+// Every group contains all the ingested spans, so the query is retried at the next group if it fails before returning any data,
+// e.g. because some nodes in the group are unavailable. The query isn't retried if some data has been already returned,
+// since writeBlock cannot be rolled back.
+func runNetworkQuery(qctx *logstorage.QueryContext, writeBlock logstorage.WriteDataBlockFunc) error {
+	var err error
+	for i, sg := range netstorageSelects {
+		var hasData atomic.Bool
+		err = sg.s.RunQuery(qctx, func(workerID uint, db *logstorage.DataBlock) {
+			hasData.Store(true)
+			writeBlock(workerID, db)
+		})
+		if err == nil || hasData.Load() || qctx.Context.Err() != nil || i+1 == len(netstorageSelects) {
+			return err
+		}
+		logger.Warnf("cannot run query at %s: %s; re-trying the query at %s", sg, err, netstorageSelects[i+1])
+		selectGroupFailovers.Inc()
+	}
+	return err
+}
+
+// getNetworkValuesWithHits calls f for netstorageSelects in the order of preference until it succeeds.
+//
+// See runNetworkQuery for details.
+func getNetworkValuesWithHits(ctx context.Context, f func(s *netselect.Storage) ([]logstorage.ValueWithHits, error)) ([]logstorage.ValueWithHits, error) {
+	var err error
+	for i, sg := range netstorageSelects {
+		var values []logstorage.ValueWithHits
+		values, err = f(sg.s)
+		if err == nil || ctx.Err() != nil || i+1 == len(netstorageSelects) {
+			return values, err
+		}
+		logger.Warnf("cannot run query at %s: %s; re-trying the query at %s", sg, err, netstorageSelects[i+1])
+		selectGroupFailovers.Inc()
+	}
+	return nil, err
+}
+
+// groupAdminResponse contains responses from the storage nodes of a single storage group for the admin request.
+type groupAdminResponse struct {
+	// Group is the -storageGroup name. It is empty for -storageNode nodes.
+	Group string `json:"group"`
+
+	// Status is the status for the storage group. See getClusterAdminStatus.
+	Status string `json:"status"`
+
+	// Nodes contains responses from every storage node in the group.
+	Nodes []netselect.AdminResponse `json:"nodes"`
+}
+
+// runNetworkAdminRequest sends the request with the given args to the given path at the storage nodes of all the storage groups in parallel.
+//
+// Admin requests manage the data at every storage node, so they are sent to all the groups including groups unused for select queries.
+// It returns responses for all the groups in the order of netstorageAdmins.
+func runNetworkAdminRequest(ctx context.Context, path string, args url.Values) []groupAdminResponse {
+	groups := make([]groupAdminResponse, len(netstorageAdmins))
+
+	var wg sync.WaitGroup
+	for i, sg := range netstorageAdmins {
+		wg.Add(1)
+		go func(g *groupAdminResponse) {
+			defer wg.Done()
+
+			responses := sg.s.RunAdminRequest(ctx, path, args)
+			g.Group = sg.name
+			g.Status = getClusterAdminStatus(responses)
+			g.Nodes = responses
+		}(&groups[i])
+	}
+	wg.Wait()
+
+	return groups
+}
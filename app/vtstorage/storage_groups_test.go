@@ -0,0 +1,204 @@
+package vtstorage
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promauth"
+
+	"github.com/VictoriaMetrics/VictoriaTraces/app/vtstorage/netselect"
+)
+
+func TestParseStorageGroupSuccess(t *testing.T) {
+	f := func(s string, resultExpected *storageGroup) {
+		t.Helper()
+
+		result, err := parseStorageGroup(s)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !reflect.DeepEqual(result, resultExpected) {
+			t.Fatalf("unexpected result\ngot\n%v\nwant\n%v", result, resultExpected)
+		}
+	}
+
+	f("az1:node1", &storageGroup{
+		name:  "az1",
+		addrs: []string{"node1"},
+	})
+	f("az-1:node1:10491,node2:10491", &storageGroup{
+		name:  "az-1",
+		addrs: []string{"node1:10491", "node2:10491"},
+	})
+	f("dr_site:node1:10491, node2:10491,", &storageGroup{
+		name:  "dr_site",
+		addrs: []string{"node1:10491", "node2:10491"},
+	})
+}
+
+func TestParseStorageGroupFailure(t *testing.T) {
+	f := func(s string) {
+		t.Helper()
+
+		_, err := parseStorageGroup(s)
+		if err == nil {
+			t.Fatalf("expecting non-nil error for %q", s)
+		}
+	}
+
+	f("")
+	f("node1")
+	f(":node1")
+	f("az1:")
+	f("az1:,")
+	f("az/1:node1")
+}
+
+func TestStorageGroupsFlagSet(t *testing.T) {
+	var sgf storageGroupsFlag
+	if err := sgf.Set("az1:node1,node2"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := sgf.Set("az2:node3"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := sgf.Set("az1:node4"); err == nil {
+		t.Fatalf("expecting non-nil error for duplicate group name")
+	}
+
+	if s := sgf.String(); s != "az1:node1,node2 az2:node3" {
+		t.Fatalf("unexpected string representation; got %q; want %q", s, "az1:node1,node2 az2:node3")
+	}
+}
+
+func TestGetSelectGroupIdxs(t *testing.T) {
+	groups := []storageGroup{
+		{name: "az1"},
+		{name: "az2"},
+		{name: "az3"},
+	}
+	f := func(names []string, resultExpected []int, isErrorExpected bool) {
+		t.Helper()
+
+		result, err := getSelectGroupIdxs(groups, names)
+		if isErrorExpected != (err != nil) {
+			t.Fatalf("unexpected error: %v; isErrorExpected=%v", err, isErrorExpected)
+		}
+		if !reflect.DeepEqual(result, resultExpected) {
+			t.Fatalf("unexpected result; got %v; want %v", result, resultExpected)
+		}
+	}
+
+	// all the groups by default
+	f(nil, []int{0, 1, 2}, false)
+
+	// the given groups in the given order
+	f([]string{"az3", "az1"}, []int{2, 0}, false)
+
+	// invalid names
+	f([]string{"az4"}, nil, true)
+	f([]string{"az1", "az1"}, nil, true)
+}
+
+func TestGetNetworkValuesWithHitsFailover(t *testing.T) {
+	origSelects := netstorageSelects
+	defer func() {
+		netstorageSelects = origSelects
+	}()
+	netstorageSelects = []*selectGroup{{}, {name: "az1"}, {name: "az2"}}
+
+	// The request must be retried at the next group until it succeeds.
+	calls := 0
+	values, err := getNetworkValuesWithHits(context.Background(), func(_ *netselect.Storage) ([]logstorage.ValueWithHits, error) {
+		calls++
+		if calls < 2 {
+			return nil, fmt.Errorf("unavailable")
+		}
+		return []logstorage.ValueWithHits{{Value: "foo", Hits: 1}}, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if calls != 2 || len(values) != 1 || values[0].Value != "foo" {
+		t.Fatalf("unexpected result after %d calls: %v", calls, values)
+	}
+
+	// The error is returned if all the groups fail.
+	calls = 0
+	if _, err := getNetworkValuesWithHits(context.Background(), func(_ *netselect.Storage) ([]logstorage.ValueWithHits, error) {
+		calls++
+		return nil, fmt.Errorf("unavailable")
+	}); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	if calls != 3 {
+		t.Fatalf("unexpected number of calls; got %d; want 3", calls)
+	}
+}
+
+func TestRunNetworkAdminRequest(t *testing.T) {
+	newServer := func(statusCode int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/internal/force_flush" {
+				t.Errorf("unexpected path: %q", r.URL.Path)
+			}
+			w.WriteHeader(statusCode)
+		}))
+	}
+	srvOK := newServer(http.StatusOK)
+	defer srvOK.Close()
+	srvFailed := newServer(http.StatusServiceUnavailable)
+	defer srvFailed.Close()
+
+	ac, err := (&promauth.Options{}).NewConfig()
+	if err != nil {
+		t.Fatalf("cannot create auth config: %s", err)
+	}
+	newGroup := func(name string, srvs ...*httptest.Server) *selectGroup {
+		addrs := make([]string, len(srvs))
+		authCfgs := make([]*promauth.Config, len(srvs))
+		for i, srv := range srvs {
+			addrs[i] = strings.TrimPrefix(srv.URL, "http://")
+			authCfgs[i] = ac
+		}
+		return &selectGroup{
+			name: name,
+			s:    netselect.NewStorage(addrs, make([]string, len(addrs)), authCfgs, make([]bool, len(addrs)), true),
+		}
+	}
+
+	origAdmins := netstorageAdmins
+	defer func() {
+		netstorageAdmins = origAdmins
+	}()
+	netstorageAdmins = []*selectGroup{
+		newGroup("", srvOK),
+		newGroup("az1", srvOK, srvFailed),
+		newGroup("az2", srvFailed),
+	}
+
+	// The request must be sent to all the groups, and the status must be reported per every group.
+	groups := runNetworkAdminRequest(context.Background(), "/internal/force_flush", nil)
+	if len(groups) != len(netstorageAdmins) {
+		t.Fatalf("unexpected number of groups; got %d; want %d", len(groups), len(netstorageAdmins))
+	}
+	statusesExpected := []string{"success", "partial_failure", "failure"}
+	nodesExpected := []int{1, 2, 1}
+	for i, g := range groups {
+		if g.Group != netstorageAdmins[i].name {
+			t.Fatalf("unexpected group name for group #%d; got %q; want %q", i, g.Group, netstorageAdmins[i].name)
+		}
+		if g.Status != statusesExpected[i] {
+			t.Fatalf("unexpected status for group %q; got %q; want %q", g.Group, g.Status, statusesExpected[i])
+		}
+		if len(g.Nodes) != nodesExpected[i] {
+			t.Fatalf("unexpected number of node responses for group %q; got %d; want %d", g.Group, len(g.Nodes), nodesExpected[i])
+		}
+	}
+}
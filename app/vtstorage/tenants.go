@@ -0,0 +1,211 @@
+package vtstorage
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/mergeset"
+)
+
+// knownTenantsFilename is the name of the file at -storageDataPath, which holds tenants seen during data ingestion.
+const knownTenantsFilename = "tenants.json"
+
+// knownTenants contains tenants, which have data at the local storage.
+//
+// The storage doesn't provide the list of the stored tenants, while background workers, which rewrite per-day partitions,
+// must process data for all the tenants. So tenants seen during data ingestion are persisted at -storageDataPath.
+type knownTenants struct {
+	// path is the path to the file with known tenants.
+	path string
+
+	// seen contains the persisted tenants. It is used for fast checks during data ingestion.
+	seen sync.Map
+
+	// tenantsLock protects tenants.
+	tenantsLock sync.Mutex
+
+	// tenants contains the persisted tenants in the form accountID:projectID.
+	tenants []string
+}
+
+var knownTenantsInstance *knownTenants
+
+// mustLoadKnownTenants loads known tenants for the storage at dataPath.
+//
+// If the file with known tenants is missing, then the tenants are read from the partitions stored at dataPath,
+// so the data ingested before the file has been introduced isn't lost during partition rewrites.
+// The storage and the archive at dataPath mustn't be opened in this case.
+func mustLoadKnownTenants(dataPath string) *knownTenants {
+	kt := &knownTenants{
+		path: filepath.Join(dataPath, knownTenantsFilename),
+	}
+	if fs.IsPathExist(kt.path) {
+		data, err := os.ReadFile(kt.path)
+		if err != nil {
+			logger.Panicf("FATAL: cannot read known tenants: %s", err)
+		}
+		if err := json.Unmarshal(data, &kt.tenants); err != nil {
+			logger.Panicf("FATAL: cannot parse known tenants from %q: %s", kt.path, err)
+		}
+	} else {
+		// This is performed only once, since the file with known tenants is created below.
+		startTime := time.Now()
+		tenants := appendStoredTenants(nil, dataPath)
+		tenants = appendStoredTenants(tenants, filepath.Join(dataPath, archiveDirname))
+		slices.Sort(tenants)
+		kt.tenants = slices.Compact(tenants)
+		// The storage directory may be missing on the first start.
+		fs.MustMkdirIfNotExist(dataPath)
+		kt.mustSaveTenantsLocked()
+		logger.Infof("found %d tenants at the stored partitions in %.3f seconds", len(kt.tenants), time.Since(startTime).Seconds())
+	}
+	for _, s := range kt.tenants {
+		kt.seen.Store(kt.mustParseTenantID(s), struct{}{})
+	}
+	return kt
+}
+
+// registerTenants registers tenants for rows in lr.
+//
+// New tenants are persisted before returning, so they aren't lost on unclean shutdown after rows in lr are stored.
+func (kt *knownTenants) registerTenants(lr *logstorage.LogRows) {
+	var newTenantIDs []logstorage.TenantID
+	var lastTenantID logstorage.TenantID
+	hasLastTenantID := false
+	lr.ForEachRow(func(_ uint64, r *logstorage.InsertRow) {
+		if hasLastTenantID && r.TenantID == lastTenantID {
+			// Fast path - rows for the same tenant usually go together.
+			return
+		}
+		lastTenantID = r.TenantID
+		hasLastTenantID = true
+		if _, ok := kt.seen.Load(r.TenantID); !ok && !slices.Contains(newTenantIDs, r.TenantID) {
+			newTenantIDs = append(newTenantIDs, r.TenantID)
+		}
+	})
+	if len(newTenantIDs) == 0 {
+		return
+	}
+
+	kt.tenantsLock.Lock()
+	defer kt.tenantsLock.Unlock()
+
+	tenants := slices.Clone(kt.tenants)
+	for _, tenantID := range newTenantIDs {
+		tenants = append(tenants, formatTenantID(tenantID))
+	}
+	slices.Sort(tenants)
+	tenants = slices.Compact(tenants)
+	if !slices.Equal(tenants, kt.tenants) {
+		kt.tenants = tenants
+		kt.mustSaveTenantsLocked()
+	}
+	// Mark tenants as seen only after they are persisted.
+	for _, tenantID := range newTenantIDs {
+		kt.seen.Store(tenantID, struct{}{})
+	}
+}
+
+func (kt *knownTenants) mustSaveTenantsLocked() {
+	data, err := json.Marshal(kt.tenants)
+	if err != nil {
+		logger.Panicf("BUG: cannot marshal known tenants: %s", err)
+	}
+	fs.MustWriteAtomic(kt.path, data, true)
+}
+
+func (kt *knownTenants) mustParseTenantID(s string) logstorage.TenantID {
+	tenantID, err := logstorage.ParseTenantID(s)
+	if err != nil {
+		logger.Panicf("FATAL: cannot parse tenant %q from %q: %s", s, kt.path, err)
+	}
+	return tenantID
+}
+
+// getTenantIDs returns the known tenants including the default tenant.
+func (kt *knownTenants) getTenantIDs() []logstorage.TenantID {
+	kt.tenantsLock.Lock()
+	tenants := kt.tenants
+	kt.tenantsLock.Unlock()
+
+	tenantIDs := []logstorage.TenantID{{}}
+	for _, s := range tenants {
+		tenantIDs = append(tenantIDs, kt.mustParseTenantID(s))
+	}
+	slices.SortFunc(tenantIDs, compareTenantIDs)
+	return slices.Compact(tenantIDs)
+}
+
+// appendStoredTenants appends tenants for the data stored at the partitions of the storage at storagePath to dst and returns the result.
+//
+// The storage at storagePath mustn't be opened.
+func appendStoredTenants(dst []string, storagePath string) []string {
+	partitionsPath := filepath.Join(storagePath, "partitions")
+	if !fs.IsPathExist(partitionsPath) {
+		return dst
+	}
+	for _, de := range fs.MustReadDir(partitionsPath) {
+		if !fs.IsDirOrSymlink(de) {
+			continue
+		}
+		indexdbPath := filepath.Join(partitionsPath, de.Name(), "indexdb")
+		if !fs.IsPathExist(indexdbPath) {
+			continue
+		}
+		dst = appendIndexdbTenants(dst, indexdbPath)
+	}
+	return dst
+}
+
+// appendIndexdbTenants appends tenants registered at the partition indexdb located at path to dst and returns the result.
+func appendIndexdbTenants(dst []string, path string) []string {
+	var isReadOnly atomic.Bool
+	isReadOnly.Store(true)
+	tb := mergeset.MustOpenTable(path, time.Second, nil, nil, &isReadOnly)
+	defer tb.MustClose()
+
+	var ts mergeset.TableSearch
+	ts.Init(tb, false)
+	defer ts.MustClose()
+
+	// Every log stream is registered at indexdb under the key consisting of the zero byte, the tenantID and the streamID.
+	// See nsPrefixStreamID at lib/logstorage/indexdb.go in VictoriaLogs.
+	const nsPrefixStreamID = 0
+	key := []byte{nsPrefixStreamID}
+	for {
+		ts.Seek(key)
+		if !ts.NextItem() {
+			break
+		}
+		item := ts.Item
+		if len(item) < 9 || item[0] != nsPrefixStreamID {
+			break
+		}
+		tenantID := logstorage.TenantID{
+			AccountID: encoding.UnmarshalUint32(item[1:5]),
+			ProjectID: encoding.UnmarshalUint32(item[5:9]),
+		}
+		dst = append(dst, formatTenantID(tenantID))
+
+		// Skip the remaining streams for the tenant.
+		n := uint64(tenantID.AccountID)<<32 | uint64(tenantID.ProjectID)
+		if n == math.MaxUint64 {
+			break
+		}
+		key = encoding.MarshalUint64(key[:1], n+1)
+	}
+	if err := ts.Error(); err != nil {
+		logger.Panicf("FATAL: cannot read tenants from indexdb at %q: %s", path, err)
+	}
+	return dst
+}
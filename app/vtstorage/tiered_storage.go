@@ -0,0 +1,495 @@
+package vtstorage
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/timeutil"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+var (
+	coldStorageDataPath = flag.String("storageDataPath.cold", "", "Optional path to directory for storing per-day partitions older than -storageDataPath.coldAfter. "+
+		"This allows keeping recently ingested data at fast disks at -storageDataPath, while moving historical data to bigger and less expensive disks. "+
+		"See https://docs.victoriametrics.com/victoriatraces/#tiered-storage")
+	coldAfter = flagutil.NewExtendedDuration("storageDataPath.coldAfter", "3d", "Per-day partitions older than the given duration are moved from -storageDataPath "+
+		"to -storageDataPath.cold; the minimum supported value is 1d. See https://docs.victoriametrics.com/victoriatraces/#tiered-storage")
+)
+
+// tieredStorageTmpDirname is the name of the directory at -storageDataPath, which holds symlinks to cold partition data until they are put in place.
+const tieredStorageTmpDirname = "tiered-storage-tmp"
+
+// tieredStorageInterval is the interval between checks for partitions, which must be moved to -storageDataPath.cold.
+const tieredStorageInterval = time.Hour
+
+// coldPartitionSubdirs contains partition subdirectories, which are moved to -storageDataPath.cold.
+//
+// The partition directory itself remains at -storageDataPath, while these subdirectories are replaced with symlinks
+// to -storageDataPath.cold. This guarantees the partition directory never disappears, so the storage doesn't create
+// a new empty partition instead of the moved one when it receives delayed spans for the partition day.
+//
+// The snapshots subdirectory is moved too, since snapshots consist of hard links, which cannot point to files at another volume.
+var coldPartitionSubdirs = []string{"indexdb", "datadb", "snapshots"}
+
+var (
+	tieredStorageMoves       = metrics.NewCounter(`vt_storage_tier_moves_total`)
+	tieredStorageMovesFailed = metrics.NewCounter(`vt_storage_tier_moves_failed_total`)
+	tieredStorageMovedBytes  = metrics.NewCounter(`vt_storage_tier_moved_bytes_total`)
+
+	tieredStorageHotPartitions  atomic.Uint64
+	tieredStorageColdPartitions atomic.Uint64
+	tieredStorageHotBytes       atomic.Uint64
+	tieredStorageColdBytes      atomic.Uint64
+
+	_ = metrics.NewGauge(`vt_storage_tier_partitions{tier="hot"}`, func() float64 {
+		return float64(tieredStorageHotPartitions.Load())
+	})
+	_ = metrics.NewGauge(`vt_storage_tier_partitions{tier="cold"}`, func() float64 {
+		return float64(tieredStorageColdPartitions.Load())
+	})
+	_ = metrics.NewGauge(`vt_storage_tier_size_bytes{tier="hot"}`, func() float64 {
+		return float64(tieredStorageHotBytes.Load())
+	})
+	_ = metrics.NewGauge(`vt_storage_tier_size_bytes{tier="cold"}`, func() float64 {
+		return float64(tieredStorageColdBytes.Load())
+	})
+)
+
+// partitionsMoveLock serializes background workers, which move partition directories.
+//
+// This prevents from removing cold partition data, which is referred by the partition temporarily moved by another worker.
+var partitionsMoveLock sync.Mutex
+
+// tieredStorage moves per-day partitions older than -storageDataPath.coldAfter from -storageDataPath to -storageDataPath.cold.
+//
+// Moved partitions remain attached to the storage, so they are transparently available for querying.
+type tieredStorage struct {
+	// dataPath is the path to -storageDataPath.
+	dataPath string
+
+	// coldPath is the absolute path to -storageDataPath.cold.
+	coldPath string
+
+	// age is the minimum age for partitions to move.
+	age time.Duration
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+var tieredStorageInstance *tieredStorage
+
+// mustInitTieredStorage prepares tiered storage for the storage at dataPath.
+//
+// It must be called before opening the storage at dataPath, since it finishes partition moves interrupted by unclean shutdown.
+//
+// nil is returned if -storageDataPath.cold isn't set.
+func mustInitTieredStorage(dataPath string) *tieredStorage {
+	mustRecoverTieredStorageTmpDir(dataPath)
+
+	if *coldStorageDataPath == "" {
+		return nil
+	}
+	if coldAfter.Duration() < 24*time.Hour {
+		logger.Fatalf("-storageDataPath.coldAfter cannot be smaller than a day; got %s", coldAfter)
+	}
+	coldPath, err := filepath.Abs(*coldStorageDataPath)
+	if err != nil {
+		logger.Fatalf("cannot obtain absolute path for -storageDataPath.cold=%q: %s", *coldStorageDataPath, err)
+	}
+	hotPath, err := filepath.Abs(dataPath)
+	if err != nil {
+		logger.Fatalf("cannot obtain absolute path for -storageDataPath=%q: %s", dataPath, err)
+	}
+	if coldPath == hotPath {
+		logger.Fatalf("-storageDataPath.cold must differ from -storageDataPath; got %q", coldPath)
+	}
+
+	ts := &tieredStorage{
+		dataPath: dataPath,
+		coldPath: coldPath,
+		age:      coldAfter.Duration(),
+		stopCh:   make(chan struct{}),
+	}
+	fs.MustMkdirIfNotExist(filepath.Join(coldPath, "partitions"))
+
+	// Drop partially copied partitions.
+	fs.MustRemoveDir(ts.coldTmpPath())
+
+	ts.removeOrphanedColdPartitions()
+
+	return ts
+}
+
+// mustRecoverTieredStorageTmpDir finishes moving partitions to -storageDataPath.cold interrupted by unclean shutdown.
+//
+// Symlinks are put to the tmp dir only after the partition data is completely copied to -storageDataPath.cold,
+// so the interrupted move can be always finished.
+func mustRecoverTieredStorageTmpDir(dataPath string) {
+	tmpPath := filepath.Join(dataPath, tieredStorageTmpDirname)
+	if !fs.IsPathExist(tmpPath) {
+		return
+	}
+
+	for _, de := range fs.MustReadDir(tmpPath) {
+		name := de.Name()
+		partitionPath := filepath.Join(dataPath, "partitions", name)
+		if !fs.IsPathExist(partitionPath) {
+			continue
+		}
+		mustReplaceWithSymlinks(filepath.Join(tmpPath, name), partitionPath)
+		logger.Infof("finished the interrupted move of partition %q to -storageDataPath.cold", name)
+	}
+	fs.MustRemoveDir(tmpPath)
+}
+
+func (ts *tieredStorage) coldTmpPath() string {
+	return filepath.Join(ts.coldPath, "tmp")
+}
+
+// start starts moving partitions in background.
+func (ts *tieredStorage) start() {
+	ts.wg.Add(1)
+	go func() {
+		defer ts.wg.Done()
+		ts.run()
+	}()
+}
+
+// mustStop stops ts. The interrupted partition move is rolled back.
+func (ts *tieredStorage) mustStop() {
+	close(ts.stopCh)
+	ts.wg.Wait()
+}
+
+func (ts *tieredStorage) run() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-ts.stopCh
+		cancel()
+	}()
+
+	d := timeutil.AddJitterToDuration(tieredStorageInterval)
+	t := time.NewTicker(d)
+	defer t.Stop()
+	for {
+		ts.movePartitions(ctx)
+		updateTieredStorageStats()
+
+		select {
+		case <-ts.stopCh:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (ts *tieredStorage) movePartitions(ctx context.Context) {
+	partitionsMoveLock.Lock()
+	defer partitionsMoveLock.Unlock()
+
+	ts.removeOrphanedColdPartitions()
+
+	deadline := time.Now().Add(-ts.age)
+	for _, name := range localStorage.PartitionList() {
+		day, err := time.Parse("20060102", name)
+		if err != nil {
+			continue
+		}
+		if day.Add(24 * time.Hour).After(deadline) {
+			// The partition may contain spans younger than the age.
+			continue
+		}
+		partitionPath := filepath.Join(ts.dataPath, "partitions", name)
+		if isColdPartition(partitionPath) {
+			continue
+		}
+
+		logger.Infof("moving partition %q to -storageDataPath.cold=%q", name, ts.coldPath)
+		startTime := time.Now()
+		if err := ts.movePartition(ctx, name); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Errorf("cannot move partition %q to -storageDataPath.cold: %s", name, err)
+			tieredStorageMovesFailed.Inc()
+			continue
+		}
+
+		size := getDirSize(filepath.Join(ts.coldPath, "partitions", name))
+		tieredStorageMoves.Inc()
+		tieredStorageMovedBytes.AddInt64(int64(size))
+		logger.Infof("partition %q has been moved to -storageDataPath.cold in %.3f seconds; size: %d bytes", name, time.Since(startTime).Seconds(), size)
+	}
+}
+
+// movePartition moves the partition with the given name to ts.coldPath.
+//
+// The partition data is copied from the partition snapshot while the partition remains available for querying.
+// Then the partition is detached for a short period of time needed for copying the data changed since the snapshot creation
+// and for replacing the partition data with symlinks to ts.coldPath.
+func (ts *tieredStorage) movePartition(ctx context.Context, name string) error {
+	partitionPath := filepath.Join(ts.dataPath, "partitions", name)
+	snapshotsPath := filepath.Join(partitionPath, "snapshots")
+	if err := checkNoSnapshots(snapshotsPath); err != nil {
+		return err
+	}
+
+	tmpPartitionPath := filepath.Join(ts.coldTmpPath(), name)
+	fs.MustRemoveDir(tmpPartitionPath)
+	fs.MustMkdirIfNotExist(tmpPartitionPath)
+
+	snapshotPath, err := localStorage.PartitionSnapshotCreate(name)
+	if err != nil {
+		fs.MustRemoveDir(tmpPartitionPath)
+		return err
+	}
+	for _, subdir := range []string{"indexdb", "datadb"} {
+		if err = copyPartitionSubdir(ctx, filepath.Join(snapshotPath, subdir), filepath.Join(tmpPartitionPath, subdir)); err != nil {
+			break
+		}
+	}
+	fs.MustRemoveDir(snapshotPath)
+	if err != nil {
+		fs.MustRemoveDir(tmpPartitionPath)
+		return err
+	}
+
+	if err := localStorage.PartitionDetach(name); err != nil {
+		fs.MustRemoveDir(tmpPartitionPath)
+		return err
+	}
+	if err := checkNoSnapshots(snapshotsPath); err != nil {
+		fs.MustRemoveDir(tmpPartitionPath)
+		if err := localStorage.PartitionAttach(name); err != nil {
+			logger.Panicf("FATAL: cannot attach partition %q: %s", name, err)
+		}
+		return err
+	}
+
+	// Copy the data, which has been changed since the snapshot creation. Parts are immutable, so only new parts
+	// and parts.json files must be copied, while parts removed by background merges must be deleted.
+	for _, subdir := range []string{"indexdb", "datadb"} {
+		syncPartitionSubdir(filepath.Join(partitionPath, subdir), filepath.Join(tmpPartitionPath, subdir))
+	}
+	fs.MustMkdirIfNotExist(filepath.Join(tmpPartitionPath, "snapshots"))
+	fs.MustSyncPath(tmpPartitionPath)
+
+	coldPartitionPath := filepath.Join(ts.coldPath, "partitions", name)
+	fs.MustRemoveDir(coldPartitionPath)
+	mustRenamePath(tmpPartitionPath, coldPartitionPath)
+
+	// Replace the partition data with symlinks to the cold partition.
+	symlinksPath := filepath.Join(ts.dataPath, tieredStorageTmpDirname, name)
+	fs.MustMkdirIfNotExist(symlinksPath)
+	for _, subdir := range coldPartitionSubdirs {
+		if err := os.Symlink(filepath.Join(coldPartitionPath, subdir), filepath.Join(symlinksPath, subdir)); err != nil {
+			logger.Panicf("FATAL: cannot create symlink: %s", err)
+		}
+	}
+	fs.MustSyncPathAndParentDir(symlinksPath)
+	mustReplaceWithSymlinks(symlinksPath, partitionPath)
+	fs.MustRemoveDir(filepath.Join(ts.dataPath, tieredStorageTmpDirname))
+
+	if err := localStorage.PartitionAttach(name); err != nil {
+		logger.Panicf("FATAL: cannot attach the moved partition %q: %s", name, err)
+	}
+	return nil
+}
+
+// checkNoSnapshots returns an error if snapshotsPath contains snapshots.
+func checkNoSnapshots(snapshotsPath string) error {
+	if !fs.IsPathExist(snapshotsPath) {
+		return nil
+	}
+	if des := fs.MustReadDir(snapshotsPath); len(des) > 0 {
+		return fmt.Errorf("the partition contains %d snapshots at %q; they must be removed before moving the partition, "+
+			"since snapshots consist of hard links, which cannot be moved to another volume", len(des), snapshotsPath)
+	}
+	return nil
+}
+
+// copyPartitionSubdir copies the indexdb or datadb partition subdirectory at srcPath to dstPath.
+func copyPartitionSubdir(ctx context.Context, srcPath, dstPath string) error {
+	fs.MustMkdirIfNotExist(dstPath)
+	for _, de := range fs.MustReadDir(srcPath) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		name := de.Name()
+		if de.IsDir() {
+			fs.MustCopyDirectory(filepath.Join(srcPath, name), filepath.Join(dstPath, name))
+		} else {
+			fs.MustCopyFile(filepath.Join(srcPath, name), filepath.Join(dstPath, name))
+		}
+	}
+	fs.MustSyncPath(dstPath)
+	return nil
+}
+
+// syncPartitionSubdir makes the indexdb or datadb partition subdirectory at dstPath identical to srcPath.
+//
+// Part directories are immutable, so the existing part directories at dstPath are left as is.
+func syncPartitionSubdir(srcPath, dstPath string) {
+	srcNames := make(map[string]struct{})
+	for _, de := range fs.MustReadDir(srcPath) {
+		name := de.Name()
+		srcNames[name] = struct{}{}
+		dst := filepath.Join(dstPath, name)
+		if !de.IsDir() {
+			fs.MustCopyFile(filepath.Join(srcPath, name), dst)
+		} else if !fs.IsPathExist(dst) {
+			fs.MustCopyDirectory(filepath.Join(srcPath, name), dst)
+		}
+	}
+	for _, de := range fs.MustReadDir(dstPath) {
+		name := de.Name()
+		if _, ok := srcNames[name]; !ok {
+			fs.MustRemoveDir(filepath.Join(dstPath, name))
+		}
+	}
+	fs.MustSyncPath(dstPath)
+}
+
+// mustReplaceWithSymlinks replaces the subdirectories at partitionPath with the corresponding symlinks from symlinksPath.
+func mustReplaceWithSymlinks(symlinksPath, partitionPath string) {
+	for _, de := range fs.MustReadDir(symlinksPath) {
+		name := de.Name()
+		dst := filepath.Join(partitionPath, name)
+		if fi, err := os.Lstat(dst); err == nil {
+			if fi.Mode()&os.ModeSymlink != 0 {
+				fs.MustRemovePath(dst)
+			} else {
+				fs.MustRemoveDir(dst)
+			}
+		}
+		mustRenamePath(filepath.Join(symlinksPath, name), dst)
+	}
+}
+
+// removeOrphanedColdPartitions removes partitions at ts.coldPath, which aren't referred by partitions at ts.dataPath.
+//
+// Such partitions appear when the partition is dropped because of retention, since only symlinks are dropped in this case.
+// They also appear when the partition is rewritten by retention filter, since the rewritten partition is stored at ts.dataPath.
+func (ts *tieredStorage) removeOrphanedColdPartitions() {
+	coldPartitionsPath := filepath.Join(ts.coldPath, "partitions")
+	for _, de := range fs.MustReadDir(coldPartitionsPath) {
+		name := de.Name()
+		coldPartitionPath := filepath.Join(coldPartitionsPath, name)
+		target, err := os.Readlink(filepath.Join(ts.dataPath, "partitions", name, "datadb"))
+		if err == nil && target == filepath.Join(coldPartitionPath, "datadb") {
+			continue
+		}
+		logger.Infof("removing cold partition %q, since it isn't referred by -storageDataPath", coldPartitionPath)
+		fs.MustRemoveDir(coldPartitionPath)
+	}
+	fs.MustSyncPath(coldPartitionsPath)
+}
+
+// isColdPartition returns true if the partition at partitionPath is stored at -storageDataPath.cold.
+func isColdPartition(partitionPath string) bool {
+	fi, err := os.Lstat(filepath.Join(partitionPath, "datadb"))
+	return err == nil && fi.Mode()&os.ModeSymlink != 0
+}
+
+// getDirSize returns the size in bytes of files at the given path. Symlinks are followed.
+func getDirSize(path string) uint64 {
+	des, err := os.ReadDir(path)
+	if err != nil {
+		return 0
+	}
+	n := uint64(0)
+	for _, de := range des {
+		p := filepath.Join(path, de.Name())
+		fi, err := os.Stat(p)
+		if err != nil {
+			// The file may be removed by background merge.
+			continue
+		}
+		if fi.IsDir() {
+			n += getDirSize(p)
+		} else {
+			n += uint64(fi.Size())
+		}
+	}
+	return n
+}
+
+// PartitionTierInfo contains information about the storage tier for the partition.
+type PartitionTierInfo struct {
+	// Name is the partition name in the YYYYMMDD format.
+	Name string `json:"name"`
+
+	// Tier is either "hot" for partitions stored at -storageDataPath or "cold" for partitions stored at -storageDataPath.cold.
+	Tier string `json:"tier"`
+
+	// Path is the path to the partition data.
+	Path string `json:"path"`
+
+	// SizeBytes is the on-disk size of the partition data.
+	SizeBytes uint64 `json:"size_bytes"`
+}
+
+func getPartitionTiers() []PartitionTierInfo {
+	names := localStorage.PartitionList()
+	pts := make([]PartitionTierInfo, 0, len(names))
+	for _, name := range names {
+		partitionPath := filepath.Join(*storageDataPath, "partitions", name)
+		pti := PartitionTierInfo{
+			Name: name,
+			Tier: "hot",
+			Path: partitionPath,
+		}
+		if isColdPartition(partitionPath) {
+			pti.Tier = "cold"
+			if target, err := os.Readlink(filepath.Join(partitionPath, "datadb")); err == nil {
+				pti.Path = filepath.Dir(target)
+			}
+		}
+		pti.SizeBytes = getDirSize(partitionPath)
+		pts = append(pts, pti)
+	}
+	return pts
+}
+
+func updateTieredStorageStats() {
+	var hotPartitions, coldPartitions, hotBytes, coldBytes uint64
+	for _, pti := range getPartitionTiers() {
+		if pti.Tier == "cold" {
+			coldPartitions++
+			coldBytes += pti.SizeBytes
+		} else {
+			hotPartitions++
+			hotBytes += pti.SizeBytes
+		}
+	}
+	tieredStorageHotPartitions.Store(hotPartitions)
+	tieredStorageColdPartitions.Store(coldPartitions)
+	tieredStorageHotBytes.Store(hotBytes)
+	tieredStorageColdBytes.Store(coldBytes)
+}
+
+func processPartitionTiers(w http.ResponseWriter, r *http.Request) bool {
+	if localStorage == nil {
+		return processClusterAdminRequest(w, r, partitionManageAuthKey)
+	}
+
+	if !httpserver.CheckAuthFlag(w, r, partitionManageAuthKey) {
+		return true
+	}
+
+	writeJSONResponse(w, getPartitionTiers())
+	return true
+}
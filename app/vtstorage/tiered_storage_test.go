@@ -0,0 +1,132 @@
+package vtstorage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+)
+
+func TestTieredStorage(t *testing.T) {
+	dataPath := filepath.Join(t.TempDir(), "hot")
+	coldPath := filepath.Join(t.TempDir(), "cold")
+
+	mustOpenTestStorage(t, dataPath, 30*24*time.Hour)
+
+	// Ingest spans for today and for 5 days ago.
+	now := time.Now()
+	oldDay := now.Add(-5 * 24 * time.Hour)
+	mustAddTestRows(func(lr *logstorage.LogRows) {
+		for _, ts := range []time.Time{now, oldDay, oldDay} {
+			addTestSpan(lr, logstorage.TenantID{}, ts.UnixNano(), "a", "svc")
+		}
+	})
+
+	ts := &tieredStorage{
+		dataPath: dataPath,
+		coldPath: coldPath,
+		age:      3 * 24 * time.Hour,
+		stopCh:   make(chan struct{}),
+	}
+	fs.MustMkdirIfNotExist(filepath.Join(coldPath, "partitions"))
+	ts.movePartitions(context.Background())
+
+	oldName := oldDay.UTC().Format("20060102")
+	newName := now.UTC().Format("20060102")
+	if !isColdPartition(filepath.Join(dataPath, "partitions", oldName)) {
+		t.Fatalf("partition %q must be moved to the cold storage", oldName)
+	}
+	if isColdPartition(filepath.Join(dataPath, "partitions", newName)) {
+		t.Fatalf("partition %q mustn't be moved to the cold storage", newName)
+	}
+	if !fs.IsPathExist(filepath.Join(coldPath, "partitions", oldName, "datadb", "parts.json")) {
+		t.Fatalf("missing partition data at the cold storage")
+	}
+
+	origStorageDataPath := *storageDataPath
+	*storageDataPath = dataPath
+	defer func() {
+		*storageDataPath = origStorageDataPath
+	}()
+	tiers := make(map[string]string)
+	for _, pti := range getPartitionTiers() {
+		tiers[pti.Name] = pti.Tier
+	}
+	if tiers[oldName] != "cold" || tiers[newName] != "hot" {
+		t.Fatalf("unexpected partition tiers: %v", tiers)
+	}
+
+	// The moved partition remains queryable.
+	if n := getStorageRowsCount(localStorage); n != 3 {
+		t.Fatalf("unexpected number of rows after moving the partition; got %d; want 3", n)
+	}
+
+	// Moving the partition again is no-op.
+	ts.movePartitions(context.Background())
+	if n := getStorageRowsCount(localStorage); n != 3 {
+		t.Fatalf("unexpected number of rows after the second move; got %d; want 3", n)
+	}
+
+	// Verify that the moved partition is opened after the restart.
+	localStorage.MustClose()
+	localStorage = logstorage.MustOpenStorage(dataPath, &logstorage.StorageConfig{
+		Retention: 30 * 24 * time.Hour,
+	})
+	if n := getStorageRowsCount(localStorage); n != 3 {
+		t.Fatalf("unexpected number of rows after the restart; got %d; want 3", n)
+	}
+
+	// Verify that the cold partition data is removed after the partition is dropped.
+	if err := localStorage.PartitionDetach(oldName); err != nil {
+		t.Fatalf("cannot detach partition: %s", err)
+	}
+	fs.MustRemoveDir(filepath.Join(dataPath, "partitions", oldName))
+	ts.removeOrphanedColdPartitions()
+	if fs.IsPathExist(filepath.Join(coldPath, "partitions", oldName)) {
+		t.Fatalf("the cold partition must be removed after the partition is dropped")
+	}
+}
+
+func TestMustRecoverTieredStorageTmpDir(t *testing.T) {
+	dataPath := t.TempDir()
+	coldPartitionPath := filepath.Join(t.TempDir(), "partitions", "20250101")
+
+	partitionPath := filepath.Join(dataPath, "partitions", "20250101")
+	symlinksPath := filepath.Join(dataPath, tieredStorageTmpDirname, "20250101")
+	fs.MustMkdirIfNotExist(symlinksPath)
+	for _, subdir := range coldPartitionSubdirs {
+		fs.MustMkdirIfNotExist(filepath.Join(coldPartitionPath, subdir))
+		if err := os.Symlink(filepath.Join(coldPartitionPath, subdir), filepath.Join(symlinksPath, subdir)); err != nil {
+			t.Fatalf("cannot create symlink: %s", err)
+		}
+	}
+
+	// Simulate unclean shutdown after replacing indexdb with the symlink.
+	fs.MustMkdirIfNotExist(filepath.Join(partitionPath, "datadb"))
+	mustRenamePath(filepath.Join(symlinksPath, "indexdb"), filepath.Join(partitionPath, "indexdb"))
+
+	mustRecoverTieredStorageTmpDir(dataPath)
+
+	if fs.IsPathExist(filepath.Join(dataPath, tieredStorageTmpDirname)) {
+		t.Fatalf("the tmp dir must be removed")
+	}
+	for _, subdir := range coldPartitionSubdirs {
+		target, err := os.Readlink(filepath.Join(partitionPath, subdir))
+		if err != nil {
+			t.Fatalf("cannot read symlink for %q: %s", subdir, err)
+		}
+		if want := filepath.Join(coldPartitionPath, subdir); target != want {
+			t.Fatalf("unexpected symlink target for %q; got %q; want %q", subdir, target, want)
+		}
+	}
+}
+
+func getStorageRowsCount(s *logstorage.Storage) uint64 {
+	var ss logstorage.StorageStats
+	s.UpdateStats(&ss)
+	return ss.SmallPartRowsCount + ss.BigPartRowsCount + ss.InmemoryRowsCount
+}
@@ -0,0 +1,353 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+// Stats contains stats for backup and restore operations.
+type Stats struct {
+	// FilesCopied is the number of files copied to or from the remote storage.
+	FilesCopied atomic.Int64
+
+	// BytesCopied is the number of bytes copied to or from the remote storage.
+	BytesCopied atomic.Int64
+
+	// FilesSkipped is the number of files, which already exist at the remote storage.
+	FilesSkipped atomic.Int64
+
+	// BytesSkipped is the number of bytes in files, which already exist at the remote storage.
+	BytesSkipped atomic.Int64
+}
+
+// ObjectSet is a set of objects stored at the remote storage.
+type ObjectSet struct {
+	mu sync.Mutex
+	m  map[string]struct{}
+}
+
+// ListObjects returns the set of objects stored at rfs.
+func ListObjects(ctx context.Context, rfs RemoteFS) (*ObjectSet, error) {
+	paths, err := rfs.ListFiles(ctx, "objects/")
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]struct{}, len(paths))
+	for _, path := range paths {
+		m[path[strings.LastIndexByte(path, '/')+1:]] = struct{}{}
+	}
+	return &ObjectSet{
+		m: m,
+	}, nil
+}
+
+// Has returns true if the object with the given sha256Hex hash exists in s.
+func (s *ObjectSet) Has(sha256Hex string) bool {
+	s.mu.Lock()
+	_, ok := s.m[sha256Hex]
+	s.mu.Unlock()
+	return ok
+}
+
+func (s *ObjectSet) add(sha256Hex string) {
+	s.mu.Lock()
+	s.m[sha256Hex] = struct{}{}
+	s.mu.Unlock()
+}
+
+// BackupPartition uploads files from the partition snapshot at snapshotPath to rfs and returns the manifest for the partition with the given name.
+//
+// Files are stored as objects named by the sha256 hash of their contents, so files already stored at rfs aren't uploaded again.
+// Part files are immutable, so their hashes are taken from prevPM if it contains them. This allows skipping unchanged parts without reading them.
+//
+// Nothing is uploaded if dryRun is set, while stats contain files, which would be uploaded.
+func BackupPartition(ctx context.Context, rfs RemoteFS, name, snapshotPath string, prevPM *PartitionManifest, objects *ObjectSet,
+	concurrency int, dryRun bool, stats *Stats) (*PartitionManifest, error) {
+	var files []FileInfo
+	err := filepath.WalkDir(snapshotPath, func(path string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if de.IsDir() {
+			return nil
+		}
+		fi, err := de.Info()
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(snapshotPath, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, FileInfo{
+			Path: filepath.ToSlash(relPath),
+			Size: fi.Size(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot read snapshot for partition %q at %q: %w", name, snapshotPath, err)
+	}
+
+	prevHashes := make(map[FileInfo]string)
+	if prevPM != nil {
+		for _, fi := range prevPM.Files {
+			if isPartFile(fi.Path) {
+				prevHashes[FileInfo{Path: fi.Path, Size: fi.Size}] = fi.SHA256
+			}
+		}
+	}
+
+	err = runParallel(ctx, concurrency, len(files), func(i int) error {
+		fi := &files[i]
+		path := filepath.Join(snapshotPath, filepath.FromSlash(fi.Path))
+
+		sha256Hex, ok := prevHashes[*fi]
+		if !ok {
+			h, err := getFileSHA256(path)
+			if err != nil {
+				return err
+			}
+			sha256Hex = h
+		}
+		fi.SHA256 = sha256Hex
+
+		if objects.Has(sha256Hex) {
+			stats.FilesSkipped.Add(1)
+			stats.BytesSkipped.Add(fi.Size)
+			return nil
+		}
+		if !dryRun {
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			err = rfs.WriteFile(ctx, getObjectPath(sha256Hex), f, fi.Size, sha256Hex)
+			_ = f.Close()
+			if err != nil {
+				return fmt.Errorf("cannot upload %q to %s: %w", path, rfs, err)
+			}
+			objects.add(sha256Hex)
+		}
+		stats.FilesCopied.Add(1)
+		stats.BytesCopied.Add(fi.Size)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &PartitionManifest{
+		Name:  name,
+		Files: files,
+	}, nil
+}
+
+// isPartFile returns true if the file at the given path relative to the partition directory belongs to a part.
+//
+// Parts are stored in indexdb/<partName>/ and datadb/<partName>/ directories. They are immutable.
+func isPartFile(path string) bool {
+	return strings.Count(path, "/") == 2
+}
+
+func getFileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("cannot read %q: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// DeleteOldBackups deletes backups at rfs except of the keepLast newest backups, and then deletes objects, which aren't referred by the remaining backups.
+//
+// Backup names are compared lexicographically, so the default backup names based on the creation time are ordered by age.
+// Nothing is deleted if dryRun is set, while the returned names and the number of objects are for backups and objects, which would be deleted.
+//
+// It returns names for the deleted backups and the number of deleted objects.
+func DeleteOldBackups(ctx context.Context, rfs RemoteFS, keepLast int, dryRun bool) ([]string, int, error) {
+	names, err := ListBackups(ctx, rfs)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(names) <= keepLast {
+		return nil, 0, nil
+	}
+	deleteNames := names[:len(names)-keepLast]
+	keepNames := names[len(names)-keepLast:]
+
+	// Collect objects referred by the remaining backups before deleting old backups,
+	// so objects aren't deleted if some of the remaining manifests cannot be read.
+	referred := make(map[string]struct{})
+	for _, name := range keepNames {
+		m, err := ReadManifest(ctx, rfs, name)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, pm := range m.Partitions {
+			for _, fi := range pm.Files {
+				referred[fi.SHA256] = struct{}{}
+			}
+		}
+	}
+
+	for _, name := range deleteNames {
+		if dryRun {
+			logger.Infof("dry run: backup %q would be deleted from %s", name, rfs)
+			continue
+		}
+		logger.Infof("deleting backup %q from %s", name, rfs)
+		if err := rfs.DeleteFile(ctx, getManifestPath(name)); err != nil {
+			return nil, 0, fmt.Errorf("cannot delete backup %q from %s: %w", name, rfs, err)
+		}
+	}
+
+	objectPaths, err := rfs.ListFiles(ctx, "objects/")
+	if err != nil {
+		return nil, 0, err
+	}
+	deletedObjects := 0
+	for _, path := range objectPaths {
+		if _, ok := referred[path[strings.LastIndexByte(path, '/')+1:]]; ok {
+			continue
+		}
+		deletedObjects++
+		if dryRun {
+			continue
+		}
+		if err := rfs.DeleteFile(ctx, path); err != nil {
+			return nil, 0, fmt.Errorf("cannot delete object %q from %s: %w", path, rfs, err)
+		}
+	}
+	return slices.Clone(deleteNames), deletedObjects, nil
+}
+
+// RestorePartition downloads files for the partition pm from rfs to dstDir.
+//
+// Checksums for the downloaded files are verified.
+func RestorePartition(ctx context.Context, rfs RemoteFS, pm *PartitionManifest, dstDir string, concurrency int, stats *Stats) error {
+	err := runParallel(ctx, concurrency, len(pm.Files), func(i int) error {
+		fi := &pm.Files[i]
+		if err := restoreFile(ctx, rfs, fi, dstDir); err != nil {
+			return fmt.Errorf("cannot restore %q for partition %q: %w", fi.Path, pm.Name, err)
+		}
+		stats.FilesCopied.Add(1)
+		stats.BytesCopied.Add(fi.Size)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Sync directories, so the restored files survive unclean shutdown.
+	return filepath.WalkDir(dstDir, func(path string, de fs.DirEntry, err error) error {
+		if err != nil || !de.IsDir() {
+			return err
+		}
+		return syncPath(path)
+	})
+}
+
+func restoreFile(ctx context.Context, rfs RemoteFS, fi *FileInfo, dstDir string) error {
+	if strings.Contains(fi.Path, "..") || filepath.IsAbs(fi.Path) {
+		return fmt.Errorf("invalid file path %q", fi.Path)
+	}
+	r, err := rfs.ReadFile(ctx, getObjectPath(fi.SHA256))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	path := filepath.Join(dstDir, filepath.FromSlash(fi.Path))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	n, err := io.Copy(f, io.TeeReader(r, h))
+	if err == nil {
+		err = f.Sync()
+	}
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		return err
+	}
+	if n != fi.Size {
+		return fmt.Errorf("unexpected file size; got %d bytes; want %d bytes", n, fi.Size)
+	}
+	if sha256Hex := hex.EncodeToString(h.Sum(nil)); sha256Hex != fi.SHA256 {
+		return fmt.Errorf("checksum mismatch; got sha256=%s; want sha256=%s", sha256Hex, fi.SHA256)
+	}
+	return nil
+}
+
+func syncPath(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
+
+// runParallel calls f for every index in the range [0..n) on up to concurrency goroutines.
+//
+// The first error returned by f is returned.
+func runParallel(ctx context.Context, concurrency, n int, f func(i int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+	idxCh := make(chan int)
+	for range max(concurrency, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range idxCh {
+				if err := f(i); err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		idxCh <- i
+	}
+	close(idxCh)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,230 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBackupRestorePartition(t *testing.T) {
+	tmpDir := t.TempDir()
+	ctx := context.Background()
+	rfs := newFSRemote(filepath.Join(tmpDir, "backups"))
+
+	snapshotPath := filepath.Join(tmpDir, "snapshot")
+	mustWriteFile(t, filepath.Join(snapshotPath, "datadb", "parts.json"), `["part1"]`)
+	mustWriteFile(t, filepath.Join(snapshotPath, "datadb", "part1", "values.bin"), "foobar")
+	mustWriteFile(t, filepath.Join(snapshotPath, "indexdb", "part2", "items.bin"), "baz")
+
+	createBackup := func(name string, prevPM *PartitionManifest) (*PartitionManifest, *Stats) {
+		t.Helper()
+		objects, err := ListObjects(ctx, rfs)
+		if err != nil {
+			t.Fatalf("cannot list objects: %s", err)
+		}
+		var stats Stats
+		pm, err := BackupPartition(ctx, rfs, "20251018", snapshotPath, prevPM, objects, 2, false, &stats)
+		if err != nil {
+			t.Fatalf("cannot back up partition: %s", err)
+		}
+		m := &Manifest{
+			Name:       name,
+			Partitions: []*PartitionManifest{pm},
+		}
+		if err := WriteManifest(ctx, rfs, m); err != nil {
+			t.Fatalf("cannot write manifest: %s", err)
+		}
+		return pm, &stats
+	}
+
+	// The first backup uploads all the files
+	pm1, stats := createBackup("20251018000000", nil)
+	if n := stats.FilesCopied.Load(); n != 3 {
+		t.Fatalf("unexpected number of uploaded files; got %d; want 3", n)
+	}
+	if n := pm1.Size(); n != int64(len(`["part1"]`)+len("foobar")+len("baz")) {
+		t.Fatalf("unexpected partition size: %d", n)
+	}
+
+	// The second backup uploads only the changed file
+	mustWriteFile(t, filepath.Join(snapshotPath, "datadb", "parts.json"), `["part1","part3"]`)
+	mustWriteFile(t, filepath.Join(snapshotPath, "datadb", "part3", "values.bin"), "foobar")
+	pm2, stats := createBackup("20251018010000", pm1)
+	if n := stats.FilesCopied.Load(); n != 1 {
+		t.Fatalf("unexpected number of uploaded files; got %d; want 1", n)
+	}
+	if n := stats.FilesSkipped.Load(); n != 3 {
+		t.Fatalf("unexpected number of skipped files; got %d; want 3", n)
+	}
+
+	names, err := ListBackups(ctx, rfs)
+	if err != nil {
+		t.Fatalf("cannot list backups: %s", err)
+	}
+	if strings.Join(names, ",") != "20251018000000,20251018010000" {
+		t.Fatalf("unexpected backups: %q", names)
+	}
+
+	// Restore the first backup
+	dstDir := filepath.Join(tmpDir, "restored")
+	var restoreStats Stats
+	if err := RestorePartition(ctx, rfs, pm1, dstDir, 2, &restoreStats); err != nil {
+		t.Fatalf("cannot restore partition: %s", err)
+	}
+	mustHaveFile(t, filepath.Join(dstDir, "datadb", "parts.json"), `["part1"]`)
+	mustHaveFile(t, filepath.Join(dstDir, "datadb", "part1", "values.bin"), "foobar")
+	mustHaveFile(t, filepath.Join(dstDir, "indexdb", "part2", "items.bin"), "baz")
+
+	// Delete the first backup; the objects referred only by it must be deleted
+	deleted, deletedObjects, err := DeleteOldBackups(ctx, rfs, 1, false)
+	if err != nil {
+		t.Fatalf("cannot delete old backups: %s", err)
+	}
+	if len(deleted) != 1 || deleted[0] != "20251018000000" {
+		t.Fatalf("unexpected deleted backups: %q", deleted)
+	}
+	if deletedObjects != 1 {
+		t.Fatalf("unexpected number of deleted objects; got %d; want 1", deletedObjects)
+	}
+	objects, err := ListObjects(ctx, rfs)
+	if err != nil {
+		t.Fatalf("cannot list objects: %s", err)
+	}
+	for _, fi := range pm2.Files {
+		if !objects.Has(fi.SHA256) {
+			t.Fatalf("missing object for %q after deleting old backups", fi.Path)
+		}
+	}
+
+	// Restore must detect corrupted objects
+	fi := pm2.Files[0]
+	mustWriteFile(t, filepath.Join(rfs.dir, filepath.FromSlash(getObjectPath(fi.SHA256))), strings.Repeat("x", int(fi.Size)))
+	err = RestorePartition(ctx, rfs, pm2, filepath.Join(tmpDir, "restored2"), 2, &restoreStats)
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("expecting checksum mismatch error; got %v", err)
+	}
+}
+
+func TestDeleteOldBackupsDryRun(t *testing.T) {
+	tmpDir := t.TempDir()
+	ctx := context.Background()
+	rfs := newFSRemote(filepath.Join(tmpDir, "backups"))
+
+	snapshotPath := filepath.Join(tmpDir, "snapshot")
+	for _, name := range []string{"20251018000000", "20251018010000"} {
+		mustWriteFile(t, filepath.Join(snapshotPath, "datadb", "parts.json"), name)
+		objects, err := ListObjects(ctx, rfs)
+		if err != nil {
+			t.Fatalf("cannot list objects: %s", err)
+		}
+		var stats Stats
+		pm, err := BackupPartition(ctx, rfs, "20251018", snapshotPath, nil, objects, 1, false, &stats)
+		if err != nil {
+			t.Fatalf("cannot back up partition: %s", err)
+		}
+		if err := WriteManifest(ctx, rfs, &Manifest{Name: name, Partitions: []*PartitionManifest{pm}}); err != nil {
+			t.Fatalf("cannot write manifest: %s", err)
+		}
+	}
+
+	// Dry run must return backups and objects, which would be deleted, without deleting them
+	deleted, deletedObjects, err := DeleteOldBackups(ctx, rfs, 1, true)
+	if err != nil {
+		t.Fatalf("cannot delete old backups: %s", err)
+	}
+	if len(deleted) != 1 || deleted[0] != "20251018000000" {
+		t.Fatalf("unexpected deleted backups: %q", deleted)
+	}
+	if deletedObjects != 1 {
+		t.Fatalf("unexpected number of deleted objects; got %d; want 1", deletedObjects)
+	}
+	names, err := ListBackups(ctx, rfs)
+	if err != nil {
+		t.Fatalf("cannot list backups: %s", err)
+	}
+	if strings.Join(names, ",") != "20251018000000,20251018010000" {
+		t.Fatalf("unexpected backups after dry run: %q", names)
+	}
+	paths, err := rfs.ListFiles(ctx, "objects/")
+	if err != nil {
+		t.Fatalf("cannot list objects: %s", err)
+	}
+	if len(paths) != 2 {
+		t.Fatalf("unexpected number of objects after dry run; got %d; want 2", len(paths))
+	}
+}
+
+func TestReadManifestInvalidSHA256(t *testing.T) {
+	ctx := context.Background()
+	rfs := newFSRemote(t.TempDir())
+
+	f := func(sha256Hex string, resultExpected bool) {
+		t.Helper()
+
+		m := &Manifest{
+			Name: "20251018000000",
+			Partitions: []*PartitionManifest{{
+				Name: "20251018",
+				Files: []FileInfo{{
+					Path:   "datadb/parts.json",
+					Size:   2,
+					SHA256: sha256Hex,
+				}},
+			}},
+		}
+		if err := WriteManifest(ctx, rfs, m); err != nil {
+			t.Fatalf("cannot write manifest: %s", err)
+		}
+		_, err := ReadManifest(ctx, rfs, m.Name)
+		if (err == nil) != resultExpected {
+			t.Fatalf("unexpected result for ReadManifest with sha256=%q: %v", sha256Hex, err)
+		}
+	}
+
+	f(strings.Repeat("0a", 32), true)
+	f("", false)
+	f("a", false)
+	f(strings.Repeat("0a", 31), false)
+	f(strings.Repeat("0a", 33), false)
+	f(strings.Repeat("zz", 32), false)
+	f("../"+strings.Repeat("0a", 30)+"a", false)
+}
+
+func TestValidateBackupName(t *testing.T) {
+	f := func(name string, resultExpected bool) {
+		t.Helper()
+		err := ValidateBackupName(name)
+		if (err == nil) != resultExpected {
+			t.Fatalf("unexpected result for ValidateBackupName(%q): %v", name, err)
+		}
+	}
+
+	f("20251018000000", true)
+	f("daily-backup_1", true)
+	f("", false)
+	f("foo/bar", false)
+	f("..", false)
+}
+
+func mustWriteFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("cannot create directory: %s", err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("cannot write file: %s", err)
+	}
+}
+
+func mustHaveFile(t *testing.T, path, dataExpected string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("cannot read file: %s", err)
+	}
+	if string(data) != dataExpected {
+		t.Fatalf("unexpected contents for %q; got %q; want %q", path, data, dataExpected)
+	}
+}
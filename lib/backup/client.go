@@ -0,0 +1,92 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// StorageClient manages partitions at VictoriaTraces via /internal/partition/* HTTP endpoints.
+//
+// See https://docs.victoriametrics.com/victoriatraces/#partitions-lifecycle
+type StorageClient struct {
+	// URL is the url for VictoriaTraces or vtstorage node; for example, http://localhost:10428 .
+	URL string
+
+	// AuthKey is the value for -partitionManageAuthKey command-line flag at VictoriaTraces.
+	AuthKey string
+
+	c http.Client
+}
+
+// PartitionList returns names for the partitions attached to the storage.
+func (sc *StorageClient) PartitionList(ctx context.Context) ([]string, error) {
+	var names []string
+	if err := sc.do(ctx, "/internal/partition/list", nil, &names); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// PartitionSnapshotCreate creates a snapshot for the partition with the given name and returns the absolute path to the snapshot.
+func (sc *StorageClient) PartitionSnapshotCreate(ctx context.Context, name string) (string, error) {
+	var snapshotPath string
+	if err := sc.do(ctx, "/internal/partition/snapshot/create", url.Values{"name": {name}}, &snapshotPath); err != nil {
+		return "", err
+	}
+	return snapshotPath, nil
+}
+
+// PartitionSnapshotDelete deletes the snapshot at the given path.
+func (sc *StorageClient) PartitionSnapshotDelete(ctx context.Context, snapshotPath string) error {
+	return sc.do(ctx, "/internal/partition/snapshot/delete", url.Values{"path": {snapshotPath}}, nil)
+}
+
+// PartitionAttach attaches the partition with the given name to the storage.
+func (sc *StorageClient) PartitionAttach(ctx context.Context, name string) error {
+	return sc.do(ctx, "/internal/partition/attach", url.Values{"name": {name}}, nil)
+}
+
+// PartitionDetach detaches the partition with the given name from the storage.
+func (sc *StorageClient) PartitionDetach(ctx context.Context, name string) error {
+	return sc.do(ctx, "/internal/partition/detach", url.Values{"name": {name}}, nil)
+}
+
+func (sc *StorageClient) do(ctx context.Context, path string, args url.Values, response any) error {
+	if args == nil {
+		args = url.Values{}
+	}
+	if sc.AuthKey != "" {
+		args.Set("authKey", sc.AuthKey)
+	}
+	reqURL := strings.TrimSuffix(sc.URL, "/") + path
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, reqURL, strings.NewReader(args.Encode()))
+	if err != nil {
+		return fmt.Errorf("cannot create request to %q: %w", reqURL, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := sc.c.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot send request to %q: %w", reqURL, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("cannot read response from %q: %w", reqURL, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code for %q: %d; response: %q", reqURL, resp.StatusCode, data)
+	}
+	if response == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, response); err != nil {
+		return fmt.Errorf("cannot parse response from %q: %w; response: %q", reqURL, err, data)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// fsRemote is RemoteFS for the local filesystem.
+type fsRemote struct {
+	dir string
+}
+
+func newFSRemote(dir string) *fsRemote {
+	return &fsRemote{
+		dir: filepath.Clean(dir),
+	}
+}
+
+func (fr *fsRemote) String() string {
+	return "fs://" + fr.dir
+}
+
+func (fr *fsRemote) ListFiles(_ context.Context, prefix string) ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(fr.dir, func(path string, de fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if de.IsDir() {
+			return nil
+		}
+		relPath, err := filepath.Rel(fr.dir, path)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		if strings.HasSuffix(relPath, ".tmp") {
+			// Skip incompletely written files.
+			return nil
+		}
+		if strings.HasPrefix(relPath, prefix) {
+			paths = append(paths, relPath)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list files at %s: %w", fr, err)
+	}
+	slices.Sort(paths)
+	return paths, nil
+}
+
+func (fr *fsRemote) ReadFile(_ context.Context, path string) (io.ReadCloser, error) {
+	return os.Open(fr.getPath(path))
+}
+
+func (fr *fsRemote) WriteFile(_ context.Context, path string, r io.Reader, size int64, sha256Hex string) error {
+	dstPath := fr.getPath(path)
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+		return err
+	}
+
+	// Use unique name for the temporary file, since files with identical contents may be written concurrently.
+	f, err := os.CreateTemp(filepath.Dir(dstPath), filepath.Base(dstPath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+	h := sha256.New()
+	n, err := io.Copy(f, io.TeeReader(r, h))
+	if err == nil {
+		err = f.Sync()
+	}
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil && n != size {
+		err = fmt.Errorf("unexpected number of bytes written to %q; got %d; want %d", path, n, size)
+	}
+	if err == nil && hex.EncodeToString(h.Sum(nil)) != sha256Hex {
+		err = fmt.Errorf("checksum mismatch for the data written to %q", path)
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, dstPath)
+}
+
+func (fr *fsRemote) DeleteFile(_ context.Context, path string) error {
+	dstPath := fr.getPath(path)
+	if err := os.Remove(dstPath); err != nil {
+		return err
+	}
+
+	// Remove empty parent directories.
+	for dir := filepath.Dir(dstPath); dir != fr.dir && strings.HasPrefix(dir, fr.dir); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (fr *fsRemote) getPath(path string) string {
+	return filepath.Join(fr.dir, filepath.FromSlash(path))
+}
@@ -0,0 +1,171 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// manifestFilename is the name of the file with backup manifest.
+//
+// The manifest is written after all the backup files are uploaded, so only complete backups have manifests.
+const manifestFilename = "manifest.json"
+
+var backupNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-][a-zA-Z0-9_.-]*$`)
+
+// Manifest describes a backup.
+type Manifest struct {
+	// Name is the backup name.
+	Name string `json:"name"`
+
+	// CreatedAt is the backup creation time in RFC3339 format.
+	CreatedAt string `json:"created_at"`
+
+	// Partitions contains backed up partitions.
+	Partitions []*PartitionManifest `json:"partitions"`
+}
+
+// PartitionManifest describes a backed up per-day partition.
+type PartitionManifest struct {
+	// Name is the partition name in YYYYMMDD format.
+	Name string `json:"name"`
+
+	// Files contains files for the partition.
+	Files []FileInfo `json:"files"`
+}
+
+// FileInfo describes a backed up file.
+type FileInfo struct {
+	// Path is the path to the file relative to the partition directory.
+	Path string `json:"path"`
+
+	// Size is the file size in bytes.
+	Size int64 `json:"size"`
+
+	// SHA256 is hex-encoded sha256 hash of the file contents.
+	//
+	// The file contents is stored in the object with the name derived from the hash, so identical files are stored only once.
+	SHA256 string `json:"sha256"`
+}
+
+// Size returns the size in bytes for all the files in the partition.
+func (pm *PartitionManifest) Size() int64 {
+	n := int64(0)
+	for _, fi := range pm.Files {
+		n += fi.Size
+	}
+	return n
+}
+
+// GetPartition returns the partition with the given name from m.
+//
+// nil is returned if m doesn't contain the partition.
+func (m *Manifest) GetPartition(name string) *PartitionManifest {
+	for _, pm := range m.Partitions {
+		if pm.Name == name {
+			return pm
+		}
+	}
+	return nil
+}
+
+// ValidateBackupName returns an error if name cannot be used as a backup name.
+func ValidateBackupName(name string) error {
+	if !backupNameRegexp.MatchString(name) {
+		return fmt.Errorf("backup name %q must contain only alphanumeric chars, '_', '-' and '.', and it cannot start with '.'", name)
+	}
+	return nil
+}
+
+func getManifestPath(backupName string) string {
+	return "backups/" + backupName + "/" + manifestFilename
+}
+
+// getObjectPath returns the path for the object with the given sha256Hex hash.
+//
+// sha256Hex must be validated with validateSHA256Hex.
+func getObjectPath(sha256Hex string) string {
+	return "objects/" + sha256Hex[:2] + "/" + sha256Hex
+}
+
+// validateSHA256Hex returns an error if sha256Hex isn't a hex-encoded sha256 hash.
+func validateSHA256Hex(sha256Hex string) error {
+	if len(sha256Hex) != 2*sha256.Size {
+		return fmt.Errorf("unexpected length for sha256 hash %q; got %d chars; want %d chars", sha256Hex, len(sha256Hex), 2*sha256.Size)
+	}
+	if _, err := hex.DecodeString(sha256Hex); err != nil {
+		return fmt.Errorf("cannot decode sha256 hash %q: %w", sha256Hex, err)
+	}
+	return nil
+}
+
+// validate returns an error if m contains invalid file hashes.
+//
+// Hashes are used for building object paths at the remote storage, so they must be validated before use.
+func (m *Manifest) validate() error {
+	for _, pm := range m.Partitions {
+		for _, fi := range pm.Files {
+			if err := validateSHA256Hex(fi.SHA256); err != nil {
+				return fmt.Errorf("invalid file %q at partition %q: %w", fi.Path, pm.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ListBackups returns sorted names for complete backups at rfs.
+func ListBackups(ctx context.Context, rfs RemoteFS) ([]string, error) {
+	paths, err := rfs.ListFiles(ctx, "backups/")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, path := range paths {
+		name, ok := strings.CutSuffix(strings.TrimPrefix(path, "backups/"), "/"+manifestFilename)
+		if ok && !strings.Contains(name, "/") {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// ReadManifest reads the manifest for the backup with the given name from rfs.
+func ReadManifest(ctx context.Context, rfs RemoteFS, backupName string) (*Manifest, error) {
+	r, err := rfs.ReadFile(ctx, getManifestPath(backupName))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read manifest for backup %q at %s: %w", backupName, rfs, err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read manifest for backup %q at %s: %w", backupName, rfs, err)
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("cannot parse manifest for backup %q at %s: %w", backupName, rfs, err)
+	}
+	if err := m.validate(); err != nil {
+		return nil, fmt.Errorf("invalid manifest for backup %q at %s: %w", backupName, rfs, err)
+	}
+	return &m, nil
+}
+
+// WriteManifest writes m to rfs.
+func WriteManifest(ctx context.Context, rfs RemoteFS, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("BUG: cannot marshal manifest: %w", err)
+	}
+	h := sha256.Sum256(data)
+	if err := rfs.WriteFile(ctx, getManifestPath(m.Name), bytes.NewReader(data), int64(len(data)), hex.EncodeToString(h[:])); err != nil {
+		return fmt.Errorf("cannot write manifest for backup %q to %s: %w", m.Name, rfs, err)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// RemoteFS is a remote storage for backups.
+//
+// All the paths are relative to the storage root and use '/' as a separator.
+type RemoteFS interface {
+	// String returns human-readable description for the storage.
+	String() string
+
+	// ListFiles returns sorted paths for all the files with the given prefix.
+	ListFiles(ctx context.Context, prefix string) ([]string, error)
+
+	// ReadFile returns the reader for the file at the given path.
+	//
+	// The caller must close the returned reader.
+	ReadFile(ctx context.Context, path string) (io.ReadCloser, error)
+
+	// WriteFile atomically writes size bytes from r to the file at the given path.
+	//
+	// sha256Hex must contain hex-encoded sha256 hash of the written data.
+	WriteFile(ctx context.Context, path string, r io.Reader, size int64, sha256Hex string) error
+
+	// DeleteFile deletes the file at the given path.
+	DeleteFile(ctx context.Context, path string) error
+}
+
+// NewRemoteFS returns RemoteFS for the given url.
+//
+// The following urls are supported:
+//
+//   - fs:///path/to/dir - local filesystem directory, which may be mounted from NFS or other network filesystem.
+//   - s3://bucket/path/to/dir - S3-compatible object storage. See -customS3Endpoint and -s3ForcePathStyle command-line flags.
+func NewRemoteFS(url string) (RemoteFS, error) {
+	scheme, path, ok := strings.Cut(url, "://")
+	if !ok {
+		return nil, fmt.Errorf("missing scheme in %q; supported schemes: fs://, s3://", url)
+	}
+	switch scheme {
+	case "fs":
+		if !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("the path at %q must be absolute; for example, fs:///path/to/backups", url)
+		}
+		return newFSRemote(path), nil
+	case "s3":
+		bucket, dir, _ := strings.Cut(path, "/")
+		if bucket == "" {
+			return nil, fmt.Errorf("missing bucket name at %q; for example, s3://bucket/path/to/backups", url)
+		}
+		return newS3Remote(bucket, dir)
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q at %q; supported schemes: fs://, s3://", scheme, url)
+	}
+}
@@ -0,0 +1,354 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	customS3Endpoint = flag.String("customS3Endpoint", "", "Custom S3 endpoint for use with S3-compatible storages such as MinIO; for example, http://minio:9000 . "+
+		"AWS S3 endpoint for -s3Region is used if not set")
+	s3ForcePathStyle = flag.Bool("s3ForcePathStyle", true, "Whether to use path-style requests to S3 such as http://endpoint/bucket/object instead of "+
+		"virtual-hosted-style requests such as http://bucket.endpoint/object")
+	s3Region      = flag.String("s3Region", "", "S3 region; the region from AWS_REGION environment variable is used if not set; us-east-1 is used if AWS_REGION isn't set")
+	credsFilePath = flag.String("credsFilePath", "", "Optional path to file with S3 credentials in AWS shared credentials file format. "+
+		"The profile from AWS_PROFILE environment variable is used, or the default profile if AWS_PROFILE isn't set. "+
+		"Credentials are read from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables if the flag isn't set")
+)
+
+// emptyPayloadHash is hex-encoded sha256 hash for empty request body.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// s3Remote is RemoteFS for S3-compatible object storage.
+type s3Remote struct {
+	bucket string
+	dir    string
+
+	endpoint  *url.URL
+	pathStyle bool
+
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+
+	c *http.Client
+}
+
+func newS3Remote(bucket, dir string) (*s3Remote, error) {
+	region := *s3Region
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	endpoint := *customS3Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse -customS3Endpoint=%q: %w", endpoint, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("-customS3Endpoint=%q must have the form http://host:port or https://host:port", endpoint)
+	}
+
+	sr := &s3Remote{
+		bucket:    bucket,
+		dir:       strings.Trim(dir, "/"),
+		endpoint:  u,
+		pathStyle: *s3ForcePathStyle,
+		region:    region,
+		c:         &http.Client{},
+	}
+	if *credsFilePath != "" {
+		if err := sr.readCredsFile(*credsFilePath); err != nil {
+			return nil, fmt.Errorf("cannot read -credsFilePath=%q: %w", *credsFilePath, err)
+		}
+	} else {
+		sr.accessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+		sr.secretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		sr.sessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if sr.accessKey == "" || sr.secretKey == "" {
+		return nil, fmt.Errorf("missing S3 credentials; set them via -credsFilePath or via AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables")
+	}
+	return sr, nil
+}
+
+// readCredsFile reads credentials from the file at path in AWS shared credentials file format.
+func (sr *s3Remote) readCredsFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	profile := os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+
+	section := ""
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if section != profile {
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(k) {
+		case "aws_access_key_id":
+			sr.accessKey = strings.TrimSpace(v)
+		case "aws_secret_access_key":
+			sr.secretKey = strings.TrimSpace(v)
+		case "aws_session_token":
+			sr.sessionToken = strings.TrimSpace(v)
+		}
+	}
+	return sc.Err()
+}
+
+func (sr *s3Remote) String() string {
+	return fmt.Sprintf("s3://%s/%s", sr.bucket, sr.dir)
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (sr *s3Remote) ListFiles(ctx context.Context, prefix string) ([]string, error) {
+	keyPrefix := sr.getKey(prefix)
+	var paths []string
+	continuationToken := ""
+	for {
+		args := url.Values{
+			"list-type": {"2"},
+			"prefix":    {keyPrefix},
+		}
+		if continuationToken != "" {
+			args.Set("continuation-token", continuationToken)
+		}
+		resp, err := sr.doRequest(ctx, http.MethodGet, "", args, nil, 0, emptyPayloadHash)
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("cannot read response from %s: %w", sr, err)
+		}
+
+		var lbr listBucketResult
+		if err := xml.Unmarshal(data, &lbr); err != nil {
+			return nil, fmt.Errorf("cannot parse response from %s: %w", sr, err)
+		}
+		for _, c := range lbr.Contents {
+			paths = append(paths, strings.TrimPrefix(c.Key, sr.getKey("")))
+		}
+		if !lbr.IsTruncated {
+			break
+		}
+		continuationToken = lbr.NextContinuationToken
+	}
+	slices.Sort(paths)
+	return paths, nil
+}
+
+func (sr *s3Remote) ReadFile(ctx context.Context, path string) (io.ReadCloser, error) {
+	resp, err := sr.doRequest(ctx, http.MethodGet, sr.getKey(path), nil, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (sr *s3Remote) WriteFile(ctx context.Context, path string, r io.Reader, size int64, sha256Hex string) error {
+	resp, err := sr.doRequest(ctx, http.MethodPut, sr.getKey(path), nil, r, size, sha256Hex)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	return nil
+}
+
+func (sr *s3Remote) DeleteFile(ctx context.Context, path string) error {
+	resp, err := sr.doRequest(ctx, http.MethodDelete, sr.getKey(path), nil, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	return nil
+}
+
+func (sr *s3Remote) getKey(path string) string {
+	if sr.dir == "" {
+		return path
+	}
+	return sr.dir + "/" + path
+}
+
+// doRequest sends signed request with the given method for the given object key to S3.
+//
+// The caller must close the response body.
+func (sr *s3Remote) doRequest(ctx context.Context, method, key string, args url.Values, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	u := *sr.endpoint
+	if sr.pathStyle {
+		u.Path = "/" + sr.bucket + "/" + key
+	} else {
+		u.Host = sr.bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	u.RawPath = awsURIEncode(u.Path, false)
+	u.RawQuery = getCanonicalQueryString(args)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create request to %s: %w", sr, err)
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	if sr.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sr.sessionToken)
+	}
+	signRequest(req, payloadHash, sr.accessKey, sr.secretKey, sr.region, time.Now())
+
+	resp, err := sr.c.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot send %s request to %q: %w", method, u.Redacted(), err)
+	}
+	if resp.StatusCode/100 != 2 {
+		data, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status code for %s request to %q: %d; response: %q", method, u.Redacted(), resp.StatusCode, data)
+	}
+	return resp, nil
+}
+
+// signRequest signs req with AWS Signature Version 4.
+//
+// All the request headers are signed. See https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func signRequest(req *http.Request, payloadHash, accessKey, secretKey, region string, t time.Time) {
+	amzDate := t.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{
+		"host": host,
+	}
+	for k, vs := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(vs, ","))
+	}
+	headerNames := make([]string, 0, len(headers))
+	for k := range headers {
+		headerNames = append(headerNames, k)
+	}
+	slices.Sort(headerNames)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range headerNames {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", k, headers[k])
+	}
+	signedHeaders := strings.Join(headerNames, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s,SignedHeaders=%s,Signature=%s", accessKey, scope, signedHeaders, signature))
+}
+
+func getCanonicalQueryString(args url.Values) string {
+	keys := make([]string, 0, len(args))
+	for k := range args {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	var a []string
+	for _, k := range keys {
+		vs := slices.Clone(args[k])
+		slices.Sort(vs)
+		for _, v := range vs {
+			a = append(a, awsURIEncode(k, true)+"="+awsURIEncode(v, true))
+		}
+	}
+	return strings.Join(a, "&")
+}
+
+// awsURIEncode encodes s according to https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func awsURIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
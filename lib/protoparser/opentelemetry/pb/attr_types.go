@@ -0,0 +1,329 @@
+package pb
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/valyala/fastjson"
+	"github.com/valyala/quicktemplate"
+)
+
+// Attribute value types stored in attribute types fields.
+//
+// Attributes are stored as string fields, so their types are stored in a separate field per every attribute list
+// such as ResourceAttrTypesField or SpanAttrTypesField. The field contains JSON object with attribute keys in the original order
+// and the following types:
+//
+//   - AttrTypeString, AttrTypeEmptyString, AttrTypeBool, AttrTypeInt, AttrTypeDouble, AttrTypeBytes and AttrTypeEmpty for scalar values.
+//   - JSON array with element types for array values. Array values are stored as JSON arrays.
+//   - JSON object with key types for key-value list values. Key-value lists are stored as separate fields with `parent.key` names.
+const (
+	AttrTypeString = "s"
+
+	// AttrTypeEmptyString is used for empty strings, since they are stored as "-".
+	AttrTypeEmptyString = "e"
+
+	AttrTypeBool   = "b"
+	AttrTypeInt    = "i"
+	AttrTypeDouble = "d"
+
+	// AttrTypeBytes is used for bytes, which are stored in base64 encoding.
+	AttrTypeBytes = "x"
+
+	// AttrTypeEmpty is used for values without any type set.
+	AttrTypeEmpty = "n"
+)
+
+// EmptyAttrValue is stored instead of empty attribute values, since empty fields aren't supported by VictoriaLogs storage.
+const EmptyAttrValue = "-"
+
+// FormatAttrValue returns the string representation of av, which is stored in the attribute field.
+func FormatAttrValue(av *AnyValue) string {
+	if av != nil && av.DoubleValue != nil {
+		f := *av.DoubleValue
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			// NaN and Inf values cannot be represented in JSON, so store them in the form, which can be parsed back.
+			return strconv.FormatFloat(f, 'g', -1, 64)
+		}
+	}
+	v := av.FormatString(true)
+	if len(v) == 0 {
+		return EmptyAttrValue
+	}
+	return v
+}
+
+// MarshalAttrTypes appends types for kvs in JSON form to dst and returns the result.
+func MarshalAttrTypes(dst []byte, kvs []*KeyValue) []byte {
+	dst = append(dst, '{')
+	for i, kv := range kvs {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = quicktemplate.AppendJSONString(dst, kv.Key, true)
+		dst = append(dst, ':')
+		dst = appendAnyValueType(dst, kv.Value, true)
+	}
+	return append(dst, '}')
+}
+
+func appendAnyValueType(dst []byte, av *AnyValue, toplevel bool) []byte {
+	t := AttrTypeEmpty
+	switch {
+	case av == nil:
+	case av.StringValue != nil:
+		t = AttrTypeString
+		if toplevel && *av.StringValue == "" {
+			t = AttrTypeEmptyString
+		}
+	case av.BoolValue != nil:
+		t = AttrTypeBool
+	case av.IntValue != nil:
+		t = AttrTypeInt
+	case av.DoubleValue != nil:
+		t = AttrTypeDouble
+	case av.BytesValue != nil:
+		t = AttrTypeBytes
+	case av.ArrayValue != nil:
+		dst = append(dst, '[')
+		for i, v := range av.ArrayValue.Values {
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			dst = appendAnyValueType(dst, v, false)
+		}
+		return append(dst, ']')
+	case av.KeyValueList != nil:
+		return MarshalAttrTypes(dst, av.KeyValueList.Values)
+	}
+	dst = append(dst, '"')
+	dst = append(dst, t...)
+	return append(dst, '"')
+}
+
+// AttrTypes contains attribute types parsed by ParseAttrTypes.
+type AttrTypes struct {
+	o *fastjson.Object
+}
+
+// ParseAttrTypes parses attribute types stored by MarshalAttrTypes.
+func ParseAttrTypes(s string) (*AttrTypes, error) {
+	v, err := fastjson.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse attribute types %q: %w", s, err)
+	}
+	o, err := v.Object()
+	if err != nil {
+		return nil, fmt.Errorf("attribute types %q must be JSON object: %w", s, err)
+	}
+	return &AttrTypes{
+		o: o,
+	}, nil
+}
+
+// GetFieldTypes returns types for attribute fields with the given prefix and suffix.
+//
+// The returned map contains scalar types per every field name. Array values have no type in the returned map.
+func (at *AttrTypes) GetFieldTypes(prefix, suffix string) map[string]string {
+	m := make(map[string]string)
+	addFieldTypes(m, at.o, "", prefix, suffix)
+	return m
+}
+
+func addFieldTypes(m map[string]string, o *fastjson.Object, parentKey, prefix, suffix string) {
+	o.Visit(func(k []byte, v *fastjson.Value) {
+		key := string(k)
+		if parentKey != "" {
+			key = parentKey + "." + key
+		}
+		switch v.Type() {
+		case fastjson.TypeString:
+			m[prefix+key+suffix] = string(v.GetStringBytes())
+		case fastjson.TypeObject:
+			addFieldTypes(m, v.GetObject(), key, prefix, suffix)
+		}
+	})
+}
+
+// BuildKeyValues builds attributes with the original types from attribute fields with the given prefix and suffix.
+//
+// getValue must return the stored value for the given field name. Attributes without stored values are skipped.
+func (at *AttrTypes) BuildKeyValues(prefix, suffix string, getValue func(name string) (string, bool)) []*KeyValue {
+	return buildKeyValues(at.o, "", prefix, suffix, getValue)
+}
+
+func buildKeyValues(o *fastjson.Object, parentKey, prefix, suffix string, getValue func(name string) (string, bool)) []*KeyValue {
+	var kvs []*KeyValue
+	o.Visit(func(k []byte, v *fastjson.Value) {
+		key := string(k)
+		fieldName := key
+		if parentKey != "" {
+			fieldName = parentKey + "." + key
+		}
+
+		var av *AnyValue
+		if v.Type() == fastjson.TypeObject {
+			av = &AnyValue{
+				KeyValueList: &KeyValueList{
+					Values: buildKeyValues(v.GetObject(), fieldName, prefix, suffix, getValue),
+				},
+			}
+		} else {
+			s, ok := getValue(prefix + fieldName + suffix)
+			if !ok {
+				return
+			}
+			av = parseAttrValue(v, s)
+		}
+		kvs = append(kvs, &KeyValue{
+			Key:   key,
+			Value: av,
+		})
+	})
+	return kvs
+}
+
+// parseAttrValue returns the attribute value with the type t from the stored value s.
+//
+// The value is returned as string if it cannot be parsed according to t.
+func parseAttrValue(t *fastjson.Value, s string) *AnyValue {
+	if t.Type() == fastjson.TypeArray {
+		v, err := fastjson.Parse(s)
+		if err == nil {
+			if av, ok := parseJSONAttrValue(t, v); ok {
+				return av
+			}
+		}
+		return newStringValue(s)
+	}
+
+	typ := string(t.GetStringBytes())
+	if typ == AttrTypeEmptyString {
+		return newStringValue("")
+	}
+	if av, ok := parseScalarAttrValue(typ, s); ok {
+		return av
+	}
+	return newStringValue(s)
+}
+
+func parseJSONAttrValue(t, v *fastjson.Value) (*AnyValue, bool) {
+	switch t.Type() {
+	case fastjson.TypeArray:
+		ts := t.GetArray()
+		vs, err := v.Array()
+		if err != nil || len(ts) != len(vs) {
+			return nil, false
+		}
+		var values []*AnyValue
+		for i := range vs {
+			av, ok := parseJSONAttrValue(ts[i], vs[i])
+			if !ok {
+				return nil, false
+			}
+			values = append(values, av)
+		}
+		return &AnyValue{
+			ArrayValue: &ArrayValue{
+				Values: values,
+			},
+		}, true
+	case fastjson.TypeObject:
+		vo, err := v.Object()
+		if err != nil {
+			return nil, false
+		}
+		var kvs []*KeyValue
+		ok := true
+		t.GetObject().Visit(func(k []byte, kt *fastjson.Value) {
+			kv := vo.Get(string(k))
+			if !ok || kv == nil {
+				ok = false
+				return
+			}
+			av, okLocal := parseJSONAttrValue(kt, kv)
+			if !okLocal {
+				ok = false
+				return
+			}
+			kvs = append(kvs, &KeyValue{
+				Key:   string(k),
+				Value: av,
+			})
+		})
+		if !ok {
+			return nil, false
+		}
+		return &AnyValue{
+			KeyValueList: &KeyValueList{
+				Values: kvs,
+			},
+		}, true
+	case fastjson.TypeString:
+		typ := string(t.GetStringBytes())
+		switch v.Type() {
+		case fastjson.TypeNull:
+			return &AnyValue{}, typ == AttrTypeEmpty
+		case fastjson.TypeString:
+			if typ == AttrTypeString || typ == AttrTypeEmptyString {
+				return newStringValue(string(v.GetStringBytes())), true
+			}
+			return parseScalarAttrValue(typ, string(v.GetStringBytes()))
+		default:
+			return parseScalarAttrValue(typ, string(v.MarshalTo(nil)))
+		}
+	default:
+		return nil, false
+	}
+}
+
+func parseScalarAttrValue(typ, s string) (*AnyValue, bool) {
+	switch typ {
+	case AttrTypeString:
+		return newStringValue(s), true
+	case AttrTypeBool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, false
+		}
+		return &AnyValue{
+			BoolValue: &b,
+		}, true
+	case AttrTypeInt:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		return &AnyValue{
+			IntValue: &n,
+		}, true
+	case AttrTypeDouble:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, false
+		}
+		return &AnyValue{
+			DoubleValue: &f,
+		}, true
+	case AttrTypeBytes:
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, false
+		}
+		return &AnyValue{
+			BytesValue: &b,
+		}, true
+	case AttrTypeEmpty:
+		return &AnyValue{}, true
+	default:
+		return nil, false
+	}
+}
+
+func newStringValue(s string) *AnyValue {
+	return &AnyValue{
+		StringValue: &s,
+	}
+}
@@ -0,0 +1,183 @@
+package pb
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestAttrTypesRoundTrip(t *testing.T) {
+	f := func(kvs []*KeyValue) {
+		t.Helper()
+
+		fields := appendTestAttrFields(nil, kvs, "", "span_attr:", ":0")
+		types := string(MarshalAttrTypes(nil, kvs))
+		at, err := ParseAttrTypes(types)
+		if err != nil {
+			t.Fatalf("cannot parse attribute types: %s", err)
+		}
+		result := at.BuildKeyValues("span_attr:", ":0", func(name string) (string, bool) {
+			v, ok := fields[name]
+			return v, ok
+		})
+		if !reflect.DeepEqual(result, kvs) {
+			t.Fatalf("unexpected attributes for types %s\ngot\n%s\nwant\n%s", types, formatTestKeyValues(result), formatTestKeyValues(kvs))
+		}
+	}
+
+	// no attributes
+	f(nil)
+
+	// scalar values
+	f([]*KeyValue{
+		{Key: "str", Value: &AnyValue{StringValue: ptrTo("foo")}},
+		{Key: "empty_str", Value: &AnyValue{StringValue: ptrTo("")}},
+		{Key: "dash", Value: &AnyValue{StringValue: ptrTo("-")}},
+		{Key: "bool", Value: &AnyValue{BoolValue: ptrTo(true)}},
+		{Key: "int", Value: &AnyValue{IntValue: ptrTo(int64(-9007199254740993))}},
+		{Key: "double", Value: &AnyValue{DoubleValue: ptrTo(0.1)}},
+		{Key: "double_int", Value: &AnyValue{DoubleValue: ptrTo(200.0)}},
+		{Key: "big_double", Value: &AnyValue{DoubleValue: ptrTo(1.5e300)}},
+		{Key: "inf", Value: &AnyValue{DoubleValue: ptrTo(math.Inf(-1))}},
+		{Key: "bytes", Value: &AnyValue{BytesValue: ptrTo([]byte{0, 1, 255})}},
+		{Key: "none", Value: &AnyValue{}},
+		{Key: "with \"quotes\" and .dots", Value: &AnyValue{IntValue: ptrTo(int64(1))}},
+	})
+
+	// arrays
+	f([]*KeyValue{
+		{Key: "empty_array", Value: &AnyValue{ArrayValue: &ArrayValue{}}},
+		{Key: "array", Value: &AnyValue{ArrayValue: &ArrayValue{
+			Values: []*AnyValue{
+				{StringValue: ptrTo("")},
+				{StringValue: ptrTo("1")},
+				{IntValue: ptrTo(int64(1))},
+				{DoubleValue: ptrTo(1.0)},
+				{BoolValue: ptrTo(false)},
+				{BytesValue: ptrTo([]byte("foo"))},
+				{},
+				{ArrayValue: &ArrayValue{Values: []*AnyValue{{IntValue: ptrTo(int64(2))}}}},
+				{KeyValueList: &KeyValueList{Values: []*KeyValue{
+					{Key: "b", Value: &AnyValue{IntValue: ptrTo(int64(3))}},
+					{Key: "a", Value: &AnyValue{StringValue: ptrTo("x")}},
+				}}},
+			},
+		}}},
+	})
+
+	// key-value lists
+	f([]*KeyValue{
+		{Key: "empty_map", Value: &AnyValue{KeyValueList: &KeyValueList{}}},
+		{Key: "map", Value: &AnyValue{KeyValueList: &KeyValueList{
+			Values: []*KeyValue{
+				{Key: "z", Value: &AnyValue{IntValue: ptrTo(int64(1))}},
+				{Key: "nested", Value: &AnyValue{KeyValueList: &KeyValueList{
+					Values: []*KeyValue{
+						{Key: "a", Value: &AnyValue{BoolValue: ptrTo(true)}},
+					},
+				}}},
+				{Key: "arr", Value: &AnyValue{ArrayValue: &ArrayValue{
+					Values: []*AnyValue{{DoubleValue: ptrTo(2.5)}},
+				}}},
+			},
+		}}},
+	})
+}
+
+func TestAttrTypesNaN(t *testing.T) {
+	kvs := []*KeyValue{
+		{Key: "nan", Value: &AnyValue{DoubleValue: ptrTo(math.NaN())}},
+	}
+	fields := appendTestAttrFields(nil, kvs, "", "", "")
+	at, err := ParseAttrTypes(string(MarshalAttrTypes(nil, kvs)))
+	if err != nil {
+		t.Fatalf("cannot parse attribute types: %s", err)
+	}
+	result := at.BuildKeyValues("", "", func(name string) (string, bool) {
+		v, ok := fields[name]
+		return v, ok
+	})
+	if len(result) != 1 || result[0].Value.DoubleValue == nil || !math.IsNaN(*result[0].Value.DoubleValue) {
+		t.Fatalf("unexpected result: %s", formatTestKeyValues(result))
+	}
+}
+
+func TestAttrTypesMissingFields(t *testing.T) {
+	at, err := ParseAttrTypes(`{"a":"i","b":"i","c":"i"}`)
+	if err != nil {
+		t.Fatalf("cannot parse attribute types: %s", err)
+	}
+	fields := map[string]string{
+		"a": "1",
+		"c": "foo",
+	}
+	result := at.BuildKeyValues("", "", func(name string) (string, bool) {
+		v, ok := fields[name]
+		return v, ok
+	})
+
+	// missing fields must be skipped, while values with unexpected types must be returned as strings.
+	resultExpected := []*KeyValue{
+		{Key: "a", Value: &AnyValue{IntValue: ptrTo(int64(1))}},
+		{Key: "c", Value: &AnyValue{StringValue: ptrTo("foo")}},
+	}
+	if !reflect.DeepEqual(result, resultExpected) {
+		t.Fatalf("unexpected result\ngot\n%s\nwant\n%s", formatTestKeyValues(result), formatTestKeyValues(resultExpected))
+	}
+}
+
+func TestGetFieldTypes(t *testing.T) {
+	at, err := ParseAttrTypes(`{"a":"i","arr":["s"],"m":{"b":"d","c":{"d":"b"}}}`)
+	if err != nil {
+		t.Fatalf("cannot parse attribute types: %s", err)
+	}
+	result := at.GetFieldTypes("event:event_attr:", ":1")
+	resultExpected := map[string]string{
+		"event:event_attr:a:1":     "i",
+		"event:event_attr:m.b:1":   "d",
+		"event:event_attr:m.c.d:1": "b",
+	}
+	if !reflect.DeepEqual(result, resultExpected) {
+		t.Fatalf("unexpected result\ngot\n%v\nwant\n%v", result, resultExpected)
+	}
+}
+
+func TestParseAttrTypesFailure(t *testing.T) {
+	f := func(s string) {
+		t.Helper()
+		if _, err := ParseAttrTypes(s); err == nil {
+			t.Fatalf("expecting non-nil error for %q", s)
+		}
+	}
+
+	f("")
+	f("{")
+	f(`["s"]`)
+}
+
+// appendTestAttrFields flattens kvs into fields in the same way as it is done at data ingestion.
+func appendTestAttrFields(fields map[string]string, kvs []*KeyValue, parentKey, prefix, suffix string) map[string]string {
+	if fields == nil {
+		fields = make(map[string]string)
+	}
+	for _, kv := range kvs {
+		key := kv.Key
+		if parentKey != "" {
+			key = parentKey + "." + key
+		}
+		if kv.Value.KeyValueList != nil {
+			appendTestAttrFields(fields, kv.Value.KeyValueList.Values, key, prefix, suffix)
+			continue
+		}
+		fields[prefix+key+suffix] = FormatAttrValue(kv.Value)
+	}
+	return fields
+}
+
+func formatTestKeyValues(kvs []*KeyValue) string {
+	return (&KeyValueList{Values: kvs}).FormatString()
+}
+
+func ptrTo[T any](v T) *T {
+	return &v
+}
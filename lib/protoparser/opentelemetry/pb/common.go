@@ -2,7 +2,10 @@ package pb
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"math"
+	"strconv"
 	"strings"
 
 	"github.com/VictoriaMetrics/easyproto"
@@ -10,7 +13,8 @@ import (
 
 // Resource represents the corresponding OTEL protobuf message
 type Resource struct {
-	Attributes []*KeyValue `json:"attributes"`
+	Attributes             []*KeyValue `json:"attributes"`
+	DroppedAttributesCount uint32      `json:"droppedAttributesCount"`
 }
 
 // marshalProtobuf marshals
@@ -18,12 +22,14 @@ func (r *Resource) marshalProtobuf(mm *easyproto.MessageMarshaler) {
 	for _, a := range r.Attributes {
 		a.marshalProtobuf(mm.AppendMessage(1))
 	}
+	mm.AppendUint32(2, r.DroppedAttributesCount)
 }
 
 // unmarshalProtobuf unmarshals r from protobuf message at src.
 func (r *Resource) unmarshalProtobuf(src []byte) (err error) {
 	// message Resource {
 	//   repeated KeyValue attributes = 1;
+	//   uint32 dropped_attributes_count = 2;
 	// }
 	var fc easyproto.FieldContext
 	for len(src) > 0 {
@@ -42,6 +48,12 @@ func (r *Resource) unmarshalProtobuf(src []byte) (err error) {
 			if err := a.unmarshalProtobuf(data); err != nil {
 				return fmt.Errorf("cannot unmarshal Attribute: %w", err)
 			}
+		case 2:
+			droppedAttributesCount, ok := fc.Uint32()
+			if !ok {
+				return fmt.Errorf("cannot read resource dropped attributes count")
+			}
+			r.DroppedAttributesCount = droppedAttributesCount
 		}
 	}
 	return nil
@@ -95,13 +107,91 @@ func (kv *KeyValue) unmarshalProtobuf(src []byte) (err error) {
 
 // AnyValue represents the corresponding OTEL protobuf message
 type AnyValue struct {
-	StringValue  *string       `json:"stringValue"`
-	BoolValue    *bool         `json:"boolValue"`
-	IntValue     *int64        `json:"intValue,string"`
-	DoubleValue  *float64      `json:"doubleValue"`
-	ArrayValue   *ArrayValue   `json:"arrayValue"`
-	KeyValueList *KeyValueList `json:"keyValueList"`
-	BytesValue   *[]byte       `json:"BytesValue"`
+	StringValue  *string       `json:"stringValue,omitempty"`
+	BoolValue    *bool         `json:"boolValue,omitempty"`
+	IntValue     *int64        `json:"intValue,string,omitempty"`
+	DoubleValue  *float64      `json:"doubleValue,omitempty"`
+	ArrayValue   *ArrayValue   `json:"arrayValue,omitempty"`
+	KeyValueList *KeyValueList `json:"kvlistValue,omitempty"`
+	BytesValue   *[]byte       `json:"bytesValue,omitempty"`
+}
+
+// MarshalJSON marshals av into OTLP JSON.
+//
+// NaN and Inf double values are marshaled as strings according to https://protobuf.dev/programming-guides/json/ ,
+// since they cannot be represented as JSON numbers.
+func (av *AnyValue) MarshalJSON() ([]byte, error) {
+	type anyValue AnyValue
+	return json.Marshal(&struct {
+		*anyValue
+		DoubleValue *jsonDouble `json:"doubleValue,omitempty"`
+	}{
+		anyValue:    (*anyValue)(av),
+		DoubleValue: (*jsonDouble)(av.DoubleValue),
+	})
+}
+
+// UnmarshalJSON unmarshals av from OTLP JSON.
+func (av *AnyValue) UnmarshalJSON(data []byte) error {
+	type anyValue AnyValue
+	v := struct {
+		*anyValue
+		DoubleValue *jsonDouble `json:"doubleValue"`
+	}{
+		anyValue: (*anyValue)(av),
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	av.DoubleValue = (*float64)(v.DoubleValue)
+	return nil
+}
+
+// jsonDouble is float64, which can be marshaled to JSON and unmarshaled from JSON in the protobuf JSON form.
+//
+// It supports string values such as "NaN", "Infinity" and "-Infinity" in addition to JSON numbers.
+type jsonDouble float64
+
+func (f jsonDouble) MarshalJSON() ([]byte, error) {
+	v := float64(f)
+	switch {
+	case math.IsNaN(v):
+		return []byte(`"NaN"`), nil
+	case math.IsInf(v, 1):
+		return []byte(`"Infinity"`), nil
+	case math.IsInf(v, -1):
+		return []byte(`"-Infinity"`), nil
+	default:
+		return json.Marshal(v)
+	}
+}
+
+func (f *jsonDouble) UnmarshalJSON(data []byte) error {
+	var v float64
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		switch s {
+		case "NaN":
+			v = math.NaN()
+		case "Infinity":
+			v = math.Inf(1)
+		case "-Infinity":
+			v = math.Inf(-1)
+		default:
+			n, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return fmt.Errorf("cannot parse double value %q: %w", s, err)
+			}
+			v = n
+		}
+	} else if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*f = jsonDouble(v)
+	return nil
 }
 
 func (av *AnyValue) marshalProtobuf(mm *easyproto.MessageMarshaler) {
@@ -0,0 +1,61 @@
+package pb
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestAnyValueMarshalJSON(t *testing.T) {
+	f := func(av *AnyValue, resultExpected string) {
+		t.Helper()
+
+		data, err := json.Marshal(av)
+		if err != nil {
+			t.Fatalf("cannot marshal value: %s", err)
+		}
+		if string(data) != resultExpected {
+			t.Fatalf("unexpected result; got %s; want %s", data, resultExpected)
+		}
+
+		// the marshaled value must be unmarshaled to the original value
+		var avUnmarshaled AnyValue
+		if err := json.Unmarshal(data, &avUnmarshaled); err != nil {
+			t.Fatalf("cannot unmarshal %s: %s", data, err)
+		}
+		dataUnmarshaled, err := json.Marshal(&avUnmarshaled)
+		if err != nil {
+			t.Fatalf("cannot marshal unmarshaled value: %s", err)
+		}
+		if string(dataUnmarshaled) != resultExpected {
+			t.Fatalf("unexpected result after unmarshaling; got %s; want %s", dataUnmarshaled, resultExpected)
+		}
+	}
+
+	f(&AnyValue{}, `{}`)
+	f(&AnyValue{StringValue: ptrTo("")}, `{"stringValue":""}`)
+	f(&AnyValue{BoolValue: ptrTo(false)}, `{"boolValue":false}`)
+	f(&AnyValue{IntValue: ptrTo(int64(-12))}, `{"intValue":"-12"}`)
+	f(&AnyValue{DoubleValue: ptrTo(1.5)}, `{"doubleValue":1.5}`)
+	f(&AnyValue{DoubleValue: ptrTo(math.NaN())}, `{"doubleValue":"NaN"}`)
+	f(&AnyValue{DoubleValue: ptrTo(math.Inf(1))}, `{"doubleValue":"Infinity"}`)
+	f(&AnyValue{DoubleValue: ptrTo(math.Inf(-1))}, `{"doubleValue":"-Infinity"}`)
+	f(&AnyValue{BytesValue: ptrTo([]byte("foo"))}, `{"bytesValue":"Zm9v"}`)
+	f(&AnyValue{ArrayValue: &ArrayValue{Values: []*AnyValue{{StringValue: ptrTo("a")}}}}, `{"arrayValue":{"values":[{"stringValue":"a"}]}}`)
+	f(&AnyValue{KeyValueList: &KeyValueList{Values: []*KeyValue{{Key: "k", Value: &AnyValue{IntValue: ptrTo(int64(1))}}}}}, `{"kvlistValue":{"values":[{"key":"k","value":{"intValue":"1"}}]}}`)
+}
+
+func TestAnyValueUnmarshalJSONFailure(t *testing.T) {
+	f := func(data string) {
+		t.Helper()
+
+		var av AnyValue
+		if err := json.Unmarshal([]byte(data), &av); err == nil {
+			t.Fatalf("expecting non-nil error for %s", data)
+		}
+	}
+
+	f(`{"doubleValue":"foo"}`)
+	f(`{"doubleValue":true}`)
+	f(`{"stringValue":1}`)
+}
@@ -9,17 +9,37 @@ const (
 	TraceIDIndexPartitionCount = uint64(1024)
 )
 
+// Special: stream field value for span names folded because of high cardinality
+const (
+	// FoldedSpanName is used as the span name stream field value for spans of services with too many distinct span names.
+	// The real span name is kept in NameField for such spans.
+	FoldedSpanName = "<folded>"
+)
+
+// Special: fields added to query results
+const (
+	// RegionField contains the region of the storage node, which returned the span.
+	// It is added by vtselect to query results if -storageNode.region is set. It isn't stored in VictoriaTraces.
+	RegionField = "vt_region"
+)
+
 // Resource
 const (
-	ResourceAttrPrefix      = "resource_attr:"
-	ResourceAttrServiceName = "resource_attr:service.name" // ResourceAttrServiceName service name is a special resource attribute
+	ResourceAttrPrefix                  = "resource_attr:"
+	ResourceAttrServiceName             = "resource_attr:service.name" // ResourceAttrServiceName service name is a special resource attribute
+	ResourceAttrTypesField              = "resource_attr_types"        // ResourceAttrTypesField contains types for resource attributes. See MarshalAttrTypes
+	ResourceDroppedAttributesCountField = "resource_dropped_attributes_count"
+	ResourceSchemaURLField              = "resource_schema_url" // ResourceSchemaURLField contains the schema_url of ResourceSpans
 )
 
 // ScopeSpans - InstrumentationScope
 const (
-	InstrumentationScopeName       = "scope_name"
-	InstrumentationScopeVersion    = "scope_version"
-	InstrumentationScopeAttrPrefix = "scope_attr:"
+	InstrumentationScopeName                   = "scope_name"
+	InstrumentationScopeVersion                = "scope_version"
+	InstrumentationScopeAttrPrefix             = "scope_attr:"
+	InstrumentationScopeAttrTypes              = "scope_attr_types" // InstrumentationScopeAttrTypes contains types for scope attributes. See MarshalAttrTypes
+	InstrumentationScopeDroppedAttributesCount = "scope_dropped_attributes_count"
+	InstrumentationScopeSchemaURL              = "scope_schema_url" // InstrumentationScopeSchemaURL contains the schema_url of ScopeSpans
 )
 
 // Span
@@ -34,6 +54,7 @@ const (
 	StartTimeUnixNanoField      = "start_time_unix_nano"
 	EndTimeUnixNanoField        = "end_time_unix_nano"
 	SpanAttrPrefixField         = "span_attr:"
+	SpanAttrTypesField          = "span_attr_types" // SpanAttrTypesField contains types for span attributes. See MarshalAttrTypes
 	DroppedAttributesCountField = "dropped_attributes_count"
 	// Span_Event Here
 	DroppedEventsCountField = "dropped_events_count"
@@ -44,6 +65,10 @@ const (
 	// DurationField field is calculated by end-start to allow duration filter on span.
 	// It's not part of OTLP.
 	DurationField = "duration"
+
+	// OriginalNameField contains the span name before the normalization at data ingestion.
+	// It's stored only if the span name has been changed by the normalization.
+	OriginalNameField = "original_name"
 )
 
 // Span_Event
@@ -53,6 +78,7 @@ const (
 	EventTimeUnixNanoField           = "event_time_unix_nano"
 	EventNameField                   = "event_name"
 	EventAttrPrefix                  = "event_attr:"
+	EventAttrTypesField              = "event_attr_types"
 	EventDroppedAttributesCountField = "event_dropped_attributes_count"
 )
 
@@ -64,6 +90,7 @@ const (
 	LinkSpanIDField                 = "link_span_id"
 	LinkTraceStateField             = "link_trace_state"
 	LinkAttrPrefix                  = "link_attr:"
+	LinkAttrTypesField              = "link_attr_types"
 	LinkDroppedAttributesCountField = "link_dropped_attributes_count"
 	LinkFlagsField                  = "link_flags"
 )
@@ -90,6 +90,93 @@ func (r *ExportTraceServiceRequest) UnmarshalJSONCustom(src []byte) (err error)
 	return nil
 }
 
+// ExportTraceServiceResponse represent the OTLP protobuf message
+//
+// https://github.com/open-telemetry/opentelemetry-proto/blob/v1.5.0/opentelemetry/proto/collector/trace/v1/trace_service.proto#L45
+type ExportTraceServiceResponse struct {
+	PartialSuccess ExportTracePartialSuccess `json:"partialSuccess"`
+}
+
+// ExportTracePartialSuccess contains details on spans rejected by the server.
+//
+// https://github.com/open-telemetry/opentelemetry-proto/blob/v1.5.0/opentelemetry/proto/collector/trace/v1/trace_service.proto#L67
+type ExportTracePartialSuccess struct {
+	RejectedSpans int64  `json:"rejectedSpans,string"`
+	ErrorMessage  string `json:"errorMessage"`
+}
+
+// MarshalProtobuf marshals r to protobuf message, appends it to dst and returns the result.
+func (r *ExportTraceServiceResponse) MarshalProtobuf(dst []byte) []byte {
+	m := mp.Get()
+	r.marshalProtobuf(m.MessageMarshaler())
+	dst = m.Marshal(dst)
+	mp.Put(m)
+	return dst
+}
+
+func (r *ExportTraceServiceResponse) marshalProtobuf(mm *easyproto.MessageMarshaler) {
+	//message ExportTraceServiceResponse {
+	//	ExportTracePartialSuccess partial_success = 1;
+	//}
+	r.PartialSuccess.marshalProtobuf(mm.AppendMessage(1))
+}
+
+// UnmarshalProtobuf unmarshals r from protobuf message at src.
+func (r *ExportTraceServiceResponse) UnmarshalProtobuf(src []byte) (err error) {
+	var fc easyproto.FieldContext
+	for len(src) > 0 {
+		src, err = fc.NextField(src)
+		if err != nil {
+			return fmt.Errorf("cannot read next field in ExportTraceServiceResponse: %w", err)
+		}
+		switch fc.FieldNum {
+		case 1:
+			data, ok := fc.MessageData()
+			if !ok {
+				return fmt.Errorf("cannot read partial success data")
+			}
+			if err = r.PartialSuccess.unmarshalProtobuf(data); err != nil {
+				return fmt.Errorf("cannot unmarshal partial success: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+func (ps *ExportTracePartialSuccess) marshalProtobuf(mm *easyproto.MessageMarshaler) {
+	//message ExportTracePartialSuccess {
+	//	int64 rejected_spans = 1;
+	//	string error_message = 2;
+	//}
+	mm.AppendInt64(1, ps.RejectedSpans)
+	mm.AppendString(2, ps.ErrorMessage)
+}
+
+func (ps *ExportTracePartialSuccess) unmarshalProtobuf(src []byte) (err error) {
+	var fc easyproto.FieldContext
+	for len(src) > 0 {
+		src, err = fc.NextField(src)
+		if err != nil {
+			return fmt.Errorf("cannot read next field in ExportTracePartialSuccess: %w", err)
+		}
+		switch fc.FieldNum {
+		case 1:
+			rejectedSpans, ok := fc.Int64()
+			if !ok {
+				return fmt.Errorf("cannot read rejected spans")
+			}
+			ps.RejectedSpans = rejectedSpans
+		case 2:
+			errorMessage, ok := fc.String()
+			if !ok {
+				return fmt.Errorf("cannot read error message")
+			}
+			ps.ErrorMessage = strings.Clone(errorMessage)
+		}
+	}
+	return nil
+}
+
 // ResourceSpans represent a collection of ScopeSpans from a Resource.
 //
 // https://github.com/open-telemetry/opentelemetry-proto/blob/v1.5.0/opentelemetry/proto/trace/v1/trace.proto#L48
@@ -157,7 +244,7 @@ func (rs *ResourceSpans) unmarshalProtobuf(src []byte) (err error) {
 type ScopeSpans struct {
 	Scope     InstrumentationScope `json:"scope"`
 	Spans     []*Span              `json:"spans"`
-	SchemaURL string               `json:"schemaURL"`
+	SchemaURL string               `json:"schemaUrl"`
 }
 
 func (ss *ScopeSpans) marshalProtobuf(mm *easyproto.MessageMarshaler) {
@@ -286,7 +373,7 @@ type Span struct {
 	TraceID                string       `json:"traceId"`
 	SpanID                 string       `json:"spanId"`
 	TraceState             string       `json:"traceState"`
-	ParentSpanID           string       `json:"parentSpanID"`
+	ParentSpanID           string       `json:"parentSpanId"`
 	Flags                  uint32       `json:"flags"`
 	Name                   string       `json:"name"`
 	Kind                   SpanKind     `json:"kind"`
@@ -1,6 +1,7 @@
 package pb
 
 import (
+	"encoding/json"
 	"os"
 	"path"
 	"reflect"
@@ -44,3 +45,30 @@ func TestUnmarshalExportTraceServiceRequest(t *testing.T) {
 		}
 	}
 }
+
+func TestExportTraceServiceResponseMarshal(t *testing.T) {
+	r := &ExportTraceServiceResponse{
+		PartialSuccess: ExportTracePartialSuccess{
+			RejectedSpans: 42,
+			ErrorMessage:  "foo",
+		},
+	}
+
+	var result ExportTraceServiceResponse
+	if err := result.UnmarshalProtobuf(r.MarshalProtobuf(nil)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(&result, r) {
+		t.Fatalf("unexpected response after protobuf roundtrip; got %+v; want %+v", &result, r)
+	}
+
+	// int64 fields are encoded as strings in OTLP JSON.
+	data, err := json.Marshal(r)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	resultExpected := `{"partialSuccess":{"rejectedSpans":"42","errorMessage":"foo"}}`
+	if string(data) != resultExpected {
+		t.Fatalf("unexpected JSON response; got %s; want %s", data, resultExpected)
+	}
+}